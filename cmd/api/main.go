@@ -4,17 +4,33 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/consolelog"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/jobs"
 	"libvirt-controller/internal/metrics"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/quota"
+	"libvirt-controller/internal/reaper"
+	"libvirt-controller/internal/reconcile"
+	"libvirt-controller/internal/scheduler"
 	"libvirt-controller/internal/server"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// reconcileTimeout bounds the startup reconciliation, a hanging libvirt
+// daemon must not keep the API from starting.
+const reconcileTimeout = 2 * time.Minute
+
 func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	// Create context that listens for the interrupt signal from the OS.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -42,19 +58,101 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 func main() {
 	apiServer := server.NewServer()
 
-	// Register your libvirt collector
+	// Register your libvirt collector. The per domain collectors are served by
+	// metrics.Handler, which can limit them to some domains.
 	interfaceCollector := metrics.NewLibvirtInterfaceCollector()
-	prometheus.MustRegister(interfaceCollector)
 	diskCollector := metrics.NewLibvirtDiskCollector()
-	prometheus.MustRegister(diskCollector)
+	memoryCollector := metrics.NewLibvirtMemoryCollector()
+	domainCollectors := []metrics.DomainCollector{interfaceCollector, diskCollector, memoryCollector}
+	// Asks every guest agent on each scrape, so it's opt-in
+	if config.Bool("GUEST_FS_METRICS", false) {
+		domainCollectors = append(domainCollectors, metrics.NewLibvirtGuestFSCollector())
+	}
+	metrics.Register(prometheus.DefaultRegisterer, "commands_in_flight", metrics.NewCommandsInFlightGauge())
+	metrics.Register(prometheus.DefaultRegisterer, "command_duration", metrics.NewCommandDurationHistogram())
 
 	// Metrics server
 	metricsMux := http.NewServeMux()
-	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsMux.Handle("/metrics", metrics.Handler(domainCollectors...))
 	metricsServer := &http.Server{
 		Addr:    ":9100",
 		Handler: metricsMux,
 	}
+	server.ConfigureTimeouts(metricsServer)
+
+	// Keep async jobs queryable across restarts
+	if stateDir := os.Getenv("STATE_DIR"); stateDir != "" {
+		if err := jobs.Default.Persist(filepath.Join(stateDir, "jobs.json")); err != nil {
+			log.Printf("Job state is not persisted: %v", err)
+		}
+	}
+
+	// Tenant quotas, counting the domains they already own
+	if quotaFile := os.Getenv("QUOTA_FILE"); quotaFile != "" {
+		if err := quota.Default.Configure(quotaFile); err != nil {
+			log.Fatalf("Failed to load quotas: %v", err)
+		}
+		if stateDir := os.Getenv("STATE_DIR"); stateDir != "" {
+			if err := quota.Default.Persist(filepath.Join(stateDir, "quota.json")); err != nil {
+				log.Printf("Quota state is not persisted: %v", err)
+			}
+		}
+		if err := quota.Default.LoadDomains(); err != nil {
+			log.Printf("Failed to count domain quotas: %v", err)
+		}
+	}
+
+	// Optional host tools, the endpoints needing them fail with a clear error
+	if err := qemu.CheckSparsify(); err != nil {
+		log.Printf("Disk sparsification is unavailable: %v", err)
+	}
+	if err := helpers.CheckISOTool(); err != nil {
+		log.Printf("Cloud-init and ISO creation are unavailable: %v", err)
+	}
+
+	// Catch what a crash during a define or delete left behind
+	reconcileCtx, cancelReconcile := context.WithTimeout(context.Background(), reconcileTimeout)
+	reconcile.Run(reconcileCtx, config.Bool("RECONCILE_REPAIR", false))
+	cancelReconcile()
+
+	// Background workers stop once both servers are shut down
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	consolelog.Default = consolelog.NewCapturer(config.Int("CONSOLE_LOG_BUFFER_KB", consolelog.DefaultBufferKB) << 10)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		consolelog.Default.Run(workerCtx)
+	}()
+
+	for _, item := range filesystem.InvalidStorageClasses() {
+		log.Printf("Ignoring invalid entry %q in STORAGE_CLASSES, expected name=path", item)
+	}
+
+	// Every storage class gets its own reaper, snapshot scheduler and time sync
+	interval := config.Seconds("DOMAIN_REAPER_INTERVAL", reaper.DefaultInterval)
+	snapshotInterval := config.Seconds("SNAPSHOT_SCHEDULER_INTERVAL", scheduler.DefaultInterval)
+	timeSyncInterval := config.Seconds("TIME_SYNC_INTERVAL", scheduler.DefaultInterval)
+	for _, definitionsDir := range filesystem.StorageClasses() {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			reaper.Run(workerCtx, definitionsDir, interval)
+		}()
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			scheduler.Run(workerCtx, definitionsDir, snapshotInterval)
+		}()
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			scheduler.NewTimeSync(definitionsDir).Run(workerCtx, timeSyncInterval)
+		}()
+	}
 
 	// Graceful shutdown done channel
 	done := make(chan bool, 1)
@@ -81,5 +179,7 @@ func main() {
 	// Wait for shutdown
 	<-done
 	<-done
+	stopWorkers()
+	workers.Wait()
 	log.Println("All servers shut down cleanly.")
 }
@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+)
+
+// FileName is the audit log stored in every VM directory. Once it exceeds
+// AUDIT_MAX_BYTES it is rotated to FileName + ".1", replacing the previous
+// rotation.
+const FileName = "audit.log"
+
+// DefaultMaxBytes is the size at which the audit log is rotated.
+const DefaultMaxBytes = 1 << 20
+
+// maxValueLength caps recorded string values, so large payloads such as
+// XML definitions don't flood the log.
+const maxValueLength = 256
+
+// Redacted replaces the values of sensitive fields.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are substrings of parameter names whose values are never
+// recorded.
+var sensitiveKeys = []string{"password", "passphrase", "secret", "token", "key", "credential", "userdata", "user_data"}
+
+// sensitiveNames are parameter names whose values are never recorded, for
+// names too short to match as substrings, e.g. "script" within
+// "description". Scripts of /script may carry credentials.
+var sensitiveNames = map[string]bool{"script": true}
+
+// Entry is one mutating operation on a domain.
+type Entry struct {
+	Time      time.Time              `json:"time"`
+	Action    string                 `json:"action"` // Method and route, e.g. "POST /v1/domain/{id}/start"
+	Identity  string                 `json:"identity"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Status    int                    `json:"status"`
+	Result    string                 `json:"result"` // success or failure
+	Params    map[string]interface{} `json:"params,omitempty"`
+}
+
+// Appends from concurrent requests must not interleave.
+var mu sync.Mutex
+
+// Record appends e to the audit log in vmDir, rotating the log when it grew
+// past AUDIT_MAX_BYTES.
+func Record(vmDir string, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	path := filepath.Join(vmDir, FileName)
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(data)) >= int64(config.Int("AUDIT_MAX_BYTES", DefaultMaxBytes)) {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// Read returns the newest limit entries of the audit log in vmDir, oldest
+// first, including the rotated log.
+func Read(vmDir string, limit int) ([]Entry, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	path := filepath.Join(vmDir, FileName)
+	var entries []Entry
+	for _, file := range []string{path + ".1", path} {
+		read, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, read...)
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+func readFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // A line torn by a crash
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// Redact returns a copy of params fit for the audit log: values of
+// sensitive keys are replaced, long strings are truncated and nested
+// objects are redacted the same way.
+func Redact(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		if isSensitive(key) {
+			redacted[key] = Redacted
+			continue
+		}
+		redacted[key] = redactValue(value)
+	}
+	return redacted
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return Redact(v)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = redactValue(item)
+		}
+		return items
+	case string:
+		if len(v) > maxValueLength {
+			return v[:maxValueLength] + "..."
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveNames[key] {
+		return true
+	}
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
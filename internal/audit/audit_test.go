@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordRotates(t *testing.T) {
+	t.Setenv("AUDIT_MAX_BYTES", "1000")
+	dir := t.TempDir()
+
+	for i := 0; i < 20; i++ {
+		e := Entry{Time: time.Now().UTC(), Action: fmt.Sprintf("POST /v1/domain/{id}/op-%d", i), Identity: "anonymous", Status: 200, Result: "success"}
+		if err := Record(dir, e); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	for _, name := range []string{FileName, FileName + ".1"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if info.Size() > 1000 {
+			t.Errorf("%s has %d bytes, more than the limit", name, info.Size())
+		}
+	}
+
+	entries, err := Read(dir, 3)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	want := []string{"POST /v1/domain/{id}/op-17", "POST /v1/domain/{id}/op-18", "POST /v1/domain/{id}/op-19"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("Read() = %q, want %q", actions, want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	long := string(make([]byte, 300))
+	params := map[string]interface{}{
+		"username": "root",
+		"password": "hunter2",
+		"spec": map[string]interface{}{
+			"name":     "vm-1",
+			"ssh_keys": []interface{}{"ssh-ed25519 AAAA"},
+		},
+		"xml_config":  long,
+		"script":      "mysql -p'hunter2' < dump.sql",
+		"description": "nightly import",
+	}
+
+	got := Redact(params)
+	want := map[string]interface{}{
+		"username": "root",
+		"password": Redacted,
+		"spec": map[string]interface{}{
+			"name":     "vm-1",
+			"ssh_keys": Redacted,
+		},
+		"xml_config":  long[:maxValueLength] + "...",
+		"script":      Redacted,
+		"description": "nightly import",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Redact() = %v, want %v", got, want)
+	}
+	if params["password"] != "hunter2" {
+		t.Error("Redact() modified its input")
+	}
+}
@@ -0,0 +1,33 @@
+package cmdutil
+
+import (
+	"context"
+	"os"
+)
+
+type connectURIKey struct{}
+
+// WithConnectURI returns a context whose virsh calls connect to the libvirt
+// daemon at uri instead of the default one.
+func WithConnectURI(ctx context.Context, uri string) context.Context {
+	return context.WithValue(ctx, connectURIKey{}, uri)
+}
+
+// ConnectURI returns the libvirt URI virsh calls made with ctx connect to.
+// Without one in ctx it is LIBVIRT_URI, and "" when that is unset too, which
+// leaves the choice to virsh (normally the local qemu:///system).
+func ConnectURI(ctx context.Context) string {
+	if uri, ok := ctx.Value(connectURIKey{}).(string); ok && uri != "" {
+		return uri
+	}
+	return os.Getenv("LIBVIRT_URI")
+}
+
+// VirshArgs prepends the connection of ctx to the arguments of a virsh call.
+func VirshArgs(ctx context.Context, args ...string) []string {
+	uri := ConnectURI(ctx)
+	if uri == "" {
+		return args
+	}
+	return append([]string{"-c", uri}, args...)
+}
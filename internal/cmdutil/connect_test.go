@@ -0,0 +1,34 @@
+package cmdutil
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestVirshArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		defaultURI string
+		ctxURI     string
+		want       []string
+	}{
+		{"virsh default", "", "", []string{"list", "--all"}},
+		{"configured default", "qemu+unix:///system?socket=/run/alt.sock", "", []string{"-c", "qemu+unix:///system?socket=/run/alt.sock", "list", "--all"}},
+		{"request host", "", "qemu+ssh://node2/system", []string{"-c", "qemu+ssh://node2/system", "list", "--all"}},
+		{"request host overrides default", "qemu:///system", "qemu+ssh://node2/system", []string{"-c", "qemu+ssh://node2/system", "list", "--all"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LIBVIRT_URI", tt.defaultURI)
+			ctx := context.Background()
+			if tt.ctxURI != "" {
+				ctx = WithConnectURI(ctx, tt.ctxURI)
+			}
+			if got := VirshArgs(ctx, "list", "--all"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VirshArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
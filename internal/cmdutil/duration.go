@@ -0,0 +1,25 @@
+package cmdutil
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// durationObserver receives the run time of every finished command.
+var durationObserver atomic.Pointer[func(command string, d time.Duration)]
+
+// ObserveDurations registers fn to be called with the base name of the
+// command (virsh, qemu-img, ...) and its run time after every external
+// command, whether it succeeded or not. Time spent waiting for a slot of
+// MAX_CONCURRENT_COMMANDS isn't included. Only the last fn is kept.
+func ObserveDurations(fn func(command string, d time.Duration)) {
+	durationObserver.Store(&fn)
+}
+
+// observeSince reports a command started at start to the observer.
+func observeSince(command string, start time.Time) {
+	if fn := durationObserver.Load(); fn != nil {
+		(*fn)(filepath.Base(command), time.Since(start))
+	}
+}
@@ -0,0 +1,35 @@
+package cmdutil
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObserveDurations(t *testing.T) {
+	t.Cleanup(func() { durationObserver.Store(nil) })
+
+	var mu sync.Mutex
+	observed := make(map[string]time.Duration)
+	ObserveDurations(func(command string, d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		observed[command] = d
+	})
+
+	if _, err := ExecuteContext(context.Background(), "/bin/sleep", "0.05"); err != nil {
+		t.Fatalf("ExecuteContext() error = %v", err)
+	}
+	// Failed commands are timed as well
+	ExecuteStream(context.Background(), func(string) {}, "sh", "-c", "exit 1")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if d, ok := observed["sleep"]; !ok || d < 50*time.Millisecond {
+		t.Errorf("sleep observed as %s (present %t), want at least 50ms labeled by base name", d, ok)
+	}
+	if _, ok := observed["sh"]; !ok {
+		t.Errorf("failed sh command was not observed: %v", observed)
+	}
+}
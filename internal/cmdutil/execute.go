@@ -2,21 +2,43 @@ package cmdutil
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
+	"time"
 )
 
 // Execute runs a command and returns the output or an error.
 func Execute(command string, args ...string) (string, error) {
-	cmd := exec.Command(command, args...)
+	return ExecuteContext(context.Background(), command, args...)
+}
+
+// ExecuteContext runs a command like Execute, killing it when ctx is done.
+// The returned error then wraps the cause of the cancellation. When the
+// command itself fails its stdout is still returned, for tools that report
+// results through the exit status. At most MAX_CONCURRENT_COMMANDS commands
+// run at once, callers beyond that wait.
+func ExecuteContext(ctx context.Context, command string, args ...string) (string, error) {
+	// Wait for a free slot, giving up together with the caller
+	l := getLimiter()
+	if err := l.acquire(ctx); err != nil {
+		return "", fmt.Errorf("command %s aborted: %w", command, err)
+	}
+	defer l.release()
+	defer observeSince(command, time.Now())
+
+	cmd := exec.CommandContext(ctx, command, args...)
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command %s aborted: %w", command, context.Cause(ctx))
+	}
 	if err != nil {
-		return "", fmt.Errorf("command execution failed: %s, %w", stderr.String(), err)
+		return out.String(), fmt.Errorf("command execution failed: %s, %w", stderr.String(), err)
 	}
 	return out.String(), nil
 }
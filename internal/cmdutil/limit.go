@@ -0,0 +1,69 @@
+package cmdutil
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"libvirt-controller/internal/config"
+)
+
+// defaultMaxConcurrentCommands bounds external commands unless
+// MAX_CONCURRENT_COMMANDS is set. 0 or less removes the limit.
+const defaultMaxConcurrentCommands = 32
+
+// commandLimiter is a semaphore bounding the external commands running at
+// once, so bursts of requests queue up instead of forking hundreds of virsh
+// and qemu-img processes.
+type commandLimiter struct {
+	slots    chan struct{} // nil when unlimited
+	inFlight atomic.Int64
+}
+
+func newCommandLimiter(n int) *commandLimiter {
+	l := &commandLimiter{}
+	if n > 0 {
+		l.slots = make(chan struct{}, n)
+	}
+	return l
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (l *commandLimiter) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+	l.inFlight.Add(1)
+	return nil
+}
+
+func (l *commandLimiter) release() {
+	l.inFlight.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+var (
+	// The environment is read on first use, after .env files were loaded
+	limiterOnce sync.Once
+	limiter     *commandLimiter
+)
+
+func getLimiter() *commandLimiter {
+	limiterOnce.Do(func() {
+		if limiter == nil {
+			limiter = newCommandLimiter(config.Int("MAX_CONCURRENT_COMMANDS", defaultMaxConcurrentCommands))
+		}
+	})
+	return limiter
+}
+
+// InFlight returns the number of external commands currently running.
+func InFlight() int64 {
+	return getLimiter().inFlight.Load()
+}
@@ -0,0 +1,110 @@
+package cmdutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCommandLimiterBoundsConcurrency(t *testing.T) {
+	const limit = 3
+	l := newCommandLimiter(limit)
+
+	var running, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.acquire(context.Background()); err != nil {
+				t.Error(err)
+				return
+			}
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			if got := l.inFlight.Load(); got > limit {
+				t.Errorf("in-flight count %d exceeds limit %d", got, limit)
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			l.release()
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > limit {
+		t.Errorf("peak concurrency %d exceeds limit %d", p, limit)
+	}
+	if got := l.inFlight.Load(); got != 0 {
+		t.Errorf("in-flight count is %d after all commands finished", got)
+	}
+}
+
+func TestCommandLimiterRespectsCancellation(t *testing.T) {
+	l := newCommandLimiter(1)
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer l.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the queued caller to give up with the context, got %v", err)
+	}
+	if got := l.inFlight.Load(); got != 1 {
+		t.Errorf("in-flight count is %d, want 1", got)
+	}
+}
+
+func TestExecuteContextUsesLimiter(t *testing.T) {
+	original := getLimiter()
+	defer func() { limiter = original }()
+	limiter = newCommandLimiter(2)
+
+	var peak atomic.Int64
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if n := InFlight(); n > peak.Load() {
+				peak.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ExecuteContext(context.Background(), "sleep", "0.05"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-stopped
+
+	if p := peak.Load(); p > 2 {
+		t.Errorf("observed %d commands running at once, limit is 2", p)
+	}
+	if InFlight() != 0 {
+		t.Errorf("in-flight count is %d after all commands finished", InFlight())
+	}
+}
@@ -0,0 +1,108 @@
+package cmdutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStderrLines is how much of stderr ExecuteStream keeps for its error.
+const maxStderrLines = 10
+
+// ExecuteStream runs a command like ExecuteContext, but instead of buffering
+// the output it passes every line to onLine as soon as it is written. Output
+// is streamed from stdout and stderr, since progress meters such as the one
+// of virsh migrate --verbose write to stderr, and carriage returns end a line
+// as well. onLine is never called concurrently. The last lines of stderr are
+// included in the error when the command fails.
+func ExecuteStream(ctx context.Context, onLine func(string), command string, args ...string) error {
+	// Wait for a free slot, giving up together with the caller
+	l := getLimiter()
+	if err := l.acquire(ctx); err != nil {
+		return fmt.Errorf("command %s aborted: %w", command, err)
+	}
+	defer l.release()
+	defer observeSince(command, time.Now())
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("command execution failed: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("command execution failed: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("command execution failed: %w", err)
+	}
+
+	var mu sync.Mutex
+	var stderrTail []string
+	emit := func(line string, fromStderr bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fromStderr {
+			stderrTail = append(stderrTail, line)
+			if len(stderrTail) > maxStderrLines {
+				stderrTail = stderrTail[1:]
+			}
+		}
+		onLine(line)
+	}
+
+	// Both pipes have to be drained before Wait closes them
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		scanLines(stdout, func(line string) { emit(line, false) })
+	}()
+	go func() {
+		defer wg.Done()
+		scanLines(stderr, func(line string) { emit(line, true) })
+	}()
+	wg.Wait()
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return fmt.Errorf("command %s aborted: %w", command, context.Cause(ctx))
+	}
+	if err != nil {
+		return fmt.Errorf("command execution failed: %s, %w", strings.Join(stderrTail, "\n"), err)
+	}
+	return nil
+}
+
+// scanLines calls fn for every non-empty line read from r.
+func scanLines(r io.Reader, fn func(string)) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitLines)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			fn(line)
+		}
+	}
+	// Keep reading after an overlong line so the command doesn't block
+	io.Copy(io.Discard, r)
+}
+
+// splitLines is bufio.ScanLines, but also ends a line at a carriage return.
+func splitLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
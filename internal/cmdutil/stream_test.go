@@ -0,0 +1,69 @@
+package cmdutil
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteStreamDeliversLinesIncrementally(t *testing.T) {
+	start := time.Now()
+	var lines []string
+	var arrivals []time.Duration
+	err := ExecuteStream(context.Background(), func(line string) {
+		lines = append(lines, line)
+		arrivals = append(arrivals, time.Since(start))
+	}, "sh", "-c", "for i in 1 2 3; do echo step $i; sleep 0.1; done")
+	if err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+	total := time.Since(start)
+
+	want := []string{"step 1", "step 2", "step 3"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("got lines %q, want %q", lines, want)
+	}
+	// The first line must arrive while the command is still running
+	if arrivals[0] > total-150*time.Millisecond {
+		t.Errorf("first line arrived after %s of %s, output was not streamed", arrivals[0], total)
+	}
+}
+
+func TestExecuteStreamSplitsProgressOutput(t *testing.T) {
+	var lines []string
+	err := ExecuteStream(context.Background(), func(line string) {
+		lines = append(lines, line)
+	}, "sh", "-c", `printf 'Migration: [ 10 %%]\rMigration: [ 55 %%]\rMigration: [100 %%]\n' >&2; echo done`)
+	if err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+
+	for _, want := range []string{"Migration: [ 10 %]", "Migration: [ 55 %]", "Migration: [100 %]", "done"} {
+		found := false
+		for _, line := range lines {
+			found = found || line == want
+		}
+		if !found {
+			t.Errorf("line %q missing from %q", want, lines)
+		}
+	}
+}
+
+func TestExecuteStreamReportsStderrOnFailure(t *testing.T) {
+	err := ExecuteStream(context.Background(), func(string) {}, "sh", "-c", "echo 'error: Domain not found' >&2; exit 1")
+	if err == nil || !strings.Contains(err.Error(), "error: Domain not found") {
+		t.Fatalf("expected the stderr output in the error, got %v", err)
+	}
+}
+
+func TestExecuteStreamAbortsWithContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := ExecuteStream(ctx, func(string) {}, "sleep", "5")
+	if err == nil || !strings.Contains(err.Error(), "aborted") {
+		t.Fatalf("expected the command to be aborted, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Int returns the integer value of an environment variable, or def when it
+// is unset or not a valid integer.
+func Int(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("invalid value %q for %s, using default %d", value, name, def)
+		return def
+	}
+	return n
+}
+
+// Seconds reads an environment variable holding a number of seconds.
+func Seconds(name string, def time.Duration) time.Duration {
+	return time.Duration(Int(name, int(def/time.Second))) * time.Second
+}
+
+// Bool reads a boolean environment variable ("true", "1", "false", ...).
+func Bool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("invalid value %q for %s, using default %t", value, name, def)
+		return def
+	}
+	return b
+}
+
+// List splits a comma separated environment variable, dropping empty items.
+func List(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,263 @@
+// Package consolelog keeps the recent serial console output of domains in
+// memory. For guests without an agent the console is the only window in.
+// The output is tailed from the file the serial port writes to: the source
+// of a file serial or the log of a pty serial (the console_log of a spec).
+package consolelog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+)
+
+// DefaultBufferKB is the console output kept per domain.
+const DefaultBufferKB = 64
+
+const (
+	// pollInterval is how often the console files are read.
+	pollInterval = time.Second
+
+	// scanInterval is how often the domains that opted in are looked up.
+	scanInterval = 10 * time.Second
+)
+
+// Calls finding the console files; swapped out in tests.
+var (
+	listVMDirs = filesystem.ListVMDirs
+	dumpXML    = libvirt.DumpXML
+)
+
+// Capturer tails the console files of the domains with capture_console set
+// in their metadata into a bounded buffer each.
+type Capturer struct {
+	mu       sync.Mutex
+	size     int
+	captures map[string]*capture
+}
+
+// capture is the console of one domain.
+type capture struct {
+	ring   *ring
+	path   string   // Console file, empty while unknown
+	file   *os.File // nil until the file exists
+	offset int64
+
+	// started is set once the file was first opened. The output from
+	// before is skipped but for what fits the ring, reopening continues.
+	started bool
+}
+
+// Default is the capturer read by the HTTP handlers.
+var Default = NewCapturer(DefaultBufferKB << 10)
+
+// NewCapturer creates a capturer keeping size bytes per domain.
+func NewCapturer(size int) *Capturer {
+	return &Capturer{size: size, captures: make(map[string]*capture)}
+}
+
+// Read returns the captured console output of vmID, oldest first, and
+// whether older output was dropped. ok is false while nothing is captured.
+func (c *Capturer) Read(vmID string) (output []byte, truncated bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cp, ok := c.captures[vmID]
+	if !ok {
+		return nil, false, false
+	}
+	return cp.ring.Bytes(), cp.ring.truncated, true
+}
+
+// Run captures the consoles until ctx is cancelled, then closes the files.
+func (c *Capturer) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	defer c.closeAll()
+
+	var lastScan time.Time
+	for {
+		if time.Since(lastScan) >= scanInterval {
+			c.scan(ctx)
+			lastScan = time.Now()
+		}
+		c.poll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan starts capturing the domains that opted in and drops the others.
+// Output captured so far is kept while a domain is stopped, the file it
+// was read from continues when it starts again.
+func (c *Capturer) scan(ctx context.Context) {
+	dirs, err := listVMDirs()
+	if err != nil {
+		log.Printf("console capture: failed to list domains: %v", err)
+		return
+	}
+
+	wanted := make(map[string]string)
+	for vmID, vmDir := range dirs {
+		m, err := metadata.Load(vmDir)
+		if err != nil || !m.CaptureConsole {
+			continue
+		}
+		// The persistent definition names the file while the domain is stopped
+		xml, err := dumpXML(ctx, vmID, true)
+		if err != nil {
+			log.Printf("console capture: failed to read the definition of %s: %v", vmID, err)
+			continue
+		}
+		domain, err := domainxml.Parse([]byte(xml))
+		if err != nil {
+			continue
+		}
+		wanted[vmID] = ConsoleFile(domain)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for vmID, cp := range c.captures {
+		if _, ok := wanted[vmID]; !ok {
+			cp.close()
+			delete(c.captures, vmID)
+		}
+	}
+	for vmID, path := range wanted {
+		cp, ok := c.captures[vmID]
+		if !ok {
+			cp = &capture{ring: newRing(c.size)}
+			c.captures[vmID] = cp
+		}
+		if cp.path != path {
+			cp.close()
+			cp.path, cp.offset, cp.started = path, 0, false
+		}
+	}
+}
+
+// ConsoleFile returns the file the first serial port of domain writes to,
+// empty when its output isn't written anywhere and there is nothing to
+// capture.
+func ConsoleFile(domain *domainxml.Domain) string {
+	if len(domain.Devices.Serials) == 0 {
+		return ""
+	}
+	serial := domain.Devices.Serials[0]
+	switch {
+	case serial.Log != nil && filepath.IsAbs(serial.Log.File):
+		return serial.Log.File
+	case serial.Type == "file" && serial.Source != nil && filepath.IsAbs(serial.Source.Path):
+		return serial.Source.Path
+	}
+	return ""
+}
+
+// poll reads what was written to the console files since the last poll.
+func (c *Capturer) poll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for vmID, cp := range c.captures {
+		if err := cp.read(c.size); err != nil {
+			log.Printf("console capture: failed to read the console of %s: %v", vmID, err)
+			cp.close()
+		}
+	}
+}
+
+func (c *Capturer) closeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cp := range c.captures {
+		cp.close()
+	}
+}
+
+// read copies new output into the ring. It opens the file once it exists and
+// reopens it when it was replaced, e.g. rotated by virtlogd. A file that
+// shrank was truncated by a restart of the domain and is read from the start.
+func (cp *capture) read(size int) error {
+	if cp.path == "" {
+		return nil
+	}
+	if cp.file == nil {
+		file, err := os.Open(cp.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !cp.started {
+			info, err := file.Stat()
+			if err != nil {
+				file.Close()
+				return err
+			}
+			cp.offset, cp.started = max(info.Size()-int64(size), 0), true
+		}
+		cp.file = file
+	}
+
+	if info, err := cp.file.Stat(); err == nil && info.Size() < cp.offset {
+		cp.offset = 0
+	}
+	if err := cp.copy(); err != nil {
+		return err
+	}
+
+	// Whatever the replaced file still had is read, the new one from its start
+	current, err := os.Stat(cp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if opened, err := cp.file.Stat(); err == nil && !os.SameFile(opened, current) {
+		cp.close()
+		file, err := os.Open(cp.path)
+		if err != nil {
+			return err
+		}
+		cp.file, cp.offset = file, 0
+		return cp.copy()
+	}
+	return nil
+}
+
+// copy reads the file from offset to its end into the ring.
+func (cp *capture) copy() error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := cp.file.ReadAt(buf, cp.offset)
+		cp.ring.Write(buf[:n])
+		cp.offset += int64(n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (cp *capture) close() {
+	if cp.file != nil {
+		cp.file.Close()
+		cp.file = nil
+	}
+}
@@ -0,0 +1,92 @@
+package consolelog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"libvirt-controller/internal/metadata"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(8)
+	r.Write([]byte("abc"))
+	r.Write([]byte("defg"))
+	if got := string(r.Bytes()); got != "abcdefg" || r.truncated {
+		t.Fatalf("Bytes() = %q, truncated %v; want %q", got, r.truncated, "abcdefg")
+	}
+	r.Write([]byte("hij"))
+	if got := string(r.Bytes()); got != "cdefghij" || !r.truncated {
+		t.Fatalf("Bytes() = %q, truncated %v; want %q", got, r.truncated, "cdefghij")
+	}
+	r.Write([]byte("0123456789"))
+	if got := string(r.Bytes()); got != "23456789" {
+		t.Fatalf("Bytes() = %q, want %q", got, "23456789")
+	}
+}
+
+func TestCapturer(t *testing.T) {
+	origList, origDump := listVMDirs, dumpXML
+	t.Cleanup(func() { listVMDirs, dumpXML = origList, origDump })
+
+	vmDir := t.TempDir()
+	logFile := filepath.Join(t.TempDir(), "vm-1-console.log")
+	setCapture := func(on bool) {
+		if err := metadata.Save(vmDir, &metadata.Metadata{CaptureConsole: on}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendLog := func(s string) {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listVMDirs = func() (map[string]string, error) { return map[string]string{"vm-1": vmDir}, nil }
+	dumpXML = func(ctx context.Context, domain string, inactive bool) (string, error) {
+		return `<domain type="kvm"><name>vm-1</name><devices><serial type="pty"><target port="0"/><log file="` + logFile + `" append="on"/></serial></devices></domain>`, nil
+	}
+
+	c := NewCapturer(16)
+	check := func(step, want string) {
+		t.Helper()
+		c.poll()
+		if got, _, _ := c.Read("vm-1"); string(got) != want {
+			t.Errorf("%s: output = %q, want %q", step, got, want)
+		}
+	}
+
+	setCapture(true)
+	c.scan(context.Background())
+	check("not started yet", "")
+
+	// Older output is skipped but for what fits
+	appendLog("BIOS 1.16\nBooting\n")
+	check("started", "OS 1.16\nBooting\n")
+	appendLog("login: ")
+	check("appended", "\nBooting\nlogin: ")
+
+	// A restart without append truncates the file
+	if err := os.WriteFile(logFile, []byte("BIOS\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check("truncated", "ing\nlogin: BIOS\n")
+
+	// virtlogd rotates by renaming the file away
+	if err := os.Rename(logFile, logFile+".0"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog("rotated\n")
+	check("rotated", "n: BIOS\nrotated\n")
+
+	setCapture(false)
+	c.scan(context.Background())
+	if _, _, ok := c.Read("vm-1"); ok {
+		t.Error("capture kept after opting out")
+	}
+}
@@ -0,0 +1,46 @@
+package consolelog
+
+// ring keeps the last len(buf) bytes written to it.
+type ring struct {
+	buf       []byte
+	start     int // Offset of the oldest byte
+	n         int // Bytes held
+	truncated bool
+}
+
+func newRing(size int) *ring {
+	return &ring{buf: make([]byte, size)}
+}
+
+// Write appends p, overwriting the oldest bytes once the ring is full.
+func (r *ring) Write(p []byte) {
+	size := len(r.buf)
+	if size == 0 {
+		return
+	}
+	if len(p) >= size {
+		r.truncated = r.truncated || r.n > 0 || len(p) > size
+		copy(r.buf, p[len(p)-size:])
+		r.start, r.n = 0, size
+		return
+	}
+
+	end := (r.start + r.n) % size
+	copied := copy(r.buf[end:], p)
+	copy(r.buf, p[copied:])
+	if overflow := r.n + len(p) - size; overflow > 0 {
+		r.truncated = true
+		r.start = (r.start + overflow) % size
+		r.n = size
+	} else {
+		r.n += len(p)
+	}
+}
+
+// Bytes returns a copy of the held bytes, oldest first.
+func (r *ring) Bytes() []byte {
+	out := make([]byte, r.n)
+	copied := copy(out, r.buf[r.start:min(r.start+r.n, len(r.buf))])
+	copy(out[copied:], r.buf)
+	return out
+}
@@ -0,0 +1,268 @@
+package domainxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+)
+
+// lookPath finds host binaries; swapped out in tests.
+var lookPath = exec.LookPath
+
+// checkVFIO inspects host PCI devices; swapped out in tests.
+var checkVFIO = CheckVFIO
+
+// checkSharedDir inspects host directories; swapped out in tests.
+var checkSharedDir = CheckSharedDir
+
+// Build validates the spec and renders it as libvirt domain XML.
+func Build(spec DomainSpec) (string, error) {
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	// libvirt only fails at start time without swtpm, catch it when defining
+	if spec.TPM {
+		if _, err := lookPath("swtpm"); err != nil {
+			return "", fmt.Errorf("tpm requires swtpm to be installed on the host: %w", err)
+		}
+	}
+	for i, dev := range spec.HostDevices {
+		addr, _ := ParsePCIAddress(dev)
+		if err := checkVFIO(addr); err != nil {
+			return "", fmt.Errorf("host_devices[%d]: %w", i, err)
+		}
+	}
+	if len(spec.SharedDirs) > 0 {
+		if err := CheckVirtiofsd(); err != nil {
+			return "", err
+		}
+	}
+	for i, d := range spec.SharedDirs {
+		if err := checkSharedDir(d.Source); err != nil {
+			return "", fmt.Errorf("shared_dirs[%d]: %w", i, err)
+		}
+	}
+	// Work on copies so defaults don't leak into the caller's slices
+	spec.Disks = append([]DiskSpec(nil), spec.Disks...)
+	spec.Interfaces = append([]InterfaceSpec(nil), spec.Interfaces...)
+	if spec.CPU != nil {
+		cpu := *spec.CPU
+		spec.CPU = &cpu
+	}
+	spec.applyDefaults()
+
+	domain := Domain{
+		Type:   "kvm",
+		Name:   spec.Name,
+		Memory: Memory{Unit: "MiB", Value: spec.MemoryMB},
+		VCPU:   spec.VCPUs,
+		OS: OS{
+			Type: OSType{Arch: spec.Arch, Machine: spec.Machine, Value: "hvm"},
+			Boot: []Boot{{Dev: "hd"}},
+		},
+	}
+
+	if spec.SharedMemory || len(spec.SharedDirs) > 0 {
+		domain.MemoryBacking = sharedMemoryBacking()
+	}
+
+	if spec.Firmware == FirmwareEFI {
+		buildEFI(&domain, spec)
+	}
+
+	if spec.CPU != nil {
+		domain.CPU = buildCPU(*spec.CPU)
+	}
+	if len(spec.NUMA) > 0 {
+		// Cells alone leave the CPU model to the hypervisor
+		if domain.CPU == nil {
+			domain.CPU = &CPU{}
+		}
+		domain.CPU.NUMA = buildNUMA(spec.NUMA)
+	}
+	if spec.NUMATune != nil {
+		domain.NUMATune = buildNUMATune(*spec.NUMATune)
+	}
+
+	targets := newTargetAllocator(spec.Disks)
+	needsSCSI := false
+	for _, d := range spec.Disks {
+		target := d.Target
+		if target == "" {
+			target = targets.next(targetPrefix(d.Bus))
+		}
+		if d.Bus == BusSCSI {
+			needsSCSI = true
+		}
+		source := &DiskSource{File: d.Path}
+		if d.Secret != "" {
+			source.Encryption = &Encryption{Format: "luks", Secret: EncryptionSecret{Type: "passphrase", UUID: d.Secret}}
+		}
+		domain.Devices.Disks = append(domain.Devices.Disks, Disk{
+			Type:   "file",
+			Device: "disk",
+			Driver: &DiskDriver{Name: "qemu", Type: d.Format, Cache: d.Cache},
+			Source: source,
+			Target: DiskTarget{Dev: target, Bus: d.Bus},
+			Serial: d.Serial,
+			WWN:    d.WWN,
+		})
+	}
+
+	if spec.CloudInitISO != "" {
+		domain.Devices.Disks = append(domain.Devices.Disks, Disk{
+			Type:     "file",
+			Device:   "cdrom",
+			Driver:   &DiskDriver{Name: "qemu", Type: "raw"},
+			Source:   &DiskSource{File: spec.CloudInitISO},
+			Target:   DiskTarget{Dev: targets.next("sd"), Bus: BusSATA},
+			ReadOnly: &struct{}{},
+		})
+	}
+
+	if needsSCSI {
+		domain.Devices.Controllers = append(domain.Devices.Controllers, Controller{Type: "scsi", Model: "virtio-scsi"})
+	}
+
+	for _, n := range spec.Interfaces {
+		iface := Interface{
+			Type:   "network",
+			Source: InterfaceSource{Network: n.Network},
+			Model:  &InterfaceModel{Type: n.Model},
+		}
+		if n.Bridge != "" {
+			iface.Type, iface.Source = "bridge", InterfaceSource{Bridge: n.Bridge}
+		}
+		if n.MAC != "" {
+			iface.MAC = &InterfaceMAC{Address: n.MAC}
+		}
+		domain.Devices.Interfaces = append(domain.Devices.Interfaces, iface)
+	}
+
+	// The guest agent channel is required by every agent backed endpoint
+	domain.Devices.Channels = append(domain.Devices.Channels, Channel{
+		Type:   "unix",
+		Target: ChannelTarget{Type: "virtio", Name: "org.qemu.guest_agent.0"},
+	})
+
+	if *spec.Serial {
+		// The console element refers to the first serial port rather than adding a device
+		port := 0
+		serial := Serial{Type: "pty", Target: &SerialTarget{Port: &port}}
+		if spec.ConsoleLog != "" {
+			serial.Log = &SerialLog{File: spec.ConsoleLog, Append: "on"}
+		}
+		domain.Devices.Serials = append(domain.Devices.Serials, serial)
+		domain.Devices.Consoles = append(domain.Devices.Consoles, Console{Type: "pty", Target: &ConsoleTarget{Type: "serial", Port: &port}})
+	}
+
+	if spec.RNG {
+		// urandom never blocks, unlike /dev/random on older host kernels
+		domain.Devices.RNGs = append(domain.Devices.RNGs, RNG{
+			Model:   "virtio",
+			Backend: RNGBackend{Model: "random", Path: "/dev/urandom"},
+		})
+	}
+
+	if spec.TPM {
+		// CRB is the interface Windows 11 expects, TIS is for old guests only
+		domain.Devices.TPMs = append(domain.Devices.TPMs, TPM{
+			Model:   "tpm-crb",
+			Backend: TPMBackend{Type: "emulator", Version: "2.0"},
+		})
+	}
+
+	for _, dev := range spec.HostDevices {
+		addr, _ := ParsePCIAddress(dev)
+		domain.Devices.HostDevs = append(domain.Devices.HostDevs, addr.HostDevice())
+	}
+
+	for _, d := range spec.SharedDirs {
+		domain.Devices.Filesystems = append(domain.Devices.Filesystems, d.Filesystem())
+	}
+
+	if spec.Graphics != "none" {
+		domain.Devices.Graphics = append(domain.Devices.Graphics, Graphics{Type: spec.Graphics, AutoPort: "yes", Listen: "127.0.0.1"})
+		domain.Devices.Videos = append(domain.Devices.Videos, Video{Model: VideoModel{Type: "virtio"}})
+	}
+
+	out, err := xml.MarshalIndent(domain, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal domain XML: %w", err)
+	}
+	return string(out), nil
+}
+
+// buildEFI switches the domain to UEFI firmware. Without an explicit loader
+// libvirt picks a matching OVMF build from the firmware descriptors the host
+// ships, which is what domcapabilities reports.
+func buildEFI(domain *Domain, spec DomainSpec) {
+	secure := "no"
+	if spec.SecureBoot {
+		secure = "yes"
+		domain.Features = &Features{SMM: &SMM{State: "on"}}
+	}
+
+	if spec.Loader == "" {
+		domain.OS.Firmware = FirmwareEFI
+		domain.OS.FirmwareFeatures = &OSFirmware{Features: []FirmwareFeature{
+			{Enabled: secure, Name: "secure-boot"},
+			{Enabled: secure, Name: "enrolled-keys"},
+		}}
+		return
+	}
+
+	domain.OS.Loader = &Loader{ReadOnly: "yes", Secure: secure, Type: "pflash", Path: spec.Loader}
+	if spec.NVRAM != "" {
+		domain.OS.NVRAM = &NVRAM{Path: spec.NVRAM}
+	}
+}
+
+func buildCPU(c CPUSpec) *CPU {
+	cpu := &CPU{Mode: c.Mode}
+	if c.Mode == CPUCustom {
+		cpu.Match = "exact"
+		// Refuse to start rather than silently emulate a different model
+		cpu.Model = &CPUModel{Fallback: "forbid", Value: c.Model}
+	}
+	if c.Sockets > 0 {
+		cpu.Topology = &CPUTopology{Sockets: c.Sockets, Cores: c.Cores, Threads: c.Threads}
+	}
+	return cpu
+}
+
+// targetAllocator hands out unused device names such as vda, vdb or sda,
+// continuing with vdaa after vdz the way libvirt names disks.
+type targetAllocator struct {
+	used map[string]bool
+}
+
+func newTargetAllocator(disks []DiskSpec) *targetAllocator {
+	a := &targetAllocator{used: make(map[string]bool)}
+	for _, d := range disks {
+		if d.Target != "" {
+			a.used[d.Target] = true
+		}
+	}
+	return a
+}
+
+func (a *targetAllocator) next(prefix string) string {
+	for i := 0; ; i++ {
+		name := prefix + diskSuffix(i)
+		if !a.used[name] {
+			a.used[name] = true
+			return name
+		}
+	}
+}
+
+// diskSuffix returns the letters of the i-th disk: a to z, then aa to zz,
+// aaa and so on.
+func diskSuffix(i int) string {
+	suffix := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		suffix = string(rune('a'+(i-1)%26)) + suffix
+	}
+	return suffix
+}
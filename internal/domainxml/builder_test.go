@@ -0,0 +1,409 @@
+package domainxml
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildDiskBusAndCache(t *testing.T) {
+	spec := DomainSpec{
+		Name:     "vm-1",
+		MemoryMB: 1024,
+		VCPUs:    2,
+		Disks: []DiskSpec{
+			{Path: "/data/vm-1/root.img"},
+			{Path: "/data/vm-1/win.img", Bus: BusSATA, Cache: CacheWriteback},
+			{Path: "/data/vm-1/data.img", Bus: BusSCSI, Cache: CacheWritethrough, Format: "raw"},
+		},
+		CloudInitISO: "/data/vm-1/cloud-init.iso",
+	}
+
+	out, err := Build(spec)
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+	if domain.Name != "vm-1" || domain.Memory.Value != 1024 || domain.VCPU != 2 {
+		t.Errorf("unexpected domain basics: %+v", domain)
+	}
+
+	expected := []struct {
+		file, dev, bus, cache, format string
+	}{
+		{"/data/vm-1/root.img", "vda", BusVirtio, CacheNone, "qcow2"},
+		{"/data/vm-1/win.img", "sda", BusSATA, CacheWriteback, "qcow2"},
+		{"/data/vm-1/data.img", "sdb", BusSCSI, CacheWritethrough, "raw"},
+		{"/data/vm-1/cloud-init.iso", "sdc", BusSATA, "", "raw"},
+	}
+	if len(domain.Devices.Disks) != len(expected) {
+		t.Fatalf("expected %d disks, got %d", len(expected), len(domain.Devices.Disks))
+	}
+	for i, want := range expected {
+		got := domain.Devices.Disks[i]
+		if got.Source.File != want.file || got.Target.Dev != want.dev || got.Target.Bus != want.bus ||
+			got.Driver.Cache != want.cache || got.Driver.Type != want.format {
+			t.Errorf("disk %d: got %+v/%+v/%+v, want %+v", i, got.Source, got.Target, got.Driver, want)
+		}
+	}
+
+	if len(domain.Devices.Controllers) != 1 || domain.Devices.Controllers[0].Model != "virtio-scsi" {
+		t.Errorf("expected a virtio-scsi controller, got %+v", domain.Devices.Controllers)
+	}
+	if spec.Disks[0].Bus != "" {
+		t.Errorf("Build modified the caller's spec")
+	}
+}
+
+func TestBuildManyDisks(t *testing.T) {
+	spec := DomainSpec{Name: "vm-1", MemoryMB: 1024, VCPUs: 1}
+	for i := 0; i < 30; i++ {
+		spec.Disks = append(spec.Disks, DiskSpec{Path: "/data/vm-1/disk.img"})
+	}
+	spec.Disks[27].Target = "vdab"
+
+	out, err := Build(spec)
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+	got := make([]string, 0, len(domain.Devices.Disks))
+	for _, d := range domain.Devices.Disks {
+		got = append(got, d.Target.Dev)
+	}
+	if want := []string{"vdz", "vdaa", "vdab", "vdac", "vdad"}; !reflect.DeepEqual(got[25:], want) {
+		t.Errorf("targets after vdy = %v, want %v", got[25:], want)
+	}
+}
+
+func TestDiskSuffix(t *testing.T) {
+	for i, want := range map[int]string{0: "a", 25: "z", 26: "aa", 51: "az", 52: "ba", 701: "zz", 702: "aaa"} {
+		if got := diskSuffix(i); got != want {
+			t.Errorf("diskSuffix(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestBuildRejectsInvalidDisks(t *testing.T) {
+	cases := map[string]DiskSpec{
+		"unknown bus":        {Path: "/a.img", Bus: "ide"},
+		"unknown cache":      {Path: "/a.img", Cache: "unsafe"},
+		"virtio on sd name":  {Path: "/a.img", Target: "sda"},
+		"sata on vd name":    {Path: "/a.img", Bus: BusSATA, Target: "vda"},
+		"missing path":       {Bus: BusVirtio},
+		"secret on raw":      {Path: "/a.img", Format: "raw", Secret: "6d5c1a3e-0f4b-4a8e-9c2d-1b7e3f5a9d20"},
+		"secret not a uuid":  {Path: "/a.img", Secret: "disk-key"},
+		"wwn too short":      {Path: "/a.img", Bus: BusSCSI, WWN: "5000c50015ea71a"},
+		"wwn not hex":        {Path: "/a.img", Bus: BusSCSI, WWN: "5000c50015ea71zz"},
+		"wwn on virtio":      {Path: "/a.img", WWN: "5000c50015ea71ad"},
+		"serial with slash":  {Path: "/a.img", Serial: "disk/1"},
+		"long virtio serial": {Path: "/a.img", Serial: "0123456789abcdefghijk"},
+	}
+	for name, disk := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Build(DomainSpec{Name: "vm", MemoryMB: 512, VCPUs: 1, Disks: []DiskSpec{disk}})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), "disks[0]") {
+				t.Errorf("error should name the disk, got %q", err)
+			}
+		})
+	}
+}
+
+func TestBuildDiskEncryption(t *testing.T) {
+	const secret = "6d5c1a3e-0f4b-4a8e-9c2d-1b7e3f5a9d20"
+	spec := DomainSpec{
+		Name:     "vm-1",
+		MemoryMB: 1024,
+		VCPUs:    2,
+		Disks: []DiskSpec{
+			{Path: "/data/vm-1/root.qcow2", Secret: secret},
+			{Path: "/data/vm-1/data.qcow2"},
+		},
+	}
+
+	out, err := Build(spec)
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+
+	want := &Encryption{Format: "luks", Secret: EncryptionSecret{Type: "passphrase", UUID: secret}}
+	if got := domain.Devices.Disks[0].Source.Encryption; !reflect.DeepEqual(got, want) {
+		t.Errorf("encryption = %+v, want %+v", got, want)
+	}
+	if got := domain.Devices.Disks[1].Source.Encryption; got != nil {
+		t.Errorf("unencrypted disk has encryption %+v", got)
+	}
+}
+
+func TestBuildDiskSerialAndWWN(t *testing.T) {
+	spec := DomainSpec{
+		Name:     "vm-1",
+		MemoryMB: 1024,
+		VCPUs:    2,
+		Disks: []DiskSpec{
+			{Path: "/data/vm-1/root.qcow2", Serial: "root-disk"},
+			{Path: "/data/vm-1/data.qcow2", Bus: BusSCSI, Serial: "data-disk", WWN: "0x5000C50015EA71AD"},
+			{Path: "/data/vm-1/scratch.qcow2"},
+		},
+	}
+
+	out, err := Build(spec)
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	if !strings.Contains(out, "<serial>root-disk</serial>") || !strings.Contains(out, "<wwn>0x5000C50015EA71AD</wwn>") {
+		t.Errorf("serial or wwn missing from XML:\n%s", out)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+
+	want := [][2]string{{"root-disk", ""}, {"data-disk", "0x5000C50015EA71AD"}, {"", ""}}
+	for i, w := range want {
+		disk := domain.Devices.Disks[i]
+		if disk.Serial != w[0] || disk.WWN != w[1] {
+			t.Errorf("disks[%d] serial, wwn = %q, %q, want %q, %q", i, disk.Serial, disk.WWN, w[0], w[1])
+		}
+	}
+}
+
+func TestBuildCPU(t *testing.T) {
+	spec := DomainSpec{
+		Name:     "vm-1",
+		MemoryMB: 1024,
+		VCPUs:    8,
+		CPU:      &CPUSpec{Mode: CPUCustom, Model: "Skylake-Server", Sockets: 2, Cores: 2, Threads: 2},
+	}
+
+	out, err := Build(spec)
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+
+	cpu := domain.CPU
+	if cpu == nil || cpu.Mode != CPUCustom || cpu.Model == nil || cpu.Model.Value != "Skylake-Server" {
+		t.Fatalf("unexpected cpu element: %+v", cpu)
+	}
+	if cpu.Topology == nil || *cpu.Topology != (CPUTopology{Sockets: 2, Cores: 2, Threads: 2}) {
+		t.Errorf("unexpected topology: %+v", cpu.Topology)
+	}
+}
+
+func TestBuildCPUDefaults(t *testing.T) {
+	out, err := Build(DomainSpec{Name: "vm", MemoryMB: 512, VCPUs: 2, CPU: &CPUSpec{}})
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, _ := Parse([]byte(out))
+	if domain.CPU == nil || domain.CPU.Mode != CPUHostModel || domain.CPU.Topology != nil || domain.CPU.Model != nil {
+		t.Errorf("expected a bare host-model cpu, got %+v", domain.CPU)
+	}
+
+	out, _ = Build(DomainSpec{Name: "vm", MemoryMB: 512, VCPUs: 2})
+	if domain, _ := Parse([]byte(out)); domain.CPU != nil {
+		t.Errorf("expected no cpu element without a cpu spec, got %+v", domain.CPU)
+	}
+}
+
+func TestBuildRejectsInvalidCPU(t *testing.T) {
+	cases := map[string]CPUSpec{
+		"topology mismatch": {Sockets: 1, Cores: 2, Threads: 1},
+		"partial topology":  {Sockets: 4},
+		"custom no model":   {Mode: CPUCustom},
+		"model w/o custom":  {Mode: CPUHostPassthrough, Model: "EPYC"},
+		"unknown mode":      {Mode: "maximum"},
+	}
+	for name, cpu := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Build(DomainSpec{Name: "vm", MemoryMB: 512, VCPUs: 4, CPU: &cpu})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), "cpu:") {
+				t.Errorf("error should name the cpu, got %q", err)
+			}
+		})
+	}
+}
+
+func TestBuildEFI(t *testing.T) {
+	t.Run("auto-selected secure boot firmware", func(t *testing.T) {
+		out, err := Build(DomainSpec{Name: "vm", MemoryMB: 2048, VCPUs: 2, Firmware: FirmwareEFI, SecureBoot: true})
+		if err != nil {
+			t.Fatalf("Build returned error: %v", err)
+		}
+		domain, err := Parse([]byte(out))
+		if err != nil {
+			t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+		}
+
+		if domain.OS.Firmware != FirmwareEFI || domain.OS.Loader != nil {
+			t.Errorf("expected libvirt firmware selection, got %+v", domain.OS)
+		}
+		if domain.OS.Type.Machine != "q35" {
+			t.Errorf("secure boot should default to q35, got %q", domain.OS.Type.Machine)
+		}
+		if domain.Features == nil || domain.Features.SMM == nil || domain.Features.SMM.State != "on" {
+			t.Errorf("secure boot requires SMM, got %+v", domain.Features)
+		}
+		want := []FirmwareFeature{{Enabled: "yes", Name: "secure-boot"}, {Enabled: "yes", Name: "enrolled-keys"}}
+		if domain.OS.FirmwareFeatures == nil || !reflect.DeepEqual(domain.OS.FirmwareFeatures.Features, want) {
+			t.Errorf("unexpected firmware features: %+v", domain.OS.FirmwareFeatures)
+		}
+	})
+
+	t.Run("explicit loader", func(t *testing.T) {
+		out, err := Build(DomainSpec{
+			Name: "vm", MemoryMB: 2048, VCPUs: 2, Firmware: FirmwareEFI,
+			Loader: "/usr/share/OVMF/OVMF_CODE.fd", NVRAM: "/data/vm/OVMF_VARS.fd",
+		})
+		if err != nil {
+			t.Fatalf("Build returned error: %v", err)
+		}
+		domain, _ := Parse([]byte(out))
+
+		loader := domain.OS.Loader
+		if loader == nil || loader.Path != "/usr/share/OVMF/OVMF_CODE.fd" || loader.Type != "pflash" || loader.Secure != "no" {
+			t.Errorf("unexpected loader: %+v", loader)
+		}
+		if domain.OS.NVRAM == nil || domain.OS.NVRAM.Path != "/data/vm/OVMF_VARS.fd" {
+			t.Errorf("unexpected nvram: %+v", domain.OS.NVRAM)
+		}
+		if domain.OS.Firmware != "" || domain.Features != nil {
+			t.Errorf("explicit loader should not enable autoselection or SMM: %+v %+v", domain.OS, domain.Features)
+		}
+	})
+
+	t.Run("bios by default", func(t *testing.T) {
+		out, _ := Build(DomainSpec{Name: "vm", MemoryMB: 512, VCPUs: 1})
+		domain, _ := Parse([]byte(out))
+		if domain.OS.Firmware != "" || domain.OS.Loader != nil || domain.OS.FirmwareFeatures != nil {
+			t.Errorf("expected a BIOS domain, got %+v", domain.OS)
+		}
+	})
+}
+
+func TestBuildRejectsInvalidFirmware(t *testing.T) {
+	cases := map[string]DomainSpec{
+		"secure boot on bios":   {SecureBoot: true},
+		"loader on bios":        {Loader: "/usr/share/OVMF/OVMF_CODE.fd"},
+		"nvram without loader":  {Firmware: FirmwareEFI, NVRAM: "/data/vm/VARS.fd"},
+		"secure boot on i440fx": {Firmware: FirmwareEFI, SecureBoot: true, Machine: "pc-i440fx-8.2"},
+		"unknown firmware":      {Firmware: "coreboot"},
+	}
+	for name, spec := range cases {
+		t.Run(name, func(t *testing.T) {
+			spec.Name, spec.MemoryMB, spec.VCPUs = "vm", 512, 1
+			if _, err := Build(spec); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestBuildTPM(t *testing.T) {
+	original := lookPath
+	defer func() { lookPath = original }()
+
+	lookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	out, err := Build(DomainSpec{Name: "vm", MemoryMB: 4096, VCPUs: 2, TPM: true})
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+	want := []TPM{{Model: "tpm-crb", Backend: TPMBackend{Type: "emulator", Version: "2.0"}}}
+	if !reflect.DeepEqual(domain.Devices.TPMs, want) {
+		t.Errorf("unexpected tpm devices: %+v", domain.Devices.TPMs)
+	}
+
+	out, _ = Build(DomainSpec{Name: "vm", MemoryMB: 4096, VCPUs: 2})
+	if domain, _ := Parse([]byte(out)); len(domain.Devices.TPMs) != 0 {
+		t.Errorf("expected no tpm device unless enabled, got %+v", domain.Devices.TPMs)
+	}
+
+	lookPath = func(file string) (string, error) { return "", exec.ErrNotFound }
+	if _, err := Build(DomainSpec{Name: "vm", MemoryMB: 4096, VCPUs: 2, TPM: true}); err == nil || !strings.Contains(err.Error(), "swtpm") {
+		t.Errorf("expected a swtpm error, got %v", err)
+	}
+}
+
+func TestBuildSerialAndRNG(t *testing.T) {
+	off := false
+	port := 0
+	tests := []struct {
+		name        string
+		spec        DomainSpec
+		wantSerials []Serial
+		wantConsole []Console
+		wantRNGs    []RNG
+	}{
+		{
+			name:        "serial console on by default",
+			spec:        DomainSpec{Name: "vm", MemoryMB: 1024, VCPUs: 1},
+			wantSerials: []Serial{{Type: "pty", Target: &SerialTarget{Port: &port}}},
+			wantConsole: []Console{{Type: "pty", Target: &ConsoleTarget{Type: "serial", Port: &port}}},
+		},
+		{
+			name: "serial console disabled",
+			spec: DomainSpec{Name: "vm", MemoryMB: 1024, VCPUs: 1, Serial: &off},
+		},
+		{
+			name:        "console log",
+			spec:        DomainSpec{Name: "vm", MemoryMB: 1024, VCPUs: 1, ConsoleLog: "/var/log/libvirt/qemu/vm-console.log"},
+			wantSerials: []Serial{{Type: "pty", Target: &SerialTarget{Port: &port}, Log: &SerialLog{File: "/var/log/libvirt/qemu/vm-console.log", Append: "on"}}},
+			wantConsole: []Console{{Type: "pty", Target: &ConsoleTarget{Type: "serial", Port: &port}}},
+		},
+		{
+			name:        "rng enabled",
+			spec:        DomainSpec{Name: "vm", MemoryMB: 1024, VCPUs: 1, RNG: true},
+			wantSerials: []Serial{{Type: "pty", Target: &SerialTarget{Port: &port}}},
+			wantConsole: []Console{{Type: "pty", Target: &ConsoleTarget{Type: "serial", Port: &port}}},
+			wantRNGs:    []RNG{{Model: "virtio", Backend: RNGBackend{Model: "random", Path: "/dev/urandom"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := Build(tt.spec)
+			if err != nil {
+				t.Fatalf("Build returned error: %v", err)
+			}
+			domain, err := Parse([]byte(out))
+			if err != nil {
+				t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+			}
+			if !reflect.DeepEqual(domain.Devices.Serials, tt.wantSerials) {
+				t.Errorf("unexpected serial devices: %+v\n%s", domain.Devices.Serials, out)
+			}
+			if !reflect.DeepEqual(domain.Devices.Consoles, tt.wantConsole) {
+				t.Errorf("unexpected console devices: %+v\n%s", domain.Devices.Consoles, out)
+			}
+			if !reflect.DeepEqual(domain.Devices.RNGs, tt.wantRNGs) {
+				t.Errorf("unexpected rng devices: %+v\n%s", domain.Devices.RNGs, out)
+			}
+		})
+	}
+}
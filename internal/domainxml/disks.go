@@ -0,0 +1,72 @@
+package domainxml
+
+import "strconv"
+
+// DiskFile is a disk or CD-ROM of the domain backed by a file on the host.
+type DiskFile struct {
+	Target string `json:"target"`
+	Source string `json:"source"`
+}
+
+// DiskFiles returns the file backed disks and CD-ROMs of the domain. Empty
+// CD-ROM drives and disks backed by block devices or the network are left out.
+func (d *Domain) DiskFiles() []DiskFile {
+	var files []DiskFile
+	for _, disk := range d.Devices.Disks {
+		if disk.Type != "file" || disk.Source == nil || disk.Source.File == "" {
+			continue
+		}
+		files = append(files, DiskFile{Target: disk.Target.Dev, Source: disk.Source.File})
+	}
+	return files
+}
+
+// Address is the guest address of a device, a PCI slot for type "pci" and a
+// position on a disk controller for type "drive". Numbers are hex with a 0x
+// prefix or decimal, as libvirt writes them.
+type Address struct {
+	Type       string `xml:"type,attr"`
+	Domain     string `xml:"domain,attr,omitempty"`
+	Bus        string `xml:"bus,attr,omitempty"`
+	Slot       string `xml:"slot,attr,omitempty"`
+	Function   string `xml:"function,attr,omitempty"`
+	Controller string `xml:"controller,attr,omitempty"`
+	Target     string `xml:"target,attr,omitempty"`
+	Unit       string `xml:"unit,attr,omitempty"`
+}
+
+// PCI returns the PCI address of a "pci" address.
+func (a *Address) PCI() (PCIAddress, bool) {
+	if a == nil || a.Type != "pci" {
+		return PCIAddress{}, false
+	}
+	var fields [4]int
+	for i, s := range []string{a.Domain, a.Bus, a.Slot, a.Function} {
+		n, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			return PCIAddress{}, false
+		}
+		fields[i] = int(n)
+	}
+	return PCIAddress{Domain: fields[0], Bus: fields[1], Slot: fields[2], Function: fields[3]}, true
+}
+
+// Drive returns the bus, target and unit of a "drive" address.
+func (a *Address) Drive() (bus, target, unit int, ok bool) {
+	if a == nil || a.Type != "drive" {
+		return 0, 0, 0, false
+	}
+	var fields [3]int
+	for i, s := range []string{a.Bus, a.Target, a.Unit} {
+		// libvirt leaves out zero fields
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		fields[i] = int(n)
+	}
+	return fields[0], fields[1], fields[2], true
+}
@@ -0,0 +1,129 @@
+package domainxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pciAddressPattern matches [domain:]bus:slot.function, as printed by lspci -D.
+var pciAddressPattern = regexp.MustCompile(`^(?:([0-9a-fA-F]{4}):)?([0-9a-fA-F]{2}):([0-9a-fA-F]{2})\.([0-7])$`)
+
+// sysfsPCIDevices is where the kernel exposes PCI devices; swapped out in tests.
+var sysfsPCIDevices = "/sys/bus/pci/devices"
+
+// PCIAddress identifies a host PCI device.
+type PCIAddress struct {
+	Domain   int
+	Bus      int
+	Slot     int
+	Function int
+}
+
+// ParsePCIAddress parses an address such as "0000:01:00.0" or "01:00.0".
+func ParsePCIAddress(s string) (PCIAddress, error) {
+	m := pciAddressPattern.FindStringSubmatch(s)
+	if m == nil {
+		return PCIAddress{}, fmt.Errorf("invalid PCI address %q, expected the form 0000:01:00.0", s)
+	}
+	var addr PCIAddress
+	if m[1] != "" {
+		d, _ := strconv.ParseInt(m[1], 16, 32)
+		addr.Domain = int(d)
+	}
+	b, _ := strconv.ParseInt(m[2], 16, 32)
+	sl, _ := strconv.ParseInt(m[3], 16, 32)
+	f, _ := strconv.ParseInt(m[4], 10, 32)
+	addr.Bus, addr.Slot, addr.Function = int(b), int(sl), int(f)
+
+	// The slot is a 5 bit field
+	if addr.Slot > 0x1f {
+		return PCIAddress{}, fmt.Errorf("invalid PCI address %q, slot must be <= 1f", s)
+	}
+	return addr, nil
+}
+
+// String returns the address in the full form used by sysfs.
+func (a PCIAddress) String() string {
+	return fmt.Sprintf("%04x:%02x:%02x.%d", a.Domain, a.Bus, a.Slot, a.Function)
+}
+
+// HostDevice returns the hostdev element passing the device through.
+func (a PCIAddress) HostDevice() HostDev {
+	return HostDev{
+		Mode: "subsystem",
+		Type: "pci",
+		// The device is expected to be bound to vfio-pci already, libvirt
+		// must not rebind it to the host driver when the domain stops
+		Managed: "no",
+		Source: HostDevSource{Address: HostDevAddress{
+			Domain:   fmt.Sprintf("0x%04x", a.Domain),
+			Bus:      fmt.Sprintf("0x%02x", a.Bus),
+			Slot:     fmt.Sprintf("0x%02x", a.Slot),
+			Function: fmt.Sprintf("0x%x", a.Function),
+		}},
+	}
+}
+
+// HostDeviceXML returns the hostdev XML for virsh attach-device and detach-device.
+func HostDeviceXML(a PCIAddress) (string, error) {
+	out, err := xml.MarshalIndent(a.HostDevice(), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal hostdev XML: %w", err)
+	}
+	return string(out), nil
+}
+
+// CheckVFIO verifies the device and every other device in its IOMMU group are
+// bound to vfio-pci. The group is the smallest unit the IOMMU can isolate, so
+// qemu refuses to start when any member is still owned by a host driver.
+func CheckVFIO(a PCIAddress) error {
+	devDir := filepath.Join(sysfsPCIDevices, a.String())
+	if _, err := os.Stat(devDir); err != nil {
+		return fmt.Errorf("PCI device %s not found on the host", a)
+	}
+	if driver := boundDriver(devDir); driver != "vfio-pci" {
+		return fmt.Errorf("PCI device %s is bound to %s, it must be bound to vfio-pci for passthrough", a, driverOrNone(driver))
+	}
+
+	members, err := os.ReadDir(filepath.Join(devDir, "iommu_group", "devices"))
+	if err != nil {
+		return fmt.Errorf("PCI device %s has no IOMMU group, is the IOMMU enabled (intel_iommu=on / amd_iommu=on)?", a)
+	}
+	var unbound []string
+	for _, m := range members {
+		if m.Name() == a.String() {
+			continue
+		}
+		// Bridges in the group are fine without a driver, pci-stub is accepted by vfio too
+		switch driver := boundDriver(filepath.Join(sysfsPCIDevices, m.Name())); driver {
+		case "", "vfio-pci", "pci-stub", "pcieport":
+		default:
+			unbound = append(unbound, fmt.Sprintf("%s (%s)", m.Name(), driver))
+		}
+	}
+	if len(unbound) > 0 {
+		return fmt.Errorf("PCI device %s shares its IOMMU group with devices still bound to host drivers: %s; bind them to vfio-pci as well or pass the whole group through", a, strings.Join(unbound, ", "))
+	}
+	return nil
+}
+
+// boundDriver returns the name of the driver bound to a device, "" if none.
+func boundDriver(devDir string) string {
+	target, err := os.Readlink(filepath.Join(devDir, "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+func driverOrNone(driver string) string {
+	if driver == "" {
+		return "no driver"
+	}
+	return driver
+}
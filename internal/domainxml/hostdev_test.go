@@ -0,0 +1,154 @@
+package domainxml
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePCIAddress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PCIAddress
+		wantErr bool
+	}{
+		{in: "0000:01:00.0", want: PCIAddress{Bus: 1}},
+		{in: "01:00.1", want: PCIAddress{Bus: 1, Function: 1}},
+		{in: "0001:af:1f.7", want: PCIAddress{Domain: 1, Bus: 0xaf, Slot: 0x1f, Function: 7}},
+		{in: "0000:01:20.0", wantErr: true},
+		{in: "0000:01:00.8", wantErr: true},
+		{in: "1:00.0", wantErr: true},
+		{in: "0000:01:00.0; reboot", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePCIAddress(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSysfs creates a sysfs like tree where every device is in one IOMMU
+// group and bound to the given driver ("" for none).
+func fakeSysfs(t *testing.T, drivers map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	devices := filepath.Join(root, "devices")
+	group := filepath.Join(root, "iommu_groups", "1", "devices")
+	for _, dir := range []string{devices, group} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for dev, driver := range drivers {
+		devDir := filepath.Join(devices, dev)
+		if err := os.Mkdir(devDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Dir(group), filepath.Join(devDir, "iommu_group")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(devDir, filepath.Join(group, dev)); err != nil {
+			t.Fatal(err)
+		}
+		if driver != "" {
+			if err := os.Symlink(filepath.Join(root, "drivers", driver), filepath.Join(devDir, "driver")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return devices
+}
+
+func TestCheckVFIO(t *testing.T) {
+	original := sysfsPCIDevices
+	defer func() { sysfsPCIDevices = original }()
+
+	tests := []struct {
+		name    string
+		drivers map[string]string
+		wantErr string
+	}{
+		{
+			name:    "bound to vfio-pci",
+			drivers: map[string]string{"0000:01:00.0": "vfio-pci", "0000:01:00.1": "vfio-pci"},
+		},
+		{
+			name:    "bound to host driver",
+			drivers: map[string]string{"0000:01:00.0": "nvidia"},
+			wantErr: "bound to nvidia",
+		},
+		{
+			name:    "group member on host driver",
+			drivers: map[string]string{"0000:01:00.0": "vfio-pci", "0000:01:00.1": "snd_hda_intel"},
+			wantErr: "0000:01:00.1 (snd_hda_intel)",
+		},
+		{
+			name:    "device missing",
+			drivers: map[string]string{"0000:02:00.0": "vfio-pci"},
+			wantErr: "not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sysfsPCIDevices = fakeSysfs(t, tt.drivers)
+			err := CheckVFIO(PCIAddress{Bus: 1})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBuildHostDevices(t *testing.T) {
+	original := checkVFIO
+	defer func() { checkVFIO = original }()
+	checkVFIO = func(PCIAddress) error { return nil }
+
+	out, err := Build(DomainSpec{Name: "vm", MemoryMB: 1024, VCPUs: 1, HostDevices: []string{"01:00.0"}})
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+	want := []HostDev{{
+		Mode:    "subsystem",
+		Type:    "pci",
+		Managed: "no",
+		Source:  HostDevSource{Address: HostDevAddress{Domain: "0x0000", Bus: "0x01", Slot: "0x00", Function: "0x0"}},
+	}}
+	for i := range domain.Devices.HostDevs {
+		domain.Devices.HostDevs[i].XMLName.Local = ""
+	}
+	if !reflect.DeepEqual(domain.Devices.HostDevs, want) {
+		t.Errorf("unexpected hostdev devices: %+v\n%s", domain.Devices.HostDevs, out)
+	}
+
+	if _, err := Build(DomainSpec{Name: "vm", MemoryMB: 1024, VCPUs: 1, HostDevices: []string{"01:00.0", "0000:01:00.0"}}); err == nil {
+		t.Error("expected duplicate host devices to be rejected")
+	}
+}
@@ -0,0 +1,28 @@
+package domainxml
+
+import (
+	"fmt"
+	"strings"
+)
+
+// memoryUnits maps the libvirt memory units to bytes.
+var memoryUnits = map[string]int64{
+	"b": 1, "bytes": 1,
+	"kb": 1000, "k": 1 << 10, "kib": 1 << 10,
+	"mb": 1000 * 1000, "m": 1 << 20, "mib": 1 << 20,
+	"gb": 1000 * 1000 * 1000, "g": 1 << 30, "gib": 1 << 30,
+	"tb": 1000 * 1000 * 1000 * 1000, "t": 1 << 40, "tib": 1 << 40,
+}
+
+// MiB returns the memory size in MiB. libvirt defaults to KiB without a unit.
+func (m Memory) MiB() (int64, error) {
+	unit := strings.ToLower(m.Unit)
+	if unit == "" {
+		unit = "kib"
+	}
+	factor, ok := memoryUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown memory unit %q", m.Unit)
+	}
+	return int64(m.Value) * factor >> 20, nil
+}
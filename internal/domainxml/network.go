@@ -0,0 +1,81 @@
+package domainxml
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultNetwork is the libvirt network interfaces join when neither the spec
+// nor DEFAULT_NETWORK or DEFAULT_BRIDGE name one.
+const defaultNetwork = "default"
+
+// sysfsNet lists the host network devices; swapped out in tests.
+var sysfsNet = "/sys/class/net"
+
+// interfaceSourcePattern matches libvirt network and host bridge names.
+var interfaceSourcePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// DefaultInterfaceSource returns what interfaces without a network or bridge
+// connect to: DEFAULT_BRIDGE when set, else DEFAULT_NETWORK, else the
+// "default" libvirt network.
+func DefaultInterfaceSource() (network, bridge string) {
+	if bridge := strings.TrimSpace(os.Getenv("DEFAULT_BRIDGE")); bridge != "" {
+		return "", bridge
+	}
+	if network := strings.TrimSpace(os.Getenv("DEFAULT_NETWORK")); network != "" {
+		return network, ""
+	}
+	return defaultNetwork, ""
+}
+
+// CheckBridge verifies the host has a bridge named name, libvirt only
+// notices a missing one when the domain starts.
+func CheckBridge(name string) error {
+	dir := filepath.Join(sysfsNet, name)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("bridge %q doesn't exist on this host", name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bridge")); err != nil {
+		return fmt.Errorf("host interface %q isn't a bridge", name)
+	}
+	return nil
+}
+
+func (n InterfaceSpec) validate() error {
+	if n.Network != "" && n.Bridge != "" {
+		return fmt.Errorf("network and bridge are mutually exclusive")
+	}
+	// applyDefaults fills in the default after validation, check it here
+	from := ""
+	if n.Network == "" && n.Bridge == "" {
+		n.Network, n.Bridge = DefaultInterfaceSource()
+		from = " (from DEFAULT_NETWORK or DEFAULT_BRIDGE)"
+	}
+	for _, name := range []string{n.Network, n.Bridge} {
+		if name != "" && !interfaceSourcePattern.MatchString(name) {
+			return fmt.Errorf("%q%s is not a valid network or bridge name", name, from)
+		}
+	}
+	return nil
+}
+
+// Networks returns the libvirt networks and host bridges the interfaces of
+// the spec join, defaults included, so callers can check they exist before
+// defining.
+func (s DomainSpec) Networks() (networks, bridges []string) {
+	for _, n := range s.Interfaces {
+		if n.Network == "" && n.Bridge == "" {
+			n.Network, n.Bridge = DefaultInterfaceSource()
+		}
+		if n.Network != "" {
+			networks = append(networks, n.Network)
+		}
+		if n.Bridge != "" {
+			bridges = append(bridges, n.Bridge)
+		}
+	}
+	return networks, bridges
+}
@@ -0,0 +1,89 @@
+package domainxml
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildInterfaces(t *testing.T) {
+	type source struct{ typ, network, bridge string }
+	tests := []struct {
+		name           string
+		defaultNetwork string
+		defaultBridge  string
+		interfaces     []InterfaceSpec
+		want           []source
+		wantErr        string
+	}{
+		{"default network", "", "", []InterfaceSpec{{}}, []source{{"network", "default", ""}}, ""},
+		{"DEFAULT_NETWORK", "lan", "", []InterfaceSpec{{}}, []source{{"network", "lan", ""}}, ""},
+		{"DEFAULT_BRIDGE", "lan", "br0", []InterfaceSpec{{}, {Network: "isolated"}}, []source{{"bridge", "", "br0"}, {"network", "isolated", ""}}, ""},
+		{"explicit bridge", "", "", []InterfaceSpec{{Bridge: "br1"}}, []source{{"bridge", "", "br1"}}, ""},
+		{"network and bridge", "", "", []InterfaceSpec{{Network: "default", Bridge: "br0"}}, nil, "mutually exclusive"},
+		{"invalid name", "", "", []InterfaceSpec{{Bridge: "br0'/>"}}, nil, "not a valid"},
+		{"invalid DEFAULT_BRIDGE", "", "br0'/>", []InterfaceSpec{{}}, nil, `interfaces[0]: "br0'/>" (from DEFAULT_NETWORK or DEFAULT_BRIDGE) is not a valid`},
+		{"invalid DEFAULT_NETWORK", "lan net", "", []InterfaceSpec{{Network: "isolated"}, {}}, nil, "interfaces[1]:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_NETWORK", tt.defaultNetwork)
+			t.Setenv("DEFAULT_BRIDGE", tt.defaultBridge)
+
+			out, err := Build(DomainSpec{Name: "vm-1", MemoryMB: 1024, VCPUs: 1, Interfaces: tt.interfaces})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Build() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build returned error: %v", err)
+			}
+			domain, err := Parse([]byte(out))
+			if err != nil {
+				t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+			}
+			var got []source
+			for _, iface := range domain.Devices.Interfaces {
+				got = append(got, source{iface.Type, iface.Source.Network, iface.Source.Bridge})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("interfaces = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	t.Setenv("DEFAULT_NETWORK", "lan")
+	t.Setenv("DEFAULT_BRIDGE", "")
+	spec := DomainSpec{Interfaces: []InterfaceSpec{{}, {Network: "isolated"}, {Bridge: "br0"}}}
+	networks, bridges := spec.Networks()
+	if want := []string{"lan", "isolated"}; !reflect.DeepEqual(networks, want) {
+		t.Errorf("networks = %v, want %v", networks, want)
+	}
+	if want := []string{"br0"}; !reflect.DeepEqual(bridges, want) {
+		t.Errorf("bridges = %v, want %v", bridges, want)
+	}
+}
+
+func TestCheckBridge(t *testing.T) {
+	sysfsNet = t.TempDir()
+	defer func() { sysfsNet = "/sys/class/net" }()
+	for _, dir := range []string{"br0/bridge", "eth0"} {
+		if err := os.MkdirAll(filepath.Join(sysfsNet, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{"br0": "", "br9": `bridge "br9" doesn't exist`, "eth0": `"eth0" isn't a bridge`}
+	for name, wantErr := range tests {
+		err := CheckBridge(name)
+		if (err == nil) != (wantErr == "") || (err != nil && !strings.Contains(err.Error(), wantErr)) {
+			t.Errorf("CheckBridge(%q) = %v, want %q", name, err, wantErr)
+		}
+	}
+}
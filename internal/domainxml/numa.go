@@ -0,0 +1,163 @@
+package domainxml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Supported numatune memory modes.
+const (
+	NUMAStrict      = "strict"
+	NUMAPreferred   = "preferred"
+	NUMAInterleave  = "interleave"
+	NUMARestrictive = "restrictive"
+)
+
+// maxHostNodes bounds the host NUMA node IDs of a nodeset, Linux supports
+// at most 1024 nodes.
+const maxHostNodes = 1024
+
+// NUMACellSpec is a guest NUMA cell. The cells of a spec partition its vCPUs
+// and memory, cell i is numbered i inside the guest.
+type NUMACellSpec struct {
+	CPUs     string `json:"cpus"`      // vCPUs of the cell, e.g. "0-3" or "0,2,4-5"
+	MemoryMB int    `json:"memory_mb"` // Memory of the cell, the cells add up to memory_mb
+}
+
+// NUMATuneSpec pins the guest memory to host NUMA nodes.
+type NUMATuneSpec struct {
+	Mode    string           `json:"mode,omitempty"`    // strict (default), preferred, interleave or restrictive
+	Nodeset string           `json:"nodeset,omitempty"` // Host nodes of all guest memory, e.g. "0-1"
+	Cells   []MemoryNodeSpec `json:"cells,omitempty"`   // Host nodes of single guest cells
+}
+
+// MemoryNodeSpec pins the memory of one guest NUMA cell to host nodes.
+type MemoryNodeSpec struct {
+	Cell    int    `json:"cell"`
+	Mode    string `json:"mode,omitempty"` // strict (default), preferred, interleave or restrictive
+	Nodeset string `json:"nodeset"`
+}
+
+// validateNUMA checks that the cells account for every vCPU exactly once and
+// for all of the memory.
+func validateNUMA(cells []NUMACellSpec, vcpus, memoryMB int) error {
+	owner := make(map[int]int)
+	total := 0
+	for i, cell := range cells {
+		ids, err := parseIDList(cell.CPUs, vcpus)
+		if err != nil {
+			return fmt.Errorf("numa[%d]: cpus: %w", i, err)
+		}
+		for _, id := range ids {
+			if other, ok := owner[id]; ok {
+				return fmt.Errorf("numa[%d]: vCPU %d is already in cell %d", i, id, other)
+			}
+			owner[id] = i
+		}
+		if cell.MemoryMB <= 0 {
+			return fmt.Errorf("numa[%d]: memory_mb must be > 0", i)
+		}
+		total += cell.MemoryMB
+	}
+	if len(owner) != vcpus {
+		return fmt.Errorf("numa: the cells hold %d of %d vCPUs", len(owner), vcpus)
+	}
+	if total != memoryMB {
+		return fmt.Errorf("numa: the cells hold %d MiB but memory_mb is %d", total, memoryMB)
+	}
+	return nil
+}
+
+func (t NUMATuneSpec) validate(cells int) error {
+	if err := validateNUMAMode(t.Mode); err != nil {
+		return err
+	}
+	if t.Nodeset != "" {
+		if _, err := parseIDList(t.Nodeset, maxHostNodes); err != nil {
+			return fmt.Errorf("nodeset: %w", err)
+		}
+	} else if t.Mode != "" {
+		return fmt.Errorf("mode requires a nodeset")
+	}
+	if t.Nodeset == "" && len(t.Cells) == 0 {
+		return fmt.Errorf("nodeset or cells is required")
+	}
+
+	seen := make(map[int]bool)
+	for i, n := range t.Cells {
+		if n.Cell < 0 || n.Cell >= cells {
+			return fmt.Errorf("cells[%d]: cell %d is not a guest NUMA cell", i, n.Cell)
+		}
+		if seen[n.Cell] {
+			return fmt.Errorf("cells[%d]: cell %d is listed more than once", i, n.Cell)
+		}
+		seen[n.Cell] = true
+		if err := validateNUMAMode(n.Mode); err != nil {
+			return fmt.Errorf("cells[%d]: %w", i, err)
+		}
+		if _, err := parseIDList(n.Nodeset, maxHostNodes); err != nil {
+			return fmt.Errorf("cells[%d]: nodeset: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateNUMAMode(mode string) error {
+	switch mode {
+	case "", NUMAStrict, NUMAPreferred, NUMAInterleave, NUMARestrictive:
+		return nil
+	}
+	return fmt.Errorf("mode must be one of strict, preferred, interleave or restrictive")
+}
+
+// parseIDList returns the IDs of a CPU or node list such as "0-3,6". IDs
+// must be below limit, which also bounds the list a range can expand to.
+func parseIDList(list string, limit int) ([]int, error) {
+	if list == "" {
+		return nil, fmt.Errorf("is required")
+	}
+	var ids []int
+	for _, part := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid list %q, expected the form 0-3,6", list)
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("invalid list %q, expected the form 0-3,6", list)
+		}
+		if end >= limit {
+			return nil, fmt.Errorf("%d is out of range, IDs must be below %d", end, limit)
+		}
+		for id := start; id <= end; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// buildNUMA returns the numa element of the guest cells.
+func buildNUMA(cells []NUMACellSpec) *NUMA {
+	numa := &NUMA{}
+	for i, cell := range cells {
+		numa.Cells = append(numa.Cells, NUMACell{ID: i, CPUs: cell.CPUs, Memory: cell.MemoryMB, Unit: "MiB"})
+	}
+	return numa
+}
+
+// buildNUMATune returns the numatune element of the spec.
+func buildNUMATune(t NUMATuneSpec) *NUMATune {
+	tune := &NUMATune{}
+	if t.Nodeset != "" {
+		tune.Memory = &NUMAMemory{Mode: t.Mode, Nodeset: t.Nodeset}
+	}
+	for _, n := range t.Cells {
+		tune.MemNodes = append(tune.MemNodes, MemNode{CellID: n.Cell, Mode: n.Mode, Nodeset: n.Nodeset})
+	}
+	return tune
+}
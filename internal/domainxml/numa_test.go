@@ -0,0 +1,108 @@
+package domainxml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildNUMA(t *testing.T) {
+	spec := DomainSpec{
+		Name:     "vm-1",
+		MemoryMB: 8192,
+		VCPUs:    8,
+		CPU:      &CPUSpec{Mode: CPUHostPassthrough, Sockets: 2, Cores: 4, Threads: 1},
+		NUMA: []NUMACellSpec{
+			{CPUs: "0-3", MemoryMB: 4096},
+			{CPUs: "4-7", MemoryMB: 4096},
+		},
+		NUMATune: &NUMATuneSpec{
+			Mode:    NUMAStrict,
+			Nodeset: "0-1",
+			Cells:   []MemoryNodeSpec{{Cell: 0, Nodeset: "0"}, {Cell: 1, Mode: NUMAPreferred, Nodeset: "1"}},
+		},
+	}
+
+	out, err := Build(spec)
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+
+	if domain.CPU == nil || domain.CPU.Mode != CPUHostPassthrough || domain.CPU.NUMA == nil {
+		t.Fatalf("unexpected cpu element: %+v", domain.CPU)
+	}
+	wantCells := []NUMACell{
+		{ID: 0, CPUs: "0-3", Memory: 4096, Unit: "MiB"},
+		{ID: 1, CPUs: "4-7", Memory: 4096, Unit: "MiB"},
+	}
+	if !reflect.DeepEqual(domain.CPU.NUMA.Cells, wantCells) {
+		t.Errorf("numa cells = %+v, want %+v", domain.CPU.NUMA.Cells, wantCells)
+	}
+	wantTune := &NUMATune{
+		Memory:   &NUMAMemory{Mode: NUMAStrict, Nodeset: "0-1"},
+		MemNodes: []MemNode{{CellID: 0, Nodeset: "0"}, {CellID: 1, Mode: NUMAPreferred, Nodeset: "1"}},
+	}
+	if !reflect.DeepEqual(domain.NUMATune, wantTune) {
+		t.Errorf("numatune = %+v, want %+v", domain.NUMATune, wantTune)
+	}
+}
+
+func TestBuildNUMAWithoutCPU(t *testing.T) {
+	out, err := Build(DomainSpec{Name: "vm", MemoryMB: 2048, VCPUs: 2, NUMA: []NUMACellSpec{{CPUs: "0", MemoryMB: 1024}, {CPUs: "1", MemoryMB: 1024}}})
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	if !strings.Contains(out, "<cpu>") {
+		t.Errorf("expected a cpu element without a mode:\n%s", out)
+	}
+	domain, _ := Parse([]byte(out))
+	if domain.CPU == nil || domain.CPU.NUMA == nil || len(domain.CPU.NUMA.Cells) != 2 || domain.NUMATune != nil {
+		t.Errorf("unexpected cpu element: %+v", domain.CPU)
+	}
+}
+
+func TestBuildRejectsInvalidNUMA(t *testing.T) {
+	cases := map[string]struct {
+		cells []NUMACellSpec
+		tune  *NUMATuneSpec
+		want  string
+	}{
+		"memory mismatch": {cells: []NUMACellSpec{{CPUs: "0-1", MemoryMB: 1024}, {CPUs: "2-3", MemoryMB: 512}}, want: "numa:"},
+		"missing vcpu":    {cells: []NUMACellSpec{{CPUs: "0-1", MemoryMB: 1024}, {CPUs: "2", MemoryMB: 1024}}, want: "numa:"},
+		"overlap":         {cells: []NUMACellSpec{{CPUs: "0-2", MemoryMB: 1024}, {CPUs: "2-3", MemoryMB: 1024}}, want: "numa[1]:"},
+		"out of range":    {cells: []NUMACellSpec{{CPUs: "0-1", MemoryMB: 1024}, {CPUs: "2-4", MemoryMB: 1024}}, want: "numa[1]:"},
+		"bad cpu list":    {cells: []NUMACellSpec{{CPUs: "0-1", MemoryMB: 1024}, {CPUs: "3-2", MemoryMB: 1024}}, want: "numa[1]:"},
+		"empty cell":      {cells: []NUMACellSpec{{CPUs: "0-3", MemoryMB: 2048}, {CPUs: "", MemoryMB: 0}}, want: "numa[1]:"},
+		"unknown mode":    {tune: &NUMATuneSpec{Mode: "auto", Nodeset: "0"}, want: "numatune:"},
+		"mode w/o nodes":  {tune: &NUMATuneSpec{Mode: NUMAStrict}, want: "numatune:"},
+		"bad nodeset":     {tune: &NUMATuneSpec{Nodeset: "0,a"}, want: "numatune:"},
+		"huge nodeset":    {tune: &NUMATuneSpec{Nodeset: "0-2147483646"}, want: "numatune:"},
+		"huge cpu range":  {cells: []NUMACellSpec{{CPUs: "0-1", MemoryMB: 1024}, {CPUs: "2-2147483646", MemoryMB: 1024}}, want: "numa[1]:"},
+		"cell w/o numa":   {tune: &NUMATuneSpec{Cells: []MemoryNodeSpec{{Cell: 0, Nodeset: "0"}}}, want: "numatune:"},
+		"unknown cell": {
+			cells: []NUMACellSpec{{CPUs: "0-1", MemoryMB: 1024}, {CPUs: "2-3", MemoryMB: 1024}},
+			tune:  &NUMATuneSpec{Cells: []MemoryNodeSpec{{Cell: 2, Nodeset: "0"}}},
+			want:  "numatune:",
+		},
+		"duplicate cell": {
+			cells: []NUMACellSpec{{CPUs: "0-1", MemoryMB: 1024}, {CPUs: "2-3", MemoryMB: 1024}},
+			tune:  &NUMATuneSpec{Cells: []MemoryNodeSpec{{Cell: 1, Nodeset: "0"}, {Cell: 1, Nodeset: "1"}}},
+			want:  "numatune:",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := Build(DomainSpec{Name: "vm", MemoryMB: 2048, VCPUs: 4, NUMA: tc.cells, NUMATune: tc.tune})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.HasPrefix(err.Error(), tc.want) {
+				t.Errorf("error should start with %q, got %q", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package domainxml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+)
+
+// uuidPattern matches the UUIDs libvirt assigns to secrets.
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsUUID reports whether s is a UUID such as a libvirt secret's.
+func IsUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
+
+// Secret is a libvirt secret definition. The value is set separately.
+type Secret struct {
+	XMLName     xml.Name `xml:"secret"`
+	Ephemeral   string   `xml:"ephemeral,attr"`
+	Private     string   `xml:"private,attr"`
+	Description string   `xml:"description,omitempty"`
+}
+
+// SecretXML returns the definition of a persistent secret. The value of a
+// private secret can't be read back through libvirt.
+func SecretXML(description string, private bool) (string, error) {
+	secret := Secret{Ephemeral: "no", Private: "no", Description: description}
+	if private {
+		secret.Private = "yes"
+	}
+	out, err := xml.MarshalIndent(secret, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal secret XML: %w", err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,128 @@
+package domainxml
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"libvirt-controller/internal/config"
+)
+
+// maxMountTagLength is the longest mount tag the virtio-fs device accepts.
+const maxMountTagLength = 36
+
+// virtiofsdPaths are where distributions install virtiofsd outside of PATH;
+// swapped out in tests.
+var virtiofsdPaths = []string{"/usr/libexec/virtiofsd", "/usr/lib/qemu/virtiofsd", "/usr/lib/virtiofsd"}
+
+// SharedDirSpec shares a host directory with the guest over virtio-fs. The
+// guest mounts it with `mount -t virtiofs <tag> <mountpoint>`.
+type SharedDirSpec struct {
+	Source string `json:"source"` // Host directory, must be under SHARED_DIR_BASES
+	Tag    string `json:"tag"`    // Mount tag seen by the guest
+}
+
+func (s SharedDirSpec) Validate() error {
+	if s.Source == "" {
+		return fmt.Errorf("source is required")
+	}
+	if !filepath.IsAbs(s.Source) {
+		return fmt.Errorf("source must be an absolute path")
+	}
+	if s.Tag == "" {
+		return fmt.Errorf("tag is required")
+	}
+	if len(s.Tag) > maxMountTagLength {
+		return fmt.Errorf("tag must be at most %d characters", maxMountTagLength)
+	}
+	if strings.ContainsFunc(s.Tag, func(r rune) bool { return r <= ' ' || r > '~' }) {
+		return fmt.Errorf("tag must be printable ASCII without spaces")
+	}
+	return nil
+}
+
+// Filesystem returns the filesystem element of the share.
+func (s SharedDirSpec) Filesystem() Filesystem {
+	return Filesystem{
+		Type:       "mount",
+		AccessMode: "passthrough",
+		Driver:     &FilesystemDriver{Type: "virtiofs"},
+		Source:     FilesystemSource{Dir: s.Source},
+		Target:     FilesystemTarget{Dir: s.Tag},
+	}
+}
+
+// FilesystemXML returns the filesystem XML for virsh attach-device and detach-device.
+func FilesystemXML(fs Filesystem) (string, error) {
+	out, err := xml.MarshalIndent(fs, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal filesystem XML: %w", err)
+	}
+	return string(out), nil
+}
+
+// sharedMemoryBacking is the memory backing virtio-fs requires.
+func sharedMemoryBacking() *MemoryBacking {
+	return &MemoryBacking{Source: &MemorySource{Type: "memfd"}, Access: &MemoryAccess{Mode: "shared"}}
+}
+
+// HasSharedMemory reports whether the guest memory is shared with the host,
+// without which qemu can't start a virtio-fs device.
+func (d *Domain) HasSharedMemory() bool {
+	return d.MemoryBacking != nil && d.MemoryBacking.Access != nil && d.MemoryBacking.Access.Mode == "shared"
+}
+
+// FindFilesystem returns the filesystem mounted by tag.
+func (d *Domain) FindFilesystem(tag string) (Filesystem, bool) {
+	for _, fs := range d.Devices.Filesystems {
+		if fs.Target.Dir == tag {
+			return fs, true
+		}
+	}
+	return Filesystem{}, false
+}
+
+// CheckSharedDir verifies source is an existing directory inside one of the
+// comma separated SHARED_DIR_BASES. Symlinks are resolved first so they can't
+// point a share outside of the bases. Nothing can be shared without bases.
+func CheckSharedDir(source string) error {
+	bases := config.List("SHARED_DIR_BASES")
+	if len(bases) == 0 {
+		return errors.New("sharing host directories is disabled, set SHARED_DIR_BASES to allow it")
+	}
+
+	resolved, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return fmt.Errorf("directory %s not found on the host", source)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", source)
+	}
+	for _, base := range bases {
+		base, err := filepath.EvalSymlinks(base)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(base, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is outside of SHARED_DIR_BASES", source)
+}
+
+// CheckVirtiofsd verifies the virtiofsd daemon libvirt starts for every share
+// is installed. It's packaged separately from qemu by most distributions.
+func CheckVirtiofsd() error {
+	if _, err := lookPath("virtiofsd"); err == nil {
+		return nil
+	}
+	for _, path := range virtiofsdPaths {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+	return errors.New("virtio-fs requires virtiofsd to be installed on the host")
+}
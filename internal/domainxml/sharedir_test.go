@@ -0,0 +1,107 @@
+package domainxml
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildSharedDirs(t *testing.T) {
+	originalLookPath, originalCheck := lookPath, checkSharedDir
+	defer func() { lookPath, checkSharedDir = originalLookPath, originalCheck }()
+	lookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	checkSharedDir = func(source string) error { return nil }
+
+	share := SharedDirSpec{Source: "/srv/share/src", Tag: "src"}
+	out, err := Build(DomainSpec{Name: "vm", MemoryMB: 2048, VCPUs: 2, SharedDirs: []SharedDirSpec{share}})
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	domain, err := Parse([]byte(out))
+	if err != nil {
+		t.Fatalf("generated XML does not parse: %v\n%s", err, out)
+	}
+	if !domain.HasSharedMemory() {
+		t.Errorf("shared dirs need shared memory backing, got %+v", domain.MemoryBacking)
+	}
+	fs, ok := domain.FindFilesystem("src")
+	if !ok || fs.Type != "mount" || fs.AccessMode != "passthrough" || fs.Driver == nil || fs.Driver.Type != "virtiofs" || fs.Source.Dir != "/srv/share/src" {
+		t.Errorf("unexpected filesystem %+v in\n%s", fs, out)
+	}
+
+	out, _ = Build(DomainSpec{Name: "vm", MemoryMB: 2048, VCPUs: 2})
+	if domain, _ := Parse([]byte(out)); domain.HasSharedMemory() || len(domain.Devices.Filesystems) != 0 {
+		t.Errorf("expected private memory and no shares unless requested:\n%s", out)
+	}
+	out, _ = Build(DomainSpec{Name: "vm", MemoryMB: 2048, VCPUs: 2, SharedMemory: true})
+	if domain, _ := Parse([]byte(out)); !domain.HasSharedMemory() {
+		t.Errorf("shared_memory must enable shared memory backing:\n%s", out)
+	}
+
+	duplicate := DomainSpec{Name: "vm", MemoryMB: 2048, VCPUs: 2, SharedDirs: []SharedDirSpec{share, share}}
+	if _, err := Build(duplicate); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("expected a duplicate tag error, got %v", err)
+	}
+}
+
+func TestFilesystemXMLRoundTrip(t *testing.T) {
+	want := SharedDirSpec{Source: "/srv/share", Tag: "data"}.Filesystem()
+	out, err := FilesystemXML(want)
+	if err != nil {
+		t.Fatalf("FilesystemXML() error = %v", err)
+	}
+	var got Filesystem
+	if err := xml.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("filesystem XML does not parse: %v\n%s", err, out)
+	}
+	got.XMLName = xml.Name{}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %+v, want %+v\n%s", got, want, out)
+	}
+}
+
+func TestCheckSharedDir(t *testing.T) {
+	base := t.TempDir()
+	outside := t.TempDir()
+	inside := filepath.Join(base, "project")
+	if err := os.Mkdir(inside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	escape := filepath.Join(base, "escape")
+	if err := os.Symlink(outside, escape); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SHARED_DIR_BASES", "")
+	if err := CheckSharedDir(inside); err == nil {
+		t.Error("expected sharing to be disabled without SHARED_DIR_BASES")
+	}
+
+	t.Setenv("SHARED_DIR_BASES", base)
+	tests := []struct {
+		name    string
+		source  string
+		wantErr bool
+	}{
+		{"inside", inside, false},
+		{"base itself", base, false},
+		{"outside", outside, true},
+		{"symlink out of the base", escape, true},
+		{"missing", filepath.Join(base, "missing"), true},
+		{"file", file, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckSharedDir(tt.source); (err != nil) != tt.wantErr {
+				t.Errorf("CheckSharedDir(%s) error = %v, wantErr %t", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package domainxml
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Supported disk buses and cache modes.
+const (
+	BusVirtio = "virtio"
+	BusSATA   = "sata"
+	BusSCSI   = "scsi"
+
+	CacheNone         = "none"
+	CacheWriteback    = "writeback"
+	CacheWritethrough = "writethrough"
+)
+
+// maxVirtioSerialLen is the longest serial a virtio disk reports in full.
+const maxVirtioSerialLen = 20
+
+var (
+	// Characters libvirt accepts in a disk serial
+	diskSerialPattern = regexp.MustCompile(`^[A-Za-z0-9_ .+-]+$`)
+	wwnPattern        = regexp.MustCompile(`^(0x)?[0-9a-fA-F]{16}$`)
+)
+
+// Supported firmware types.
+const (
+	FirmwareBIOS = "bios"
+	FirmwareEFI  = "efi"
+)
+
+// Supported CPU modes.
+const (
+	CPUHostPassthrough = "host-passthrough"
+	CPUHostModel       = "host-model"
+	CPUCustom          = "custom"
+)
+
+// DomainSpec is the high level description of a domain used to generate XML.
+type DomainSpec struct {
+	Name         string          `json:"name"`
+	MemoryMB     int             `json:"memory_mb"`
+	VCPUs        int             `json:"vcpus"`
+	Arch         string          `json:"arch,omitempty"`
+	Machine      string          `json:"machine,omitempty"`
+	CPU          *CPUSpec        `json:"cpu,omitempty"`          // hypervisor default CPU when nil
+	NUMA         []NUMACellSpec  `json:"numa,omitempty"`         // guest NUMA cells, one cell when empty
+	NUMATune     *NUMATuneSpec   `json:"numatune,omitempty"`     // host NUMA nodes the guest memory is allocated on
+	Firmware     string          `json:"firmware,omitempty"`     // bios (default) or efi
+	SecureBoot   bool            `json:"secure_boot,omitempty"`  // efi only
+	Loader       string          `json:"loader,omitempty"`       // OVMF code path, auto-selected by libvirt when empty
+	NVRAM        string          `json:"nvram,omitempty"`        // per-domain variable store, created by libvirt when empty
+	TPM          bool            `json:"tpm,omitempty"`          // emulated TPM 2.0, needs swtpm on the host
+	RNG          bool            `json:"rng,omitempty"`          // virtio-rng fed from the host's /dev/urandom
+	Serial       *bool           `json:"serial,omitempty"`       // pty serial console, on unless set to false
+	ConsoleLog   string          `json:"console_log,omitempty"`  // file the serial console output is also written to
+	HostDevices  []string        `json:"host_devices,omitempty"` // PCI addresses to pass through, bound to vfio-pci
+	SharedDirs   []SharedDirSpec `json:"shared_dirs,omitempty"`  // virtio-fs shares, need virtiofsd on the host
+	Disks        []DiskSpec      `json:"disks"`
+	Interfaces   []InterfaceSpec `json:"interfaces,omitempty"`
+	CloudInitISO string          `json:"cloud_init_iso,omitempty"`
+	Graphics     string          `json:"graphics,omitempty"` // vnc (default), spice or none
+
+	// SharedMemory shares the guest memory with the host so shared dirs can
+	// be hotplugged later. It's implied by SharedDirs.
+	SharedMemory bool `json:"shared_memory,omitempty"`
+}
+
+// CPUSpec selects the guest CPU model and topology.
+type CPUSpec struct {
+	Mode    string `json:"mode,omitempty"`  // host-model (default), host-passthrough or custom
+	Model   string `json:"model,omitempty"` // named model such as "Skylake-Server", custom mode only
+	Sockets int    `json:"sockets,omitempty"`
+	Cores   int    `json:"cores,omitempty"`
+	Threads int    `json:"threads,omitempty"`
+}
+
+// DiskSpec describes a file backed disk attached to the domain.
+type DiskSpec struct {
+	Path   string `json:"path"`
+	Format string `json:"format,omitempty"` // defaults to qcow2
+	Target string `json:"target,omitempty"` // assigned from the bus when empty
+	Bus    string `json:"bus,omitempty"`    // virtio (default), sata or scsi
+	Cache  string `json:"cache,omitempty"`  // none (default), writeback or writethrough
+	Secret string `json:"secret,omitempty"` // UUID of the libvirt secret a LUKS encrypted qcow2 image is unlocked with
+	Serial string `json:"serial,omitempty"` // shown in /dev/disk/by-id inside the guest
+	WWN    string `json:"wwn,omitempty"`    // 16 hex digits, sata and scsi only
+}
+
+// InterfaceSpec describes a network interface attached to the domain.
+type InterfaceSpec struct {
+	Network string `json:"network,omitempty"` // libvirt network, see DefaultInterfaceSource when neither it nor bridge is set
+	Bridge  string `json:"bridge,omitempty"`  // host bridge, instead of a network
+	MAC     string `json:"mac,omitempty"`
+	Model   string `json:"model,omitempty"` // defaults to virtio
+}
+
+// applyDefaults fills in unset optional fields.
+func (s *DomainSpec) applyDefaults() {
+	if s.Arch == "" {
+		s.Arch = "x86_64"
+	}
+	if s.Graphics == "" {
+		s.Graphics = "vnc"
+	}
+	if s.Firmware == "" {
+		s.Firmware = FirmwareBIOS
+	}
+	if s.Serial == nil {
+		// Without a serial console there is no boot output to look at
+		serial := true
+		s.Serial = &serial
+	}
+	if s.SecureBoot && s.Machine == "" {
+		// SMM, and with it Secure Boot, is only available on q35
+		s.Machine = "q35"
+	}
+	if s.CPU != nil && s.CPU.Mode == "" {
+		s.CPU.Mode = CPUHostModel
+	}
+	for i := range s.Disks {
+		d := &s.Disks[i]
+		if d.Format == "" {
+			d.Format = "qcow2"
+		}
+		if d.Bus == "" {
+			d.Bus = BusVirtio
+		}
+		if d.Cache == "" {
+			d.Cache = CacheNone
+		}
+	}
+	for i := range s.Interfaces {
+		n := &s.Interfaces[i]
+		if n.Network == "" && n.Bridge == "" {
+			n.Network, n.Bridge = DefaultInterfaceSource()
+		}
+		if n.Model == "" {
+			n.Model = "virtio"
+		}
+	}
+}
+
+// Validate checks the spec for missing or inconsistent values.
+func (s *DomainSpec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if s.MemoryMB <= 0 {
+		return fmt.Errorf("memory_mb must be > 0")
+	}
+	if s.VCPUs <= 0 {
+		return fmt.Errorf("vcpus must be > 0")
+	}
+	switch s.Graphics {
+	case "", "vnc", "spice", "none":
+	default:
+		return fmt.Errorf("graphics must be one of vnc, spice or none")
+	}
+
+	switch s.Firmware {
+	case "", FirmwareBIOS:
+		if s.SecureBoot {
+			return fmt.Errorf("secure_boot requires firmware %q", FirmwareEFI)
+		}
+		if s.Loader != "" || s.NVRAM != "" {
+			return fmt.Errorf("loader and nvram require firmware %q", FirmwareEFI)
+		}
+	case FirmwareEFI:
+		if s.NVRAM != "" && s.Loader == "" {
+			return fmt.Errorf("nvram requires an explicit loader")
+		}
+		if s.SecureBoot && s.Machine != "" && !strings.Contains(s.Machine, "q35") {
+			return fmt.Errorf("secure_boot requires a q35 machine type, got %q", s.Machine)
+		}
+	default:
+		return fmt.Errorf("firmware must be bios or efi")
+	}
+	if s.ConsoleLog != "" {
+		if s.Serial != nil && !*s.Serial {
+			return fmt.Errorf("console_log requires the serial console")
+		}
+		if !filepath.IsAbs(s.ConsoleLog) {
+			return fmt.Errorf("console_log must be an absolute path")
+		}
+	}
+	if s.CPU != nil {
+		if err := s.CPU.validate(s.VCPUs); err != nil {
+			return fmt.Errorf("cpu: %w", err)
+		}
+	}
+	if len(s.NUMA) > 0 {
+		if err := validateNUMA(s.NUMA, s.VCPUs, s.MemoryMB); err != nil {
+			return err
+		}
+	}
+	if s.NUMATune != nil {
+		if err := s.NUMATune.validate(len(s.NUMA)); err != nil {
+			return fmt.Errorf("numatune: %w", err)
+		}
+	}
+
+	seen := make(map[PCIAddress]bool)
+	for i, dev := range s.HostDevices {
+		addr, err := ParsePCIAddress(dev)
+		if err != nil {
+			return fmt.Errorf("host_devices[%d]: %w", i, err)
+		}
+		if seen[addr] {
+			return fmt.Errorf("host_devices[%d]: %s is listed more than once", i, addr)
+		}
+		seen[addr] = true
+	}
+
+	tags := make(map[string]bool)
+	for i, d := range s.SharedDirs {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("shared_dirs[%d]: %w", i, err)
+		}
+		if tags[d.Tag] {
+			return fmt.Errorf("shared_dirs[%d]: tag %q is used more than once", i, d.Tag)
+		}
+		tags[d.Tag] = true
+	}
+
+	for i, n := range s.Interfaces {
+		if err := n.validate(); err != nil {
+			return fmt.Errorf("interfaces[%d]: %w", i, err)
+		}
+	}
+
+	targets := make(map[string]bool)
+	for i, d := range s.Disks {
+		if err := d.validate(); err != nil {
+			return fmt.Errorf("disks[%d]: %w", i, err)
+		}
+		if d.Target != "" {
+			if targets[d.Target] {
+				return fmt.Errorf("disks[%d]: target %q is used more than once", i, d.Target)
+			}
+			targets[d.Target] = true
+		}
+	}
+	return nil
+}
+
+func (c CPUSpec) validate(vcpus int) error {
+	switch c.Mode {
+	case "", CPUHostModel, CPUHostPassthrough:
+		if c.Model != "" {
+			return fmt.Errorf("model requires mode %q", CPUCustom)
+		}
+	case CPUCustom:
+		if c.Model == "" {
+			return fmt.Errorf("model is required in mode %q", CPUCustom)
+		}
+	default:
+		return fmt.Errorf("mode must be one of host-model, host-passthrough or custom")
+	}
+
+	// The topology is all or nothing and has to account for every vCPU
+	if c.Sockets == 0 && c.Cores == 0 && c.Threads == 0 {
+		return nil
+	}
+	if c.Sockets <= 0 || c.Cores <= 0 || c.Threads <= 0 {
+		return fmt.Errorf("sockets, cores and threads must all be > 0 when a topology is given")
+	}
+	if n := c.Sockets * c.Cores * c.Threads; n != vcpus {
+		return fmt.Errorf("sockets*cores*threads is %d but vcpus is %d", n, vcpus)
+	}
+	return nil
+}
+
+func (d DiskSpec) validate() error {
+	if d.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	switch d.Bus {
+	case "", BusVirtio, BusSATA, BusSCSI:
+	default:
+		return fmt.Errorf("bus must be one of virtio, sata or scsi")
+	}
+	switch d.Cache {
+	case "", CacheNone, CacheWriteback, CacheWritethrough:
+	default:
+		return fmt.Errorf("cache must be one of none, writeback or writethrough")
+	}
+	switch d.Format {
+	case "", "qcow2", "raw":
+	default:
+		return fmt.Errorf("format must be qcow2 or raw")
+	}
+	if d.Secret != "" {
+		if d.Format == "raw" {
+			return fmt.Errorf("secret requires format qcow2")
+		}
+		if !IsUUID(d.Secret) {
+			return fmt.Errorf("secret must be a UUID")
+		}
+	}
+	if d.Serial != "" {
+		if !diskSerialPattern.MatchString(d.Serial) {
+			return fmt.Errorf("serial may only contain letters, digits, spaces and _ + - .")
+		}
+		// virtio-blk truncates longer serials
+		if busOrDefault(d.Bus) == BusVirtio && len(d.Serial) > maxVirtioSerialLen {
+			return fmt.Errorf("serial must be at most %d characters on bus virtio", maxVirtioSerialLen)
+		}
+	}
+	if d.WWN != "" {
+		if !wwnPattern.MatchString(d.WWN) {
+			return fmt.Errorf("wwn must be 16 hex digits")
+		}
+		if busOrDefault(d.Bus) == BusVirtio {
+			return fmt.Errorf("wwn is not supported on bus virtio")
+		}
+	}
+
+	// The target prefix decides the bus inside the guest, so it must agree
+	if d.Target != "" {
+		prefix := targetPrefix(d.Bus)
+		if !strings.HasPrefix(d.Target, prefix) {
+			return fmt.Errorf("target %q does not match bus %q (expected %s*)", d.Target, busOrDefault(d.Bus), prefix)
+		}
+	}
+	return nil
+}
+
+func busOrDefault(bus string) string {
+	if bus == "" {
+		return BusVirtio
+	}
+	return bus
+}
+
+// targetPrefix returns the device name prefix libvirt expects for a bus.
+func targetPrefix(bus string) string {
+	if busOrDefault(bus) == BusVirtio {
+		return "vd"
+	}
+	return "sd"
+}
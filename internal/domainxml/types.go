@@ -0,0 +1,343 @@
+package domainxml
+
+import "encoding/xml"
+
+// Domain mirrors the subset of the libvirt domain XML schema the controller
+// generates and inspects.
+type Domain struct {
+	XMLName  xml.Name  `xml:"domain"`
+	Type     string    `xml:"type,attr"`
+	Name     string    `xml:"name"`
+	Memory   Memory    `xml:"memory"`
+	VCPU     int       `xml:"vcpu"`
+	OS       OS        `xml:"os"`
+	Features *Features `xml:"features"`
+	CPU      *CPU      `xml:"cpu"`
+	Devices  Devices   `xml:"devices"`
+
+	MemoryBacking *MemoryBacking `xml:"memoryBacking"`
+	NUMATune      *NUMATune      `xml:"numatune"`
+}
+
+type Memory struct {
+	Unit  string `xml:"unit,attr,omitempty"`
+	Value int    `xml:",chardata"`
+}
+
+// MemoryBacking shares the guest memory with the host, which virtio-fs needs
+// for virtiofsd to access the guest's buffers.
+type MemoryBacking struct {
+	Source *MemorySource `xml:"source"`
+	Access *MemoryAccess `xml:"access"`
+}
+
+type MemorySource struct {
+	Type string `xml:"type,attr"`
+}
+
+type MemoryAccess struct {
+	Mode string `xml:"mode,attr"`
+}
+
+type OS struct {
+	Firmware         string      `xml:"firmware,attr,omitempty"`
+	Type             OSType      `xml:"type"`
+	FirmwareFeatures *OSFirmware `xml:"firmware"`
+	Loader           *Loader     `xml:"loader"`
+	NVRAM            *NVRAM      `xml:"nvram"`
+	Boot             []Boot      `xml:"boot"`
+}
+
+// OSFirmware lists the features libvirt must look for when it selects the
+// firmware image itself.
+type OSFirmware struct {
+	Features []FirmwareFeature `xml:"feature"`
+}
+
+type FirmwareFeature struct {
+	Enabled string `xml:"enabled,attr"`
+	Name    string `xml:"name,attr"`
+}
+
+type Loader struct {
+	ReadOnly string `xml:"readonly,attr,omitempty"`
+	Secure   string `xml:"secure,attr,omitempty"`
+	Type     string `xml:"type,attr,omitempty"`
+	Path     string `xml:",chardata"`
+}
+
+type NVRAM struct {
+	Path string `xml:",chardata"`
+}
+
+type Features struct {
+	SMM *SMM `xml:"smm"`
+}
+
+// SMM is required by Secure Boot to protect the firmware variables.
+type SMM struct {
+	State string `xml:"state,attr"`
+}
+
+type OSType struct {
+	Arch    string `xml:"arch,attr,omitempty"`
+	Machine string `xml:"machine,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type Boot struct {
+	Dev string `xml:"dev,attr"`
+}
+
+type CPU struct {
+	Mode     string       `xml:"mode,attr,omitempty"`
+	Match    string       `xml:"match,attr,omitempty"`
+	Model    *CPUModel    `xml:"model"`
+	Topology *CPUTopology `xml:"topology"`
+	NUMA     *NUMA        `xml:"numa"`
+}
+
+type CPUModel struct {
+	Fallback string `xml:"fallback,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+type CPUTopology struct {
+	Sockets int `xml:"sockets,attr"`
+	Cores   int `xml:"cores,attr"`
+	Threads int `xml:"threads,attr"`
+}
+
+// NUMA lists the guest NUMA cells.
+type NUMA struct {
+	Cells []NUMACell `xml:"cell"`
+}
+
+type NUMACell struct {
+	ID     int    `xml:"id,attr"`
+	CPUs   string `xml:"cpus,attr"`
+	Memory int    `xml:"memory,attr"`
+	Unit   string `xml:"unit,attr,omitempty"`
+}
+
+// NUMATune binds the guest memory to host NUMA nodes, as a whole and per
+// guest cell.
+type NUMATune struct {
+	Memory   *NUMAMemory `xml:"memory"`
+	MemNodes []MemNode   `xml:"memnode"`
+}
+
+type NUMAMemory struct {
+	Mode    string `xml:"mode,attr,omitempty"`
+	Nodeset string `xml:"nodeset,attr,omitempty"`
+}
+
+type MemNode struct {
+	CellID  int    `xml:"cellid,attr"`
+	Mode    string `xml:"mode,attr,omitempty"`
+	Nodeset string `xml:"nodeset,attr"`
+}
+
+type Devices struct {
+	Disks       []Disk       `xml:"disk"`
+	Controllers []Controller `xml:"controller"`
+	Interfaces  []Interface  `xml:"interface"`
+	Channels    []Channel    `xml:"channel"`
+	Graphics    []Graphics   `xml:"graphics"`
+	Videos      []Video      `xml:"video"`
+	Serials     []Serial     `xml:"serial"`
+	Consoles    []Console    `xml:"console"`
+	RNGs        []RNG        `xml:"rng"`
+	TPMs        []TPM        `xml:"tpm"`
+	HostDevs    []HostDev    `xml:"hostdev"`
+	Filesystems []Filesystem `xml:"filesystem"`
+}
+
+type Disk struct {
+	Type     string      `xml:"type,attr"`
+	Device   string      `xml:"device,attr"`
+	Driver   *DiskDriver `xml:"driver"`
+	Source   *DiskSource `xml:"source"`
+	Target   DiskTarget  `xml:"target"`
+	ReadOnly *struct{}   `xml:"readonly"`
+	Serial   string      `xml:"serial,omitempty"`
+	WWN      string      `xml:"wwn,omitempty"`
+	Address  *Address    `xml:"address"` // Assigned by libvirt unless given
+}
+
+type DiskDriver struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Cache string `xml:"cache,attr,omitempty"`
+}
+
+type DiskSource struct {
+	File       string      `xml:"file,attr,omitempty"`
+	Encryption *Encryption `xml:"encryption"`
+}
+
+// Encryption unlocks a LUKS encrypted image with a libvirt secret.
+type Encryption struct {
+	Format string           `xml:"format,attr"`
+	Secret EncryptionSecret `xml:"secret"`
+}
+
+type EncryptionSecret struct {
+	Type string `xml:"type,attr"`
+	UUID string `xml:"uuid,attr"`
+}
+
+type DiskTarget struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr,omitempty"`
+}
+
+type Controller struct {
+	Type  string `xml:"type,attr"`
+	Model string `xml:"model,attr,omitempty"`
+}
+
+type Interface struct {
+	Type   string          `xml:"type,attr"`
+	MAC    *InterfaceMAC   `xml:"mac"`
+	Source InterfaceSource `xml:"source"`
+	Model  *InterfaceModel `xml:"model"`
+}
+
+type InterfaceMAC struct {
+	Address string `xml:"address,attr"`
+}
+
+type InterfaceSource struct {
+	Network string `xml:"network,attr,omitempty"`
+	Bridge  string `xml:"bridge,attr,omitempty"`
+}
+
+type InterfaceModel struct {
+	Type string `xml:"type,attr"`
+}
+
+type Channel struct {
+	Type   string        `xml:"type,attr"`
+	Target ChannelTarget `xml:"target"`
+}
+
+type ChannelTarget struct {
+	Type string `xml:"type,attr"`
+	Name string `xml:"name,attr,omitempty"`
+}
+
+type Graphics struct {
+	Type     string `xml:"type,attr"`
+	AutoPort string `xml:"autoport,attr,omitempty"`
+	Listen   string `xml:"listen,attr,omitempty"`
+}
+
+type Video struct {
+	Model VideoModel `xml:"model"`
+}
+
+type VideoModel struct {
+	Type string `xml:"type,attr"`
+}
+
+type Serial struct {
+	Type   string        `xml:"type,attr"`
+	Source *SerialSource `xml:"source"`
+	Target *SerialTarget `xml:"target"`
+	Log    *SerialLog    `xml:"log"`
+}
+
+type SerialSource struct {
+	Path string `xml:"path,attr,omitempty"` // File of a file serial, pts device of a pty
+}
+
+// SerialLog copies the output of a serial port into a file.
+type SerialLog struct {
+	File   string `xml:"file,attr"`
+	Append string `xml:"append,attr,omitempty"`
+}
+
+type SerialTarget struct {
+	Port *int `xml:"port,attr"`
+}
+
+type Console struct {
+	Type   string         `xml:"type,attr"`
+	Target *ConsoleTarget `xml:"target"`
+}
+
+type ConsoleTarget struct {
+	Type string `xml:"type,attr"`
+	Port *int   `xml:"port,attr"`
+}
+
+type RNG struct {
+	Model   string     `xml:"model,attr"`
+	Backend RNGBackend `xml:"backend"`
+}
+
+type RNGBackend struct {
+	Model string `xml:"model,attr"`
+	Path  string `xml:",chardata"`
+}
+
+type TPM struct {
+	Model   string     `xml:"model,attr"`
+	Backend TPMBackend `xml:"backend"`
+}
+
+type TPMBackend struct {
+	Type    string `xml:"type,attr"`
+	Version string `xml:"version,attr,omitempty"`
+}
+
+type HostDev struct {
+	XMLName xml.Name      `xml:"hostdev"`
+	Mode    string        `xml:"mode,attr"`
+	Type    string        `xml:"type,attr"`
+	Managed string        `xml:"managed,attr,omitempty"`
+	Source  HostDevSource `xml:"source"`
+}
+
+type HostDevSource struct {
+	Address HostDevAddress `xml:"address"`
+}
+
+type HostDevAddress struct {
+	Domain   string `xml:"domain,attr"`
+	Bus      string `xml:"bus,attr"`
+	Slot     string `xml:"slot,attr"`
+	Function string `xml:"function,attr"`
+}
+
+type Filesystem struct {
+	XMLName    xml.Name          `xml:"filesystem"`
+	Type       string            `xml:"type,attr"`
+	AccessMode string            `xml:"accessmode,attr,omitempty"`
+	Driver     *FilesystemDriver `xml:"driver"`
+	Source     FilesystemSource  `xml:"source"`
+	Target     FilesystemTarget  `xml:"target"`
+}
+
+type FilesystemDriver struct {
+	Type string `xml:"type,attr"`
+}
+
+type FilesystemSource struct {
+	Dir string `xml:"dir,attr"`
+}
+
+// FilesystemTarget is the mount tag the guest mounts the share by.
+type FilesystemTarget struct {
+	Dir string `xml:"dir,attr"`
+}
+
+// Parse decodes a libvirt domain XML document.
+func Parse(data []byte) (*Domain, error) {
+	var d Domain
+	if err := xml.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,114 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+)
+
+// defaultDebounceMS is how long, in milliseconds, power state changes of a
+// domain are held back so that flaps such as a reboot's end up in one webhook.
+const defaultDebounceMS = 2000
+
+// defaultDebounceMaxMS bounds, in milliseconds, how long a domain that keeps
+// changing state is held back in total.
+const defaultDebounceMaxMS = 30000
+
+// stateEvents are the power state changes of a domain. Other events are sent
+// right away.
+var stateEvents = map[string]bool{
+	"domain.started":  true,
+	"domain.stopped":  true,
+	"domain.shutdown": true,
+	"domain.rebooted": true,
+	"domain.paused":   true,
+	"domain.resumed":  true,
+}
+
+// pendingState is a power state change waiting for the domain to settle.
+type pendingState struct {
+	eventType   string
+	message     string
+	data        map[string]interface{}
+	transitions []string // Every event type coalesced into this one, in order
+	since       time.Time
+	timer       *time.Timer
+}
+
+// debouncer coalesces the power state changes of each domain. A change
+// arriving within the window of the previous one replaces it and restarts
+// the window; once the domain settles, or maxHold after the first change,
+// one webhook reports the last state with all transitions in
+// data.transitions.
+type debouncer struct {
+	window  func() time.Duration
+	maxHold func() time.Duration
+	send    func(id, eventType, message string, data map[string]interface{})
+	mu      sync.Mutex
+	pending map[string]*pendingState
+}
+
+func newDebouncer(window, maxHold func() time.Duration, send func(id, eventType, message string, data map[string]interface{})) *debouncer {
+	return &debouncer{window: window, maxHold: maxHold, send: send, pending: make(map[string]*pendingState)}
+}
+
+// debounceWindow reads WEBHOOK_DEBOUNCE_MS, 0 disables debouncing.
+func debounceWindow() time.Duration {
+	return time.Duration(config.Int("WEBHOOK_DEBOUNCE_MS", defaultDebounceMS)) * time.Millisecond
+}
+
+// debounceMaxHold reads WEBHOOK_DEBOUNCE_MAX_MS.
+func debounceMaxHold() time.Duration {
+	return time.Duration(config.Int("WEBHOOK_DEBOUNCE_MAX_MS", defaultDebounceMaxMS)) * time.Millisecond
+}
+
+// notify sends a power state change once the domain settles, anything else
+// right away. The pending state change of the domain is sent first, e.g.
+// before the domain.undefined following a stop.
+func (d *debouncer) notify(id, eventType, message string, data map[string]interface{}) {
+	window := d.window()
+	if !stateEvents[eventType] || id == "" || window <= 0 {
+		d.flush(id, nil)
+		d.send(id, eventType, message, data)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.pending[id]
+	if !ok {
+		p = &pendingState{since: time.Now()}
+		d.pending[id] = p
+		p.timer = time.AfterFunc(window, func() { d.flush(id, p) })
+	} else {
+		// A flapping domain is still reported once it was held for maxHold
+		p.timer.Reset(max(min(window, d.maxHold()-time.Since(p.since)), 0))
+	}
+	p.eventType, p.message, p.data = eventType, message, data
+	p.transitions = append(p.transitions, eventType)
+}
+
+// flush sends the pending state change of domain id. With only set it is
+// sent only if it is still that one, for timers firing after their change
+// was flushed by another event.
+func (d *debouncer) flush(id string, only *pendingState) {
+	d.mu.Lock()
+	p, ok := d.pending[id]
+	if !ok || (only != nil && p != only) {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.pending, id)
+	p.timer.Stop()
+	d.mu.Unlock()
+
+	data := make(map[string]interface{}, len(p.data)+1)
+	for k, v := range p.data {
+		data[k] = v
+	}
+	if len(p.transitions) > 1 {
+		data["transitions"] = p.transitions
+	}
+	d.send(id, p.eventType, p.message, data)
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentEvent is a webhook recorded by recorder.
+type sentEvent struct {
+	id, eventType string
+	data          map[string]interface{}
+}
+
+// recorder records the webhooks a debouncer sends.
+type recorder struct {
+	mu   sync.Mutex
+	sent []sentEvent
+}
+
+func (r *recorder) send(id, eventType, message string, data map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, sentEvent{id: id, eventType: eventType, data: data})
+}
+
+func (r *recorder) events() []sentEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]sentEvent(nil), r.sent...)
+}
+
+// waitSent waits until n webhooks were sent and returns them.
+func (r *recorder) waitSent(t *testing.T, n int) []sentEvent {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if sent := r.events(); len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %+v, want %d webhooks", r.events(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDebounceCollapsesReboot(t *testing.T) {
+	rec := &recorder{}
+	d := newDebouncer(func() time.Duration { return 50 * time.Millisecond }, func() time.Duration { return time.Hour }, rec.send)
+
+	// A reboot as seen by the controller, and another domain starting meanwhile
+	d.notify("vm-1", "domain.shutdown", "Domain shutdown initiated", nil)
+	d.notify("vm-1", "domain.stopped", "Domain stopped", map[string]interface{}{"forced": false})
+	d.notify("vm-2", "domain.started", "Domain started", nil)
+	d.notify("vm-1", "domain.started", "Domain started", map[string]interface{}{"boot": 2})
+
+	sent := rec.waitSent(t, 2)
+	time.Sleep(100 * time.Millisecond) // Nothing else may follow
+	if sent = rec.events(); len(sent) != 2 {
+		t.Fatalf("sent %+v, want one webhook per domain", sent)
+	}
+	for _, e := range sent {
+		switch e.id {
+		case "vm-1":
+			want := map[string]interface{}{"boot": 2, "transitions": []string{"domain.shutdown", "domain.stopped", "domain.started"}}
+			if e.eventType != "domain.started" || !reflect.DeepEqual(e.data, want) {
+				t.Errorf("vm-1 sent %s with %v, want domain.started with %v", e.eventType, e.data, want)
+			}
+		case "vm-2":
+			if e.eventType != "domain.started" || len(e.data) != 0 {
+				t.Errorf("vm-2 sent %s with %v, want a plain domain.started", e.eventType, e.data)
+			}
+		}
+	}
+}
+
+func TestDebounceSendsOtherEventsInOrder(t *testing.T) {
+	rec := &recorder{}
+	d := newDebouncer(func() time.Duration { return time.Hour }, func() time.Duration { return time.Hour }, rec.send)
+
+	d.notify("vm-1", "domain.stopped", "Domain stopped", nil)
+	d.notify("vm-1", "domain.undefined", "Domain undefined", nil)
+
+	var got []string
+	for _, e := range rec.events() {
+		got = append(got, e.eventType)
+	}
+	if want := []string{"domain.stopped", "domain.undefined"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+func TestDebounceDisabled(t *testing.T) {
+	rec := &recorder{}
+	d := newDebouncer(func() time.Duration { return 0 }, func() time.Duration { return time.Hour }, rec.send)
+
+	d.notify("vm-1", "domain.shutdown", "Domain shutdown initiated", nil)
+	d.notify("vm-1", "domain.stopped", "Domain stopped", nil)
+	if sent := rec.events(); len(sent) != 2 {
+		t.Errorf("sent %+v, want both events right away", sent)
+	}
+}
+
+func TestDebounceMaxHold(t *testing.T) {
+	rec := &recorder{}
+	d := newDebouncer(func() time.Duration { return 40 * time.Millisecond }, func() time.Duration { return 100 * time.Millisecond }, rec.send)
+
+	// The domain flaps faster than the window for longer than maxHold
+	start := time.Now()
+	for time.Since(start) < 300*time.Millisecond {
+		d.notify("vm-1", "domain.stopped", "Domain stopped", nil)
+		time.Sleep(10 * time.Millisecond)
+		d.notify("vm-1", "domain.started", "Domain started", nil)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent := rec.events(); len(sent) < 2 {
+		t.Errorf("sent %d webhooks while the domain flapped for 300ms, want one per maxHold", len(sent))
+	}
+}
+
+func TestDeliveryQueueKeepsOrder(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	q := newDeliveryQueue(func(id, eventType, message string, data map[string]interface{}) error {
+		time.Sleep(time.Millisecond) // Give later webhooks a chance to overtake
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, id+" "+eventType)
+		return nil
+	})
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		eventType := fmt.Sprintf("event-%d", i)
+		q.enqueue("vm-1", eventType, "", nil)
+		want = append(want, "vm-1 "+eventType)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(got)
+		mu.Unlock()
+		if n == len(want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %d of %d webhooks", n, len(want))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
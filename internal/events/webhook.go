@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
+
+	"libvirt-controller/internal/httpclient"
 )
 
 // WebhookPayload represents the structure of the JSON payload for the webhook.
@@ -60,9 +64,7 @@ func SendWebhook(
 	}
 
 	// 4. Create a new HTTP client
-	client := &http.Client{
-		Timeout: 10 * time.Second, // Set a timeout for the request
-	}
+	client := httpclient.New(10 * time.Second) // Set a timeout for the request
 
 	// 5. Create a new HTTP POST request
 	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(jsonPayload))
@@ -89,3 +91,68 @@ func SendWebhook(
 	fmt.Printf("Webhook successfully sent to %s. Status: %s\n", webhookURL, resp.Status)
 	return nil
 }
+
+// stateDebouncer holds back the power state changes passed to Notify.
+var stateDebouncer = newDebouncer(debounceWindow, debounceMaxHold, webhooks.enqueue)
+
+// webhooks delivers the webhooks passed on by stateDebouncer.
+var webhooks = newDeliveryQueue(SendWebhook)
+
+// Notify sends a webhook in the background and logs delivery failures.
+// Power state changes of a domain are debounced, see WEBHOOK_DEBOUNCE_MS.
+// It is a no-op when WEBHOOK_URL is not configured.
+func Notify(id string, eventType string, message string, data map[string]interface{}) {
+	if os.Getenv("WEBHOOK_URL") == "" {
+		return
+	}
+	stateDebouncer.notify(id, eventType, message, data)
+}
+
+// webhook is an event waiting for delivery.
+type webhook struct {
+	eventType, message string
+	data               map[string]interface{}
+}
+
+// deliveryQueue sends webhooks in the background and logs delivery failures.
+// The webhooks of one domain are sent one after another in the order they
+// were queued, so a receiver never sees a domain's events reordered.
+type deliveryQueue struct {
+	send   func(id, eventType, message string, data map[string]interface{}) error
+	mu     sync.Mutex
+	queues map[string][]webhook // By domain, present while a sender runs
+}
+
+func newDeliveryQueue(send func(id, eventType, message string, data map[string]interface{}) error) *deliveryQueue {
+	return &deliveryQueue{send: send, queues: make(map[string][]webhook)}
+}
+
+func (q *deliveryQueue) enqueue(id string, eventType string, message string, data map[string]interface{}) {
+	q.mu.Lock()
+	queue, running := q.queues[id]
+	q.queues[id] = append(queue, webhook{eventType: eventType, message: message, data: data})
+	q.mu.Unlock()
+	if !running {
+		go q.run(id)
+	}
+}
+
+// run sends the queued webhooks of domain id until none are left.
+func (q *deliveryQueue) run(id string) {
+	for {
+		q.mu.Lock()
+		queue := q.queues[id]
+		if len(queue) == 0 {
+			delete(q.queues, id)
+			q.mu.Unlock()
+			return
+		}
+		next := queue[0]
+		q.queues[id] = queue[1:]
+		q.mu.Unlock()
+
+		if err := q.send(id, next.eventType, next.message, next.data); err != nil {
+			log.Printf("failed to send %s webhook for %s: %v", next.eventType, id, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"libvirt-controller/internal/config"
+)
+
+// DefaultStorageClass is the class whose VM directories live in
+// DEFINITIONS_DIR. It is used when a request doesn't name a class.
+const DefaultStorageClass = "default"
+
+var ErrUnknownStorageClass = errors.New("unknown storage class")
+
+// StorageClasses maps storage class names to the base directory holding
+// their VM directories: DEFINITIONS_DIR as the default class, plus the
+// comma separated name=path pairs of STORAGE_CLASSES, e.g.
+// "fast=/nvme/vm,bulk=/hdd/vm". Invalid entries are skipped, see
+// InvalidStorageClasses.
+func StorageClasses() map[string]string {
+	classes, _ := parseStorageClasses()
+	return classes
+}
+
+// InvalidStorageClasses returns the entries of STORAGE_CLASSES that aren't
+// name=path pairs, for logging them once at startup.
+func InvalidStorageClasses() []string {
+	_, invalid := parseStorageClasses()
+	return invalid
+}
+
+func parseStorageClasses() (classes map[string]string, invalid []string) {
+	classes = make(map[string]string)
+	if dir := os.Getenv("DEFINITIONS_DIR"); dir != "" {
+		classes[DefaultStorageClass] = dir
+	}
+	for _, item := range config.List("STORAGE_CLASSES") {
+		name, dir, ok := strings.Cut(item, "=")
+		name, dir = strings.TrimSpace(name), strings.TrimSpace(dir)
+		if !ok || name == "" || dir == "" || name == DefaultStorageClass {
+			invalid = append(invalid, item)
+			continue
+		}
+		classes[name] = dir
+	}
+	return classes, invalid
+}
+
+// StorageClassDir returns the base directory of a storage class, "" being
+// the default class.
+func StorageClassDir(class string) (string, error) {
+	if class == "" {
+		class = DefaultStorageClass
+	}
+	dir, ok := StorageClasses()[class]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownStorageClass, class)
+	}
+	return dir, nil
+}
+
+// FindVMDir searches all storage classes, the default one first, for the
+// directory of vmID. It returns "" and no error when no class has it.
+func FindVMDir(vmID string) (vmDir string, class string, err error) {
+	classes := StorageClasses()
+	for _, name := range searchOrder(classes) {
+		dir := filepath.Join(classes[name], vmID)
+		exists, err := CheckDirectoryExists(dir)
+		if err != nil {
+			return "", "", err
+		}
+		if exists {
+			return dir, name, nil
+		}
+	}
+	return "", "", nil
+}
+
+// ListVMDirs returns the directory of every VM in all storage classes, keyed
+// by VM ID. An ID present in several classes resolves like FindVMDir.
+func ListVMDirs() (map[string]string, error) {
+	classes := StorageClasses()
+	dirs := make(map[string]string)
+	for _, name := range searchOrder(classes) {
+		entries, err := os.ReadDir(classes[name])
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage class %q: %w", name, err)
+		}
+		for _, entry := range entries {
+			if _, ok := dirs[entry.Name()]; !ok && entry.IsDir() {
+				dirs[entry.Name()] = filepath.Join(classes[name], entry.Name())
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// InStorageClass reports whether path lies inside the base directory of a
+// storage class. The directories are resolved first so symlinks can't lead
+// outside of the classes; path itself may be a symlink or not exist.
+func InStorageClass(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	resolved := filepath.Join(dir, filepath.Base(path))
+	for _, base := range StorageClasses() {
+		base, err := filepath.EvalSymlinks(base)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(base, resolved); err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
+// searchOrder returns the class names with the default class first and the
+// others sorted, so lookups are deterministic.
+func searchOrder(classes map[string]string) []string {
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		if name != DefaultStorageClass {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := classes[DefaultStorageClass]; ok {
+		names = append([]string{DefaultStorageClass}, names...)
+	}
+	return names
+}
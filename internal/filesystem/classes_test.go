@@ -0,0 +1,19 @@
+package filesystem
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStorageClasses(t *testing.T) {
+	t.Setenv("DEFINITIONS_DIR", "/data/vm")
+	t.Setenv("STORAGE_CLASSES", "fast=/nvme/vm, bulk = /hdd/vm,broken,default=/other,=/nameless")
+
+	want := map[string]string{"default": "/data/vm", "fast": "/nvme/vm", "bulk": "/hdd/vm"}
+	if got := StorageClasses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("StorageClasses() = %v, want %v", got, want)
+	}
+	if got, want := InvalidStorageClasses(), []string{"broken", "default=/other", "=/nameless"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("InvalidStorageClasses() = %q, want %q", got, want)
+	}
+}
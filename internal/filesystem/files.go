@@ -1,14 +1,23 @@
 package filesystem
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+
+	"libvirt-controller/internal/httpclient"
 )
 
 // SaveFile saves data to a file within a specified directory.
@@ -43,8 +52,15 @@ func UpdateFile(dir, filename string, data []byte) error {
 	return os.WriteFile(filePath, data, 0644) // Overwrite the file with new data
 }
 
-// downloadFile handles actual downloading from the URL to a specified path
-func DownloadFile(url, filePath string, mode os.FileMode) error {
+// DownloadFile handles actual downloading from the URL to a specified path.
+// header is sent along, e.g. for authentication, and s3://bucket/key URLs are
+// fetched from the configured object store.
+func DownloadFile(ctx context.Context, rawURL, filePath string, mode os.FileMode, header http.Header) error {
+	downloadURL, err := resolveDownloadURL(rawURL)
+	if err != nil {
+		return err
+	}
+
 	// Create the file
 	out, err := os.Create(filePath)
 	if err != nil {
@@ -52,9 +68,22 @@ func DownloadFile(url, filePath string, mode os.FileMode) error {
 	}
 	defer out.Close()
 
-	// Get the data
-	resp, err := http.Get(url)
+	// Get the data, the request is aborted together with ctx
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid download URL %s", RedactURL(rawURL))
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	// Images can take long to download, ctx bounds the request instead
+	resp, err := httpclient.New(0).Do(req)
 	if err != nil {
+		// The URL may carry a signature or credentials
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = RedactURL(urlErr.URL)
+		}
 		return err
 	}
 	defer resp.Body.Close()
@@ -74,11 +103,18 @@ func DownloadFile(url, filePath string, mode os.FileMode) error {
 	return os.Chmod(filePath, mode)
 }
 
-// DownloadCachedFile manages the cache logic and uses downloadFile if necessary
-func DownloadCachedFile(url string, name string, mode os.FileMode) error {
+// DownloadCachedFile manages the cache logic and uses downloadFile if necessary.
+// Downloads with credentials in header or the URL bypass the cache, so
+// private images are never handed to other callers.
+func DownloadCachedFile(ctx context.Context, rawURL string, name string, mode os.FileMode, header http.Header) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid download URL %s", RedactURL(rawURL))
+	}
+
 	// Get cache directory from environment
 	cacheDir := os.Getenv("CACHE_DIR")
-	useCache := cacheDir != "" // Determine if caching should be used
+	useCache := cacheDir != "" && len(header) == 0 && u.User == nil // Determine if caching should be used
 
 	// Determine cache duration
 	var cacheDuration time.Duration
@@ -98,11 +134,11 @@ func DownloadCachedFile(url string, name string, mode os.FileMode) error {
 	// If no cache directory is set, directly download and copy the file
 	if !useCache {
 		// Download the file directly to the destination
-		return DownloadFile(url, name, mode)
+		return DownloadFile(ctx, rawURL, name, mode, header)
 	}
 
 	// Ensure cache directory exists if caching is enabled
-	err := os.MkdirAll(cacheDir, os.ModePerm)
+	err = os.MkdirAll(cacheDir, os.ModePerm)
 	if err != nil {
 		return err
 	}
@@ -114,9 +150,7 @@ func DownloadCachedFile(url string, name string, mode os.FileMode) error {
 		fmt.Printf("Error cleaning cache directory %s: %v\n", cacheDir, err)
 	}
 
-	// Determine the filename from the URL
-	fileName := filepath.Base(url)
-	cacheFilePath := filepath.Join(cacheDir, fileName)
+	cacheFilePath := filepath.Join(cacheDir, cacheKey(u))
 
 	// Check if file is in the cache and not older than the specified duration
 	/*if FileExists(cacheFilePath) && !IsFileOlderThan(cacheFilePath, cacheDuration) {
@@ -131,8 +165,10 @@ func DownloadCachedFile(url string, name string, mode os.FileMode) error {
 	}
 
 	// Download the file into the cache
-	err = DownloadFile(url, cacheFilePath, mode)
+	err = DownloadFile(ctx, rawURL, cacheFilePath, mode, header)
 	if err != nil {
+		// An aborted download must not be served from the cache later
+		os.Remove(cacheFilePath)
 		return err
 	}
 
@@ -140,6 +176,38 @@ func DownloadCachedFile(url string, name string, mode os.FileMode) error {
 	return CopyFile(cacheFilePath, name, mode)
 }
 
+// signatureParams are the query parameters of presigned S3, GCS and Azure
+// URLs that change with every signature, compared in lower case.
+var signatureParams = map[string]bool{
+	"signature":      true,
+	"expires":        true,
+	"awsaccesskeyid": true,
+	"sig":            true,
+	"se":             true,
+	"st":             true,
+	"sp":             true,
+	"sv":             true,
+	"sr":             true,
+	"spr":            true,
+}
+
+// cacheKey names the cache file of a download: a hash of the URL without its
+// signature, so presigned URLs of one object share an entry while objects of
+// the same basename in other buckets or paths don't. The basename is kept for
+// readability.
+func cacheKey(u *url.URL) string {
+	query := u.Query()
+	for name := range query {
+		lower := strings.ToLower(name)
+		if signatureParams[lower] || strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "x-goog-") {
+			query.Del(name)
+		}
+	}
+	key := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path, RawQuery: query.Encode()}
+	sum := sha256.Sum256([]byte(key.String()))
+	return hex.EncodeToString(sum[:16]) + "-" + path.Base(u.Path)
+}
+
 // FileExists checks if a file exists at the given path
 func FileExists(path string) bool {
 	_, err := os.Stat(path)
@@ -199,3 +267,35 @@ func CopyFile(src, dst string, mode os.FileMode) error {
 
 	return os.Chmod(dst, mode)
 }
+
+// SaveFileAtomic writes data to a temporary file next to the target and
+// renames it into place, so readers never observe a partially written file.
+func SaveFileAtomic(dir string, filename string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, "."+filename+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file in %s: %w", dir, err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", tmpPath, err)
+	}
+
+	filePath := filepath.Join(dir, filename)
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("failed to save file %s: %w", filePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package filesystem
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"libvirt-controller/internal/config"
+)
+
+// s3PresignExpiry is how long a presigned S3 download URL stays valid.
+const s3PresignExpiry = time.Hour
+
+// s3Config is the object store s3:// URLs are downloaded from, read from
+// S3_ENDPOINT, S3_REGION, S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY and
+// S3_FORCE_PATH_STYLE.
+type s3Config struct {
+	Endpoint        *url.URL
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool // bucket in the path rather than the host name, as MinIO and Ceph expect
+}
+
+func loadS3Config() (*s3Config, error) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3.amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", endpoint)
+	}
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	cfg := &s3Config{
+		Endpoint:        u,
+		Region:          region,
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		PathStyle:       config.Bool("S3_FORCE_PATH_STYLE", false),
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("s3:// URLs need S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+	}
+	return cfg, nil
+}
+
+// resolveDownloadURL turns s3://bucket/key URLs into presigned HTTP URLs for
+// the configured object store. Other URLs are returned unchanged.
+func resolveDownloadURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "s3" {
+		return rawURL, nil
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return "", fmt.Errorf("invalid S3 URL %s, expected s3://bucket/key", RedactURL(rawURL))
+	}
+	cfg, err := loadS3Config()
+	if err != nil {
+		return "", err
+	}
+	return cfg.presign(u.Host, key, time.Now(), s3PresignExpiry), nil
+}
+
+// presign returns a GET URL for key in bucket signed with AWS Signature
+// Version 4 in the query string.
+func (c *s3Config) presign(bucket string, key string, now time.Time, expires time.Duration) string {
+	host := c.Endpoint.Host
+	path := "/" + s3EscapePath(key)
+	if c.PathStyle {
+		path = "/" + s3Escape(bucket) + path
+	} else {
+		host = bucket + "." + host
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + c.Region + "/s3/aws4_request"
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    c.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       fmt.Sprint(int(expires.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = s3Escape(name) + "=" + s3Escape(query[name])
+	}
+	canonicalQuery := strings.Join(pairs, "&")
+
+	canonicalRequest := strings.Join([]string{"GET", path, canonicalQuery, "host:" + host, "", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := []byte("AWS4" + c.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], c.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return c.Endpoint.Scheme + "://" + host + path + "?" + canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// Signature Version 4 requires.
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// s3EscapePath escapes an object key, keeping its slashes.
+func s3EscapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// RedactURL strips credentials and the query string, which may hold a
+// presigned signature, from a URL so it can be logged.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
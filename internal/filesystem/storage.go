@@ -0,0 +1,78 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// StorageError reports a directory that can't take the data of an operation,
+// either because it is not writable (Err is set) or because its filesystem
+// has less than RequiredBytes free.
+type StorageError struct {
+	Path           string
+	AvailableBytes uint64
+	RequiredBytes  uint64
+	Err            error
+}
+
+func (e *StorageError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s is not writable: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s has %d bytes available, %d required", e.Path, e.AvailableBytes, e.RequiredBytes)
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// CheckStorage verifies that dir is writable and that its filesystem has at
+// least requiredBytes free. A dir that doesn't exist yet is checked through
+// its nearest existing parent, where it would be created.
+func CheckStorage(dir string, requiredBytes uint64) error {
+	existing, err := existingParent(dir)
+	if err != nil {
+		return err
+	}
+
+	// Creating a file is the only reliable test, read-only mounts and ACLs
+	// are not visible in the permission bits
+	f, err := os.CreateTemp(existing, ".write-check-*")
+	if err != nil {
+		return &StorageError{Path: existing, RequiredBytes: requiredBytes, Err: err}
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	usage, err := disk.Usage(existing)
+	if err != nil {
+		return fmt.Errorf("failed to get free space of %s: %w", existing, err)
+	}
+	if usage.Free < requiredBytes {
+		return &StorageError{Path: existing, AvailableBytes: usage.Free, RequiredBytes: requiredBytes}
+	}
+	return nil
+}
+
+// existingParent returns path or its closest ancestor that exists.
+func existingParent(path string) (string, error) {
+	path = filepath.Clean(path)
+	for {
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to check %s: %w", path, err)
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", fmt.Errorf("no existing parent directory of %s", path)
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,25 @@
+package filesystem
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckStorage(t *testing.T) {
+	dir := t.TempDir()
+
+	// Directories that don't exist yet are checked through their parent
+	if err := CheckStorage(filepath.Join(dir, "vm-1", "disks"), 0); err != nil {
+		t.Fatalf("CheckStorage() error = %v", err)
+	}
+
+	err := CheckStorage(dir, 1<<62)
+	var storageErr *StorageError
+	if !errors.As(err, &storageErr) {
+		t.Fatalf("CheckStorage() error = %v, want a StorageError", err)
+	}
+	if storageErr.Path != dir || storageErr.RequiredBytes != 1<<62 || storageErr.AvailableBytes == 0 || storageErr.Err != nil {
+		t.Errorf("got %+v", storageErr)
+	}
+}
@@ -0,0 +1,53 @@
+package filesystem
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// tailChunkSize is how much of the file is read per step when seeking backwards.
+const tailChunkSize = 64 * 1024
+
+// TailLines returns up to n trailing lines of a file and the file size, which
+// callers can use as the offset to continue reading from.
+func TailLines(path string, n int) ([]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+
+	// Read backwards until enough newlines were seen or the start is reached
+	var buf []byte
+	offset := size
+	for offset > 0 && bytes.Count(buf, []byte("\n")) <= n {
+		step := int64(tailChunkSize)
+		if offset < step {
+			step = offset
+		}
+		offset -= step
+		chunk := make([]byte, step)
+		if _, err := f.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		buf = append(chunk, buf...)
+	}
+
+	text := strings.TrimRight(string(buf), "\n")
+	if text == "" {
+		return []string{}, size, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, size, nil
+}
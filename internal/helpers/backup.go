@@ -0,0 +1,80 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BackupTarget is where disk backups are pushed to: a directory on the host,
+// such as an NFS mount, or a directory on another machine reached over ssh.
+type BackupTarget struct {
+	Host string // [user@]host, empty for a local directory
+	Port string
+	Dir  string
+}
+
+// ParseBackupTarget parses an absolute path or an ssh://[user@]host[:port]/dir URL.
+func ParseBackupTarget(raw string) (BackupTarget, error) {
+	if filepath.IsAbs(raw) {
+		return BackupTarget{Dir: filepath.Clean(raw)}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" {
+		return BackupTarget{}, fmt.Errorf("must be an absolute path or an ssh://[user@]host[:port]/dir URL")
+	}
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return BackupTarget{}, fmt.Errorf("must not contain a password, use ssh keys")
+		}
+	}
+	if u.Path == "" || u.Path == "/" {
+		return BackupTarget{}, fmt.Errorf("must name a directory on the remote host")
+	}
+	// Neither may be taken for an ssh or rsync option
+	if strings.HasPrefix(u.Hostname(), "-") || (u.User != nil && strings.HasPrefix(u.User.Username(), "-")) {
+		return BackupTarget{}, fmt.Errorf("has an invalid host")
+	}
+
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	return BackupTarget{Host: host, Port: u.Port(), Dir: path.Clean(u.Path)}, nil
+}
+
+// String returns the target in the form it was given.
+func (t BackupTarget) String() string {
+	if t.Host == "" {
+		return t.Dir
+	}
+	host := t.Host
+	if t.Port != "" {
+		host += ":" + t.Port
+	}
+	return "ssh://" + host + t.Dir
+}
+
+// PushFile copies src into the target directory as name. rsync keeps the
+// holes of sparse images, so thin provisioned disks don't grow in transit.
+func PushFile(ctx context.Context, src string, target BackupTarget, name string) error {
+	args := []string{"--sparse", "--partial", "--timeout=300"}
+	dest := path.Join(target.Dir, name)
+	if target.Host != "" {
+		ssh := "ssh -o BatchMode=yes"
+		if target.Port != "" {
+			ssh += " -p " + target.Port
+		}
+		args = append(args, "-e", ssh)
+		dest = target.Host + ":" + dest
+	}
+	args = append(args, "--", src, dest)
+
+	if _, err := execute(ctx, "rsync", args...); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, target, err)
+	}
+	return nil
+}
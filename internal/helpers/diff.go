@@ -0,0 +1,58 @@
+package helpers
+
+import "strings"
+
+// DiffSummary describes the line level differences between two texts.
+type DiffSummary struct {
+	Added   int      `json:"added"`
+	Removed int      `json:"removed"`
+	Changes []string `json:"changes"` // Changed lines prefixed with "+ " or "- "
+}
+
+// DiffLines compares two texts line by line using a longest common subsequence.
+func DiffLines(oldText, newText string) DiffSummary {
+	a := splitLines(oldText)
+	b := splitLines(newText)
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	summary := DiffSummary{Changes: []string{}}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			summary.Added++
+			summary.Changes = append(summary.Changes, "+ "+strings.TrimSpace(b[j]))
+			j++
+		default:
+			summary.Removed++
+			summary.Changes = append(summary.Changes, "- "+strings.TrimSpace(a[i]))
+			i++
+		}
+	}
+	return summary
+}
+
+func splitLines(text string) []string {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
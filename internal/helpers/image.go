@@ -1,20 +1,27 @@
 package helpers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 
 	"libvirt-controller/internal/cmdutil"
 )
 
+// execute runs external commands; swapped out in tests.
+var execute = cmdutil.ExecuteContext
+
 // ResizeDisk resizes the disk image to the desired size in GB.
-func ResizeDisk(imagePath string, sizeGB int) error {
+func ResizeDisk(ctx context.Context, imagePath string, sizeGB int) error {
 	// Convert size in GB to the required format for qemu-img (e.g., "10G" for 10 GB)
 	size := fmt.Sprintf("%dG", sizeGB)
 
-	// Use cmdutil.Execute to run the qemu-img command
-	_, err := cmdutil.Execute("qemu-img", "resize", imagePath, size)
+	// Run the qemu-img command
+	_, err := execute(ctx, "qemu-img", "resize", imagePath, size)
 	if err != nil {
 		return fmt.Errorf("failed to resize disk image: %w", err)
 	}
@@ -22,41 +29,135 @@ func ResizeDisk(imagePath string, sizeGB int) error {
 	return nil
 }
 
-// GenerateCloudInitISO creates a cloud-init ISO, including an empty one if no files are available.
-func GenerateCloudInitISO(dir string) error {
+// LiveResizeDisk grows the disk attached to a running domain as target to the
+// desired size in GB. qemu resizes the image itself and notifies the guest,
+// so the new size is visible without a reboot. qemu-img can't be used while
+// qemu holds the write lock on the image.
+func LiveResizeDisk(ctx context.Context, domain string, target string, sizeGB int) error {
+	_, err := execute(ctx, "virsh", cmdutil.VirshArgs(ctx, "blockresize", domain, target, fmt.Sprintf("%dG", sizeGB))...)
+	if err != nil {
+		return fmt.Errorf("failed to resize block device %s of %s: %w", target, domain, err)
+	}
+	return nil
+}
+
+// Cloud-init datasources a generated ISO can be read by.
+const (
+	DatasourceNoCloud     = "nocloud"
+	DatasourceConfigDrive = "configdrive"
+)
+
+// cloudInitLayout maps the files stored in the VM directory to their path on
+// the ISO, along with the volume label the datasource looks for.
+type cloudInitLayout struct {
+	VolumeID string
+	Files    map[string]string
+}
+
+var cloudInitLayouts = map[string]cloudInitLayout{
+	DatasourceNoCloud: {
+		VolumeID: "cidata",
+		Files: map[string]string{
+			"meta-data":      "meta-data",
+			"vendor-data":    "vendor-data",
+			"user-data":      "user-data",
+			"network-config": "network-config",
+		},
+	},
+	DatasourceConfigDrive: {
+		VolumeID: "config-2",
+		Files: map[string]string{
+			"meta-data":      "openstack/latest/meta_data.json",
+			"vendor-data":    "openstack/latest/vendor_data.json",
+			"user-data":      "openstack/latest/user_data",
+			"network-config": "openstack/latest/network_data.json",
+		},
+	},
+}
+
+// IsCloudInitDatasource reports whether GenerateCloudInitISO supports name.
+func IsCloudInitDatasource(name string) bool {
+	_, ok := cloudInitLayouts[name]
+	return ok
+}
+
+// GenerateCloudInitISO creates a cloud-init ISO for the given datasource,
+// including an empty one if no files are available. A failed generation
+// leaves the previous ISO in place.
+func GenerateCloudInitISO(ctx context.Context, dir string, datasource string) error {
+	layout, ok := cloudInitLayouts[datasource]
+	if !ok {
+		return fmt.Errorf("unknown cloud-init datasource %q", datasource)
+	}
 	isoPath := filepath.Join(dir, "cloud-init.iso")
-	files := []string{
-		filepath.Join(dir, "meta-data"),
-		filepath.Join(dir, "vendor-data"),
-		filepath.Join(dir, "user-data"),
-		filepath.Join(dir, "network-config"),
+
+	// Graft the files that exist onto their path in the ISO, sorted so the
+	// command line is stable
+	var sources []string
+	for source := range layout.Files {
+		sources = append(sources, source)
 	}
+	sort.Strings(sources)
 
-	// Filter out missing files
-	var validFiles []string
-	for _, file := range files {
+	var graftPoints []string
+	for _, source := range sources {
+		file := filepath.Join(dir, source)
 		if _, err := os.Stat(file); err == nil {
-			validFiles = append(validFiles, file)
+			graftPoints = append(graftPoints, layout.Files[source]+"="+file)
 		}
 	}
 
 	// Ensure at least one file (use /dev/null as a placeholder for an empty ISO to ensure valid libvirt XML spec)
-	if len(validFiles) == 0 {
-		validFiles = append(validFiles, "/dev/null")
+	if len(graftPoints) == 0 {
+		graftPoints = append(graftPoints, "/dev/null")
+	}
+
+	if err := BuildISO(ctx, isoPath, layout.VolumeID, graftPoints); err != nil {
+		return fmt.Errorf("failed to create cloud-init ISO: %w", err)
 	}
 
-	_, err := cmdutil.Execute("genisoimage",
+	fmt.Println("Successfully created", isoPath)
+	return nil
+}
+
+// ErrISOToolUnavailable is returned when genisoimage isn't installed.
+var ErrISOToolUnavailable = errors.New("genisoimage is not installed on the host")
+
+// lookPath finds host binaries; swapped out in tests.
+var lookPath = exec.LookPath
+
+// CheckISOTool reports whether genisoimage is installed.
+func CheckISOTool() error {
+	if _, err := lookPath("genisoimage"); err != nil {
+		return ErrISOToolUnavailable
+	}
+	return nil
+}
+
+// BuildISO writes an ISO 9660 image with Joliet and Rock Ridge extensions
+// labelled volID to outputPath. files are genisoimage graft points, either
+// "path/on/iso=/host/file" or a host file put in the root. A failed build
+// leaves a previous image at outputPath in place.
+func BuildISO(ctx context.Context, outputPath string, volID string, files []string) error {
+	// genisoimage writes next to the ISO, which is only replaced once the
+	// new image is complete
+	tmpPath := outputPath + ".tmp"
+	defer os.Remove(tmpPath) // No-op once the rename succeeded
+
+	_, err := execute(ctx, "genisoimage",
 		append([]string{
-			"-output", isoPath,
-			"-volid", "cidata",
+			"-output", tmpPath,
+			"-volid", volID,
 			"-joliet",
 			"-rock",
-		}, validFiles...)...,
+			"-graft-points",
+		}, files...)...,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create cloud-init ISO: %w", err)
+		return err
+	}
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", outputPath, err)
 	}
-
-	fmt.Println("Successfully created", isoPath)
 	return nil
 }
@@ -0,0 +1,147 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateCloudInitISO(t *testing.T) {
+	tests := []struct {
+		datasource  string
+		volumeID    string
+		graftPoints []string
+	}{
+		{
+			datasource: DatasourceNoCloud,
+			volumeID:   "cidata",
+			graftPoints: []string{
+				"meta-data=%s/meta-data",
+				"user-data=%s/user-data",
+			},
+		},
+		{
+			datasource: DatasourceConfigDrive,
+			volumeID:   "config-2",
+			graftPoints: []string{
+				"openstack/latest/meta_data.json=%s/meta-data",
+				"openstack/latest/user_data=%s/user-data",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.datasource, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range []string{"meta-data", "user-data"} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var gotArgs []string
+			original := execute
+			defer func() { execute = original }()
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				if command != "genisoimage" {
+					t.Fatalf("unexpected command %s", command)
+				}
+				gotArgs = args
+				return "", os.WriteFile(args[1], []byte("iso"), 0644)
+			}
+
+			if err := GenerateCloudInitISO(context.Background(), dir, tt.datasource); err != nil {
+				t.Fatalf("GenerateCloudInitISO() error = %v", err)
+			}
+
+			want := []string{"-output", filepath.Join(dir, "cloud-init.iso.tmp"), "-volid", tt.volumeID, "-joliet", "-rock", "-graft-points"}
+			for _, g := range tt.graftPoints {
+				want = append(want, fmt.Sprintf(g, dir))
+			}
+			if !reflect.DeepEqual(gotArgs, want) {
+				t.Errorf("genisoimage args = %v; want %v", gotArgs, want)
+			}
+		})
+	}
+}
+
+func TestGenerateCloudInitISOKeepsPreviousOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	isoPath := filepath.Join(dir, "cloud-init.iso")
+	if err := os.WriteFile(isoPath, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	original := execute
+	defer func() { execute = original }()
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		// genisoimage may leave a truncated image behind
+		if err := os.WriteFile(args[1], []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return "", fmt.Errorf("command execution failed: genisoimage: No space left on device, exit status 1")
+	}
+
+	if err := GenerateCloudInitISO(context.Background(), dir, DatasourceNoCloud); err == nil {
+		t.Fatal("expected an error when genisoimage fails")
+	}
+	data, err := os.ReadFile(isoPath)
+	if err != nil || string(data) != "previous" {
+		t.Errorf("cloud-init.iso = %q, %v; want the previous image", data, err)
+	}
+	if _, err := os.Stat(isoPath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary ISO left behind: %v", err)
+	}
+}
+
+func TestGenerateCloudInitISOUnknownDatasource(t *testing.T) {
+	if err := GenerateCloudInitISO(context.Background(), t.TempDir(), "ec2"); err == nil {
+		t.Error("expected an error for an unknown datasource")
+	}
+}
+
+func TestBuildISO(t *testing.T) {
+	var gotArgs []string
+	original := execute
+	defer func() { execute = original }()
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		gotArgs = args
+		return "", os.WriteFile(args[1], []byte("iso"), 0644)
+	}
+
+	isoPath := filepath.Join(t.TempDir(), "drivers.iso")
+	files := []string{"OEMDRV/ks.cfg=/tmp/ks.cfg", "/srv/drivers"}
+	if err := BuildISO(context.Background(), isoPath, "OEMDRV", files); err != nil {
+		t.Fatalf("BuildISO() error = %v", err)
+	}
+
+	want := []string{"-output", isoPath + ".tmp", "-volid", "OEMDRV", "-joliet", "-rock", "-graft-points", "OEMDRV/ks.cfg=/tmp/ks.cfg", "/srv/drivers"}
+	if !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("genisoimage args = %v; want %v", gotArgs, want)
+	}
+	if data, err := os.ReadFile(isoPath); err != nil || string(data) != "iso" {
+		t.Errorf("%s = %q, %v; want the built image", isoPath, data, err)
+	}
+}
+
+func TestLiveResizeDisk(t *testing.T) {
+	var gotCommand string
+	var gotArgs []string
+	original := execute
+	defer func() { execute = original }()
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		gotCommand, gotArgs = command, args
+		return "Block device 'vda' is resized", nil
+	}
+
+	if err := LiveResizeDisk(context.Background(), "vm-1", "vda", 40); err != nil {
+		t.Fatalf("LiveResizeDisk() error = %v", err)
+	}
+	want := []string{"blockresize", "vm-1", "vda", "40G"}
+	if gotCommand != "virsh" || !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("executed %s %v, want virsh %v", gotCommand, gotArgs, want)
+	}
+}
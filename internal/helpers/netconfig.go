@@ -0,0 +1,462 @@
+package helpers
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nodeCheck validates one node of a network-config, path names it in errors.
+type nodeCheck func(n *yaml.Node, path string) error
+
+// schema maps the keys a mapping may hold to the checks of their values.
+type schema map[string]nodeCheck
+
+// ValidateNetworkConfig checks a NoCloud network-config against the keys and
+// value types of cloud-init's network config version 1 or 2 (netplan),
+// whichever it declares. The config may be wrapped in a "network" key.
+// Errors name the line and the path of the offending key.
+func ValidateNetworkConfig(data string) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return fmt.Errorf("is empty")
+	}
+	root := doc.Content[0]
+	if err := resolveAliases(root, make(map[*yaml.Node]bool)); err != nil {
+		return err
+	}
+	if root.Kind != yaml.MappingNode {
+		return nodeError(root, "", "must be a mapping")
+	}
+	path := ""
+	if network := mappingValue(root, "network"); network != nil && len(root.Content) == 2 {
+		root, path = network, "network"
+		if root.Kind != yaml.MappingNode {
+			return nodeError(root, path, "must be a mapping")
+		}
+	}
+
+	// "config: disabled" turns off cloud-init's network configuration
+	if config := mappingValue(root, "config"); config != nil && len(root.Content) == 2 &&
+		config.Kind == yaml.ScalarNode && config.Value == "disabled" {
+		return nil
+	}
+
+	version := mappingValue(root, "version")
+	if version == nil {
+		return nodeError(root, path, "version is required")
+	}
+	switch version.Value {
+	case "1":
+		return checkMapping(networkV1, []string{"config"})(root, path)
+	case "2":
+		return checkMapping(networkV2, nil)(root, path)
+	}
+	return nodeError(version, join(path, "version"), "must be 1 or 2")
+}
+
+// resolveAliases replaces the aliases below n by the nodes they refer to and
+// expands merge keys ("<<: *defaults") into their mappings, the way
+// cloud-init reads the config. Nodes shared through anchors are visited
+// once, so nested aliases can't blow up.
+func resolveAliases(n *yaml.Node, seen map[*yaml.Node]bool) error {
+	if seen[n] {
+		return nil
+	}
+	seen[n] = true
+	for i, c := range n.Content {
+		for c.Kind == yaml.AliasNode {
+			c = c.Alias
+		}
+		n.Content[i] = c
+		if err := resolveAliases(c, seen); err != nil {
+			return err
+		}
+	}
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var content, merged []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if key.Tag != "!!merge" {
+			content = append(content, key, value)
+			continue
+		}
+		sources := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			sources = value.Content
+		}
+		for _, source := range sources {
+			if source.Kind != yaml.MappingNode {
+				return nodeError(source, "", "merge key must refer to a mapping")
+			}
+			merged = append(merged, source.Content...)
+		}
+	}
+	// Keys of the mapping itself override merged ones
+	for i := 0; i+1 < len(merged); i += 2 {
+		if mappingValue(&yaml.Node{Content: content}, merged[i].Value) == nil {
+			content = append(content, merged[i], merged[i+1])
+		}
+	}
+	n.Content = content
+	return nil
+}
+
+// Version 1, https://cloudinit.readthedocs.io/en/latest/reference/network-config-format-v1.html
+
+var networkV1 = schema{
+	"version": checkInt,
+	"config":  checkList(checkV1Config),
+}
+
+var v1Routes = checkList(checkMapping(schema{
+	"gateway":     checkString,
+	"network":     checkString,
+	"netmask":     checkString,
+	"destination": checkString,
+	"metric":      checkInt,
+}, nil))
+
+var v1SubnetTypes = []string{"dhcp", "dhcp4", "dhcp6", "static", "static6", "manual",
+	"ipv6_dhcpv6-stateful", "ipv6_dhcpv6-stateless", "ipv6_slaac"}
+
+var v1Subnet = schema{
+	"type":            checkEnum(v1SubnetTypes...),
+	"control":         checkEnum("auto", "hotplug", "manual"),
+	"address":         checkString,
+	"netmask":         checkString,
+	"broadcast":       checkString,
+	"gateway":         checkString,
+	"metric":          checkInt,
+	"dns_nameservers": checkStringList,
+	"dns_search":      checkStringList,
+	"routes":          v1Routes,
+	"ipv4":            checkBool,
+	"ipv6":            checkBool,
+}
+
+func checkV1Subnet(n *yaml.Node, path string) error {
+	if err := checkMapping(v1Subnet, []string{"type"})(n, path); err != nil {
+		return err
+	}
+	if t := mappingValue(n, "type").Value; (t == "static" || t == "static6") && mappingValue(n, "address") == nil {
+		return nodeError(n, path, "address is required for %s subnets", t)
+	}
+	return nil
+}
+
+// v1Interface holds the keys shared by the interface types.
+var v1Interface = schema{
+	"type":        checkString,
+	"name":        checkString,
+	"mac_address": checkString,
+	"mtu":         checkInt,
+	"accept-ra":   checkBool,
+	"subnets":     checkList(checkV1Subnet),
+}
+
+// v1Types are the keys and required keys of the version 1 config types.
+var v1Types = map[string]struct {
+	keys     schema
+	required []string
+}{
+	"physical": {v1Interface, []string{"name"}},
+	"bond": {merge(v1Interface, schema{
+		"bond_interfaces": checkStringList,
+		"params":          checkAnyMapping,
+	}), []string{"name", "bond_interfaces"}},
+	"bridge": {merge(v1Interface, schema{
+		"bridge_interfaces": checkStringList,
+		"params":            checkAnyMapping,
+	}), []string{"name", "bridge_interfaces"}},
+	"vlan": {merge(v1Interface, schema{
+		"vlan_link": checkString,
+		"vlan_id":   checkInt,
+	}), []string{"name", "vlan_link", "vlan_id"}},
+	"nameserver": {schema{
+		"type":      checkString,
+		"address":   checkStringOrList,
+		"search":    checkStringOrList,
+		"interface": checkString,
+	}, nil},
+	"route": {schema{
+		"type":        checkString,
+		"destination": checkString,
+		"gateway":     checkString,
+		"network":     checkString,
+		"netmask":     checkString,
+		"metric":      checkInt,
+	}, nil},
+}
+
+func checkV1Config(n *yaml.Node, path string) error {
+	if n.Kind != yaml.MappingNode {
+		return nodeError(n, path, "must be a mapping")
+	}
+	t := mappingValue(n, "type")
+	if t == nil {
+		return nodeError(n, path, "type is required")
+	}
+	configType, ok := v1Types[t.Value]
+	if !ok {
+		return nodeError(t, join(path, "type"), "must be one of %s", strings.Join(slices.Sorted(maps.Keys(v1Types)), ", "))
+	}
+	return checkMapping(configType.keys, configType.required)(n, path)
+}
+
+// Version 2, https://cloudinit.readthedocs.io/en/latest/reference/network-config-format-v2.html
+
+var v2Nameservers = checkMapping(schema{
+	"addresses": checkStringList,
+	"search":    checkStringList,
+}, nil)
+
+var v2Routes = checkList(checkMapping(schema{
+	"to":                        checkString,
+	"via":                       checkString,
+	"from":                      checkString,
+	"on-link":                   checkBool,
+	"metric":                    checkInt,
+	"type":                      checkString,
+	"scope":                     checkString,
+	"table":                     checkInt,
+	"mtu":                       checkInt,
+	"congestion-window":         checkInt,
+	"advertised-receive-window": checkInt,
+}, nil))
+
+// v2Device holds the keys shared by all device types.
+var v2Device = schema{
+	"renderer":                checkEnum("networkd", "NetworkManager", "sriov"),
+	"dhcp4":                   checkBool,
+	"dhcp6":                   checkBool,
+	"dhcp4-overrides":         checkAnyMapping,
+	"dhcp6-overrides":         checkAnyMapping,
+	"dhcp-identifier":         checkEnum("duid", "mac"),
+	"accept-ra":               checkBool,
+	"addresses":               checkList(checkAny),
+	"ipv6-address-generation": checkString,
+	"ipv6-address-token":      checkString,
+	"ipv6-mtu":                checkInt,
+	"ipv6-privacy":            checkBool,
+	"link-local":              checkStringList,
+	"ignore-carrier":          checkBool,
+	"critical":                checkBool,
+	"gateway4":                checkString,
+	"gateway6":                checkString,
+	"nameservers":             v2Nameservers,
+	"macaddress":              checkString,
+	"mtu":                     checkInt,
+	"optional":                checkBool,
+	"optional-addresses":      checkStringList,
+	"activation-mode":         checkString,
+	"routes":                  v2Routes,
+	"routing-policy":          checkList(checkAnyMapping),
+	"neigh-suppress":          checkBool,
+	"openvswitch":             checkAnyMapping,
+	"networkmanager":          checkAnyMapping,
+}
+
+// v2PhysicalDevice holds the keys of devices matched to hardware.
+var v2PhysicalDevice = merge(v2Device, schema{
+	"match": checkMapping(schema{
+		"name":       checkString,
+		"macaddress": checkString,
+		"driver":     checkStringOrList,
+	}, nil),
+	"set-name":                     checkString,
+	"wakeonlan":                    checkBool,
+	"emit-lldp":                    checkBool,
+	"receive-checksum-offload":     checkBool,
+	"transmit-checksum-offload":    checkBool,
+	"tcp-segmentation-offload":     checkBool,
+	"tcp6-segmentation-offload":    checkBool,
+	"generic-segmentation-offload": checkBool,
+	"generic-receive-offload":      checkBool,
+	"large-receive-offload":        checkBool,
+})
+
+var networkV2 = schema{
+	"version":  checkInt,
+	"renderer": checkEnum("networkd", "NetworkManager"),
+	"ethernets": checkDevices(merge(v2PhysicalDevice, schema{
+		"link":                           checkString,
+		"virtual-function-count":         checkInt,
+		"embedded-switch-mode":           checkString,
+		"delay-virtual-functions-rebind": checkBool,
+		"infiniband-mode":                checkString,
+	}), nil),
+	"bonds": checkDevices(merge(v2Device, schema{
+		"interfaces": checkStringList,
+		"parameters": checkAnyMapping,
+	}), nil),
+	"bridges": checkDevices(merge(v2Device, schema{
+		"interfaces": checkStringList,
+		"parameters": checkAnyMapping,
+	}), nil),
+	"vlans": checkDevices(merge(v2Device, schema{
+		"id":   checkInt,
+		"link": checkString,
+	}), []string{"id", "link"}),
+	// Passed through to netplan, which checks them itself
+	"wifis":             checkAnyMapping,
+	"tunnels":           checkAnyMapping,
+	"vrfs":              checkAnyMapping,
+	"modems":            checkAnyMapping,
+	"dummy-devices":     checkAnyMapping,
+	"virtual-ethernets": checkAnyMapping,
+	"nm-devices":        checkAnyMapping,
+}
+
+// checkDevices checks a mapping of device IDs to device configs.
+func checkDevices(keys schema, required []string) nodeCheck {
+	device := checkMapping(keys, required)
+	return func(n *yaml.Node, path string) error {
+		if n.Kind != yaml.MappingNode {
+			return nodeError(n, path, "must be a mapping of device names")
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := device(n.Content[i+1], join(path, n.Content[i].Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checkMapping checks that n is a mapping of known keys with valid values
+// holding the required keys.
+func checkMapping(keys schema, required []string) nodeCheck {
+	return func(n *yaml.Node, path string) error {
+		if n.Kind != yaml.MappingNode {
+			return nodeError(n, path, "must be a mapping")
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			check, ok := keys[key.Value]
+			if !ok {
+				return nodeError(key, path, "unknown key %q", key.Value)
+			}
+			if err := check(value, join(path, key.Value)); err != nil {
+				return err
+			}
+		}
+		for _, key := range required {
+			if mappingValue(n, key) == nil {
+				return nodeError(n, path, "%s is required", key)
+			}
+		}
+		return nil
+	}
+}
+
+func checkList(item nodeCheck) nodeCheck {
+	return func(n *yaml.Node, path string) error {
+		if n.Kind != yaml.SequenceNode {
+			return nodeError(n, path, "must be a list")
+		}
+		for i, c := range n.Content {
+			if err := item(c, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func checkEnum(values ...string) nodeCheck {
+	return func(n *yaml.Node, path string) error {
+		if n.Kind != yaml.ScalarNode || !slices.Contains(values, n.Value) {
+			return nodeError(n, path, "must be one of %s", strings.Join(values, ", "))
+		}
+		return nil
+	}
+}
+
+func checkString(n *yaml.Node, path string) error {
+	if n.Kind != yaml.ScalarNode {
+		return nodeError(n, path, "must be a string")
+	}
+	return nil
+}
+
+func checkInt(n *yaml.Node, path string) error {
+	if _, err := strconv.Atoi(n.Value); n.Kind != yaml.ScalarNode || err != nil {
+		return nodeError(n, path, "must be an integer")
+	}
+	return nil
+}
+
+// checkBool accepts the YAML 1.1 booleans cloud-init and netplan read, such
+// as yes and off, next to true and false.
+func checkBool(n *yaml.Node, path string) error {
+	booleans := []string{"true", "false", "yes", "no", "on", "off", "y", "n"}
+	if n.Kind != yaml.ScalarNode || !slices.Contains(booleans, strings.ToLower(n.Value)) {
+		return nodeError(n, path, "must be true or false")
+	}
+	return nil
+}
+
+var checkStringList = checkList(checkString)
+
+func checkStringOrList(n *yaml.Node, path string) error {
+	if n.Kind == yaml.SequenceNode {
+		return checkStringList(n, path)
+	}
+	return checkString(n, path)
+}
+
+func checkAnyMapping(n *yaml.Node, path string) error {
+	if n.Kind != yaml.MappingNode {
+		return nodeError(n, path, "must be a mapping")
+	}
+	return nil
+}
+
+func checkAny(*yaml.Node, string) error {
+	return nil
+}
+
+// mappingValue returns the value of key in the mapping n, nil if it's missing.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func nodeError(n *yaml.Node, path string, format string, args ...interface{}) error {
+	if path == "" {
+		return fmt.Errorf("line %d: "+format, append([]interface{}{n.Line}, args...)...)
+	}
+	return fmt.Errorf("line %d: %s: "+format, append([]interface{}{n.Line, path}, args...)...)
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func merge(schemas ...schema) schema {
+	merged := schema{}
+	for _, s := range schemas {
+		for key, check := range s {
+			merged[key] = check
+		}
+	}
+	return merged
+}
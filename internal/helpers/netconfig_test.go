@@ -0,0 +1,114 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+const networkConfigV1 = `version: 1
+config:
+  - type: physical
+    name: eth0
+    mac_address: "52:54:00:12:34:56"
+    subnets:
+      - type: static
+        address: 192.168.1.10/24
+        gateway: 192.168.1.1
+        dns_nameservers: [1.1.1.1]
+  - type: vlan
+    name: eth0.100
+    vlan_link: eth0
+    vlan_id: 100
+    subnets:
+      - type: dhcp
+  - type: nameserver
+    address: [8.8.8.8]
+    search: example.com
+`
+
+const networkConfigV2 = `network:
+  version: 2
+  ethernets:
+    primary:
+      match:
+        macaddress: "52:54:00:12:34:56"
+      set-name: eth0
+      dhcp4: yes
+      addresses:
+        - 10.0.0.5/24
+      routes:
+        - to: default
+          via: 10.0.0.1
+      nameservers:
+        addresses: [10.0.0.1]
+  bonds:
+    bond0:
+      interfaces: [primary]
+      parameters:
+        mode: active-backup
+`
+
+// networkConfigAliases shares settings through an anchor, an alias and a
+// merge key.
+const networkConfigAliases = `version: 2
+ethernets:
+  eth0: &common
+    dhcp4: true
+    nameservers: &dns
+      addresses: [10.0.0.1]
+  eth1:
+    <<: *common
+    dhcp4: false
+    mtu: 9000
+  eth2:
+    nameservers: *dns
+`
+
+func TestValidateNetworkConfig(t *testing.T) {
+	configs := map[string]string{
+		"v1":                 networkConfigV1,
+		"v2":                 networkConfigV2,
+		"aliases":            networkConfigAliases,
+		"disabled":           "network: {config: disabled}\n",
+		"disabled unwrapped": "config: disabled\n",
+	}
+	for name, config := range configs {
+		if err := ValidateNetworkConfig(config); err != nil {
+			t.Errorf("ValidateNetworkConfig(%s) error = %v", name, err)
+		}
+	}
+}
+
+func TestValidateNetworkConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"unknown key", "version: 2\nethernets:\n  eth0:\n    dhcp: true\n", `line 4: ethernets.eth0: unknown key "dhcp"`},
+		{"indentation", "version: 2\nethernets:\n  eth0:\n  dhcp4: true\n", "line 3: ethernets.eth0: must be a mapping"},
+		{"not yaml", "version: 2\nethernets:\n\teth0: {}\n", "yaml: line 3"},
+		{"no version", "ethernets: {}\n", "version is required"},
+		{"unknown version", "version: 3\n", "line 1: version: must be 1 or 2"},
+		{"wrong type", "version: 2\nethernets:\n  eth0:\n    mtu: large\n", "ethernets.eth0.mtu: must be an integer"},
+		{"bad boolean", "version: 2\nethernets:\n  eth0:\n    dhcp4: maybe\n", "ethernets.eth0.dhcp4: must be true or false"},
+		{"vlan without id", "version: 2\nvlans:\n  vlan10:\n    link: eth0\n", "vlans.vlan10: id is required"},
+		{"v1 unknown type", "version: 1\nconfig:\n  - type: wifi\n", "config[0].type: must be one of"},
+		{"v1 static without address", "version: 1\nconfig:\n  - type: physical\n    name: eth0\n    subnets:\n      - type: static\n", "config[0].subnets[0]: address is required"},
+		{"v1 without config", "version: 1\n", "config is required"},
+		{"empty", "", "is empty"},
+		{"unknown key through an alias", "version: 2\nethernets:\n  eth0: &bad\n    dhcp: true\n  eth1: *bad\n", `unknown key "dhcp"`},
+		{"unknown key through a merge", "version: 2\ndefaults: &bad\n  dhcp: true\n", `unknown key "defaults"`},
+		{"merged unknown key", "version: 2\nethernets:\n  eth0: &base\n    dhcp4: true\n  eth1:\n    <<: [*base, {speed: 10}]\n", `ethernets.eth1: unknown key "speed"`},
+		{"merge of a list", "version: 2\nethernets:\n  eth0:\n    <<: [[1]]\n", "merge key must refer to a mapping"},
+		{"disabled with other keys", "network:\n  config: disabled\n  version: 1\n", "config: must be a list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateNetworkConfig(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ValidateNetworkConfig() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package helpers
 import (
 	"bufio"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -26,3 +27,66 @@ func ParseDomainStatus(dominfo string) (string, error) {
 
 	return "", fmt.Errorf("status not found in domain info")
 }
+
+// DomainResources holds the CPU and memory allocation reported by dominfo.
+type DomainResources struct {
+	VCPUs        int    `json:"vcpus"`
+	MemoryKiB    uint64 `json:"memory_kib"`     // Currently assigned memory
+	MaxMemoryKiB uint64 `json:"max_memory_kib"` // Upper bound without a restart
+}
+
+// ParseDomainResources extracts the vCPU count and memory sizes from dominfo.
+func ParseDomainResources(dominfo string) (*DomainResources, error) {
+	res := &DomainResources{}
+	found := 0
+
+	scanner := bufio.NewScanner(strings.NewReader(dominfo))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch strings.TrimSpace(key) {
+		case "CPU(s)":
+			res.VCPUs, err = strconv.Atoi(value)
+			found++
+		case "Max memory":
+			res.MaxMemoryKiB, err = parseKiB(value)
+			found++
+		case "Used memory":
+			res.MemoryKiB, err = parseKiB(value)
+			found++
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning output: %w", err)
+	}
+	if found < 3 {
+		return nil, fmt.Errorf("resources not found in domain info")
+	}
+	return res, nil
+}
+
+// parseKiB parses values like "2097152 KiB".
+func parseKiB(value string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(value, "KiB")), 10, 64)
+}
+
+// ParseDominfoField returns the value of a "Key: value" line from dominfo.
+func ParseDominfoField(dominfo string, field string) (string, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(dominfo))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == field {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
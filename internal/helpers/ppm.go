@@ -0,0 +1,105 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// maxPPMPixels bounds the size of decoded screendumps, well above any
+// display QEMU emulates, so a bogus header can't allocate gigabytes.
+const maxPPMPixels = 16384 * 16384
+
+// maxPPMToken bounds the length of a header token.
+const maxPPMToken = 20
+
+// IsPNG reports whether the data starts with the PNG file signature.
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// IsPPM reports whether the data starts with a binary PPM (P6) header.
+func IsPPM(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("P6"))
+}
+
+// DecodePPM decodes a binary PPM (P6) image as written by QEMU screendumps.
+func DecodePPM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	var header [4]int
+	magic, err := readPPMToken(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PPM header: %w", err)
+	}
+	if magic != "P6" {
+		return nil, fmt.Errorf("unsupported PPM format %q", magic)
+	}
+	for i := 1; i < len(header); i++ {
+		tok, err := readPPMToken(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PPM header: %w", err)
+		}
+		if _, err := fmt.Sscanf(tok, "%d", &header[i]); err != nil {
+			return nil, fmt.Errorf("invalid PPM header value %q", tok)
+		}
+	}
+
+	width, height, maxVal := header[1], header[2], header[3]
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid PPM dimensions %dx%d", width, height)
+	}
+	if width > maxPPMPixels/height {
+		return nil, fmt.Errorf("PPM dimensions %dx%d exceed %d pixels", width, height, maxPPMPixels)
+	}
+	if maxVal <= 0 || maxVal > 255 {
+		return nil, fmt.Errorf("unsupported PPM max value %d", maxVal)
+	}
+
+	pixels := make([]byte, width*height*3)
+	if _, err := io.ReadFull(br, pixels); err != nil {
+		return nil, fmt.Errorf("failed to read PPM pixel data: %w", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		img.Set(i%width, i/width, color.RGBA{
+			R: uint8(int(pixels[i*3]) * 255 / maxVal),
+			G: uint8(int(pixels[i*3+1]) * 255 / maxVal),
+			B: uint8(int(pixels[i*3+2]) * 255 / maxVal),
+			A: 255,
+		})
+	}
+	return img, nil
+}
+
+// readPPMToken reads the next whitespace separated header token, skipping comments.
+// The single whitespace byte following the token is consumed.
+func readPPMToken(br *bufio.Reader) (string, error) {
+	var tok []byte
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '#' && len(tok) == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if len(tok) > 0 {
+				return string(tok), nil
+			}
+		case len(tok) == maxPPMToken:
+			return "", fmt.Errorf("header token longer than %d bytes", maxPPMToken)
+		default:
+			tok = append(tok, c)
+		}
+	}
+}
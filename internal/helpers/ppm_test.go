@@ -0,0 +1,53 @@
+package helpers
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestDecodePPM(t *testing.T) {
+	// A 2x1 image with a comment in the header and a max value of 15
+	data := append([]byte("P6\n# qemu screendump\n2 1\n15\n"), 15, 0, 0, 0, 15, 15)
+	if !IsPPM(data) || IsPNG(data) {
+		t.Fatal("a P6 image isn't detected as PPM")
+	}
+
+	img, err := DecodePPM(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodePPM() error = %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("bounds = %v, want 2x1", b)
+	}
+	want := []color.RGBA{{255, 0, 0, 255}, {0, 255, 255, 255}}
+	for x, w := range want {
+		if got := color.RGBAModel.Convert(img.At(x, 0)); got != w {
+			t.Errorf("pixel %d = %v, want %v", x, got, w)
+		}
+	}
+}
+
+func TestDecodePPMErrors(t *testing.T) {
+	tests := []struct {
+		name, data, wantErr string
+	}{
+		{"ascii format", "P3\n1 1\n255\n", "unsupported PPM format"},
+		{"zero width", "P6\n0 1\n255\n", "invalid PPM dimensions"},
+		{"too large", "P6\n100000 100000\n255\n", "exceed"},
+		{"overflowing dimensions", "P6\n4611686018427387904 4\n255\n", "exceed"},
+		{"long token", "P6\n11111111111111111111111111 1\n255\n", "longer than"},
+		{"16 bit", "P6\n1 1\n65535\n", "unsupported PPM max value"},
+		{"truncated pixels", "P6\n2 2\n255\n\x00\x00", "failed to read PPM pixel data"},
+		{"truncated header", "P6\n2", "failed to read PPM header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodePPM(strings.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("DecodePPM() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Package httpclient builds the HTTP clients the controller uses to talk to
+// other services, such as webhook receivers and image servers.
+package httpclient
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/version"
+)
+
+// UserAgent identifies the controller and the node it runs on.
+func UserAgent() string {
+	agent := "libvirt-controller/" + version.Version
+	if nodeID := os.Getenv("NODE_ID"); nodeID != "" {
+		agent += " (node " + nodeID + ")"
+	}
+	return agent
+}
+
+// extraHeaders parses HTTP_EXTRA_HEADERS, a comma separated list of
+// Name=value headers sent with every request, e.g. for proxy authentication.
+func extraHeaders() http.Header {
+	header := make(http.Header)
+	for _, item := range config.List("HTTP_EXTRA_HEADERS") {
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			log.Printf("Ignoring invalid HTTP_EXTRA_HEADERS entry %q, expected Name=value", item)
+			continue
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header
+}
+
+// New returns a client whose requests carry the User-Agent and the
+// HTTP_EXTRA_HEADERS, also on the CONNECT requests to an HTTPS proxy.
+// A timeout of 0 means no timeout. All clients share one transport, so
+// they reuse its idle connections.
+func New(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: sharedTransport(),
+	}
+}
+
+// sharedTransport builds the transport of New once, on first use.
+var sharedTransport = sync.OnceValue(func() *transport {
+	header := extraHeaders()
+	header.Set("User-Agent", UserAgent())
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.ProxyConnectHeader = header.Clone()
+	return &transport{base: base, header: header}
+})
+
+// transport adds headers the request doesn't set itself.
+type transport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,46 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewSetsHeaders(t *testing.T) {
+	t.Setenv("NODE_ID", "node-7")
+	t.Setenv("HTTP_EXTRA_HEADERS", "X-Proxy-Token=abc, X-Team = infra,invalid")
+
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("X-Team", "storage") // Set by the caller, must win
+	resp, err := New(time.Second).Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if ua := got.Get("User-Agent"); ua != "libvirt-controller/dev (node node-7)" {
+		t.Errorf("User-Agent = %q", ua)
+	}
+	if v := got.Get("X-Proxy-Token"); v != "abc" {
+		t.Errorf("X-Proxy-Token = %q, want abc", v)
+	}
+	if v := got.Get("X-Team"); v != "storage" {
+		t.Errorf("X-Team = %q, the caller's header must not be replaced", v)
+	}
+	if req.Header.Get("User-Agent") != "" {
+		t.Error("the caller's request was modified")
+	}
+}
+
+func TestNewSharesTransport(t *testing.T) {
+	if New(time.Second).Transport != New(0).Transport {
+		t.Error("clients don't share a transport")
+	}
+}
@@ -0,0 +1,245 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/quota"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	// StatusInterrupted marks jobs that were running when the controller
+	// stopped. Their outcome is unknown.
+	StatusInterrupted Status = "interrupted"
+)
+
+// Job is a long running operation executed in the background.
+type Job struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	DomainID  string      `json:"domain_id,omitempty"`
+	Tenant    string      `json:"tenant,omitempty"` // Tenant that started the job, "" for the admin token
+	Status    Status      `json:"status"`
+	Progress  float64     `json:"progress"` // Percentage between 0 and 100
+	Message   string      `json:"message,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Result    interface{} `json:"result,omitempty"` // Set by jobs started with StartWithResult
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// Reporter lets a running job publish its progress and a status message.
+type Reporter func(progress float64, message string)
+
+// Func is the work performed by a job.
+type Func func(ctx context.Context, report Reporter) error
+
+// ResultFunc is the work performed by a job with a result, which is kept
+// whether or not the job failed.
+type ResultFunc func(ctx context.Context, report Reporter) (interface{}, error)
+
+// Store keeps track of jobs in memory, and in a file once Persist is called.
+type Store struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+	ttl  time.Duration
+	path string
+}
+
+// DefaultTTL is how long finished jobs stay queryable.
+const DefaultTTL = 24 * time.Hour
+
+// Default is the store used by the HTTP handlers.
+var Default = NewStore(DefaultTTL)
+
+// NewStore creates an empty job store that forgets finished jobs after ttl.
+func NewStore(ttl time.Duration) *Store {
+	return &Store{jobs: make(map[string]*Job), ttl: ttl}
+}
+
+// Start registers a new job and runs fn in a separate goroutine. fn gets
+// the values of ctx, such as the libvirt host of the request, but not its
+// cancellation, the job outlives the request. It returns a snapshot of the
+// job as it was created.
+func (s *Store) Start(ctx context.Context, jobType string, domainID string, fn Func) Job {
+	return s.StartWithResult(ctx, jobType, domainID, func(ctx context.Context, report Reporter) (interface{}, error) {
+		return nil, fn(ctx, report)
+	})
+}
+
+// StartWithResult is Start for work reporting a result, such as the outcome
+// per domain of a job acting on several.
+func (s *Store) StartWithResult(ctx context.Context, jobType string, domainID string, fn ResultFunc) Job {
+	now := time.Now().UTC()
+	job := &Job{
+		ID:        newID(),
+		Type:      jobType,
+		DomainID:  domainID,
+		Tenant:    quota.TenantFrom(ctx),
+		Status:    StatusRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mu.Lock()
+	s.pruneLocked(now)
+	s.jobs[job.ID] = job
+	s.saveLocked()
+	snapshot := *job
+	s.mu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		// Progress is not persisted, a restart interrupts the job anyway
+		report := func(progress float64, message string) {
+			s.update(job.ID, false, func(j *Job) {
+				j.Progress = progress
+				j.Message = message
+			})
+		}
+
+		result, err := fn(ctx, report)
+
+		s.update(job.ID, true, func(j *Job) {
+			j.Result = result
+			if err != nil {
+				log.Printf("job %s (%s) failed: %v", j.ID, j.Type, err)
+				j.Status = StatusFailed
+				j.Error = err.Error()
+				return
+			}
+			j.Status = StatusCompleted
+			j.Progress = 100
+		})
+	}()
+
+	return snapshot
+}
+
+// Get returns a copy of the job with the given ID.
+func (s *Store) Get(id string) (Job, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns copies of all known jobs, oldest first.
+func (s *Store) List() []Job {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, *job)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+func (s *Store) update(id string, persist bool, fn func(j *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now().UTC()
+		if persist {
+			s.saveLocked()
+		}
+	}
+}
+
+// Persist loads the jobs saved in the file at path and keeps saving the
+// store there whenever a job starts or finishes. Jobs that were still
+// running when the file was last written are marked interrupted, finished
+// jobs stay queryable for the rest of their TTL.
+func (s *Store) Persist(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create job state directory: %w", err)
+	}
+
+	var saved []*Job
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read job state: %w", err)
+	default:
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("failed to parse job state %s: %w", path, err)
+		}
+	}
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range saved {
+		if job.Status == StatusRunning {
+			job.Status = StatusInterrupted
+			job.Error = "the controller restarted while the job was running"
+			job.UpdatedAt = now
+		}
+		if _, ok := s.jobs[job.ID]; !ok {
+			s.jobs[job.ID] = job
+		}
+	}
+	s.path = path
+	s.pruneLocked(now)
+	s.saveLocked()
+	return nil
+}
+
+// saveLocked writes the jobs to the state file, if any. Failures are only
+// logged, the in-memory store stays authoritative. Callers hold s.mu.
+func (s *Store) saveLocked() {
+	if s.path == "" {
+		return
+	}
+	list := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, job)
+	}
+	data, err := json.Marshal(list)
+	if err == nil {
+		err = filesystem.SaveFileAtomic(filepath.Dir(s.path), filepath.Base(s.path), data)
+	}
+	if err != nil {
+		log.Printf("failed to save job state to %s: %v", s.path, err)
+	}
+}
+
+// pruneLocked drops finished jobs older than the TTL. Callers hold s.mu.
+func (s *Store) pruneLocked(now time.Time) {
+	for id, job := range s.jobs {
+		if job.Status != StatusRunning && now.Sub(job.UpdatedAt) > s.ttl {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,142 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/cmdutil"
+)
+
+func TestPersistReloadsJobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "jobs.json")
+
+	before := NewStore(time.Hour)
+	if err := before.Persist(path); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	block := make(chan struct{})
+	running := before.Start(context.Background(), "domain.migrate", "vm-1", func(ctx context.Context, report Reporter) error {
+		<-block
+		return nil
+	})
+	// Let the job finish saving before the temp dir is removed
+	t.Cleanup(func() {
+		close(block)
+		waitForStatus(t, before, running.ID, StatusCompleted)
+	})
+	failed := before.Start(context.Background(), "disk.download", "", func(ctx context.Context, report Reporter) error {
+		return errors.New("connection reset")
+	})
+	waitForStatus(t, before, failed.ID, StatusFailed)
+
+	// A new store on the same file stands in for a restarted controller
+	after := NewStore(time.Hour)
+	if err := after.Persist(path); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	if job, ok := after.Get(running.ID); !ok || job.Status != StatusInterrupted || job.DomainID != "vm-1" {
+		t.Errorf("running job reloaded as %+v, %t; want interrupted", job, ok)
+	}
+	if job, ok := after.Get(failed.ID); !ok || job.Status != StatusFailed || job.Error != "connection reset" {
+		t.Errorf("failed job reloaded as %+v, %t; want it unchanged", job, ok)
+	}
+}
+
+func TestJobKeepsRequestValues(t *testing.T) {
+	s := NewStore(time.Hour)
+	ctx, cancel := context.WithCancel(cmdutil.WithConnectURI(context.Background(), "qemu+ssh://host-2/system"))
+	cancel() // The request is over before the job runs
+
+	var uri string
+	var ctxErr error
+	job := s.Start(ctx, "domain.migrate", "vm-1", func(ctx context.Context, report Reporter) error {
+		uri, ctxErr = cmdutil.ConnectURI(ctx), ctx.Err()
+		return nil
+	})
+	waitForStatus(t, s, job.ID, StatusCompleted)
+
+	if uri != "qemu+ssh://host-2/system" {
+		t.Errorf("job ran against %q, want the host of the request", uri)
+	}
+	if ctxErr != nil {
+		t.Errorf("job context was canceled with the request: %v", ctxErr)
+	}
+}
+
+func TestJobProgressAndResult(t *testing.T) {
+	s := NewStore(time.Hour)
+	reported := make(chan struct{})
+	finish := make(chan error)
+	job := s.StartWithResult(context.Background(), "domain.batch", "", func(ctx context.Context, report Reporter) (interface{}, error) {
+		report(40, "2 of 5 domains done")
+		close(reported)
+		return map[string]string{"vm-1": "ok"}, <-finish
+	})
+	if job.Status != StatusRunning || job.Progress != 0 {
+		t.Errorf("new job = %+v, want running without progress", job)
+	}
+
+	<-reported
+	if got, _ := s.Get(job.ID); got.Progress != 40 || got.Message != "2 of 5 domains done" {
+		t.Errorf("running job = %+v, want the reported progress", got)
+	}
+
+	finish <- errors.New("vm-2 failed")
+	waitForStatus(t, s, job.ID, StatusFailed)
+	got, _ := s.Get(job.ID)
+	if got.Error != "vm-2 failed" || got.Progress != 40 {
+		t.Errorf("failed job = %+v", got)
+	}
+	// The result of a failed job is kept, e.g. the domains that did succeed
+	if result, ok := got.Result.(map[string]string); !ok || result["vm-1"] != "ok" {
+		t.Errorf("result = %#v, want it kept", got.Result)
+	}
+
+	done := s.Start(context.Background(), "disk.download", "", func(ctx context.Context, report Reporter) error {
+		report(10, "")
+		return nil
+	})
+	waitForStatus(t, s, done.ID, StatusCompleted)
+	if got, _ := s.Get(done.ID); got.Progress != 100 {
+		t.Errorf("completed job progress = %v, want 100", got.Progress)
+	}
+	if _, ok := s.Get("unknown"); ok {
+		t.Error("Get() found a job that was never started")
+	}
+}
+
+func TestListAndPrune(t *testing.T) {
+	s := NewStore(time.Hour)
+	now := time.Now().UTC()
+	s.jobs["old"] = &Job{ID: "old", Status: StatusCompleted, CreatedAt: now.Add(-3 * time.Hour), UpdatedAt: now.Add(-2 * time.Hour)}
+	s.jobs["recent"] = &Job{ID: "recent", Status: StatusFailed, CreatedAt: now.Add(-2 * time.Hour), UpdatedAt: now.Add(-time.Minute)}
+	s.jobs["stuck"] = &Job{ID: "stuck", Status: StatusRunning, CreatedAt: now.Add(-4 * time.Hour), UpdatedAt: now.Add(-4 * time.Hour)}
+
+	// Starting a job forgets finished jobs past the TTL, never running ones
+	job := s.Start(context.Background(), "domain.migrate", "vm-1", func(ctx context.Context, report Reporter) error { return nil })
+	waitForStatus(t, s, job.ID, StatusCompleted)
+
+	var ids []string
+	for _, j := range s.List() {
+		ids = append(ids, j.ID)
+	}
+	if want := []string{"stuck", "recent", job.ID}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("List() = %v, want %v oldest first", ids, want)
+	}
+}
+
+func waitForStatus(t *testing.T, s *Store, id string, status Status) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ := s.Get(id); job.Status == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach status %s", id, status)
+}
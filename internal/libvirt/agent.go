@@ -1,12 +1,12 @@
 package libvirt
 
 import (
-	"libvirt-controller/internal/cmdutil"
+	"context"
 	"libvirt-controller/internal/helpers"
 )
 
 // QemuAgentFileCommand executes a file command through the qemu guest agent
-func QemuAgentFileCommand(domainName string, command string, path string) (
+func QemuAgentFileCommand(ctx context.Context, domainName string, command string, path string) (
 	string,
 	error,
 ) {
@@ -16,11 +16,12 @@ func QemuAgentFileCommand(domainName string, command string, path string) (
 		`{"execute":"guest-file-` + command + `", "arguments":{"path":"` +
 			path + `"}}`,
 	}
-	return cmdutil.Execute("virsh", args...)
+	return virsh(ctx, args...)
 }
 
 // QemuAgentExec executes a command through the qemu guest agent
 func QemuAgentExec(
+	ctx context.Context,
 	domainName string,
 	command string,
 	args []string,
@@ -33,17 +34,17 @@ func QemuAgentExec(
 			`", "arg":` + helpers.ToJson(args) + `, "capture-output":` +
 			helpers.ToJson(captureOutput) + `}}`,
 	}
-	return cmdutil.Execute("virsh", execArgs...)
+	return virsh(ctx, execArgs...)
 }
 
 // QemuAgentPing checks if the qemu guest agent is running
-func QemuAgentPing(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "qemu-agent-command", domainName,
+func QemuAgentPing(ctx context.Context, domainName string) (string, error) {
+	return virsh(ctx, "qemu-agent-command", domainName,
 		`{"execute":"guest-ping"}`)
 }
 
 // QemuAgentShutdown shuts down the guest OS through the qemu guest agent
-func QemuAgentShutdown(domainName string, mode string) (string, error) {
-	return cmdutil.Execute("virsh", "qemu-agent-command", domainName,
+func QemuAgentShutdown(ctx context.Context, domainName string, mode string) (string, error) {
+	return virsh(ctx, "qemu-agent-command", domainName,
 		`{"execute":"guest-shutdown", "arguments":{"mode":"`+mode+`"}}`)
 }
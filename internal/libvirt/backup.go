@@ -0,0 +1,38 @@
+package libvirt
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// CreateExternalSnapshot redirects the writes of the disks in overlays
+// (target to overlay file) into new qcow2 overlays, leaving their current
+// images unchanged until BlockCommit merges the overlays back. Other disks of
+// the domain are left out. No snapshot metadata is kept, the overlays are only
+// meant to live for the duration of a backup.
+func CreateExternalSnapshot(ctx context.Context, domainName string, snapshotName string, overlays map[string]string, others []string) (string, error) {
+	cmd := []string{"snapshot-create-as", domainName, snapshotName, "--disk-only", "--atomic", "--no-metadata"}
+
+	targets := make([]string, 0, len(overlays))
+	for target := range overlays {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		// virsh splits diskspec on commas, a literal one is written twice
+		file := strings.ReplaceAll(overlays[target], ",", ",,")
+		cmd = append(cmd, "--diskspec", target+",snapshot=external,file="+file)
+	}
+	for _, target := range others {
+		cmd = append(cmd, "--diskspec", target+",snapshot=no")
+	}
+	return virsh(ctx, cmd...)
+}
+
+// BlockCommit merges the active overlay of target back into its backing
+// image and switches the domain back to that image. It waits until the
+// commit is done.
+func BlockCommit(ctx context.Context, domainName string, target string) (string, error) {
+	return virsh(ctx, "blockcommit", domainName, target, "--active", "--pivot", "--wait")
+}
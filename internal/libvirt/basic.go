@@ -1,13 +1,15 @@
 package libvirt
 
 import (
-	"libvirt-controller/internal/cmdutil"
+	"context"
+	"fmt"
 	"log"
+	"strconv"
 	"strings"
 )
 
-func GetDomains() []string {
-	out, err := cmdutil.Execute("virsh", "list", "--name")
+func GetDomains(ctx context.Context) []string {
+	out, err := virshRead(ctx, "list", "--name")
 	if err != nil {
 		log.Printf("error listing libvirt domains")
 	}
@@ -22,42 +24,103 @@ func GetDomains() []string {
 }
 
 // DefineDomain defines a domain from an XML file
-func DefineDomain(xmlConfigPath string) (string, error) {
-	return cmdutil.Execute("virsh", "define", xmlConfigPath)
+func DefineDomain(ctx context.Context, xmlConfigPath string) (string, error) {
+	return virsh(ctx, "define", xmlConfigPath)
 }
 
-func UndefineDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "undefine", domainName)
+func UndefineDomain(ctx context.Context, domainName string) (string, error) {
+	// --nvram also removes the variable store of UEFI domains, libvirt refuses
+	// to undefine them otherwise. It is a no-op for BIOS domains.
+	return virsh(ctx, "undefine", domainName, "--nvram")
 }
 
-func StartDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "start", domainName)
+func StartDomain(ctx context.Context, domainName string) (string, error) {
+	defer InvalidateStatsCache()
+	return virsh(ctx, "start", domainName)
 }
 
-func RebootDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "reboot", domainName)
+// StartDomainPaused starts a domain with its vCPUs paused, so devices can be
+// attached before the guest runs. ResumeDomain lets it run.
+func StartDomainPaused(ctx context.Context, domainName string) (string, error) {
+	defer InvalidateStatsCache()
+	return virsh(ctx, "start", domainName, "--paused")
 }
 
-func ResetDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "reset", domainName)
+func RebootDomain(ctx context.Context, domainName string) (string, error) {
+	return virsh(ctx, "reboot", domainName)
 }
 
-func ShutdownDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "shutdown", domainName)
+func ResetDomain(ctx context.Context, domainName string) (string, error) {
+	return virsh(ctx, "reset", domainName)
 }
 
-func DestroyDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "destroy", domainName)
+func ShutdownDomain(ctx context.Context, domainName string) (string, error) {
+	defer InvalidateStatsCache()
+	return virsh(ctx, "shutdown", domainName)
 }
 
-func SuspendDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "suspend", domainName)
+func DestroyDomain(ctx context.Context, domainName string) (string, error) {
+	defer InvalidateStatsCache()
+	return virsh(ctx, "destroy", domainName)
 }
 
-func ResumeDomain(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "resume", domainName)
+func SuspendDomain(ctx context.Context, domainName string) (string, error) {
+	return virsh(ctx, "suspend", domainName)
 }
 
-func GetDomainInfo(domainName string) (string, error) {
-	return cmdutil.Execute("virsh", "dominfo", domainName)
+func ResumeDomain(ctx context.Context, domainName string) (string, error) {
+	return virsh(ctx, "resume", domainName)
+}
+
+func GetDomainInfo(ctx context.Context, domainName string) (string, error) {
+	return virshRead(ctx, "dominfo", domainName)
+}
+
+// SetMemory changes the memory assigned to a domain, in KiB.
+// live applies it to the running guest, config to the persistent definition.
+func SetMemory(ctx context.Context, domainName string, kib uint64, live bool, config bool) (string, error) {
+	cmd := []string{"setmem", domainName, fmt.Sprintf("%dKiB", kib)}
+	if live {
+		cmd = append(cmd, "--live")
+	}
+	if config {
+		cmd = append(cmd, "--config")
+	}
+	return virsh(ctx, cmd...)
+}
+
+// SetMemoryStatsPeriod makes the balloon driver of a running domain report
+// guest memory statistics every seconds, 0 stops the reports.
+func SetMemoryStatsPeriod(ctx context.Context, domainName string, seconds int) (string, error) {
+	return virsh(ctx, "dommemstat", domainName, "--period", strconv.Itoa(seconds), "--live")
+}
+
+// SetVCPUs changes the number of active vCPUs of a domain, up to its
+// maximum. live hotplugs them into the running guest, config changes the
+// persistent definition.
+func SetVCPUs(ctx context.Context, domainName string, count int, live bool, config bool) (string, error) {
+	cmd := []string{"setvcpus", domainName, strconv.Itoa(count)}
+	if live {
+		cmd = append(cmd, "--live")
+	}
+	if config {
+		cmd = append(cmd, "--config")
+	}
+	return virsh(ctx, cmd...)
+}
+
+// SetMaxMemory changes the maximum memory of the persistent definition, in
+// KiB. It takes effect the next time the domain boots.
+func SetMaxMemory(ctx context.Context, domainName string, kib uint64) (string, error) {
+	return virsh(ctx, "setmaxmem", domainName, fmt.Sprintf("%dKiB", kib), "--config")
+}
+
+// DumpXML returns the domain XML known to libvirt. With inactive set it is
+// the persistent definition used on the next boot instead of the live one.
+func DumpXML(ctx context.Context, domainName string, inactive bool) (string, error) {
+	cmd := []string{"dumpxml", domainName}
+	if inactive {
+		cmd = append(cmd, "--inactive")
+	}
+	return virsh(ctx, cmd...)
 }
@@ -0,0 +1,61 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStartDomainPaused(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	tests := []struct {
+		name  string
+		start func(context.Context, string) (string, error)
+		want  []string
+	}{
+		{"running", StartDomain, []string{"start", "vm-1"}},
+		{"paused", StartDomainPaused, []string{"start", "vm-1", "--paused"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				got = args
+				return "Domain 'vm-1' started\n", nil
+			}
+			if _, err := tt.start(context.Background(), "vm-1"); err != nil {
+				t.Fatalf("start error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("virsh args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireRunning(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	tests := []struct {
+		state   string
+		wantErr error
+	}{
+		{"running", nil},
+		{"shut off", ErrDomainNotRunning},
+		{"paused", ErrDomainNotRunning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				return "Id:             -\nName:           vm-1\nState:          " + tt.state + "\n", nil
+			}
+			if err := RequireRunning(context.Background(), "vm-1"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("RequireRunning() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrNoGraphicalConsole is returned when a domain has no display to capture.
+	ErrNoGraphicalConsole = errors.New("domain has no graphical console")
+	// ErrDomainNotRunning is returned when an operation needs an active domain.
+	ErrDomainNotRunning = errors.New("domain is not running")
+)
+
+// Screenshot captures the domain's primary console into destPath.
+// Depending on the hypervisor the written image is either PPM or PNG.
+func Screenshot(ctx context.Context, domainName string, destPath string) (string, error) {
+	out, err := virsh(ctx, "screenshot", domainName, destPath)
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		if strings.Contains(msg, "no screens") ||
+			strings.Contains(msg, "no graphics") ||
+			strings.Contains(msg, "there is no console") {
+			return out, ErrNoGraphicalConsole
+		}
+		if strings.Contains(msg, "domain is not running") {
+			return out, ErrDomainNotRunning
+		}
+		return out, err
+	}
+	return out, nil
+}
@@ -1,59 +1,37 @@
 package libvirt
 
 import (
-	"fmt"
-	"libvirt-controller/internal/cmdutil"
-	"log"
+	"context"
 	"strings"
 )
 
-// For Metrics
-type diskInfo struct {
-	Name string
+// BlockDevice is a disk or cdrom attached to a domain.
+type BlockDevice struct {
+	Type   string `json:"type"`   // file, block, network, ...
+	Device string `json:"device"` // disk, cdrom, ...
+	Target string `json:"target"`
+	Source string `json:"source"` // "-" for empty drives
 }
 
-func GetDomainDisks(domain string) []diskInfo {
-	out, err := cmdutil.Execute("virsh", "domblklist", domain)
+// ListBlockDevices returns the block devices of a domain.
+func ListBlockDevices(ctx context.Context, domain string) ([]BlockDevice, error) {
+	out, err := virsh(ctx, "domblklist", domain, "--details")
 	if err != nil {
-		log.Printf("error listing libvirt domain's disks")
+		return nil, err
 	}
-	lines := strings.Split(out, "\n")
-	var disks []diskInfo
-	for _, l := range lines {
-		fields := strings.Fields(l)
-		if len(fields) >= 2 && fields[0] != "Target" {
-			disks = append(disks, diskInfo{
-				Name: fields[0],
-			})
-		}
-	}
-	return disks
-}
 
-func GetDiskStats(domain, disk string) map[string]float64 {
-	out, err := cmdutil.Execute("virsh", "domblkstat", domain, disk)
-	if err != nil {
-		log.Printf("error getting disk stats for %s", disk)
-		return nil
-	}
-	lines := strings.Split(out, "\n")
-	stats := make(map[string]float64)
-	for _, l := range lines {
-		fields := strings.Fields(l)
-		if len(fields) == 3 {
-			var val float64
-			fmt.Sscanf(fields[2], "%f", &val)
-			switch fields[1] {
-			case "rd_bytes":
-				stats["rd_bytes"] = val
-			case "rd_req":
-				stats["rd_req"] = val
-			case "wr_bytes":
-				stats["wr_bytes"] = val
-			case "wr_req":
-				stats["wr_req"] = val
-			}
+	devices := []BlockDevice{}
+	for _, fields := range parseTable(out) {
+		// Type, Device, Target, Source; the source may contain spaces
+		if len(fields) < 4 {
+			continue
 		}
+		devices = append(devices, BlockDevice{
+			Type:   fields[0],
+			Device: fields[1],
+			Target: fields[2],
+			Source: strings.Join(fields[3:], " "),
+		})
 	}
-	return stats
+	return devices, nil
 }
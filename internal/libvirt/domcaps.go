@@ -0,0 +1,250 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+)
+
+// defaultDomCapsCacheTTL is how long domain capabilities are reused. They only
+// change when qemu, libvirt or the firmware packages of the host change.
+const defaultDomCapsCacheTTL = time.Hour
+
+// ErrUnsupportedMachine is returned when the host has no emulator for the
+// requested architecture or it doesn't know the machine type.
+var ErrUnsupportedMachine = errors.New("unsupported architecture or machine type")
+
+// fetchDomCaps runs the domcapabilities call behind DomainCapabilities;
+// swapped out in tests.
+var fetchDomCaps = func(ctx context.Context, arch, machine string) (string, error) {
+	args := []string{"domcapabilities"}
+	if arch != "" {
+		args = append(args, "--arch", arch)
+	}
+	if machine != "" {
+		args = append(args, "--machine", machine)
+	}
+	out, err := virshRead(ctx, args...)
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		if strings.Contains(msg, "is not supported by emulator") || strings.Contains(msg, "unknown architecture") ||
+			strings.Contains(msg, "unable to find any emulator") {
+			return "", fmt.Errorf("%w: %w", ErrUnsupportedMachine, err)
+		}
+	}
+	return out, err
+}
+
+// DomainCaps is what the host's qemu and libvirt support for domains of one
+// architecture and machine type, as reported by domcapabilities.
+type DomainCaps struct {
+	Emulator string `json:"emulator"` // e.g. /usr/bin/qemu-system-x86_64
+	VirtType string `json:"virt_type"`
+	Machine  string `json:"machine"` // Canonical name, e.g. pc-q35-8.2 for q35
+	Arch     string `json:"arch"`
+	MaxVCPUs int    `json:"max_vcpus"`
+
+	Firmware      FirmwareCaps `json:"firmware"`
+	CPUModes      []string     `json:"cpu_modes"`      // Supported modes, e.g. host-passthrough
+	DiskBuses     []string     `json:"disk_buses"`     // e.g. virtio, scsi, sata
+	DiskDevices   []string     `json:"disk_devices"`   // e.g. disk, cdrom
+	VideoModels   []string     `json:"video_models"`   // e.g. virtio, vga
+	GraphicsTypes []string     `json:"graphics_types"` // e.g. vnc, spice
+	Features      []string     `json:"features"`       // Supported features, e.g. vmcoreinfo
+
+	// Devices has every device element with its enums, for those not
+	// summarized above
+	Devices map[string]DeviceCaps `json:"devices"`
+}
+
+// FirmwareCaps are the firmware choices of the host.
+type FirmwareCaps struct {
+	Types       []string `json:"types"`        // bios, efi
+	Loaders     []string `json:"loaders"`      // Firmware images found on the host
+	LoaderTypes []string `json:"loader_types"` // rom, pflash
+	SecureBoot  bool     `json:"secure_boot"`
+}
+
+// DeviceCaps is a device element of domcapabilities.
+type DeviceCaps struct {
+	Supported bool                `json:"supported"`
+	Enums     map[string][]string `json:"enums"` // e.g. "bus": ["virtio", "scsi"]
+}
+
+// capsEnum is an enum of allowed values, like <enum name='bus'>.
+type capsEnum struct {
+	Name   string   `xml:"name,attr"`
+	Values []string `xml:"value"`
+}
+
+// capsElement is an element with a supported attribute and enums, e.g. a
+// device or feature.
+type capsElement struct {
+	XMLName   xml.Name
+	Supported string     `xml:"supported,attr"`
+	Enums     []capsEnum `xml:"enum"`
+}
+
+// domainCapsXML mirrors the parts of the domcapabilities XML the controller reads.
+type domainCapsXML struct {
+	Path    string `xml:"path"`
+	Domain  string `xml:"domain"`
+	Machine string `xml:"machine"`
+	Arch    string `xml:"arch"`
+	VCPU    struct {
+		Max int `xml:"max,attr"`
+	} `xml:"vcpu"`
+	OS struct {
+		Enums  []capsEnum `xml:"enum"`
+		Loader struct {
+			Supported string     `xml:"supported,attr"`
+			Values    []string   `xml:"value"`
+			Enums     []capsEnum `xml:"enum"`
+		} `xml:"loader"`
+	} `xml:"os"`
+	CPU struct {
+		Modes []struct {
+			Name      string `xml:"name,attr"`
+			Supported string `xml:"supported,attr"`
+		} `xml:"mode"`
+	} `xml:"cpu"`
+	Devices struct {
+		Elements []capsElement `xml:",any"`
+	} `xml:"devices"`
+	Features struct {
+		Elements []capsElement `xml:",any"`
+	} `xml:"features"`
+}
+
+// enumValues returns the values of the named enum, never nil.
+func enumValues(enums []capsEnum, name string) []string {
+	for _, e := range enums {
+		if e.Name == name && e.Values != nil {
+			return e.Values
+		}
+	}
+	return []string{}
+}
+
+// parseDomainCaps parses the output of domcapabilities.
+func parseDomainCaps(data string) (*DomainCaps, error) {
+	var x domainCapsXML
+	if err := xml.Unmarshal([]byte(data), &x); err != nil {
+		return nil, err
+	}
+	caps := &DomainCaps{
+		Emulator: x.Path,
+		VirtType: x.Domain,
+		Machine:  x.Machine,
+		Arch:     x.Arch,
+		MaxVCPUs: x.VCPU.Max,
+		Firmware: FirmwareCaps{
+			Types:       enumValues(x.OS.Enums, "firmware"),
+			Loaders:     []string{},
+			LoaderTypes: []string{},
+		},
+		CPUModes:      []string{},
+		DiskBuses:     []string{},
+		DiskDevices:   []string{},
+		VideoModels:   []string{},
+		GraphicsTypes: []string{},
+		Features:      []string{},
+		Devices:       map[string]DeviceCaps{},
+	}
+
+	if loader := x.OS.Loader; loader.Supported == "yes" {
+		if loader.Values != nil {
+			caps.Firmware.Loaders = loader.Values
+		}
+		caps.Firmware.LoaderTypes = enumValues(loader.Enums, "type")
+		for _, secure := range enumValues(loader.Enums, "secure") {
+			caps.Firmware.SecureBoot = caps.Firmware.SecureBoot || secure == "yes"
+		}
+	}
+	for _, mode := range x.CPU.Modes {
+		if mode.Supported == "yes" {
+			caps.CPUModes = append(caps.CPUModes, mode.Name)
+		}
+	}
+
+	for _, e := range x.Devices.Elements {
+		device := DeviceCaps{Supported: e.Supported == "yes", Enums: map[string][]string{}}
+		for _, enum := range e.Enums {
+			device.Enums[enum.Name] = enumValues(e.Enums, enum.Name)
+		}
+		caps.Devices[e.XMLName.Local] = device
+		if !device.Supported {
+			continue
+		}
+		switch e.XMLName.Local {
+		case "disk":
+			caps.DiskBuses = enumValues(e.Enums, "bus")
+			caps.DiskDevices = enumValues(e.Enums, "diskDevice")
+		case "video":
+			caps.VideoModels = enumValues(e.Enums, "modelType")
+		case "graphics":
+			caps.GraphicsTypes = enumValues(e.Enums, "type")
+		}
+	}
+	for _, e := range x.Features.Elements {
+		if e.Supported == "yes" {
+			caps.Features = append(caps.Features, e.XMLName.Local)
+		}
+	}
+	return caps, nil
+}
+
+// domCapsCache holds domcapabilities results by architecture and machine
+// type.
+var domCapsCache struct {
+	mu      sync.Mutex
+	entries map[string]domCapsEntry
+}
+
+type domCapsEntry struct {
+	caps    *DomainCaps
+	expires time.Time
+}
+
+// DomainCapabilities describes what domains of the given architecture and
+// machine type, e.g. x86_64 and q35, may use on this host; empty values pick
+// the host's defaults. Results are reused for DOMCAPABILITIES_CACHE_TTL
+// seconds, callers must not modify them.
+func DomainCapabilities(ctx context.Context, arch, machine string) (*DomainCaps, error) {
+	ttl := config.Seconds("DOMCAPABILITIES_CACHE_TTL", defaultDomCapsCacheTTL)
+	key := arch + "/" + machine
+
+	if ttl > 0 {
+		domCapsCache.mu.Lock()
+		entry, ok := domCapsCache.entries[key]
+		domCapsCache.mu.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			return entry.caps, nil
+		}
+	}
+
+	out, err := fetchDomCaps(ctx, arch, machine)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := parseDomainCaps(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse domain capabilities: %w", err)
+	}
+
+	if ttl > 0 {
+		domCapsCache.mu.Lock()
+		if domCapsCache.entries == nil {
+			domCapsCache.entries = make(map[string]domCapsEntry)
+		}
+		domCapsCache.entries[key] = domCapsEntry{caps: caps, expires: time.Now().Add(ttl)}
+		domCapsCache.mu.Unlock()
+	}
+	return caps, nil
+}
@@ -0,0 +1,155 @@
+package libvirt
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const q35DomainCapsXML = `<domainCapabilities>
+  <path>/usr/bin/qemu-system-x86_64</path>
+  <domain>kvm</domain>
+  <machine>pc-q35-8.2</machine>
+  <arch>x86_64</arch>
+  <vcpu max='4096'/>
+  <iothreads supported='yes'/>
+  <os supported='yes'>
+    <enum name='firmware'>
+      <value>bios</value>
+      <value>efi</value>
+    </enum>
+    <loader supported='yes'>
+      <value>/usr/share/OVMF/OVMF_CODE_4M.fd</value>
+      <value>/usr/share/OVMF/OVMF_CODE_4M.secboot.fd</value>
+      <enum name='type'>
+        <value>rom</value>
+        <value>pflash</value>
+      </enum>
+      <enum name='secure'>
+        <value>no</value>
+        <value>yes</value>
+      </enum>
+    </loader>
+  </os>
+  <cpu>
+    <mode name='host-passthrough' supported='yes'>
+      <enum name='hostPassthroughMigratable'>
+        <value>on</value>
+        <value>off</value>
+      </enum>
+    </mode>
+    <mode name='host-model' supported='yes'>
+      <model fallback='forbid'>Skylake-Client-IBRS</model>
+    </mode>
+    <mode name='custom' supported='no'/>
+  </cpu>
+  <devices>
+    <disk supported='yes'>
+      <enum name='diskDevice'>
+        <value>disk</value>
+        <value>cdrom</value>
+      </enum>
+      <enum name='bus'>
+        <value>scsi</value>
+        <value>virtio</value>
+        <value>sata</value>
+      </enum>
+    </disk>
+    <graphics supported='yes'>
+      <enum name='type'>
+        <value>vnc</value>
+        <value>spice</value>
+      </enum>
+    </graphics>
+    <video supported='yes'>
+      <enum name='modelType'>
+        <value>vga</value>
+        <value>virtio</value>
+      </enum>
+    </video>
+    <tpm supported='no'/>
+  </devices>
+  <features>
+    <gic supported='no'/>
+    <vmcoreinfo supported='yes'/>
+    <genid supported='yes'/>
+    <sev supported='no'/>
+  </features>
+</domainCapabilities>
+`
+
+func TestParseDomainCaps(t *testing.T) {
+	caps, err := parseDomainCaps(q35DomainCapsXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Emulator != "/usr/bin/qemu-system-x86_64" || caps.VirtType != "kvm" || caps.Machine != "pc-q35-8.2" || caps.Arch != "x86_64" || caps.MaxVCPUs != 4096 {
+		t.Errorf("caps = %+v", caps)
+	}
+	wantFirmware := FirmwareCaps{
+		Types:       []string{"bios", "efi"},
+		Loaders:     []string{"/usr/share/OVMF/OVMF_CODE_4M.fd", "/usr/share/OVMF/OVMF_CODE_4M.secboot.fd"},
+		LoaderTypes: []string{"rom", "pflash"},
+		SecureBoot:  true,
+	}
+	if !reflect.DeepEqual(caps.Firmware, wantFirmware) {
+		t.Errorf("firmware = %+v, want %+v", caps.Firmware, wantFirmware)
+	}
+	lists := []struct {
+		name      string
+		got, want []string
+	}{
+		{"cpu modes", caps.CPUModes, []string{"host-passthrough", "host-model"}},
+		{"disk buses", caps.DiskBuses, []string{"scsi", "virtio", "sata"}},
+		{"disk devices", caps.DiskDevices, []string{"disk", "cdrom"}},
+		{"video models", caps.VideoModels, []string{"vga", "virtio"}},
+		{"graphics types", caps.GraphicsTypes, []string{"vnc", "spice"}},
+		{"features", caps.Features, []string{"vmcoreinfo", "genid"}},
+	}
+	for _, l := range lists {
+		if !reflect.DeepEqual(l.got, l.want) {
+			t.Errorf("%s = %v, want %v", l.name, l.got, l.want)
+		}
+	}
+	if tpm, ok := caps.Devices["tpm"]; !ok || tpm.Supported {
+		t.Errorf("devices[tpm] = %+v, %v; want listed as unsupported", tpm, ok)
+	}
+}
+
+func TestDomainCapabilitiesCache(t *testing.T) {
+	original := fetchDomCaps
+	defer func() { fetchDomCaps = original }()
+	domCapsCache.entries = nil
+	defer func() { domCapsCache.entries = nil }()
+
+	calls := map[string]int{}
+	fetchDomCaps = func(ctx context.Context, arch, machine string) (string, error) {
+		calls[arch+"/"+machine]++
+		return q35DomainCapsXML, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := DomainCapabilities(context.Background(), "x86_64", "q35"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := DomainCapabilities(context.Background(), "x86_64", "pc"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"x86_64/q35": 1, "x86_64/pc": 1}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("domcapabilities calls = %v, want %v", calls, want)
+	}
+
+	// Without caching every call asks libvirt
+	t.Setenv("DOMCAPABILITIES_CACHE_TTL", "0")
+	domCapsCache.entries = nil
+	for i := 0; i < 2; i++ {
+		if _, err := DomainCapabilities(context.Background(), "aarch64", "virt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls["aarch64/virt"] != 2 {
+		t.Errorf("uncached calls = %d, want 2", calls["aarch64/virt"])
+	}
+}
@@ -0,0 +1,106 @@
+package libvirt
+
+import (
+	"context"
+)
+
+// stateNames maps virDomainState values to the names printed by dominfo.
+var stateNames = map[int]string{
+	0: "no state",
+	1: "running",
+	2: "idle",
+	3: "paused",
+	4: "in shutdown",
+	5: "shut off",
+	6: "crashed",
+	7: "pmsuspended",
+}
+
+// StateName returns the dominfo name of a numeric domain state.
+func StateName(state int) string {
+	if name, ok := stateNames[state]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ListAllDomains returns the names of running domains, and of defined but
+// inactive ones as well when includeInactive is set.
+func ListAllDomains(ctx context.Context, includeInactive bool) ([]string, error) {
+	cmd := []string{"list", "--name"}
+	if includeInactive {
+		cmd = append(cmd, "--all")
+	}
+	out, err := virshRead(ctx, cmd...)
+	if err != nil {
+		return nil, err
+	}
+	return splitNames(out), nil
+}
+
+// ListAutostartDomains returns the names of all domains marked for autostart.
+func ListAutostartDomains(ctx context.Context) ([]string, error) {
+	out, err := virshRead(ctx, "list", "--all", "--autostart", "--name")
+	if err != nil {
+		return nil, err
+	}
+	return splitNames(out), nil
+}
+
+// GetDomainStats collects state, memory, vcpu and block statistics of all
+// domains, or only the running ones, with a single domstats call. The result
+// maps each domain name to its raw "key=value" fields. Domains aren't named
+// in the call, so one undefined meanwhile is missing instead of failing it.
+func GetDomainStats(ctx context.Context, includeInactive bool) (map[string]map[string]string, error) {
+	cmd := []string{"domstats", "--raw", "--state", "--balloon", "--vcpu", "--block"}
+	if !includeInactive {
+		cmd = append(cmd, "--list-active")
+	}
+	out, err := virshRead(ctx, cmd...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDomainStats(out), nil
+}
+
+// GetAllInterfaceStats collects the interface counters of all running domains
+// from ActiveDomainStats, keyed by domain name.
+func GetAllInterfaceStats(ctx context.Context) (map[string][]InterfaceStats, error) {
+	all, err := ActiveDomainStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string][]InterfaceStats)
+	for domain, fields := range all {
+		stats[domain] = StatsInterfaces(fields)
+	}
+	return stats, nil
+}
+
+// GetAllBlockStats collects the block device counters of all running domains
+// from ActiveDomainStats, keyed by domain name.
+func GetAllBlockStats(ctx context.Context) (map[string][]BlockStats, error) {
+	all, err := ActiveDomainStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string][]BlockStats)
+	for domain, fields := range all {
+		stats[domain] = StatsBlockStats(fields)
+	}
+	return stats, nil
+}
+
+// GetAllMemoryStats collects the balloon statistics of all running domains
+// from ActiveDomainStats, keyed by domain name.
+func GetAllMemoryStats(ctx context.Context) (map[string]MemoryStats, error) {
+	all, err := ActiveDomainStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]MemoryStats)
+	for domain, fields := range all {
+		stats[domain] = StatsMemory(fields)
+	}
+	return stats, nil
+}
@@ -0,0 +1,82 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"libvirt-controller/internal/cmdutil"
+)
+
+var (
+	// ErrDomainNotFound is returned when libvirt doesn't know the domain.
+	ErrDomainNotFound = errors.New("domain not found")
+	// ErrAlreadyRunning is returned when starting a domain that is active.
+	ErrAlreadyRunning = errors.New("domain is already running")
+	// ErrAlreadyStopped is returned when stopping a domain that isn't active.
+	ErrAlreadyStopped = errors.New("domain is already stopped")
+	// ErrSecretNotFound is returned when libvirt doesn't know the secret.
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrNodeDeviceNotFound is returned when libvirt doesn't know the host device.
+	ErrNodeDeviceNotFound = errors.New("node device not found")
+)
+
+// execute runs external commands; swapped out in tests.
+var execute = cmdutil.ExecuteContext
+
+// virshErrors maps lowercased fragments of virsh's stderr to typed errors.
+// virsh reports the same condition with different wording across commands
+// and versions, e.g. "Domain is already active" from start.
+var virshErrors = []struct {
+	fragments []string
+	err       error
+}{
+	{[]string{"domain not found", "failed to get domain"}, ErrDomainNotFound},
+	{[]string{"domain is already active", "domain is already running"}, ErrAlreadyRunning},
+	{[]string{"domain is not running"}, ErrAlreadyStopped},
+	{[]string{"secret not found", "failed to get secret"}, ErrSecretNotFound},
+	{[]string{"node device not found"}, ErrNodeDeviceNotFound},
+}
+
+// virshError is a failed virsh call classified by its cause. errors.Is
+// matches both the typed error and the errors of the command itself.
+type virshError struct {
+	kind error
+	err  error
+}
+
+func (e *virshError) Error() string {
+	return e.err.Error()
+}
+
+func (e *virshError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// classifyError wraps a virsh failure in the matching typed error, if any.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := strings.ToLower(err.Error())
+	for _, known := range virshErrors {
+		for _, fragment := range known.fragments {
+			if strings.Contains(msg, fragment) {
+				return &virshError{kind: known.err, err: err}
+			}
+		}
+	}
+	return err
+}
+
+// virsh runs a virsh command, classifying common failures.
+func virsh(ctx context.Context, args ...string) (string, error) {
+	out, err := execute(ctx, "virsh", cmdutil.VirshArgs(ctx, args...)...)
+	return out, classifyError(err)
+}
+
+// virshStream runs a virsh command passing its output to onLine as it is
+// written, classifying common failures.
+func virshStream(ctx context.Context, onLine func(string), args ...string) error {
+	return classifyError(cmdutil.ExecuteStream(ctx, onLine, "virsh", cmdutil.VirshArgs(ctx, args...)...))
+}
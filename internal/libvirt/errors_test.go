@@ -0,0 +1,89 @@
+package libvirt
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// virshFailure builds the error cmdutil returns for a failed virsh call.
+func virshFailure(stderr string) error {
+	return fmt.Errorf("command execution failed: %s, %w", stderr, errors.New("exit status 1"))
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   error
+	}{
+		{
+			name:   "start unknown domain",
+			stderr: "error: failed to get domain 'vm-1'\n",
+			want:   ErrDomainNotFound,
+		},
+		{
+			name:   "dominfo unknown domain",
+			stderr: "error: failed to get domain 'vm-1'\nerror: Domain not found: no domain with matching name 'vm-1'\n",
+			want:   ErrDomainNotFound,
+		},
+		{
+			name:   "start running domain",
+			stderr: "error: Domain is already active\n",
+			want:   ErrAlreadyRunning,
+		},
+		{
+			name:   "start running domain, older libvirt",
+			stderr: "error: Failed to start domain 'vm-1'\nerror: Requested operation is not valid: domain is already running\n",
+			want:   ErrAlreadyRunning,
+		},
+		{
+			name:   "destroy stopped domain",
+			stderr: "error: Failed to destroy domain 'vm-1'\nerror: Requested operation is not valid: domain is not running\n",
+			want:   ErrAlreadyStopped,
+		},
+		{
+			name:   "shutdown stopped domain",
+			stderr: "error: Failed to shutdown domain 'vm-1'\nerror: Requested operation is not valid: domain is not running\n",
+			want:   ErrAlreadyStopped,
+		},
+		{
+			name:   "undefine unknown secret",
+			stderr: "error: failed to get secret '6d5c1a3e-0f4b-4a8e-9c2d-1b7e3f5a9d20'\nerror: Secret not found: no secret with matching uuid '6d5c1a3e-0f4b-4a8e-9c2d-1b7e3f5a9d20'\n",
+			want:   ErrSecretNotFound,
+		},
+		{
+			name:   "dump removed node device",
+			stderr: "error: Could not find matching device 'pci_0000_01_00_0'\nerror: Node device not found: no node device with matching name 'pci_0000_01_00_0'\n",
+			want:   ErrNodeDeviceNotFound,
+		},
+		{
+			name:   "unrelated failure",
+			stderr: "error: Failed to start domain 'vm-1'\nerror: Cannot access storage file '/data/vm-1/disk.img': No such file or directory\n",
+		},
+	}
+
+	typed := []error{ErrDomainNotFound, ErrAlreadyRunning, ErrAlreadyStopped, ErrSecretNotFound, ErrNodeDeviceNotFound}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := virshFailure(tt.stderr)
+			err := classifyError(original)
+
+			for _, candidate := range typed {
+				if got := errors.Is(err, candidate); got != (candidate == tt.want) {
+					t.Errorf("errors.Is(err, %q) = %t", candidate, got)
+				}
+			}
+			if !errors.Is(err, original) {
+				t.Error("classified error no longer wraps the virsh failure")
+			}
+			if err.Error() != original.Error() {
+				t.Errorf("message changed to %q", err)
+			}
+		})
+	}
+
+	if classifyError(nil) != nil {
+		t.Error("expected nil for a successful call")
+	}
+}
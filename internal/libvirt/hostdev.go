@@ -0,0 +1,69 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"libvirt-controller/internal/domainxml"
+	"os"
+)
+
+// ErrHostDeviceNotReady is returned when a device can't be passed through
+// in its current state on the host.
+var ErrHostDeviceNotReady = errors.New("host device is not ready for passthrough")
+
+// AttachHostDevice passes a host PCI device through to a domain. The device
+// is always added to the persistent definition, live also hotplugs it into
+// the running guest.
+func AttachHostDevice(ctx context.Context, domainName string, pciAddress string, live bool) (string, error) {
+	return hostDeviceCommand(ctx, "attach-device", domainName, pciAddress, live)
+}
+
+// DetachHostDevice removes a passed through PCI device from a domain.
+func DetachHostDevice(ctx context.Context, domainName string, pciAddress string, live bool) (string, error) {
+	return hostDeviceCommand(ctx, "detach-device", domainName, pciAddress, live)
+}
+
+func hostDeviceCommand(ctx context.Context, command string, domainName string, pciAddress string, live bool) (string, error) {
+	addr, err := domainxml.ParsePCIAddress(pciAddress)
+	if err != nil {
+		return "", err
+	}
+	// Detaching has to work even after the device was rebound on the host
+	if command == "attach-device" {
+		if err := domainxml.CheckVFIO(addr); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrHostDeviceNotReady, err)
+		}
+	}
+
+	deviceXML, err := domainxml.HostDeviceXML(addr)
+	if err != nil {
+		return "", err
+	}
+	return deviceCommand(ctx, command, domainName, deviceXML, live)
+}
+
+// deviceCommand runs attach-device or detach-device with deviceXML. The
+// persistent definition is always changed, live also applies it to the
+// running domain.
+func deviceCommand(ctx context.Context, command string, domainName string, deviceXML string, live bool) (string, error) {
+	// virsh only reads device XML from a file
+	f, err := os.CreateTemp("", "device-*.xml")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary device file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(deviceXML); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write temporary device file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write temporary device file: %w", err)
+	}
+
+	args := []string{command, domainName, f.Name(), "--config"}
+	if live {
+		args = append(args, "--live")
+	}
+	return virsh(ctx, args...)
+}
@@ -1,60 +1,70 @@
 package libvirt
 
 import (
-	"fmt"
-	"libvirt-controller/internal/cmdutil"
-	"log"
-	"strings"
+	"context"
 )
 
-// For Metrics
-type ifaceInfo struct {
-	Name string
-	Mac  string
+// InterfaceInfo is a domain interface as listed by domiflist.
+type InterfaceInfo struct {
+	Type   string // network, bridge, direct, ...
+	Source string // Network or bridge the interface is connected to
+	Model  string
+	MAC    string
 }
 
-func GetDomainIfaces(domain string) []ifaceInfo {
-	out, err := cmdutil.Execute("virsh", "domiflist", domain)
+// GetInterfaceDetails maps the host side device names of a domain's
+// interfaces (e.g. vnet0) to what domiflist knows about them. domstats
+// reports neither the MAC nor the network.
+func GetInterfaceDetails(ctx context.Context, domain string) (map[string]InterfaceInfo, error) {
+	out, err := virsh(ctx, "domiflist", domain)
 	if err != nil {
-		log.Printf("error listing libvirt domain's interfaces")
+		return nil, err
 	}
-	lines := strings.Split(out, "\n")
-	var ifaces []ifaceInfo
-	for _, l := range lines {
-		fields := strings.Fields(l)
-		if len(fields) >= 5 && fields[0] != "Interface" {
-			ifaces = append(ifaces, ifaceInfo{
-				Name: fields[0],
-				Mac:  fields[4],
-			})
+	return parseInterfaceList(out), nil
+}
+
+func parseInterfaceList(out string) map[string]InterfaceInfo {
+	interfaces := make(map[string]InterfaceInfo)
+	for _, row := range parseTable(out) {
+		// Interface, Type, Source, Model, MAC
+		if len(row) == 5 {
+			interfaces[row[0]] = InterfaceInfo{Type: row[1], Source: row[2], Model: row[3], MAC: row[4]}
 		}
 	}
-	return ifaces
+	return interfaces
+}
+
+// InterfaceAddress is an IP address assigned to a domain interface.
+type InterfaceAddress struct {
+	Name     string `json:"name"`
+	MAC      string `json:"mac"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
 }
 
-func GetIfaceStats(domain, iface string) map[string]float64 {
-	out, err := cmdutil.Execute("virsh", "domifstat", domain, iface)
+// GetInterfaceAddresses returns the addresses libvirt knows for a domain's
+// interfaces. source is "lease", "agent" or "arp".
+func GetInterfaceAddresses(ctx context.Context, domain string, source string) ([]InterfaceAddress, error) {
+	out, err := virsh(ctx, "domifaddr", domain, "--source", source)
 	if err != nil {
-		log.Printf("error getting interface stats")
+		return nil, err
 	}
-	lines := strings.Split(out, "\n")
-	stats := make(map[string]float64)
-	for _, l := range lines {
-		fields := strings.Fields(l)
-		if len(fields) == 3 {
-			var val float64
-			fmt.Sscanf(fields[2], "%f", &val)
-			switch fields[1] {
-			case "rx_bytes":
-				stats["rx_bytes"] = val
-			case "tx_bytes":
-				stats["tx_bytes"] = val
-			case "rx_packets":
-				stats["rx_pkts"] = val
-			case "tx_packets":
-				stats["tx_pkts"] = val
+
+	addresses := []InterfaceAddress{}
+	var last InterfaceAddress
+	for _, fields := range parseTable(out) {
+		if len(fields) != 4 {
+			continue
+		}
+		if fields[0] == "-" {
+			// Additional addresses of the same interface are printed as "- - proto addr"
+			if last.Name != "" {
+				addresses = append(addresses, InterfaceAddress{Name: last.Name, MAC: last.MAC, Protocol: fields[2], Address: fields[3]})
 			}
+			continue
 		}
+		last = InterfaceAddress{Name: fields[0], MAC: fields[1], Protocol: fields[2], Address: fields[3]}
+		addresses = append(addresses, last)
 	}
-	return stats
+	return addresses, nil
 }
@@ -0,0 +1,113 @@
+package libvirt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DomainJobInfo is the parsed output of virsh domjobinfo.
+type DomainJobInfo struct {
+	Type          string `json:"type"`
+	Operation     string `json:"operation,omitempty"`
+	DataProcessed uint64 `json:"data_processed"`
+	DataRemaining uint64 `json:"data_remaining"`
+	DataTotal     uint64 `json:"data_total"`
+	TimeElapsedMS uint64 `json:"time_elapsed_ms"`
+}
+
+// Active reports whether libvirt has a job running for the domain.
+func (j *DomainJobInfo) Active() bool {
+	return j.Type != "" && j.Type != "None"
+}
+
+// Progress returns the completed percentage of the job's data transfer.
+func (j *DomainJobInfo) Progress() float64 {
+	if j.DataTotal == 0 {
+		return 0
+	}
+	return float64(j.DataTotal-j.DataRemaining) / float64(j.DataTotal) * 100
+}
+
+// GetJobInfo returns information about the job currently running on a domain.
+func GetJobInfo(ctx context.Context, domainName string) (*DomainJobInfo, error) {
+	out, err := virsh(ctx, "domjobinfo", domainName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJobInfo(out)
+}
+
+// AbortJob aborts the job currently running on a domain.
+func AbortJob(ctx context.Context, domainName string) (string, error) {
+	return virsh(ctx, "domjobabort", domainName)
+}
+
+// ParseJobInfo parses the "Key: value" lines printed by virsh domjobinfo.
+func ParseJobInfo(out string) (*DomainJobInfo, error) {
+	info := &DomainJobInfo{}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch strings.TrimSpace(key) {
+		case "Job type":
+			info.Type = value
+		case "Operation":
+			info.Operation = value
+		case "Time elapsed":
+			// virsh pads the number, e.g. "2034         ms"
+			info.TimeElapsedMS, err = strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(value, "ms")), 10, 64)
+		case "Data processed":
+			info.DataProcessed, err = parseByteSize(value)
+		case "Data remaining":
+			info.DataRemaining, err = parseByteSize(value)
+		case "Data total":
+			info.DataTotal, err = parseByteSize(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning output: %w", err)
+	}
+	return info, nil
+}
+
+// parseByteSize converts virsh sizes like "1.500 GiB" into bytes.
+func parseByteSize(value string) (uint64, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty size")
+	}
+	n, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	multiplier := float64(1)
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "B", "bytes":
+		case "KiB":
+			multiplier = 1 << 10
+		case "MiB":
+			multiplier = 1 << 20
+		case "GiB":
+			multiplier = 1 << 30
+		case "TiB":
+			multiplier = 1 << 40
+		default:
+			return 0, fmt.Errorf("unknown unit %q", fields[1])
+		}
+	}
+	return uint64(n * multiplier), nil
+}
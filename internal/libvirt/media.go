@@ -0,0 +1,37 @@
+package libvirt
+
+import "context"
+
+// EjectMedia removes the medium from a CD-ROM drive of a running domain.
+func EjectMedia(ctx context.Context, domain string, target string) (string, error) {
+	return virsh(ctx, "change-media", domain, target, "--eject", "--live")
+}
+
+// InsertMedia loads source into an empty CD-ROM drive of a running domain.
+func InsertMedia(ctx context.Context, domain string, target string, source string) (string, error) {
+	return virsh(ctx, "change-media", domain, target, source, "--insert", "--live")
+}
+
+// LoadMedia loads source into an empty CD-ROM drive, in the running guest
+// if the domain is active and in its definition otherwise. A medium loaded
+// into a running guest is gone after the domain is restarted.
+func LoadMedia(ctx context.Context, domain string, target string, source string) (string, error) {
+	return virsh(ctx, "change-media", domain, target, source, "--insert", "--current")
+}
+
+// UnloadMedia ejects the medium of a CD-ROM drive like LoadMedia loads it.
+func UnloadMedia(ctx context.Context, domain string, target string) (string, error) {
+	return virsh(ctx, "change-media", domain, target, "--eject", "--current")
+}
+
+// AttachCDROM adds a read-only SATA CD-ROM drive holding source to the
+// definition of a domain. SATA drives can't be hotplugged, a running domain
+// sees the drive after it was restarted.
+func AttachCDROM(ctx context.Context, domain string, target string, source string) (string, error) {
+	return virsh(ctx, "attach-disk", domain, source, target, "--type", "cdrom", "--mode", "readonly", "--targetbus", "sata", "--config")
+}
+
+// DetachDrive removes the drive target from the definition of a domain.
+func DetachDrive(ctx context.Context, domain string, target string) (string, error) {
+	return virsh(ctx, "detach-disk", domain, target, "--config")
+}
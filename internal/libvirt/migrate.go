@@ -0,0 +1,51 @@
+package libvirt
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+)
+
+// migrationProgressPattern matches the progress meter of virsh migrate --verbose.
+var migrationProgressPattern = regexp.MustCompile(`^Migration: \[\s*(\d+) %\]$`)
+
+// MigrateDomain migrates a domain to the libvirt daemon at destURI.
+// live:           keep the guest running while memory is copied.
+// persistent:     define the domain on the destination host.
+// undefineSource: remove the domain definition from this host afterwards.
+// onProgress, when not nil, receives the completed percentage as virsh
+// reports it.
+func MigrateDomain(ctx context.Context, domainName string, destURI string, live bool, persistent bool, undefineSource bool, onProgress func(percent float64)) error {
+	cmd := []string{"migrate", "--verbose"}
+	if live {
+		cmd = append(cmd, "--live")
+	}
+	if persistent {
+		cmd = append(cmd, "--persistent")
+	}
+	if undefineSource {
+		cmd = append(cmd, "--undefinesource")
+	}
+	cmd = append(cmd, domainName, destURI)
+
+	defer InvalidateStatsCache()
+	return virshStream(ctx, func(line string) {
+		if percent, ok := ParseMigrationProgress(line); ok && onProgress != nil {
+			onProgress(percent)
+		}
+	}, cmd...)
+}
+
+// ParseMigrationProgress extracts the percentage from a progress line of
+// virsh migrate --verbose, such as "Migration: [ 45 %]".
+func ParseMigrationProgress(line string) (float64, bool) {
+	m := migrationProgressPattern.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	percent, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return float64(percent), true
+}
@@ -0,0 +1,66 @@
+package libvirt
+
+import (
+	"testing"
+)
+
+func TestParseMigrationProgress(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   float64
+		wantOK bool
+	}{
+		{"Migration: [  0 %]", 0, true},
+		{"Migration: [ 45 %]", 45, true},
+		{"Migration: [100 %]", 100, true},
+		{"error: operation failed: migration out job: unexpectedly failed", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := ParseMigrationProgress(tt.line)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("ParseMigrationProgress(%q) = %v, %t; want %v, %t", tt.line, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseJobInfo(t *testing.T) {
+	out := `Job type:         Unbounded
+Operation:        Outgoing migration
+Time elapsed:     2034         ms
+Data processed:   512.000 MiB
+Data remaining:   1.500 GiB
+Data total:       2.000 GiB
+Memory bandwidth: 256.010 MiB/s
+`
+	info, err := ParseJobInfo(out)
+	if err != nil {
+		t.Fatalf("ParseJobInfo() error = %v", err)
+	}
+	want := DomainJobInfo{
+		Type:          "Unbounded",
+		Operation:     "Outgoing migration",
+		DataProcessed: 512 << 20,
+		DataRemaining: 3 << 29,
+		DataTotal:     2 << 30,
+		TimeElapsedMS: 2034,
+	}
+	if *info != want {
+		t.Errorf("ParseJobInfo() = %+v, want %+v", *info, want)
+	}
+	if !info.Active() || info.Progress() != 25 {
+		t.Errorf("Active() = %t, Progress() = %v; want true, 25", info.Active(), info.Progress())
+	}
+
+	idle, err := ParseJobInfo("Job type:         None\n")
+	if err != nil || idle.Active() || idle.Progress() != 0 {
+		t.Errorf("idle job = %+v, %v", idle, err)
+	}
+
+	if _, err := ParseJobInfo("Data total:       2.000 PiB\n"); err == nil {
+		t.Error("ParseJobInfo() accepted an unknown unit")
+	}
+}
@@ -0,0 +1,32 @@
+package libvirt
+
+import (
+	"context"
+)
+
+// Network is a libvirt virtual network.
+type Network struct {
+	Name   string `json:"name"`
+	Active bool   `json:"active"` // Domains joining an inactive network fail to start
+}
+
+// ListNetworks lists the virtual networks of the host, active or not.
+func ListNetworks(ctx context.Context) ([]Network, error) {
+	all, err := virshRead(ctx, "net-list", "--all", "--name")
+	if err != nil {
+		return nil, err
+	}
+	active, err := virshRead(ctx, "net-list", "--name")
+	if err != nil {
+		return nil, err
+	}
+	isActive := make(map[string]bool)
+	for _, name := range splitNames(active) {
+		isActive[name] = true
+	}
+	networks := []Network{}
+	for _, name := range splitNames(all) {
+		networks = append(networks, Network{Name: name, Active: isActive[name]})
+	}
+	return networks, nil
+}
@@ -0,0 +1,29 @@
+package libvirt
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListNetworks(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		if strings.Join(args, " ") == "net-list --all --name" {
+			return "default\nisolated\n\n", nil
+		}
+		return "default\n\n", nil
+	}
+
+	networks, err := ListNetworks(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Network{{Name: "default", Active: true}, {Name: "isolated", Active: false}}
+	if !reflect.DeepEqual(networks, want) {
+		t.Errorf("ListNetworks() = %+v, want %+v", networks, want)
+	}
+}
@@ -0,0 +1,148 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"libvirt-controller/internal/domainxml"
+)
+
+// NodeDevice is a host device as described by nodedev-dumpxml. The PCI
+// fields are only set for PCI devices.
+type NodeDevice struct {
+	Name    string      `json:"name"` // e.g. pci_0000_01_00_0
+	Address string      `json:"address,omitempty"`
+	Class   string      `json:"class,omitempty"` // PCI class code, e.g. 0x030000 for VGA
+	Vendor  *DeviceID   `json:"vendor,omitempty"`
+	Product *DeviceID   `json:"product,omitempty"`
+	Driver  string      `json:"driver,omitempty"` // Bound host driver, empty when none
+	IOMMU   *IOMMUGroup `json:"iommu_group,omitempty"`
+}
+
+// DeviceID is a PCI vendor or product ID and its name from the PCI ID database.
+type DeviceID struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// IOMMUGroup is the IOMMU group of a device. Its members can only be passed
+// through together.
+type IOMMUGroup struct {
+	Number  int      `json:"number"`
+	Devices []string `json:"devices"` // PCI addresses, including the device itself
+}
+
+// nodeDeviceXML mirrors the parts of the nodedev XML the controller reads.
+type nodeDeviceXML struct {
+	Name   string `xml:"name"`
+	Driver struct {
+		Name string `xml:"name"`
+	} `xml:"driver"`
+	Capability struct {
+		Type     string `xml:"type,attr"`
+		Class    string `xml:"class"`
+		Domain   string `xml:"domain"`
+		Bus      string `xml:"bus"`
+		Slot     string `xml:"slot"`
+		Function string `xml:"function"`
+		Product  struct {
+			ID   string `xml:"id,attr"`
+			Name string `xml:",chardata"`
+		} `xml:"product"`
+		Vendor struct {
+			ID   string `xml:"id,attr"`
+			Name string `xml:",chardata"`
+		} `xml:"vendor"`
+		IOMMUGroup *struct {
+			Number    int `xml:"number,attr"`
+			Addresses []struct {
+				Domain   string `xml:"domain,attr"`
+				Bus      string `xml:"bus,attr"`
+				Slot     string `xml:"slot,attr"`
+				Function string `xml:"function,attr"`
+			} `xml:"address"`
+		} `xml:"iommuGroup"`
+	} `xml:"capability"`
+}
+
+// ListNodeDevices describes the host devices with the given capability,
+// e.g. "pci". Devices removed while they are listed are skipped.
+func ListNodeDevices(ctx context.Context, capability string) ([]NodeDevice, error) {
+	out, err := virshRead(ctx, "nodedev-list", "--cap", capability)
+	if err != nil {
+		return nil, err
+	}
+	devices := []NodeDevice{}
+	for _, name := range splitNames(out) {
+		dump, err := virshRead(ctx, "nodedev-dumpxml", name)
+		if errors.Is(err, ErrNodeDeviceNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		device, err := parseNodeDevice(dump)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse node device %s: %w", name, err)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// parseNodeDevice parses the output of nodedev-dumpxml.
+func parseNodeDevice(data string) (NodeDevice, error) {
+	var x nodeDeviceXML
+	if err := xml.Unmarshal([]byte(data), &x); err != nil {
+		return NodeDevice{}, err
+	}
+	device := NodeDevice{Name: x.Name, Driver: x.Driver.Name}
+	c := x.Capability
+	if c.Type != "pci" {
+		return device, nil
+	}
+
+	address, err := pciAddress(c.Domain, c.Bus, c.Slot, c.Function)
+	if err != nil {
+		return NodeDevice{}, err
+	}
+	device.Address = address
+	device.Class = c.Class
+	if c.Vendor.ID != "" {
+		device.Vendor = &DeviceID{ID: c.Vendor.ID, Name: c.Vendor.Name}
+	}
+	if c.Product.ID != "" {
+		device.Product = &DeviceID{ID: c.Product.ID, Name: c.Product.Name}
+	}
+	// Without an enabled IOMMU there are no groups and no passthrough
+	if c.IOMMUGroup != nil {
+		group := &IOMMUGroup{Number: c.IOMMUGroup.Number, Devices: []string{}}
+		for _, a := range c.IOMMUGroup.Addresses {
+			member, err := pciAddress(a.Domain, a.Bus, a.Slot, a.Function)
+			if err != nil {
+				return NodeDevice{}, err
+			}
+			group.Devices = append(group.Devices, member)
+		}
+		device.IOMMU = group
+	}
+	return device, nil
+}
+
+// pciAddress formats the fields of a nodedev address, decimal in the
+// capability and hex with a 0x prefix in the IOMMU group, as 0000:01:00.0.
+func pciAddress(domain, bus, slot, function string) (string, error) {
+	var fields [4]int
+	for i, s := range []string{domain, bus, slot, function} {
+		n, err := strconv.ParseInt(s, 0, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid PCI address field %q", s)
+		}
+		fields[i] = int(n)
+	}
+	addr := domainxml.PCIAddress{Domain: fields[0], Bus: fields[1], Slot: fields[2], Function: fields[3]}
+	return addr.String(), nil
+}
@@ -0,0 +1,92 @@
+package libvirt
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const gpuNodeDeviceXML = `<device>
+  <name>pci_0000_01_00_0</name>
+  <path>/sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0</path>
+  <parent>pci_0000_00_01_0</parent>
+  <driver>
+    <name>vfio-pci</name>
+  </driver>
+  <capability type='pci'>
+    <class>0x030000</class>
+    <domain>0</domain>
+    <bus>1</bus>
+    <slot>0</slot>
+    <function>0</function>
+    <product id='0x1b80'>GP104 [GeForce GTX 1080]</product>
+    <vendor id='0x10de'>NVIDIA Corporation</vendor>
+    <iommuGroup number='14'>
+      <address domain='0x0000' bus='0x01' slot='0x00' function='0x0'/>
+      <address domain='0x0000' bus='0x01' slot='0x00' function='0x1'/>
+    </iommuGroup>
+  </capability>
+</device>
+`
+
+const bridgeNodeDeviceXML = `<device>
+  <name>pci_0000_00_1f_0</name>
+  <parent>computer</parent>
+  <capability type='pci'>
+    <class>0x060100</class>
+    <domain>0</domain>
+    <bus>0</bus>
+    <slot>31</slot>
+    <function>0</function>
+    <product id='0xa305'>Z390 Chipset LPC/eSPI Controller</product>
+    <vendor id='0x8086'>Intel Corporation</vendor>
+  </capability>
+</device>
+`
+
+func TestListNodeDevices(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		switch args[0] {
+		case "nodedev-list":
+			return "pci_0000_00_1f_0\npci_0000_01_00_0\npci_0000_02_00_0\n\n", nil
+		case "nodedev-dumpxml":
+			switch args[1] {
+			case "pci_0000_00_1f_0":
+				return bridgeNodeDeviceXML, nil
+			case "pci_0000_01_00_0":
+				return gpuNodeDeviceXML, nil
+			}
+		}
+		// Unplugged after it was listed
+		return "", virshFailure("error: Could not find matching device 'pci_0000_02_00_0'\nerror: Node device not found: no node device with matching name 'pci_0000_02_00_0'\n")
+	}
+
+	got, err := ListNodeDevices(context.Background(), "pci")
+	if err != nil {
+		t.Fatalf("ListNodeDevices() error = %v", err)
+	}
+	want := []NodeDevice{
+		{
+			Name:    "pci_0000_00_1f_0",
+			Address: "0000:00:1f.0",
+			Class:   "0x060100",
+			Vendor:  &DeviceID{ID: "0x8086", Name: "Intel Corporation"},
+			Product: &DeviceID{ID: "0xa305", Name: "Z390 Chipset LPC/eSPI Controller"},
+		},
+		{
+			Name:    "pci_0000_01_00_0",
+			Address: "0000:01:00.0",
+			Class:   "0x030000",
+			Vendor:  &DeviceID{ID: "0x10de", Name: "NVIDIA Corporation"},
+			Product: &DeviceID{ID: "0x1b80", Name: "GP104 [GeForce GTX 1080]"},
+			Driver:  "vfio-pci",
+			IOMMU:   &IOMMUGroup{Number: 14, Devices: []string{"0000:01:00.0", "0000:01:00.1"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListNodeDevices() = %+v, want %+v", got, want)
+	}
+}
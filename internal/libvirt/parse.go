@@ -0,0 +1,166 @@
+package libvirt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// virsh output comes in two flavours. domstats --raw prints stable
+// "key=value" fields that are the same across versions and locales, so it
+// is preferred wherever it carries the needed data. Everything else is a
+// human readable table whose header is translated, which parseTable handles
+// by only looking at the rows below the dashed separator.
+
+// ParseDomainStats parses the output of virsh domstats. The result maps each
+// domain name to its raw "key=value" fields.
+func ParseDomainStats(out string) map[string]map[string]string {
+	stats := make(map[string]map[string]string)
+	var current map[string]string
+	for _, l := range strings.Split(out, "\n") {
+		line := strings.TrimSpace(l)
+		if name, ok := strings.CutPrefix(line, "Domain:"); ok {
+			current = make(map[string]string)
+			stats[strings.Trim(strings.TrimSpace(name), "'")] = current
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || current == nil {
+			continue
+		}
+		current[key] = value
+	}
+	return stats
+}
+
+// InterfaceStats are the traffic counters of a domain interface.
+type InterfaceStats struct {
+	Name      string // host side device, e.g. vnet0
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+// BlockStats are the I/O counters of a domain block device.
+type BlockStats struct {
+	Name       string // target, e.g. vda
+	Path       string
+	ReadBytes  uint64
+	ReadReqs   uint64
+	WriteBytes uint64
+	WriteReqs  uint64
+}
+
+// MemoryStats are the balloon statistics of a domain, in KiB. Unused,
+// Available and Usable come from the guest's balloon driver, which only
+// reports them once a stats period is set (GuestStats).
+type MemoryStats struct {
+	Current    uint64
+	Maximum    uint64
+	RSS        uint64
+	Unused     uint64
+	Available  uint64
+	Usable     uint64
+	GuestStats bool
+}
+
+// StatsInterfaces extracts the interface counters of a domstats entry.
+func StatsInterfaces(fields map[string]string) []InterfaceStats {
+	count := statUint(fields, "net.count")
+	ifaces := make([]InterfaceStats, 0, count)
+	for i := uint64(0); i < count; i++ {
+		prefix := "net." + strconv.FormatUint(i, 10) + "."
+		ifaces = append(ifaces, InterfaceStats{
+			Name:      fields[prefix+"name"],
+			RxBytes:   statUint(fields, prefix+"rx.bytes"),
+			RxPackets: statUint(fields, prefix+"rx.pkts"),
+			TxBytes:   statUint(fields, prefix+"tx.bytes"),
+			TxPackets: statUint(fields, prefix+"tx.pkts"),
+		})
+	}
+	return ifaces
+}
+
+// StatsBlockStats extracts the block device counters of a domstats entry.
+func StatsBlockStats(fields map[string]string) []BlockStats {
+	count := statUint(fields, "block.count")
+	blocks := make([]BlockStats, 0, count)
+	for i := uint64(0); i < count; i++ {
+		prefix := "block." + strconv.FormatUint(i, 10) + "."
+		blocks = append(blocks, BlockStats{
+			Name:       fields[prefix+"name"],
+			Path:       fields[prefix+"path"],
+			ReadBytes:  statUint(fields, prefix+"rd.bytes"),
+			ReadReqs:   statUint(fields, prefix+"rd.reqs"),
+			WriteBytes: statUint(fields, prefix+"wr.bytes"),
+			WriteReqs:  statUint(fields, prefix+"wr.reqs"),
+		})
+	}
+	return blocks
+}
+
+// StatsMemory extracts the balloon statistics of a domstats entry.
+func StatsMemory(fields map[string]string) MemoryStats {
+	_, guestStats := fields["balloon.last-update"]
+	return MemoryStats{
+		Current:    statUint(fields, "balloon.current"),
+		Maximum:    statUint(fields, "balloon.maximum"),
+		RSS:        statUint(fields, "balloon.rss"),
+		Unused:     statUint(fields, "balloon.unused"),
+		Available:  statUint(fields, "balloon.available"),
+		Usable:     statUint(fields, "balloon.usable"),
+		GuestStats: guestStats,
+	}
+}
+
+// StatsBlockDevices extracts the block devices listed in a domstats entry.
+// domstats doesn't report the device type, so Type and Device are empty.
+func StatsBlockDevices(fields map[string]string) []BlockDevice {
+	stats := StatsBlockStats(fields)
+	devices := make([]BlockDevice, 0, len(stats))
+	for _, b := range stats {
+		source := b.Path
+		if source == "" {
+			source = "-"
+		}
+		devices = append(devices, BlockDevice{Target: b.Name, Source: source})
+	}
+	return devices
+}
+
+// statUint returns a numeric domstats field, 0 when it is missing. Counters
+// the hypervisor doesn't support are simply not printed.
+func statUint(fields map[string]string, key string) uint64 {
+	n, _ := strconv.ParseUint(fields[key], 10, 64)
+	return n
+}
+
+// parseTable splits the rows of a virsh table such as domblklist or
+// domiflist into their whitespace separated columns. The header and
+// anything above the dashed separator line are skipped.
+func parseTable(out string) [][]string {
+	var rows [][]string
+	inBody := false
+	for _, l := range strings.Split(out, "\n") {
+		line := strings.TrimSpace(l)
+		if line == "" {
+			continue
+		}
+		if !inBody {
+			inBody = strings.Trim(line, "-") == ""
+			continue
+		}
+		rows = append(rows, strings.Fields(line))
+	}
+	return rows
+}
+
+func splitNames(out string) []string {
+	names := []string{}
+	for _, l := range strings.Split(out, "\n") {
+		if name := strings.TrimSpace(l); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
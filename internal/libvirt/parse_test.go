@@ -0,0 +1,155 @@
+package libvirt
+
+import (
+	"reflect"
+	"testing"
+)
+
+const domstatsOutput = `Domain: 'web-1'
+  net.count=2
+  net.0.name=vnet0
+  net.0.rx.bytes=1048576
+  net.0.rx.pkts=812
+  net.0.rx.errs=0
+  net.0.tx.bytes=524288
+  net.0.tx.pkts=409
+  net.1.name=vnet1
+  net.1.rx.bytes=10
+  net.1.tx.bytes=20
+  block.count=2
+  block.0.name=vda
+  block.0.path=/var/lib/vms/web-1/disk.qcow2
+  block.0.rd.reqs=1500
+  block.0.rd.bytes=61440000
+  block.0.wr.reqs=300
+  block.0.wr.bytes=1228800
+  block.1.name=sda
+  balloon.current=2097152
+  balloon.maximum=4194304
+  balloon.unused=1048576
+  balloon.available=2015232
+  balloon.usable=1536000
+  balloon.last-update=1700000000
+  balloon.rss=2150000
+
+Domain: 'db-1'
+  net.count=0
+  block.count=0
+  balloon.current=1048576
+  balloon.maximum=1048576
+
+`
+
+func TestParseDomainStats(t *testing.T) {
+	stats := ParseDomainStats(domstatsOutput)
+	if len(stats) != 2 {
+		t.Fatalf("got %d domains, want 2", len(stats))
+	}
+	if got := stats["web-1"]["block.0.path"]; got != "/var/lib/vms/web-1/disk.qcow2" {
+		t.Errorf("block.0.path = %q", got)
+	}
+	if got := stats["db-1"]["net.count"]; got != "0" {
+		t.Errorf("net.count of db-1 = %q, want 0", got)
+	}
+	if len(ParseDomainStats("")) != 0 {
+		t.Error("empty output should yield no domains")
+	}
+}
+
+func TestStatsInterfaces(t *testing.T) {
+	stats := ParseDomainStats(domstatsOutput)
+
+	want := []InterfaceStats{
+		{Name: "vnet0", RxBytes: 1048576, RxPackets: 812, TxBytes: 524288, TxPackets: 409},
+		// Counters the hypervisor doesn't report are zero
+		{Name: "vnet1", RxBytes: 10, TxBytes: 20},
+	}
+	if got := StatsInterfaces(stats["web-1"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("StatsInterfaces() = %+v, want %+v", got, want)
+	}
+	if got := StatsInterfaces(stats["db-1"]); len(got) != 0 {
+		t.Errorf("StatsInterfaces() = %+v, want none", got)
+	}
+}
+
+func TestStatsMemory(t *testing.T) {
+	stats := ParseDomainStats(domstatsOutput)
+
+	want := MemoryStats{Current: 2097152, Maximum: 4194304, RSS: 2150000, Unused: 1048576, Available: 2015232, Usable: 1536000, GuestStats: true}
+	if got := StatsMemory(stats["web-1"]); got != want {
+		t.Errorf("StatsMemory() = %+v, want %+v", got, want)
+	}
+	// Without a stats period the guest reports nothing
+	want = MemoryStats{Current: 1048576, Maximum: 1048576}
+	if got := StatsMemory(stats["db-1"]); got != want {
+		t.Errorf("StatsMemory() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsBlockStats(t *testing.T) {
+	stats := ParseDomainStats(domstatsOutput)
+
+	want := []BlockStats{
+		{Name: "vda", Path: "/var/lib/vms/web-1/disk.qcow2", ReadBytes: 61440000, ReadReqs: 1500, WriteBytes: 1228800, WriteReqs: 300},
+		{Name: "sda"},
+	}
+	if got := StatsBlockStats(stats["web-1"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("StatsBlockStats() = %+v, want %+v", got, want)
+	}
+
+	devices := []BlockDevice{
+		{Target: "vda", Source: "/var/lib/vms/web-1/disk.qcow2"},
+		{Target: "sda", Source: "-"},
+	}
+	if got := StatsBlockDevices(stats["web-1"]); !reflect.DeepEqual(got, devices) {
+		t.Errorf("StatsBlockDevices() = %+v, want %+v", got, devices)
+	}
+}
+
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want [][]string
+	}{
+		{
+			name: "domblklist",
+			out: ` Type   Device   Target   Source
+------------------------------------------------------------
+ file   disk     vda      /var/lib/vms/web-1/disk.qcow2
+ file   cdrom    sda      -
+`,
+			want: [][]string{
+				{"file", "disk", "vda", "/var/lib/vms/web-1/disk.qcow2"},
+				{"file", "cdrom", "sda", "-"},
+			},
+		},
+		{
+			name: "translated header",
+			out: ` Schnittstelle   Typ      Quelle    Modell   MAC
+-------------------------------------------------------------
+ vnet0           bridge   br0       virtio   52:54:00:12:34:56
+`,
+			want: [][]string{{"vnet0", "bridge", "br0", "virtio", "52:54:00:12:34:56"}},
+		},
+		{
+			name: "no rows",
+			out: ` Name   MAC address   Protocol   Address
+-------------------------------------------------------------------------------
+
+`,
+		},
+		{
+			name: "no separator",
+			out:  "error: failed to get domain 'web-1'\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTable(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTable() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
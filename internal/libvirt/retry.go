@@ -0,0 +1,67 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"libvirt-controller/internal/config"
+)
+
+const (
+	defaultReadAttempts  = 3
+	defaultReadBackoffMS = 200
+)
+
+// transientFragments are lowercased fragments of virsh's stderr for failures
+// of the connection to libvirtd rather than of the command, e.g. while the
+// daemon restarts. Running the command again may succeed.
+var transientFragments = []string{
+	"timed out during operation",
+	"connection reset by peer",
+	"cannot recv data",
+	"end of file while reading data",
+	"failed to connect to the hypervisor",
+	"client socket is closed",
+}
+
+// isRetryable reports whether err is a transient virsh failure. Failures
+// classified as typed errors, e.g. ErrDomainNotFound, are permanent.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var classified *virshError
+	if errors.As(err, &classified) {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range transientFragments {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// virshRead runs a virsh command that only reads state, retrying transient
+// failures with an exponential backoff. Commands changing state must use
+// virsh, running them twice isn't safe when the first attempt got through.
+func virshRead(ctx context.Context, args ...string) (string, error) {
+	attempts := max(config.Int("VIRSH_READ_ATTEMPTS", defaultReadAttempts), 1)
+	backoff := time.Duration(config.Int("VIRSH_READ_BACKOFF_MS", defaultReadBackoffMS)) * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		out, err := virsh(ctx, args...)
+		if err == nil || attempt >= attempts || !isRetryable(err) {
+			return out, err
+		}
+		select {
+		case <-ctx.Done():
+			return out, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
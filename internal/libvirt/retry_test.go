@@ -0,0 +1,70 @@
+package libvirt
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   bool
+	}{
+		{"timeout", "error: failed to connect to the hypervisor\nerror: Timed out during operation: cannot acquire state change lock\n", true},
+		{"connection reset", "error: Cannot recv data: Connection reset by peer\n", true},
+		{"daemon restart", "error: End of file while reading data: Input/output error\n", true},
+		{"no daemon", "error: failed to connect to the hypervisor\nerror: Failed to connect socket to '/var/run/libvirt/libvirt-sock': No such file or directory\n", true},
+		{"unknown domain", "error: failed to get domain 'vm-1'\nerror: Domain not found: no domain with matching name 'vm-1'\n", false},
+		{"invalid argument", "error: invalid argument: unsupported flags\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(classifyError(virshFailure(tt.stderr))); got != tt.want {
+				t.Errorf("isRetryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVirshRead(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+	t.Setenv("VIRSH_READ_ATTEMPTS", "3")
+	t.Setenv("VIRSH_READ_BACKOFF_MS", "0")
+
+	tests := []struct {
+		name      string
+		failures  []string
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", nil, 1, false},
+		{"transient", []string{"error: Cannot recv data: Connection reset by peer\n"}, 2, false},
+		{"permanent", []string{"error: failed to get domain 'vm-1'\n"}, 1, true},
+		{"exhausted", []string{
+			"error: Cannot recv data: Connection reset by peer\n",
+			"error: Cannot recv data: Connection reset by peer\n",
+			"error: Cannot recv data: Connection reset by peer\n",
+		}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				calls++
+				if calls <= len(tt.failures) {
+					return "", virshFailure(tt.failures[calls-1])
+				}
+				return "vm-1\n", nil
+			}
+			_, err := virshRead(context.Background(), "dominfo", "vm-1")
+			if calls != tt.wantCalls {
+				t.Errorf("virsh called %d times, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package libvirt
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"libvirt-controller/internal/domainxml"
+)
+
+// secretCreatedPattern extracts the UUID from the output of secret-define.
+var secretCreatedPattern = regexp.MustCompile(`Secret (\S+) created`)
+
+// Secret is a secret as listed by secret-list, without its value.
+type Secret struct {
+	UUID  string `json:"uuid"`
+	Usage string `json:"usage,omitempty"` // e.g. "ceph client.admin", empty for secrets without a usage
+}
+
+// DefineSecret defines a persistent secret without a value and returns its
+// UUID. The value of a private secret can't be read back.
+func DefineSecret(ctx context.Context, description string, private bool) (string, error) {
+	secretXML, err := domainxml.SecretXML(description, private)
+	if err != nil {
+		return "", err
+	}
+	xmlFile, err := writeTempFile("secret-*.xml", []byte(secretXML))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(xmlFile)
+
+	out, err := virsh(ctx, "secret-define", xmlFile)
+	if err != nil {
+		return "", err
+	}
+	match := secretCreatedPattern.FindStringSubmatch(out)
+	if match == nil {
+		return "", fmt.Errorf("unexpected secret-define output: %s", strings.TrimSpace(out))
+	}
+	return match[1], nil
+}
+
+// SetSecretValue sets the value of a secret. The value only ever goes
+// through a file readable by the controller, never the command line.
+func SetSecretValue(ctx context.Context, uuid string, value []byte) error {
+	valueFile, err := writeTempFile("secret-value-*", value)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(valueFile)
+
+	_, err = virsh(ctx, "secret-set-value", uuid, "--file", valueFile, "--plain")
+	return err
+}
+
+// SecretValue returns the value of a secret that isn't private.
+func SecretValue(ctx context.Context, uuid string) ([]byte, error) {
+	out, err := virsh(ctx, "secret-get-value", uuid)
+	if err != nil {
+		return nil, err
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the value of secret %s: %w", uuid, err)
+	}
+	return value, nil
+}
+
+// ListSecrets lists the secrets libvirt knows.
+func ListSecrets(ctx context.Context) ([]Secret, error) {
+	out, err := virsh(ctx, "secret-list")
+	if err != nil {
+		return nil, err
+	}
+	secrets := []Secret{}
+	for _, row := range parseTable(out) {
+		// UUID, Usage; the usage is split into its type and ID
+		secrets = append(secrets, Secret{UUID: row[0], Usage: strings.Join(row[1:], " ")})
+	}
+	return secrets, nil
+}
+
+// UndefineSecret deletes a secret and its value.
+func UndefineSecret(ctx context.Context, uuid string) (string, error) {
+	return virsh(ctx, "secret-undefine", uuid)
+}
+
+// writeTempFile writes data to a new temporary file only the controller can
+// read and returns its path.
+func writeTempFile(pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	return f.Name(), nil
+}
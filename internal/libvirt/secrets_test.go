@@ -0,0 +1,100 @@
+package libvirt
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSecretUUID = "6d5c1a3e-0f4b-4a8e-9c2d-1b7e3f5a9d20"
+
+func TestSecretLifecycle(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	var calls []string
+	var files []string
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		calls = append(calls, args[0])
+		for _, arg := range args {
+			if strings.Contains(arg, "s3cret") {
+				t.Errorf("secret value passed on the command line: %q", args)
+			}
+		}
+		switch args[0] {
+		case "secret-define":
+			files = append(files, args[1])
+			data, err := os.ReadFile(args[1])
+			if err != nil || !strings.Contains(string(data), `private="yes"`) || !strings.Contains(string(data), "<description>ceph</description>") {
+				t.Errorf("secret XML = %s, %v", data, err)
+			}
+			return "Secret " + testSecretUUID + " created\n", nil
+		case "secret-set-value":
+			want := []string{"secret-set-value", testSecretUUID, "--file", args[3], "--plain"}
+			if !reflect.DeepEqual(args, want) {
+				t.Errorf("args = %q, want %q", args, want)
+			}
+			files = append(files, args[3])
+			if data, err := os.ReadFile(args[3]); err != nil || string(data) != "s3cret" {
+				t.Errorf("value file holds %q, %v", data, err)
+			}
+			return "Secret value set\n", nil
+		case "secret-undefine":
+			if args[1] != testSecretUUID {
+				t.Errorf("undefined %s", args[1])
+			}
+			return "Secret " + testSecretUUID + " deleted\n", nil
+		}
+		t.Fatalf("unexpected virsh call %q", args)
+		return "", nil
+	}
+
+	ctx := context.Background()
+	uuid, err := DefineSecret(ctx, "ceph", true)
+	if err != nil || uuid != testSecretUUID {
+		t.Fatalf("DefineSecret() = %q, %v", uuid, err)
+	}
+	if err := SetSecretValue(ctx, uuid, []byte("s3cret")); err != nil {
+		t.Fatalf("SetSecretValue() error = %v", err)
+	}
+	if _, err := UndefineSecret(ctx, uuid); err != nil {
+		t.Fatalf("UndefineSecret() error = %v", err)
+	}
+
+	if want := []string{"secret-define", "secret-set-value", "secret-undefine"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("virsh calls = %q, want %q", calls, want)
+	}
+	for _, f := range files {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("temporary file %s was left behind", f)
+		}
+	}
+}
+
+func TestListSecrets(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		return ` UUID                                   Usage
+--------------------------------------------------------------------------------
+ 6d5c1a3e-0f4b-4a8e-9c2d-1b7e3f5a9d20   ceph client.admin
+ 0b7f5e2a-9a3c-4d1e-8f6b-2c4d6e8f0a1b
+
+`, nil
+	}
+
+	got, err := ListSecrets(context.Background())
+	if err != nil {
+		t.Fatalf("ListSecrets() error = %v", err)
+	}
+	want := []Secret{
+		{UUID: testSecretUUID, Usage: "ceph client.admin"},
+		{UUID: "0b7f5e2a-9a3c-4d1e-8f6b-2c4d6e8f0a1b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListSecrets() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,26 @@
+package libvirt
+
+import (
+	"context"
+
+	"libvirt-controller/internal/domainxml"
+)
+
+// AttachFilesystem adds a virtio-fs share to a domain, live also hotplugs it
+// into the running guest. The domain memory must be shared with the host.
+func AttachFilesystem(ctx context.Context, domainName string, fs domainxml.Filesystem, live bool) (string, error) {
+	deviceXML, err := domainxml.FilesystemXML(fs)
+	if err != nil {
+		return "", err
+	}
+	return deviceCommand(ctx, "attach-device", domainName, deviceXML, live)
+}
+
+// DetachFilesystem removes a virtio-fs share from a domain.
+func DetachFilesystem(ctx context.Context, domainName string, fs domainxml.Filesystem, live bool) (string, error) {
+	deviceXML, err := domainxml.FilesystemXML(fs)
+	if err != nil {
+		return "", err
+	}
+	return deviceCommand(ctx, "detach-device", domainName, deviceXML, live)
+}
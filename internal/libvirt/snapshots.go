@@ -1,12 +1,13 @@
 package libvirt
 
 import (
-	"libvirt-controller/internal/cmdutil"
+	"context"
+	"strings"
 )
 
 // TakeSnapshot creates a snapshot of a VM.
 // quiesce:  If true, attempt to quiesce the guest filesystem before taking the snapshot.
-func TakeSnapshot(domainName string, snapshotName string, quiesce bool) (string, error) {
+func TakeSnapshot(ctx context.Context, domainName string, snapshotName string, quiesce bool) (string, error) {
 	cmd := []string{
 		"snapshot-create-as",
 		domainName,
@@ -19,11 +20,11 @@ func TakeSnapshot(domainName string, snapshotName string, quiesce bool) (string,
 		cmd = append(cmd, "--quiesce")
 	}
 
-	return cmdutil.Execute("virsh", cmd...)
+	return virsh(ctx, cmd...)
 }
 
 // RevertSnapshot reverts the VM's disk to the state of the snapshot and deletes the snapshot.
-func RevertSnapshot(domainName string, snapshotName string) (string, error) {
+func RevertSnapshot(ctx context.Context, domainName string, snapshotName string) (string, error) {
 	cmd := []string{
 		"snapshot-revert",
 		domainName,
@@ -31,17 +32,33 @@ func RevertSnapshot(domainName string, snapshotName string) (string, error) {
 		//"--disk-only",
 	}
 
-	return cmdutil.Execute("virsh", cmd...)
+	return virsh(ctx, cmd...)
 }
 
 // DeleteSnapshot deletes a snapshot.
 // Essentially commits changes made since the snapshot was taken.
-func DeleteSnapshot(domainName string, snapshotName string) (string, error) {
+func DeleteSnapshot(ctx context.Context, domainName string, snapshotName string) (string, error) {
 	cmd := []string{
 		"snapshot-delete",
 		domainName,
 		snapshotName,
 		"--metadata",
 	}
-	return cmdutil.Execute("virsh", cmd...)
+	return virsh(ctx, cmd...)
+}
+
+// ListSnapshots returns the names of a domain's snapshots, oldest first.
+func ListSnapshots(ctx context.Context, domainName string) ([]string, error) {
+	out, err := virsh(ctx, "snapshot-list", domainName, "--name", "--topological")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []string{}
+	for _, l := range strings.Split(out, "\n") {
+		if name := strings.TrimSpace(l); name != "" {
+			snapshots = append(snapshots, name)
+		}
+	}
+	return snapshots, nil
 }
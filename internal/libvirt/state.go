@@ -0,0 +1,78 @@
+package libvirt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"libvirt-controller/internal/helpers"
+)
+
+// DomainState is a domain state as printed by dominfo.
+type DomainState string
+
+const (
+	StateRunning  DomainState = "running"
+	StatePaused   DomainState = "paused"
+	StateShutOff  DomainState = "shut off"
+	StateShutdown DomainState = "in shutdown"
+	StateCrashed  DomainState = "crashed"
+)
+
+// ErrStateTimeout is returned by WaitForState when the domain doesn't reach
+// the target state in time.
+var ErrStateTimeout = errors.New("timed out waiting for domain state")
+
+// statePollInterval is how often WaitForState checks dominfo.
+const statePollInterval = 500 * time.Millisecond
+
+// GetDomainState returns the current state of a domain.
+func GetDomainState(ctx context.Context, domainName string) (DomainState, error) {
+	domInfo, err := GetDomainInfo(ctx, domainName)
+	if err != nil {
+		return "", err
+	}
+	status, err := helpers.ParseDomainStatus(domInfo)
+	if err != nil {
+		return "", err
+	}
+	return DomainState(status), nil
+}
+
+// WaitForState polls dominfo until the domain is in the target state. It
+// returns the last observed state, wrapped in ErrStateTimeout if the target
+// wasn't reached within timeout.
+func WaitForState(ctx context.Context, domainName string, target DomainState, timeout time.Duration) (DomainState, error) {
+	deadline := time.After(timeout)
+	for {
+		state, err := GetDomainState(ctx, domainName)
+		if err != nil {
+			return "", err
+		}
+		if state == target {
+			return state, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, ctx.Err()
+		case <-deadline:
+			return state, fmt.Errorf("%w: %s is %s after %s, expected %s", ErrStateTimeout, domainName, state, timeout, target)
+		case <-time.After(statePollInterval):
+		}
+	}
+}
+
+// RequireRunning returns ErrDomainNotRunning unless the domain is running.
+// Guest agent commands sent to a stopped or paused domain only time out.
+func RequireRunning(ctx context.Context, domainName string) error {
+	state, err := GetDomainState(ctx, domainName)
+	if err != nil {
+		return err
+	}
+	if state != StateRunning {
+		return fmt.Errorf("%w: %s is %s", ErrDomainNotRunning, domainName, state)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package libvirt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+)
+
+// defaultStatsCacheTTL is how long the statistics of the running domains are
+// reused, long enough for the collectors of one scrape to share them.
+const defaultStatsCacheTTL = 2 * time.Second
+
+// fetchActiveStats runs the domstats call behind ActiveDomainStats; swapped
+// out in tests.
+var fetchActiveStats = func(ctx context.Context) (string, error) {
+	return virshRead(ctx, "domstats", "--raw", "--interface", "--block", "--balloon", "--list-active")
+}
+
+// statsCache holds the last ActiveDomainStats result. fetch serializes the
+// virsh calls so concurrent scrapes wait for one call instead of each making
+// their own, mu guards the cached result.
+var statsCache struct {
+	fetch      sync.Mutex
+	mu         sync.Mutex
+	stats      map[string]map[string]string
+	expires    time.Time
+	generation uint64 // Bumped by InvalidateStatsCache
+}
+
+// ActiveDomainStats returns the interface, block and balloon statistics of
+// all running domains, keyed by domain name like ParseDomainStats. Results
+// are reused for METRICS_CACHE_TTL seconds and dropped by lifecycle
+// operations, callers must not modify them.
+func ActiveDomainStats(ctx context.Context) (map[string]map[string]string, error) {
+	ttl := config.Seconds("METRICS_CACHE_TTL", defaultStatsCacheTTL)
+	if ttl <= 0 {
+		out, err := fetchActiveStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ParseDomainStats(out), nil
+	}
+
+	statsCache.fetch.Lock()
+	defer statsCache.fetch.Unlock()
+
+	statsCache.mu.Lock()
+	if statsCache.stats != nil && time.Now().Before(statsCache.expires) {
+		stats := statsCache.stats
+		statsCache.mu.Unlock()
+		return stats, nil
+	}
+	generation := statsCache.generation
+	statsCache.mu.Unlock()
+
+	out, err := fetchActiveStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := ParseDomainStats(out)
+
+	// A lifecycle operation during the call may have made it stale already
+	statsCache.mu.Lock()
+	if statsCache.generation == generation {
+		statsCache.stats = stats
+		statsCache.expires = time.Now().Add(ttl)
+	}
+	statsCache.mu.Unlock()
+	return stats, nil
+}
+
+// InvalidateStatsCache drops the cached statistics after a domain started,
+// stopped or went away.
+func InvalidateStatsCache() {
+	statsCache.mu.Lock()
+	defer statsCache.mu.Unlock()
+	statsCache.stats = nil
+	statsCache.generation++
+}
@@ -0,0 +1,62 @@
+package libvirt
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestActiveDomainStatsCache(t *testing.T) {
+	original := fetchActiveStats
+	defer func() { fetchActiveStats = original }()
+	var calls atomic.Int32
+	fetchActiveStats = func(ctx context.Context) (string, error) {
+		calls.Add(1)
+		return domstatsOutput, nil
+	}
+	t.Setenv("METRICS_CACHE_TTL", "60")
+	InvalidateStatsCache()
+
+	// Concurrent scrapes share a single call
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := GetAllBlockStats(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	stats, err := GetAllMemoryStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("domstats ran %d times, want 1", got)
+	}
+	if stats["web-1"].Current != 2097152 {
+		t.Errorf("memory stats = %+v", stats["web-1"])
+	}
+
+	InvalidateStatsCache()
+	if _, err := GetAllInterfaceStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("domstats ran %d times after invalidation, want 2", got)
+	}
+
+	// Without a TTL every call goes to virsh
+	t.Setenv("METRICS_CACHE_TTL", "0")
+	for i := 0; i < 2; i++ {
+		if _, err := ActiveDomainStats(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := calls.Load(); got != 4 {
+		t.Errorf("domstats ran %d times without a TTL, want 4", got)
+	}
+}
@@ -0,0 +1,31 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/quota"
+)
+
+// Delete powers off a domain, undefines it and removes its directory.
+func Delete(ctx context.Context, vmID string, vmDir string) error {
+	defer Lock(vmID)()
+
+	// Attempt to destroy the VM. Log the error if it fails.
+	if _, err := libvirt.DestroyDomain(ctx, vmID); err != nil {
+		log.Printf("Warning: Failed to destroy VM, it might be already off: %v", err)
+	}
+
+	if _, err := libvirt.UndefineDomain(ctx, vmID); err != nil {
+		return fmt.Errorf("failed to undefine VM: %w", err)
+	}
+
+	if err := filesystem.DeleteDirectory(vmDir); err != nil {
+		return fmt.Errorf("failed to delete VM directory: %w", err)
+	}
+	quota.Default.Release(quota.DomainKey(vmID))
+	return nil
+}
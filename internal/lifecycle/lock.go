@@ -0,0 +1,38 @@
+package lifecycle
+
+import "sync"
+
+// locks holds a mutex per VM ID while anyone holds or waits for it.
+var locks = struct {
+	sync.Mutex
+	byID map[string]*vmLock
+}{byID: make(map[string]*vmLock)}
+
+type vmLock struct {
+	sync.Mutex
+	refs int // Holders and waiters
+}
+
+// Lock serializes the operations changing the definition of vmID: defines,
+// XML updates, metadata and cloud-init changes and deletes. It blocks until the previous one is done and
+// returns the function releasing the lock.
+func Lock(vmID string) (unlock func()) {
+	locks.Lock()
+	l, ok := locks.byID[vmID]
+	if !ok {
+		l = &vmLock{}
+		locks.byID[vmID] = l
+	}
+	l.refs++
+	locks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		locks.Lock()
+		defer locks.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(locks.byID, vmID)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"libvirt-controller/internal/filesystem"
+)
+
+// FileName is the metadata file stored in every VM directory.
+const FileName = "metadata.json"
+
+// Metadata is controller-side information about a domain that libvirt
+// itself doesn't track.
+type Metadata struct {
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// ExpiresAt is when the reaper deletes the domain, nil keeps it forever.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	SnapshotSchedule *SnapshotSchedule `json:"snapshot_schedule,omitempty"`
+
+	// SyncTimeEvery is how often the guest clock is set to the host's
+	// through the guest agent, a Go duration such as "1h". Empty never syncs.
+	SyncTimeEvery string `json:"sync_time_every,omitempty"`
+
+	// CaptureConsole keeps the recent serial console output of the domain
+	// in memory, read from the file its serial port writes to.
+	CaptureConsole bool `json:"capture_console,omitempty"`
+
+	// StorageClass is the storage class the VM directory was created in.
+	StorageClass string `json:"storage_class,omitempty"`
+
+	// Tenant is charged for the domain's vCPUs and memory, see QUOTA_FILE.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// MinSnapshotInterval is the shortest allowed automatic snapshot interval.
+const MinSnapshotInterval = 5 * time.Minute
+
+// SnapshotSchedule makes the scheduler snapshot a domain periodically and
+// keep only the newest Keep automatic snapshots.
+type SnapshotSchedule struct {
+	Every string `json:"every"` // Go duration such as "6h" or "30m"
+	Keep  int    `json:"keep"`
+}
+
+// Interval parses Every.
+func (s *SnapshotSchedule) Interval() (time.Duration, error) {
+	return time.ParseDuration(s.Every)
+}
+
+func (s *SnapshotSchedule) Validate() error {
+	interval, err := s.Interval()
+	if err != nil {
+		return fmt.Errorf("'every' must be a duration such as \"6h\": %w", err)
+	}
+	if interval < MinSnapshotInterval {
+		return fmt.Errorf("'every' must be at least %s", MinSnapshotInterval)
+	}
+	if s.Keep < 1 {
+		return fmt.Errorf("'keep' must be at least 1")
+	}
+	return nil
+}
+
+// MinTimeSyncInterval is the shortest allowed guest time sync interval.
+const MinTimeSyncInterval = time.Minute
+
+// TimeSyncInterval parses SyncTimeEvery, 0 when time sync is off.
+func (m *Metadata) TimeSyncInterval() (time.Duration, error) {
+	if m.SyncTimeEvery == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(m.SyncTimeEvery)
+}
+
+// ValidateTimeSync checks a sync_time_every value, empty turns sync off.
+func ValidateTimeSync(every string) error {
+	if every == "" {
+		return nil
+	}
+	interval, err := time.ParseDuration(every)
+	if err != nil {
+		return fmt.Errorf("must be a duration such as \"1h\": %w", err)
+	}
+	if interval < MinTimeSyncInterval {
+		return fmt.Errorf("must be at least %s", MinTimeSyncInterval)
+	}
+	return nil
+}
+
+// Expired reports whether the domain is past its expiry at now.
+func (m *Metadata) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// Load reads the metadata of the VM in vmDir. A missing file yields empty
+// metadata rather than an error.
+func Load(vmDir string) (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(vmDir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Metadata{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	var m Metadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	return &m, nil
+}
+
+// Save atomically writes the metadata of the VM in vmDir.
+func Save(vmDir string, m *Metadata) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return filesystem.SaveFileAtomic(vmDir, FileName, data)
+}
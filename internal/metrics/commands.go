@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"time"
+
+	"libvirt-controller/internal/cmdutil"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewCommandsInFlightGauge reports the external commands (virsh, qemu-img,
+// ...) currently running, bounded by MAX_CONCURRENT_COMMANDS.
+func NewCommandsInFlightGauge() prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "libvirt_controller_commands_in_flight",
+		Help: "External commands currently running",
+	}, func() float64 {
+		return float64(cmdutil.InFlight())
+	})
+}
+
+// NewCommandDurationHistogram times every external command, labeled only by
+// the binary so the cardinality stays low. It hooks into cmdutil, so only one
+// should be created.
+func NewCommandDurationHistogram() *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "libvirt_command_duration_seconds",
+		Help:    "Run time of external commands such as virsh and qemu-img",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"command"})
+	cmdutil.ObserveDurations(func(command string, d time.Duration) {
+		h.WithLabelValues(command).Observe(d.Seconds())
+	})
+	return h
+}
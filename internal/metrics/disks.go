@@ -1,7 +1,9 @@
 package metrics
 
 import (
+	"context"
 	"libvirt-controller/internal/libvirt"
+	"log"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -30,17 +32,24 @@ func (c *LibvirtDiskCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *LibvirtDiskCollector) Collect(ch chan<- prometheus.Metric) {
-	domains := libvirt.GetDomains()
-	for _, d := range domains {
-		disks := libvirt.GetDomainDisks(d)
+	c.CollectDomains(ch, nil)
+}
+
+func (c *LibvirtDiskCollector) CollectDomains(ch chan<- prometheus.Metric, filter Filter) {
+	stats, err := libvirt.GetAllBlockStats(context.Background())
+	if err != nil {
+		log.Printf("failed to collect disk stats: %v", err)
+		return
+	}
+	for d, disks := range stats {
+		if !filter.Includes(d) {
+			continue
+		}
 		for _, disk := range disks {
-			stats := libvirt.GetDiskStats(d, disk.Name)
-			if stats != nil {
-				ch <- prometheus.MustNewConstMetric(&c.rdBytes, prometheus.CounterValue, stats["rd_bytes"], d, disk.Name)
-				ch <- prometheus.MustNewConstMetric(&c.wrBytes, prometheus.CounterValue, stats["wr_bytes"], d, disk.Name)
-				ch <- prometheus.MustNewConstMetric(&c.rdReqs, prometheus.CounterValue, stats["rd_req"], d, disk.Name)
-				ch <- prometheus.MustNewConstMetric(&c.wrReqs, prometheus.CounterValue, stats["wr_req"], d, disk.Name)
-			}
+			ch <- prometheus.MustNewConstMetric(&c.rdBytes, prometheus.CounterValue, float64(disk.ReadBytes), d, disk.Name)
+			ch <- prometheus.MustNewConstMetric(&c.wrBytes, prometheus.CounterValue, float64(disk.WriteBytes), d, disk.Name)
+			ch <- prometheus.MustNewConstMetric(&c.rdReqs, prometheus.CounterValue, float64(disk.ReadReqs), d, disk.Name)
+			ch <- prometheus.MustNewConstMetric(&c.wrReqs, prometheus.CounterValue, float64(disk.WriteReqs), d, disk.Name)
 		}
 	}
 }
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Filter limits a collection to some domains, nil collects all of them.
+type Filter map[string]bool
+
+// NewFilter returns a filter for domains, nil when there are none.
+func NewFilter(domains []string) Filter {
+	if len(domains) == 0 {
+		return nil
+	}
+	f := make(Filter, len(domains))
+	for _, d := range domains {
+		f[d] = true
+	}
+	return f
+}
+
+// Includes reports whether domain is collected.
+func (f Filter) Includes(domain string) bool {
+	return f == nil || f[domain]
+}
+
+// DomainCollector is a collector of per domain metrics that can skip the
+// domains left out of a filter, including the per domain virsh calls.
+type DomainCollector interface {
+	prometheus.Collector
+	CollectDomains(ch chan<- prometheus.Metric, filter Filter)
+}
+
+// Register adds a collector to registerer. A collector that can't be
+// registered is logged and disabled rather than taking the process down.
+func Register(registerer prometheus.Registerer, name string, c prometheus.Collector) bool {
+	if err := registerer.Register(c); err != nil {
+		log.Printf("metrics: disabling collector %s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// filteredCollector collects a DomainCollector for one request.
+type filteredCollector struct {
+	DomainCollector
+	filter Filter
+}
+
+func (c filteredCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectDomains(ch, c.filter)
+}
+
+// Handler serves the default registry together with the domain collectors.
+// Every `?domain=` query parameter limits the domain collectors to that
+// domain, all domains are collected without one. Collectors that can't be
+// registered, e.g. for clashing descriptors, are logged and left out.
+// Overlapping scrapes with the same filter share one collection pass.
+func Handler(collectors ...DomainCollector) http.Handler {
+	valid := prometheus.NewRegistry()
+	usable := make([]DomainCollector, 0, len(collectors))
+	for _, c := range collectors {
+		if Register(valid, fmt.Sprintf("%T", c), c) {
+			usable = append(usable, newSharedCollector(c))
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := NewFilter(r.URL.Query()["domain"])
+
+		// The collectors keep their state, only the filter is per request.
+		// They registered together above, so they do here as well.
+		registry := prometheus.NewRegistry()
+		for _, c := range usable {
+			registry.MustRegister(filteredCollector{DomainCollector: c, filter: filter})
+		}
+		gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
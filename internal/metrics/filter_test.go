@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeCollector reports one gauge per domain it knows.
+type fakeCollector struct {
+	desc    *prometheus.Desc
+	domains []string
+}
+
+func (c *fakeCollector) Describe(ch chan<- *prometheus.Desc) { ch <- c.desc }
+func (c *fakeCollector) Collect(ch chan<- prometheus.Metric) { c.CollectDomains(ch, nil) }
+
+func (c *fakeCollector) CollectDomains(ch chan<- prometheus.Metric, filter Filter) {
+	for _, d := range c.domains {
+		if filter.Includes(d) {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, d)
+		}
+	}
+}
+
+func TestHandlerFiltersDomains(t *testing.T) {
+	collector := &fakeCollector{
+		desc:    prometheus.NewDesc("libvirt_test_domain_up", "Test gauge", []string{"domain"}, nil),
+		domains: []string{"vm-1", "vm-2", "vm-3"},
+	}
+	handler := Handler(collector)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"vm-1", "vm-2", "vm-3"}},
+		{"?domain=vm-2", []string{"vm-2"}},
+		{"?domain=vm-1&domain=vm-3&domain=unknown", []string{"vm-1", "vm-3"}},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d: %s", tt.query, rec.Code, rec.Body)
+		}
+		body := rec.Body.String()
+		for _, d := range collector.domains {
+			line := `libvirt_test_domain_up{domain="` + d + `"} 1`
+			wanted := strings.Contains(strings.Join(tt.want, ","), d)
+			if strings.Contains(body, line) != wanted {
+				t.Errorf("%q: %s reported = %t, want %t", tt.query, d, !wanted, wanted)
+			}
+		}
+		// Metrics of the default registry are always served
+		if !strings.Contains(body, "go_goroutines") {
+			t.Errorf("%q: default registry metrics missing", tt.query)
+		}
+	}
+}
+
+func TestRegisterFailureDisablesCollector(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first := &fakeCollector{desc: prometheus.NewDesc("libvirt_test_domain_up", "Test gauge", []string{"domain"}, nil), domains: []string{"vm-1"}}
+	clashing := &fakeCollector{desc: prometheus.NewDesc("libvirt_test_domain_up", "Other help", []string{"vm"}, nil), domains: []string{"vm-2"}}
+
+	if !Register(registry, "first", first) {
+		t.Fatal("first collector must register")
+	}
+	// Must neither panic nor register
+	if Register(registry, "clashing", clashing) {
+		t.Error("clashing collector registered")
+	}
+
+	// The handler drops the clashing collector and serves the rest
+	rec := httptest.NewRecorder()
+	Handler(first, clashing).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `libvirt_test_domain_up{domain="vm-1"} 1`) || strings.Contains(body, "vm-2") {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultGuestFSTimeout     = 5 * time.Second
+	defaultGuestFSConcurrency = 4
+)
+
+// Domain and guest agent calls; swapped out in tests.
+var (
+	listRunningDomains = libvirt.ListAllDomains
+	guestFileSystems   = qemu.GetFileSystemInfo
+)
+
+// LibvirtGuestFSCollector exports the filesystem usage guests report through
+// their agent. Every scrape asks each running guest, so it's only registered
+// when GUEST_FS_METRICS is set.
+type LibvirtGuestFSCollector struct {
+	used  prometheus.Desc
+	total prometheus.Desc
+
+	timeout     time.Duration // GUEST_FS_METRICS_TIMEOUT, per guest
+	concurrency int           // GUEST_FS_METRICS_CONCURRENCY, guests asked at once
+}
+
+func NewLibvirtGuestFSCollector() *LibvirtGuestFSCollector {
+	return &LibvirtGuestFSCollector{
+		used:        *prometheus.NewDesc("libvirt_domain_guest_fs_used_bytes", "Used bytes of a guest filesystem as reported by the guest agent", []string{"domain", "mountpoint"}, nil),
+		total:       *prometheus.NewDesc("libvirt_domain_guest_fs_total_bytes", "Size of a guest filesystem as reported by the guest agent", []string{"domain", "mountpoint"}, nil),
+		timeout:     config.Seconds("GUEST_FS_METRICS_TIMEOUT", defaultGuestFSTimeout),
+		concurrency: max(config.Int("GUEST_FS_METRICS_CONCURRENCY", defaultGuestFSConcurrency), 1),
+	}
+}
+
+func (c *LibvirtGuestFSCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- &c.used
+	ch <- &c.total
+}
+
+func (c *LibvirtGuestFSCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectDomains(ch, nil)
+}
+
+func (c *LibvirtGuestFSCollector) CollectDomains(ch chan<- prometheus.Metric, filter Filter) {
+	domains, err := listRunningDomains(context.Background(), false)
+	if err != nil {
+		log.Printf("failed to list domains for guest filesystem stats: %v", err)
+		return
+	}
+
+	slots := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+	for _, d := range domains {
+		if !filter.Includes(d) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			c.collectDomain(ch, d)
+		}()
+	}
+	wg.Wait()
+}
+
+// collectDomain exports the filesystems of one guest. Guests without a
+// connected agent fail fast and are skipped, as are agents older than
+// qemu-ga 3.0 that don't report usage; the df fallback of the API is too
+// expensive for a scrape.
+func (c *LibvirtGuestFSCollector) collectDomain(ch chan<- prometheus.Metric, domain string) {
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	filesystems, err := guestFileSystems(ctx, domain)
+	if err != nil {
+		return
+	}
+
+	// A mountpoint can be listed twice, e.g. for stacked mounts, and a
+	// duplicate series would fail the whole scrape
+	seen := make(map[string]bool)
+	for _, fs := range filesystems {
+		if fs.UsedBytes == nil || fs.TotalBytes == nil || seen[fs.Mountpoint] {
+			continue
+		}
+		seen[fs.Mountpoint] = true
+		ch <- prometheus.MustNewConstMetric(&c.used, prometheus.GaugeValue, float64(*fs.UsedBytes), domain, fs.Mountpoint)
+		ch <- prometheus.MustNewConstMetric(&c.total, prometheus.GaugeValue, float64(*fs.TotalBytes), domain, fs.Mountpoint)
+	}
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"libvirt-controller/internal/qemu"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGuestFSCollector(t *testing.T) {
+	originalList, originalFS := listRunningDomains, guestFileSystems
+	defer func() { listRunningDomains, guestFileSystems = originalList, originalFS }()
+
+	listRunningDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		return []string{"vm-1", "vm-2", "no-agent"}, nil
+	}
+	used, total := int64(1<<30), int64(10<<30)
+	guestFileSystems = func(ctx context.Context, vm string) ([]qemu.FileSystemInfo, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Errorf("agent call for %s has no deadline", vm)
+		}
+		switch vm {
+		case "vm-1":
+			return []qemu.FileSystemInfo{
+				{Mountpoint: "/", UsedBytes: &used, TotalBytes: &total},
+				{Mountpoint: "/", UsedBytes: &used, TotalBytes: &total}, // Stacked mount
+				{Mountpoint: "/boot"}, // Agent without usage
+			}, nil
+		case "vm-2":
+			return []qemu.FileSystemInfo{{Mountpoint: "C:\\", UsedBytes: &used, TotalBytes: &total}}, nil
+		}
+		return nil, errors.New("error: Guest agent is not responding: QEMU guest agent is not connected")
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", nil, []string{"vm-1 /", "vm-2 C:\\"}},
+		{"filtered", NewFilter([]string{"vm-2"}), []string{"vm-2 C:\\"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			registry.MustRegister(filteredCollector{DomainCollector: NewLibvirtGuestFSCollector(), filter: tt.filter})
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Gather() error = %v", err)
+			}
+			if len(families) != 2 {
+				t.Fatalf("got %d metric families, want 2", len(families))
+			}
+			for _, family := range families {
+				var got []string
+				for _, m := range family.GetMetric() {
+					labels := m.GetLabel()
+					got = append(got, labels[0].GetValue()+" "+labels[1].GetValue())
+				}
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s series = %q, want %q", family.GetName(), got, tt.want)
+				}
+			}
+		})
+	}
+}
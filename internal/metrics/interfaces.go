@@ -1,45 +1,86 @@
 package metrics
 
 import (
+	"context"
+	"libvirt-controller/internal/config"
 	"libvirt-controller/internal/libvirt"
+	"log"
+	"os"
+	"slices"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// interfaceLabels are the optional labels of the interface metrics and the
+// domiflist column each is read from. domain and iface are always there.
+var interfaceLabels = map[string]func(libvirt.InterfaceInfo) string{
+	"mac":     func(i libvirt.InterfaceInfo) string { return i.MAC },
+	"type":    func(i libvirt.InterfaceInfo) string { return i.Type },
+	"network": func(i libvirt.InterfaceInfo) string { return i.Source }, // Network or bridge
+	"model":   func(i libvirt.InterfaceInfo) string { return i.Model },
+}
+
 type LibvirtInterfaceCollector struct {
 	rxBytes   *prometheus.Desc
 	txBytes   *prometheus.Desc
 	rxPackets *prometheus.Desc
 	txPackets *prometheus.Desc
+
+	labels []string // Optional labels in the order of the Descs
 }
 
 func NewLibvirtInterfaceCollector() *LibvirtInterfaceCollector {
+	labels := interfaceMetricLabels()
+	names := append([]string{"domain", "iface"}, labels...)
 	return &LibvirtInterfaceCollector{
 		rxBytes: prometheus.NewDesc(
 			"libvirt_domain_interface_rx_bytes_total",
 			"Received bytes on a domain interface",
-			[]string{"domain", "iface", "mac"},
+			names,
 			nil,
 		),
 		txBytes: prometheus.NewDesc(
 			"libvirt_domain_interface_tx_bytes_total",
 			"Transmitted bytes on a domain interface",
-			[]string{"domain", "iface", "mac"},
+			names,
 			nil,
 		),
 		rxPackets: prometheus.NewDesc(
 			"libvirt_domain_interface_rx_packets_total",
 			"Received packets on a domain interface",
-			[]string{"domain", "iface", "mac"},
+			names,
 			nil,
 		),
 		txPackets: prometheus.NewDesc(
 			"libvirt_domain_interface_tx_packets_total",
 			"Transmitted packets on a domain interface",
-			[]string{"domain", "iface", "mac"},
+			names,
 			nil,
 		),
+		labels: labels,
+	}
+}
+
+// interfaceMetricLabels reads INTERFACE_METRIC_LABELS, the optional labels
+// to add to the interface metrics. Unset means mac, "none" adds no labels.
+func interfaceMetricLabels() []string {
+	if _, ok := os.LookupEnv("INTERFACE_METRIC_LABELS"); !ok {
+		return []string{"mac"}
+	}
+	var labels []string
+	for _, label := range config.List("INTERFACE_METRIC_LABELS") {
+		if label == "none" {
+			continue
+		}
+		if _, ok := interfaceLabels[label]; !ok {
+			log.Printf("ignoring unknown interface metric label %q", label)
+			continue
+		}
+		if !slices.Contains(labels, label) {
+			labels = append(labels, label)
+		}
 	}
+	return labels
 }
 
 func (c *LibvirtInterfaceCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -50,17 +91,43 @@ func (c *LibvirtInterfaceCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *LibvirtInterfaceCollector) Collect(ch chan<- prometheus.Metric) {
-	domains := libvirt.GetDomains()
-	for _, d := range domains {
-		ifaces := libvirt.GetDomainIfaces(d)
-		for _, iface := range ifaces {
-			stats := libvirt.GetIfaceStats(d, iface.Name)
-			if stats != nil {
-				ch <- prometheus.MustNewConstMetric(c.rxBytes, prometheus.CounterValue, stats["rx_bytes"], d, iface.Name, iface.Mac)
-				ch <- prometheus.MustNewConstMetric(c.txBytes, prometheus.CounterValue, stats["tx_bytes"], d, iface.Name, iface.Mac)
-				ch <- prometheus.MustNewConstMetric(c.rxPackets, prometheus.CounterValue, stats["rx_pkts"], d, iface.Name, iface.Mac)
-				ch <- prometheus.MustNewConstMetric(c.txPackets, prometheus.CounterValue, stats["tx_pkts"], d, iface.Name, iface.Mac)
+	c.CollectDomains(ch, nil)
+}
+
+func (c *LibvirtInterfaceCollector) CollectDomains(ch chan<- prometheus.Metric, filter Filter) {
+	ctx := context.Background()
+	stats, err := libvirt.GetAllInterfaceStats(ctx)
+	if err != nil {
+		log.Printf("failed to collect interface stats: %v", err)
+		return
+	}
+	for d, ifaces := range stats {
+		if !filter.Includes(d) {
+			continue
+		}
+		// domstats has none of the optional labels, a failed lookup leaves them empty
+		var details map[string]libvirt.InterfaceInfo
+		if len(c.labels) > 0 {
+			details, err = libvirt.GetInterfaceDetails(ctx, d)
+			if err != nil {
+				log.Printf("failed to list interfaces of %s: %v", d, err)
 			}
 		}
+		for _, iface := range ifaces {
+			values := c.labelValues(d, iface.Name, details[iface.Name])
+			ch <- prometheus.MustNewConstMetric(c.rxBytes, prometheus.CounterValue, float64(iface.RxBytes), values...)
+			ch <- prometheus.MustNewConstMetric(c.txBytes, prometheus.CounterValue, float64(iface.TxBytes), values...)
+			ch <- prometheus.MustNewConstMetric(c.rxPackets, prometheus.CounterValue, float64(iface.RxPackets), values...)
+			ch <- prometheus.MustNewConstMetric(c.txPackets, prometheus.CounterValue, float64(iface.TxPackets), values...)
+		}
+	}
+}
+
+// labelValues returns the label values of an interface in the order of the Descs.
+func (c *LibvirtInterfaceCollector) labelValues(domain, iface string, info libvirt.InterfaceInfo) []string {
+	values := []string{domain, iface}
+	for _, label := range c.labels {
+		values = append(values, interfaceLabels[label](info))
 	}
+	return values
 }
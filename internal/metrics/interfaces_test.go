@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+
+	"libvirt-controller/internal/libvirt"
+)
+
+func TestInterfaceMetricLabels(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *string
+		want  []string
+	}{
+		{"unset", nil, []string{"mac"}},
+		{"none", ptr("none"), nil},
+		{"empty", ptr(""), nil},
+		{"several", ptr("network, mac,type"), []string{"network", "mac", "type"}},
+		{"unknown and repeated", ptr("bogus,mac,mac"), []string{"mac"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value != nil {
+				t.Setenv("INTERFACE_METRIC_LABELS", *tt.value)
+			}
+			if got := interfaceMetricLabels(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("interfaceMetricLabels() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterfaceLabelValues(t *testing.T) {
+	t.Setenv("INTERFACE_METRIC_LABELS", "network,mac")
+	c := NewLibvirtInterfaceCollector()
+	info := libvirt.InterfaceInfo{Type: "bridge", Source: "br0", Model: "virtio", MAC: "52:54:00:12:34:56"}
+
+	want := []string{"vm-1", "vnet0", "br0", "52:54:00:12:34:56"}
+	if got := c.labelValues("vm-1", "vnet0", info); !reflect.DeepEqual(got, want) {
+		t.Errorf("labelValues() = %q, want %q", got, want)
+	}
+}
+
+func ptr(s string) *string { return &s }
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/libvirt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultMemoryStatsPeriod is how often, in seconds, guests report memory
+// statistics once the collector has enabled them.
+const defaultMemoryStatsPeriod = 10
+
+type LibvirtMemoryCollector struct {
+	current   prometheus.Desc
+	maximum   prometheus.Desc
+	rss       prometheus.Desc
+	unused    prometheus.Desc
+	available prometheus.Desc
+	usable    prometheus.Desc
+
+	period int // MEMORY_STATS_PERIOD, 0 leaves the guests alone
+
+	mu      sync.Mutex
+	enabled map[string]bool // Domains the stats period was already set for
+}
+
+func NewLibvirtMemoryCollector() *LibvirtMemoryCollector {
+	return &LibvirtMemoryCollector{
+		current:   *prometheus.NewDesc("libvirt_domain_memory_current_bytes", "Memory currently assigned to a domain by the balloon", []string{"domain"}, nil),
+		maximum:   *prometheus.NewDesc("libvirt_domain_memory_maximum_bytes", "Maximum memory of a domain", []string{"domain"}, nil),
+		rss:       *prometheus.NewDesc("libvirt_domain_memory_rss_bytes", "Resident memory of the domain's qemu process on the host", []string{"domain"}, nil),
+		unused:    *prometheus.NewDesc("libvirt_domain_memory_unused_bytes", "Memory left completely unused by the guest", []string{"domain"}, nil),
+		available: *prometheus.NewDesc("libvirt_domain_memory_available_bytes", "Memory available to the guest OS", []string{"domain"}, nil),
+		usable:    *prometheus.NewDesc("libvirt_domain_memory_usable_bytes", "Memory the guest can use without swapping, including caches", []string{"domain"}, nil),
+		period:    config.Int("MEMORY_STATS_PERIOD", defaultMemoryStatsPeriod),
+		enabled:   make(map[string]bool),
+	}
+}
+
+func (c *LibvirtMemoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- &c.current
+	ch <- &c.maximum
+	ch <- &c.rss
+	ch <- &c.unused
+	ch <- &c.available
+	ch <- &c.usable
+}
+
+func (c *LibvirtMemoryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectDomains(ch, nil)
+}
+
+func (c *LibvirtMemoryCollector) CollectDomains(ch chan<- prometheus.Metric, filter Filter) {
+	ctx := context.Background()
+	stats, err := libvirt.GetAllMemoryStats(ctx)
+	if err != nil {
+		log.Printf("failed to collect memory stats: %v", err)
+		return
+	}
+	for d := range stats {
+		if !filter.Includes(d) {
+			delete(stats, d)
+		}
+	}
+	c.enablePeriods(ctx, stats, filter)
+
+	for d, mem := range stats {
+		ch <- prometheus.MustNewConstMetric(&c.current, prometheus.GaugeValue, float64(mem.Current*1024), d)
+		ch <- prometheus.MustNewConstMetric(&c.maximum, prometheus.GaugeValue, float64(mem.Maximum*1024), d)
+		ch <- prometheus.MustNewConstMetric(&c.rss, prometheus.GaugeValue, float64(mem.RSS*1024), d)
+		// The guest figures are missing until its first report
+		if !mem.GuestStats {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(&c.unused, prometheus.GaugeValue, float64(mem.Unused*1024), d)
+		ch <- prometheus.MustNewConstMetric(&c.available, prometheus.GaugeValue, float64(mem.Available*1024), d)
+		ch <- prometheus.MustNewConstMetric(&c.usable, prometheus.GaugeValue, float64(mem.Usable*1024), d)
+	}
+}
+
+// enablePeriods sets the stats period of the domains that don't report guest
+// statistics yet. It's tried once per domain, guests without a balloon driver
+// never report. Domains that stopped or report again are forgotten, so a
+// domain started anew gets its period set again. Domains left out of filter
+// are kept as they are.
+func (c *LibvirtMemoryCollector) enablePeriods(ctx context.Context, stats map[string]libvirt.MemoryStats, filter Filter) {
+	if c.period <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for d := range c.enabled {
+		if !filter.Includes(d) {
+			continue
+		}
+		if mem, ok := stats[d]; !ok || mem.GuestStats {
+			delete(c.enabled, d)
+		}
+	}
+	for d, mem := range stats {
+		if mem.GuestStats || c.enabled[d] {
+			continue
+		}
+		c.enabled[d] = true
+		if _, err := libvirt.SetMemoryStatsPeriod(ctx, d, c.period); err != nil {
+			log.Printf("failed to enable memory stats of %s: %v", d, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sharedCollector lets overlapping scrapes share one collection pass of a
+// DomainCollector. A scrape arriving while a pass with the same filter runs
+// waits for it and gets its metrics instead of starting its own virsh calls,
+// the next scrape after the pass collects afresh.
+type sharedCollector struct {
+	DomainCollector
+
+	mu       sync.Mutex
+	inFlight map[string]*collection // Running passes by filter
+}
+
+// collection is a running collection pass.
+type collection struct {
+	done    chan struct{} // Closed once metrics is complete
+	metrics []prometheus.Metric
+	joined  int // Scrapes waiting for the pass besides the one running it
+}
+
+func newSharedCollector(c DomainCollector) *sharedCollector {
+	return &sharedCollector{DomainCollector: c, inFlight: make(map[string]*collection)}
+}
+
+func (c *sharedCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectDomains(ch, nil)
+}
+
+func (c *sharedCollector) CollectDomains(ch chan<- prometheus.Metric, filter Filter) {
+	key := filter.key()
+	c.mu.Lock()
+	if pass, ok := c.inFlight[key]; ok {
+		pass.joined++
+		c.mu.Unlock()
+		<-pass.done
+		for _, m := range pass.metrics {
+			ch <- m
+		}
+		return
+	}
+	pass := &collection{done: make(chan struct{})}
+	c.inFlight[key] = pass
+	c.mu.Unlock()
+
+	c.collect(pass, key, filter)
+	for _, m := range pass.metrics {
+		ch <- m
+	}
+}
+
+// collect runs a pass of the wrapped collector into pass. Waiting scrapes
+// are released even if it panics, with the metrics collected until then.
+func (c *sharedCollector) collect(pass *collection, key string, filter Filter) {
+	ch := make(chan prometheus.Metric)
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for m := range ch {
+			pass.metrics = append(pass.metrics, m)
+		}
+	}()
+	defer func() {
+		close(ch)
+		<-drained
+		c.mu.Lock()
+		delete(c.inFlight, key)
+		c.mu.Unlock()
+		close(pass.done)
+	}()
+	c.DomainCollector.CollectDomains(ch, filter)
+}
+
+// key identifies the domains of a filter, the same for equal filters.
+func (f Filter) key() string {
+	if f == nil {
+		return ""
+	}
+	domains := make([]string, 0, len(f))
+	for d := range f {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+	return "\x00" + strings.Join(domains, "\x00")
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// slowCollector is a fakeCollector whose passes block until released.
+type slowCollector struct {
+	fakeCollector
+	passes  atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *slowCollector) Collect(ch chan<- prometheus.Metric) { c.CollectDomains(ch, nil) }
+
+func (c *slowCollector) CollectDomains(ch chan<- prometheus.Metric, filter Filter) {
+	c.passes.Add(1)
+	c.started <- struct{}{}
+	<-c.release
+	c.fakeCollector.CollectDomains(ch, filter)
+}
+
+// scrapeCount collects c like a scrape and returns the number of metrics.
+func scrapeCount(c DomainCollector, filter Filter) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.CollectDomains(ch, filter)
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+// waitJoined waits until n scrapes wait for the running pass of filter.
+func waitJoined(t *testing.T, c *sharedCollector, filter Filter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.mu.Lock()
+		pass := c.inFlight[filter.key()]
+		joined := pass != nil && pass.joined == n
+		c.mu.Unlock()
+		if joined {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d scrapes never joined the running pass", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentScrapesShareCollection(t *testing.T) {
+	collector := &slowCollector{
+		fakeCollector: fakeCollector{
+			desc:    prometheus.NewDesc("libvirt_test_domain_up", "Test gauge", []string{"domain"}, nil),
+			domains: []string{"vm-1", "vm-2", "vm-3"},
+		},
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	shared := newSharedCollector(collector)
+
+	const scrapes = 5
+	counts := make([]int, scrapes)
+	var wg sync.WaitGroup
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts[i] = scrapeCount(shared, nil)
+		}()
+		if i == 0 {
+			<-collector.started
+		}
+	}
+	waitJoined(t, shared, nil, scrapes-1)
+	close(collector.release)
+	wg.Wait()
+
+	if n := collector.passes.Load(); n != 1 {
+		t.Errorf("%d overlapping scrapes ran %d collection passes, want 1", scrapes, n)
+	}
+	for i, n := range counts {
+		if n != 3 {
+			t.Errorf("scrape %d got %d metrics, want 3", i, n)
+		}
+	}
+
+	// Once the pass is done the next scrape collects afresh, and scrapes of
+	// other domains never share it
+	if n := scrapeCount(shared, nil); n != 3 {
+		t.Errorf("later scrape got %d metrics, want 3", n)
+	}
+	if n := scrapeCount(shared, NewFilter([]string{"vm-2"})); n != 1 {
+		t.Errorf("filtered scrape got %d metrics, want 1", n)
+	}
+	if n := collector.passes.Load(); n != 3 {
+		t.Errorf("passes = %d after two more scrapes, want 3", n)
+	}
+}
+
+func TestFilterKey(t *testing.T) {
+	a := NewFilter([]string{"vm-1", "vm-2"})
+	b := NewFilter([]string{"vm-2", "vm-1"})
+	if a.key() != b.key() {
+		t.Errorf("equal filters have keys %q and %q", a.key(), b.key())
+	}
+	if a.key() == Filter(nil).key() || NewFilter([]string{"vm-1"}).key() == a.key() {
+		t.Error("different filters share a key")
+	}
+}
@@ -0,0 +1,106 @@
+package qemu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// guestExecPollInterval is how often GuestExecStatus is polled while waiting.
+const guestExecPollInterval = 250 * time.Millisecond
+
+// ErrGuestCommandTimeout is returned when a guest command is still running
+// once its timeout elapses. The command itself keeps running in the guest.
+var ErrGuestCommandTimeout = errors.New("guest command timed out")
+
+// GuestExec starts a command inside the guest and returns its PID.
+// When input is not nil it is passed to the command on stdin.
+func GuestExec(ctx context.Context, vm string, path string, args []string, input []byte) (int, error) {
+	arguments := map[string]interface{}{
+		"path":           path,
+		"arg":            args,
+		"capture-output": true,
+	}
+	if input != nil {
+		arguments["input-data"] = base64.StdEncoding.EncodeToString(input)
+	}
+
+	out, err := agentCommand(ctx, vm, "guest-exec", arguments)
+	if err != nil {
+		return 0, err
+	}
+
+	var res GuestExecResponse
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		return 0, fmt.Errorf("failed to parse guest-exec response: %w", err)
+	}
+	return res.Return.PID, nil
+}
+
+// GuestExecStatus returns the state of a command started with GuestExec.
+func GuestExecStatus(ctx context.Context, vm string, pid int) (*GuestExecState, error) {
+	out, err := agentCommand(ctx, vm, "guest-exec-status", map[string]interface{}{"pid": pid})
+	if err != nil {
+		return nil, err
+	}
+
+	var res GuestExecStatusResponse
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		return nil, fmt.Errorf("failed to parse guest-exec-status response: %w", err)
+	}
+	return &res.Return, nil
+}
+
+// RunGuestCommand runs a command inside the guest and polls until it exits
+// or the timeout elapses, returning the exit code and decoded output.
+func RunGuestCommand(ctx context.Context, vm string, path string, args []string, input []byte, timeout time.Duration) (*GuestExecResult, error) {
+	pid, err := GuestExec(ctx, vm, path, args, input)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := GuestExecStatus(ctx, vm, pid)
+		if err != nil {
+			return nil, err
+		}
+		if status.Exited {
+			return decodeExecStatus(status)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s (pid %d) did not exit within %s", ErrGuestCommandTimeout, path, pid, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(guestExecPollInterval):
+		}
+	}
+}
+
+func decodeExecStatus(status *GuestExecState) (*GuestExecResult, error) {
+	stdout, err := base64.StdEncoding.DecodeString(status.OutData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode guest stdout: %w", err)
+	}
+	stderr, err := base64.StdEncoding.DecodeString(status.ErrData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode guest stderr: %w", err)
+	}
+
+	exitCode := status.ExitCode
+	if status.Signal != 0 && exitCode == 0 {
+		// Killed by a signal, report it the way a shell would
+		exitCode = 128 + status.Signal
+	}
+
+	return &GuestExecResult{
+		ExitCode: exitCode,
+		Stdout:   string(stdout),
+		Stderr:   string(stderr),
+	}, nil
+}
@@ -0,0 +1,90 @@
+package qemu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunGuestCommand(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	tests := []struct {
+		name   string
+		status string
+		want   GuestExecResult
+	}{
+		{"success", `{"exited": true, "exitcode": 0, "out-data": "` + base64.StdEncoding.EncodeToString([]byte("ok\n")) + `"}`, GuestExecResult{Stdout: "ok\n"}},
+		{"failure", `{"exited": true, "exitcode": 1, "err-data": "` + base64.StdEncoding.EncodeToString([]byte("chpasswd: bad user\n")) + `"}`, GuestExecResult{ExitCode: 1, Stderr: "chpasswd: bad user\n"}},
+		{"killed", `{"exited": true, "signal": 9}`, GuestExecResult{ExitCode: 137}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input string
+			polls := 0
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				var payload struct {
+					Execute   string                 `json:"execute"`
+					Arguments map[string]interface{} `json:"arguments"`
+				}
+				if err := json.Unmarshal([]byte(args[2]), &payload); err != nil {
+					t.Fatalf("invalid agent command %v: %v", args, err)
+				}
+				switch payload.Execute {
+				case "guest-exec":
+					data, _ := base64.StdEncoding.DecodeString(payload.Arguments["input-data"].(string))
+					input = string(data)
+					return `{"return": {"pid": 42}}`, nil
+				case "guest-exec-status":
+					// The first poll finds the command still running
+					if polls++; polls == 1 {
+						return `{"return": {"exited": false}}`, nil
+					}
+					return `{"return": ` + tt.status + `}`, nil
+				}
+				t.Fatalf("unexpected agent command %s", payload.Execute)
+				return "", nil
+			}
+
+			result, err := RunGuestCommand(context.Background(), "vm1", "chpasswd", nil, []byte("root:secret\n"), time.Minute)
+			if err != nil {
+				t.Fatalf("RunGuestCommand() error = %v", err)
+			}
+			if *result != tt.want {
+				t.Errorf("result = %+v, want %+v", *result, tt.want)
+			}
+			if input != "root:secret\n" || polls != 2 {
+				t.Errorf("stdin = %q after %d polls", input, polls)
+			}
+		})
+	}
+}
+
+func TestRunGuestCommandTimeout(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+	// Answers both the guest-exec and the guest-exec-status calls
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		return `{"return": {"pid": 42, "exited": false}}`, nil
+	}
+
+	_, err := RunGuestCommand(context.Background(), "vm1", "sleep", []string{"600"}, nil, 0)
+	if !errors.Is(err, ErrGuestCommandTimeout) {
+		t.Errorf("RunGuestCommand() error = %v, want ErrGuestCommandTimeout", err)
+	}
+}
+
+func TestGuestExecResultJSON(t *testing.T) {
+	out, err := json.Marshal(GuestExecResult{ExitCode: 2, Stdout: "out", Stderr: "err"})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	// The same field names as the exit_code of the handlers' responses
+	if want := `{"exit_code":2,"stdout":"out","stderr":"err"}`; string(out) != want {
+		t.Errorf("Marshal() = %s, want %s", out, want)
+	}
+}
@@ -0,0 +1,59 @@
+package qemu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// guestFileChunkSize is the most written with one guest-file-write, keeping
+// the virsh command line short.
+const guestFileChunkSize = 32 * 1024
+
+// WriteGuestFile creates or truncates path inside the guest and writes data
+// to it.
+func WriteGuestFile(ctx context.Context, vm string, path string, data []byte) error {
+	out, err := agentCommand(ctx, vm, "guest-file-open", map[string]interface{}{"path": path, "mode": "w"})
+	if err != nil {
+		return err
+	}
+	var opened GuestFileOpenResponse
+	if err := json.Unmarshal([]byte(out), &opened); err != nil {
+		return fmt.Errorf("failed to parse guest-file-open response: %w", err)
+	}
+	handle := opened.Return
+
+	writeErr := writeGuestChunks(ctx, vm, handle, data)
+	// Close even when a write failed, the agent keeps handles open otherwise
+	_, closeErr := agentCommand(context.WithoutCancel(ctx), vm, "guest-file-close", map[string]interface{}{"handle": handle})
+	if writeErr != nil {
+		return writeErr
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to close %s in the guest: %w", path, closeErr)
+	}
+	return nil
+}
+
+func writeGuestChunks(ctx context.Context, vm string, handle int, data []byte) error {
+	for len(data) > 0 {
+		chunk := data[:min(len(data), guestFileChunkSize)]
+		out, err := agentCommand(ctx, vm, "guest-file-write", map[string]interface{}{
+			"handle":  handle,
+			"buf-b64": base64.StdEncoding.EncodeToString(chunk),
+		})
+		if err != nil {
+			return err
+		}
+		var written GuestFileWriteResponse
+		if err := json.Unmarshal([]byte(out), &written); err != nil {
+			return fmt.Errorf("failed to parse guest-file-write response: %w", err)
+		}
+		if written.Return.Count <= 0 {
+			return fmt.Errorf("guest wrote %d of %d bytes", written.Return.Count, len(chunk))
+		}
+		data = data[written.Return.Count:]
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package qemu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// FreezeFilesystems freezes all guest filesystems and returns how many were
+// frozen. Writes inside the guest block until ThawFilesystems is called.
+func FreezeFilesystems(ctx context.Context, vm string) (int, error) {
+	return freezeCommand(ctx, vm, "guest-fsfreeze-freeze")
+}
+
+// ThawFilesystems thaws the guest filesystems and returns how many were thawed.
+func ThawFilesystems(ctx context.Context, vm string) (int, error) {
+	return freezeCommand(ctx, vm, "guest-fsfreeze-thaw")
+}
+
+func freezeCommand(ctx context.Context, vm string, command string) (int, error) {
+	out, err := agentCommand(ctx, vm, command, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var res FreezeCountResponse
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		return 0, fmt.Errorf("failed to parse %s response: %w", command, err)
+	}
+	return res.Return, nil
+}
+
+// GetFreezeStatus reports whether the guest filesystems are frozen.
+func GetFreezeStatus(ctx context.Context, vm string) (FreezeStatus, error) {
+	out, err := agentCommand(ctx, vm, "guest-fsfreeze-status", nil)
+	if err != nil {
+		return "", err
+	}
+
+	var res FreezeStatusResponse
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		return "", fmt.Errorf("failed to parse freeze status: %w", err)
+	}
+	switch res.Return {
+	case FreezeStatusFrozen, FreezeStatusThawed:
+		return res.Return, nil
+	default:
+		return "", fmt.Errorf("unknown freeze status %q", res.Return)
+	}
+}
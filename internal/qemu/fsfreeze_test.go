@@ -0,0 +1,75 @@
+package qemu
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// mockAgent answers guest agent commands with canned responses.
+func mockAgent(t *testing.T, responses map[string]string) {
+	t.Helper()
+	original := execute
+	t.Cleanup(func() { execute = original })
+
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		if command != "virsh" || len(args) < 3 || args[0] != "qemu-agent-command" {
+			t.Fatalf("unexpected command %s %v", command, args)
+		}
+		for name, response := range responses {
+			if strings.Contains(args[2], `"`+name+`"`) {
+				return response, nil
+			}
+		}
+		return "", errors.New("error: Guest agent is not responding")
+	}
+}
+
+func TestGetFreezeStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     FreezeStatus
+		wantErr  bool
+	}{
+		{"frozen", `{"return": "frozen"}`, FreezeStatusFrozen, false},
+		{"thawed", `{"return": "thawed"}`, FreezeStatusThawed, false},
+		{"unknown", `{"return": "freezing"}`, "", true},
+		{"malformed", `not json`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockAgent(t, map[string]string{"guest-fsfreeze-status": tt.response})
+
+			got, err := GetFreezeStatus(context.Background(), "vm1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetFreezeStatus() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetFreezeStatus() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFreezeStatusAgentUnavailable(t *testing.T) {
+	mockAgent(t, nil)
+
+	if _, err := GetFreezeStatus(context.Background(), "vm1"); err == nil {
+		t.Error("expected an error when the agent does not respond")
+	}
+}
+
+func TestFreezeFilesystems(t *testing.T) {
+	mockAgent(t, map[string]string{"guest-fsfreeze-freeze": `{"return": 3}`})
+
+	n, err := FreezeFilesystems(context.Background(), "vm1")
+	if err != nil {
+		t.Fatalf("FreezeFilesystems() error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("FreezeFilesystems() = %d; want 3", n)
+	}
+}
@@ -0,0 +1,140 @@
+package qemu
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fsUsageTimeout bounds the df/PowerShell fallback run inside the guest.
+const fsUsageTimeout = 10 * time.Second
+
+const windowsPowerShell = `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`
+
+// windowsDiskQuery prints "<drive> <size> <free>" for every logical disk.
+const windowsDiskQuery = `Get-CimInstance Win32_LogicalDisk | ForEach-Object { "$($_.DeviceID) $($_.Size) $($_.FreeSpace)" }`
+
+// diskUsage is the used and total space of one mountpoint in bytes.
+type diskUsage struct {
+	Used  int64
+	Total int64
+}
+
+// GetFileSystemUsage returns the guest filesystems including used and total
+// bytes. Agents older than qemu-ga 3.0 don't report usage in guest-get-fsinfo,
+// so it is then collected with df, or PowerShell on Windows guests. Usage
+// stays empty if that fails as well.
+func GetFileSystemUsage(ctx context.Context, vm string) ([]FileSystemInfo, error) {
+	filesystems, err := GetFileSystemInfo(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+
+	complete := true
+	for _, fs := range filesystems {
+		if fs.UsedBytes == nil || fs.TotalBytes == nil {
+			complete = false
+			break
+		}
+	}
+	if complete {
+		return filesystems, nil
+	}
+
+	usage, err := guestDiskUsage(ctx, vm)
+	if err != nil {
+		// Usage is best effort, guest-exec may be disabled by the agent policy
+		log.Printf("Failed to collect filesystem usage of %s: %v", vm, err)
+		return filesystems, nil
+	}
+	for i := range filesystems {
+		fs := &filesystems[i]
+		if fs.UsedBytes != nil && fs.TotalBytes != nil {
+			continue
+		}
+		if u, ok := usage[normalizeMountpoint(fs.Mountpoint)]; ok {
+			fs.UsedBytes, fs.TotalBytes = &u.Used, &u.Total
+		}
+	}
+	return filesystems, nil
+}
+
+// guestDiskUsage runs the usage command matching the guest OS.
+func guestDiskUsage(ctx context.Context, vm string) (map[string]diskUsage, error) {
+	osInfo, err := GetOSInfo(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+
+	if osInfo.ID == "mswindows" {
+		result, err := RunGuestCommand(ctx, vm, windowsPowerShell, []string{"-NoProfile", "-NonInteractive", "-Command", windowsDiskQuery}, nil, fsUsageTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if result.ExitCode != 0 {
+			return nil, fmt.Errorf("powershell exited with %d: %s", result.ExitCode, result.Stderr)
+		}
+		return parseWindowsDiskUsage(result.Stdout), nil
+	}
+
+	result, err := RunGuestCommand(ctx, vm, "df", []string{"-P", "-B1"}, nil, fsUsageTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// df exits non-zero when a single mount is unreadable but still prints the rest
+	if result.ExitCode != 0 && result.Stdout == "" {
+		return nil, fmt.Errorf("df exited with %d: %s", result.ExitCode, result.Stderr)
+	}
+	return parseDfUsage(result.Stdout), nil
+}
+
+// parseDfUsage parses `df -P -B1` output keyed by mountpoint.
+func parseDfUsage(out string) map[string]diskUsage {
+	usage := make(map[string]diskUsage)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[0] == "Filesystem" {
+			continue
+		}
+		total, err1 := strconv.ParseInt(fields[1], 10, 64)
+		used, err2 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// Mountpoints may contain spaces
+		mountpoint := strings.Join(fields[5:], " ")
+		usage[mountpoint] = diskUsage{Used: used, Total: total}
+	}
+	return usage
+}
+
+// parseWindowsDiskUsage parses the windowsDiskQuery output keyed by drive.
+func parseWindowsDiskUsage(out string) map[string]diskUsage {
+	usage := make(map[string]diskUsage)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			// Drives without media have an empty size
+			continue
+		}
+		total, err1 := strconv.ParseInt(fields[1], 10, 64)
+		free, err2 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		usage[normalizeMountpoint(fields[0])] = diskUsage{Used: total - free, Total: total}
+	}
+	return usage
+}
+
+// normalizeMountpoint makes Windows drive names comparable, the agent reports
+// "C:\" while Win32_LogicalDisk uses "C:".
+func normalizeMountpoint(mountpoint string) string {
+	if len(mountpoint) >= 2 && mountpoint[1] == ':' {
+		return strings.ToUpper(mountpoint[:2])
+	}
+	return mountpoint
+}
@@ -0,0 +1,33 @@
+package qemu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDfUsage(t *testing.T) {
+	out := `Filesystem        1-blocks       Used   Available Capacity Mounted on
+/dev/vda1      20957446144 3221225472 17736220672      16% /
+/dev/vdb1       1063256064   52428800  1010827264       5% /mnt/my data
+tmpfs            209715200          0   209715200       0% /run/user/1000
+`
+	want := map[string]diskUsage{
+		"/":              {Used: 3221225472, Total: 20957446144},
+		"/mnt/my data":   {Used: 52428800, Total: 1063256064},
+		"/run/user/1000": {Used: 0, Total: 209715200},
+	}
+	if got := parseDfUsage(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseDfUsage() = %v; want %v", got, want)
+	}
+}
+
+func TestParseWindowsDiskUsage(t *testing.T) {
+	out := "C: 53684989952 21474836480\r\nD:  \r\nE: 1073741824 1073741824\r\n"
+	want := map[string]diskUsage{
+		"C:": {Used: 32210153472, Total: 53684989952},
+		"E:": {Used: 0, Total: 1073741824},
+	}
+	if got := parseWindowsDiskUsage(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseWindowsDiskUsage() = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+package qemu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// growTimeout bounds each growpart and filesystem grow run in the guest,
+// growing a large filesystem online takes a while.
+const growTimeout = 5 * time.Minute
+
+var (
+	// ErrFilesystemNotFound is returned when the guest has no filesystem at
+	// the requested mountpoint or device.
+	ErrFilesystemNotFound = errors.New("guest filesystem not found")
+
+	// ErrGrowUnsupported is returned for filesystems that can't be grown in
+	// place, e.g. on LVM or of another type than ext2/3/4 and XFS.
+	ErrGrowUnsupported = errors.New("filesystem can't be grown")
+
+	// ErrGrowFailed is returned when a grow command ran but failed.
+	ErrGrowFailed = errors.New("failed to grow guest filesystem")
+)
+
+// blockDeviceName matches guest block device names such as vda1 or
+// nvme0n1p2. Only such names are passed to the grow commands.
+var blockDeviceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// partitionName splits partition names into disk and number: vda1, sdb2 and
+// xvda1, or nvme0n1p1 and mmcblk0p1 for disks whose name ends in a digit.
+var partitionName = regexp.MustCompile(`^(?:(nvme\d+n\d+|mmcblk\d+)p|([a-z]+))(\d+)$`)
+
+// GrowStep is a command run to grow a filesystem.
+type GrowStep struct {
+	Command []string `json:"command"`
+	GuestExecResult
+}
+
+// GrowResult reports what growing a filesystem did and its size before and
+// after, the sizes are missing when the agent doesn't report them.
+type GrowResult struct {
+	Mountpoint     string     `json:"mountpoint"`
+	Device         string     `json:"device"` // e.g. /dev/vda1
+	FilesystemType string     `json:"filesystem_type"`
+	Partition      string     `json:"partition,omitempty"` // Disk and number given to growpart
+	PartitionGrown bool       `json:"partition_grown"`
+	BytesBefore    *int64     `json:"bytes_before,omitempty"`
+	BytesAfter     *int64     `json:"bytes_after,omitempty"`
+	Steps          []GrowStep `json:"steps"`
+}
+
+// growPlan holds the commands growing a filesystem. Growpart is empty for a
+// filesystem spanning the whole disk.
+type growPlan struct {
+	device   string
+	growpart []string
+	grow     []string
+}
+
+// planGrow chooses the commands for fs: growpart for its partition, then
+// resize2fs on the device for ext2/3/4 or xfs_growfs on the mountpoint for
+// XFS. Names and mountpoints come from the agent and are passed as separate
+// arguments without a shell, so they can't inject commands.
+func planGrow(fs FileSystemInfo) (growPlan, error) {
+	if !blockDeviceName.MatchString(fs.Name) {
+		return growPlan{}, fmt.Errorf("%w: unexpected device name %q", ErrGrowUnsupported, fs.Name)
+	}
+	if strings.HasPrefix(fs.Name, "dm-") || strings.HasPrefix(fs.Name, "md") {
+		return growPlan{}, fmt.Errorf("%w: %s is on a device mapper or RAID device, grow it with the guest's LVM or mdadm tools", ErrGrowUnsupported, fs.Mountpoint)
+	}
+	if strings.HasPrefix(fs.Name, "loop") {
+		return growPlan{}, fmt.Errorf("%w: %s is on a loop device", ErrGrowUnsupported, fs.Mountpoint)
+	}
+	if !path.IsAbs(fs.Mountpoint) {
+		return growPlan{}, fmt.Errorf("%w: unexpected mountpoint %q", ErrGrowUnsupported, fs.Mountpoint)
+	}
+
+	plan := growPlan{device: "/dev/" + fs.Name}
+	if m := partitionName.FindStringSubmatch(fs.Name); m != nil {
+		disk := m[1] + m[2]
+		plan.growpart = []string{"growpart", "/dev/" + disk, m[3]}
+	}
+
+	switch fs.FilesystemType {
+	case "ext2", "ext3", "ext4":
+		plan.grow = []string{"resize2fs", plan.device}
+	case "xfs":
+		plan.grow = []string{"xfs_growfs", fs.Mountpoint}
+	default:
+		return growPlan{}, fmt.Errorf("%w: %s is %s, only ext2/3/4 and xfs are supported", ErrGrowUnsupported, fs.Mountpoint, fs.FilesystemType)
+	}
+	return plan, nil
+}
+
+// findFilesystem returns the filesystem mounted at mountpoint, or on device
+// when mountpoint is empty.
+func findFilesystem(filesystems []FileSystemInfo, mountpoint, device string) (FileSystemInfo, bool) {
+	for _, fs := range filesystems {
+		if mountpoint != "" && fs.Mountpoint == mountpoint {
+			return fs, true
+		}
+		if mountpoint == "" && "/dev/"+fs.Name == device {
+			return fs, true
+		}
+	}
+	return FileSystemInfo{}, false
+}
+
+// GrowFilesystem grows the partition and the filesystem mounted at mountpoint,
+// or on device such as /dev/vda1, to the size of its disk after the disk was
+// resized. Linux guests only; growpart comes with cloud-guest-utils or
+// cloud-utils-growpart.
+func GrowFilesystem(ctx context.Context, vm, mountpoint, device string) (*GrowResult, error) {
+	before, err := GetFileSystemUsage(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+	fs, ok := findFilesystem(before, mountpoint, device)
+	if !ok {
+		return nil, fmt.Errorf("%w: nothing mounted at %s%s", ErrFilesystemNotFound, mountpoint, device)
+	}
+	plan, err := planGrow(fs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &GrowResult{
+		Mountpoint:     fs.Mountpoint,
+		Device:         plan.device,
+		FilesystemType: fs.FilesystemType,
+		BytesBefore:    fs.TotalBytes,
+		Steps:          []GrowStep{},
+	}
+	run := func(command []string) (*GuestExecResult, error) {
+		out, err := RunGuestCommand(ctx, vm, command[0], command[1:], nil, growTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run %s: %w", command[0], err)
+		}
+		result.Steps = append(result.Steps, GrowStep{Command: command, GuestExecResult: *out})
+		return out, nil
+	}
+
+	if plan.growpart != nil {
+		result.Partition = strings.Join(plan.growpart[1:], " ")
+		out, err := run(plan.growpart)
+		if err != nil {
+			return result, err
+		}
+		// Exit code 1 with NOCHANGE: the partition already fills the disk
+		nochange := out.ExitCode == 1 && strings.Contains(out.Stdout+out.Stderr, "NOCHANGE")
+		if out.ExitCode != 0 && !nochange {
+			return result, fmt.Errorf("%w: growpart exited with %d", ErrGrowFailed, out.ExitCode)
+		}
+		result.PartitionGrown = out.ExitCode == 0
+	}
+
+	out, err := run(plan.grow)
+	if err != nil {
+		return result, err
+	}
+	if out.ExitCode != 0 {
+		return result, fmt.Errorf("%w: %s exited with %d", ErrGrowFailed, plan.grow[0], out.ExitCode)
+	}
+
+	// The new size is best effort, the grow itself succeeded
+	if after, err := GetFileSystemUsage(ctx, vm); err == nil {
+		if fs, ok := findFilesystem(after, fs.Mountpoint, ""); ok {
+			result.BytesAfter = fs.TotalBytes
+		}
+	}
+	return result, nil
+}
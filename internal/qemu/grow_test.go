@@ -0,0 +1,117 @@
+package qemu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPlanGrow(t *testing.T) {
+	tests := []struct {
+		name         string
+		fs           FileSystemInfo
+		wantGrowpart []string
+		wantGrow     []string
+		wantErr      error
+	}{
+		{"ext4 partition", FileSystemInfo{Name: "vda1", Mountpoint: "/", FilesystemType: "ext4"},
+			[]string{"growpart", "/dev/vda", "1"}, []string{"resize2fs", "/dev/vda1"}, nil},
+		{"xfs partition", FileSystemInfo{Name: "sdb2", Mountpoint: "/srv", FilesystemType: "xfs"},
+			[]string{"growpart", "/dev/sdb", "2"}, []string{"xfs_growfs", "/srv"}, nil},
+		{"nvme partition", FileSystemInfo{Name: "nvme0n1p3", Mountpoint: "/", FilesystemType: "ext4"},
+			[]string{"growpart", "/dev/nvme0n1", "3"}, []string{"resize2fs", "/dev/nvme0n1p3"}, nil},
+		{"whole disk", FileSystemInfo{Name: "vdb", Mountpoint: "/data", FilesystemType: "xfs"},
+			nil, []string{"xfs_growfs", "/data"}, nil},
+		{"lvm", FileSystemInfo{Name: "dm-0", Mountpoint: "/", FilesystemType: "ext4"}, nil, nil, ErrGrowUnsupported},
+		{"btrfs", FileSystemInfo{Name: "vda2", Mountpoint: "/", FilesystemType: "btrfs"}, nil, nil, ErrGrowUnsupported},
+		{"windows", FileSystemInfo{Name: `\\?\Volume{1234}\`, Mountpoint: `C:\`, FilesystemType: "NTFS"}, nil, nil, ErrGrowUnsupported},
+		{"injected name", FileSystemInfo{Name: "vda1;reboot", Mountpoint: "/", FilesystemType: "ext4"}, nil, nil, ErrGrowUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planGrow(tt.fs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("planGrow() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(plan.growpart, tt.wantGrowpart) || !reflect.DeepEqual(plan.grow, tt.wantGrow) {
+				t.Errorf("planGrow() = %v then %v, want %v then %v", plan.growpart, plan.grow, tt.wantGrowpart, tt.wantGrow)
+			}
+		})
+	}
+}
+
+func TestGrowFilesystem(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	tests := []struct {
+		name        string
+		growpart    int // Exit code
+		growpartOut string
+		wantGrown   bool
+		wantErr     error
+	}{
+		{"grown", 0, "CHANGED: partition=1", true, nil},
+		{"already full", 1, "NOCHANGE: partition 1 is size 41940959. it cannot be grown", false, nil},
+		{"growpart failed", 2, "FAILED: failed to get a partition table", false, ErrGrowFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsinfoCalls := 0
+			var ran []interface{}
+			var exitCode int
+			var stdout string
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				var payload struct {
+					Execute   string                 `json:"execute"`
+					Arguments map[string]interface{} `json:"arguments"`
+				}
+				if err := json.Unmarshal([]byte(args[2]), &payload); err != nil {
+					t.Fatalf("invalid agent command %v: %v", args, err)
+				}
+				switch payload.Execute {
+				case "guest-get-fsinfo":
+					fsinfoCalls++
+					return fmt.Sprintf(`{"return": [{"name": "vda1", "mountpoint": "/", "filesystem-type": "ext4", "used-bytes": 1073741824, "total-bytes": %d}]}`, fsinfoCalls*10<<30), nil
+				case "guest-exec":
+					path := payload.Arguments["path"]
+					ran = append(ran, path)
+					exitCode, stdout = 0, ""
+					if path == "growpart" {
+						exitCode, stdout = tt.growpart, tt.growpartOut
+					}
+					return `{"return": {"pid": 42}}`, nil
+				case "guest-exec-status":
+					out := base64.StdEncoding.EncodeToString([]byte(stdout))
+					return fmt.Sprintf(`{"return": {"exited": true, "exitcode": %d, "out-data": %q}}`, exitCode, out), nil
+				}
+				t.Fatalf("unexpected agent command %s", payload.Execute)
+				return "", nil
+			}
+
+			result, err := GrowFilesystem(context.Background(), "vm1", "/", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GrowFilesystem() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if !reflect.DeepEqual(ran, []interface{}{"growpart"}) {
+					t.Errorf("ran %v, want only growpart", ran)
+				}
+				return
+			}
+			if !reflect.DeepEqual(ran, []interface{}{"growpart", "resize2fs"}) {
+				t.Errorf("ran %v, want growpart and resize2fs", ran)
+			}
+			if result.PartitionGrown != tt.wantGrown {
+				t.Errorf("PartitionGrown = %v, want %v", result.PartitionGrown, tt.wantGrown)
+			}
+			if *result.BytesBefore != 10<<30 || *result.BytesAfter != 20<<30 {
+				t.Errorf("sizes = %d -> %d, want 10 GiB -> 20 GiB", *result.BytesBefore, *result.BytesAfter)
+			}
+		})
+	}
+}
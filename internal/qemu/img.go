@@ -0,0 +1,154 @@
+package qemu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// DiskInfo is the subset of `qemu-img info --output=json` the controller uses.
+type DiskInfo struct {
+	Filename            string `json:"filename"`
+	Format              string `json:"format"`
+	VirtualSize         int64  `json:"virtual-size"`
+	ActualSize          int64  `json:"actual-size"`
+	BackingFilename     string `json:"backing-filename,omitempty"`
+	FullBackingFilename string `json:"full-backing-filename,omitempty"`
+}
+
+// GetDiskInfo reads the image metadata of the disk at path. --force-share
+// allows inspecting images that are attached to a running domain.
+func GetDiskInfo(ctx context.Context, path string) (*DiskInfo, error) {
+	out, err := execute(ctx, "qemu-img", "info", "--output=json", "--force-share", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var info DiskInfo
+	if err := json.Unmarshal([]byte(out), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse disk info: %w", err)
+	}
+	return &info, nil
+}
+
+// ErrCheckNotSupported is returned for image formats qemu-img can't check,
+// such as raw.
+var ErrCheckNotSupported = errors.New("image format does not support checks")
+
+// Exit statuses of `qemu-img check` besides 0 and a failed check (1).
+const (
+	checkCorruptionsFound = 2
+	checkLeaksFound       = 3
+	checkNotSupported     = 63
+)
+
+// DiskCheck is the result of `qemu-img check --output=json`. Corruptions
+// make the image unusable, leaked clusters only waste space.
+type DiskCheck struct {
+	Filename           string `json:"filename"`
+	Format             string `json:"format"`
+	CheckErrors        int    `json:"check-errors"`
+	Corruptions        int    `json:"corruptions,omitempty"`
+	Leaks              int    `json:"leaks,omitempty"`
+	CorruptionsFixed   int    `json:"corruptions-fixed,omitempty"`
+	LeaksFixed         int    `json:"leaks-fixed,omitempty"`
+	ImageEndOffset     int64  `json:"image-end-offset,omitempty"`
+	TotalClusters      int64  `json:"total-clusters,omitempty"`
+	AllocatedClusters  int64  `json:"allocated-clusters,omitempty"`
+	FragmentedClusters int64  `json:"fragmented-clusters,omitempty"`
+	CompressedClusters int64  `json:"compressed-clusters,omitempty"`
+}
+
+// CheckDisk checks the consistency of the image at path, repairing leaks and
+// corruptions when repair is set. The counts then describe the image after
+// the repair. The image must not be in use, repair needs exclusive access.
+func CheckDisk(ctx context.Context, path string, repair bool) (*DiskCheck, error) {
+	args := []string{"check", "--output=json"}
+	if repair {
+		args = append(args, "-r", "all")
+	}
+	out, err := execute(ctx, "qemu-img", append(args, path)...)
+	if err != nil {
+		// Problems found are reported through the exit status
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		switch exitErr.ExitCode() {
+		case checkCorruptionsFound, checkLeaksFound:
+		case checkNotSupported:
+			return nil, fmt.Errorf("%w: %s", ErrCheckNotSupported, path)
+		default:
+			return nil, err
+		}
+	}
+
+	var check DiskCheck
+	if err := json.Unmarshal([]byte(out), &check); err != nil {
+		return nil, fmt.Errorf("failed to parse disk check: %w", err)
+	}
+	return &check, nil
+}
+
+// EncryptDisk converts the image at path in place into a LUKS encrypted
+// qcow2 image unlocked by passphrase. qemu-img reads the passphrase from a
+// temporary file so it never shows up in the process list.
+func EncryptDisk(ctx context.Context, path string, passphrase []byte) error {
+	keyFile, err := os.CreateTemp("", "disk-key-*")
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer os.Remove(keyFile.Name())
+	_, err = keyFile.Write(passphrase)
+	if closeErr := keyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	encrypted := path + ".encrypting"
+	_, err = execute(ctx, "qemu-img", "convert", "-O", "qcow2",
+		"--object", "secret,id=sec0,file="+keyFile.Name(),
+		"-o", "encrypt.format=luks,encrypt.key-secret=sec0",
+		path, encrypted)
+	if err != nil {
+		os.Remove(encrypted)
+		return fmt.Errorf("failed to encrypt disk image: %w", err)
+	}
+	if err := os.Rename(encrypted, path); err != nil {
+		os.Remove(encrypted)
+		return fmt.Errorf("failed to replace disk image: %w", err)
+	}
+	return nil
+}
+
+// ErrSparsifyUnavailable is returned when virt-sparsify isn't installed.
+var ErrSparsifyUnavailable = errors.New("virt-sparsify is not installed on the host, it comes with libguestfs-tools")
+
+// lookPath finds host binaries; swapped out in tests.
+var lookPath = exec.LookPath
+
+// CheckSparsify reports whether virt-sparsify is installed.
+func CheckSparsify() error {
+	if _, err := lookPath("virt-sparsify"); err != nil {
+		return ErrSparsifyUnavailable
+	}
+	return nil
+}
+
+// SparsifyDisk releases the unused space of the image at path in place with
+// virt-sparsify, which also zeroes free space inside the guest filesystems.
+// The image must not be in use.
+func SparsifyDisk(ctx context.Context, path string) error {
+	if err := CheckSparsify(); err != nil {
+		return err
+	}
+	if _, err := execute(ctx, "virt-sparsify", "--in-place", path); err != nil {
+		return fmt.Errorf("failed to sparsify disk image: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package qemu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exitError returns the error of a process that exited with code.
+func exitError(t *testing.T, code int) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	if err == nil {
+		t.Fatal("expected the command to fail")
+	}
+	return fmt.Errorf("command execution failed: , %w", err)
+}
+
+func TestCheckDisk(t *testing.T) {
+	const leaked = `{"image-end-offset": 262144, "total-clusters": 16384, "check-errors": 0, "leaks": 12, "filename": "/data/disk.qcow2", "format": "qcow2"}`
+	const repaired = `{"image-end-offset": 262144, "total-clusters": 16384, "check-errors": 0, "leaks-fixed": 12, "filename": "/data/disk.qcow2", "format": "qcow2"}`
+
+	tests := []struct {
+		name     string
+		repair   bool
+		out      string
+		exitCode int
+		wantArgs []string
+		want     *DiskCheck
+		wantErr  error
+	}{
+		{
+			name:     "leaks found",
+			out:      leaked,
+			exitCode: checkLeaksFound,
+			wantArgs: []string{"check", "--output=json", "/data/disk.qcow2"},
+			want:     &DiskCheck{Filename: "/data/disk.qcow2", Format: "qcow2", Leaks: 12, ImageEndOffset: 262144, TotalClusters: 16384},
+		},
+		{
+			name:     "repaired",
+			repair:   true,
+			out:      repaired,
+			wantArgs: []string{"check", "--output=json", "-r", "all", "/data/disk.qcow2"},
+			want:     &DiskCheck{Filename: "/data/disk.qcow2", Format: "qcow2", LeaksFixed: 12, ImageEndOffset: 262144, TotalClusters: 16384},
+		},
+		{
+			name:     "raw image",
+			exitCode: checkNotSupported,
+			wantArgs: []string{"check", "--output=json", "/data/disk.qcow2"},
+			wantErr:  ErrCheckNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := execute
+			t.Cleanup(func() { execute = original })
+
+			var gotArgs []string
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				gotArgs = args
+				if tt.exitCode != 0 {
+					return tt.out, exitError(t, tt.exitCode)
+				}
+				return tt.out, nil
+			}
+
+			got, err := CheckDisk(context.Background(), "/data/disk.qcow2", tt.repair)
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("qemu-img args = %q, want %q", gotArgs, tt.wantArgs)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CheckDisk() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckDisk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckDisk() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncryptDisk(t *testing.T) {
+	original := execute
+	t.Cleanup(func() { execute = original })
+
+	path := filepath.Join(t.TempDir(), "disk.qcow2")
+	if err := os.WriteFile(path, []byte("plain"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	var keyFile string
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		for _, arg := range args {
+			if strings.Contains(arg, "hunter2") {
+				t.Errorf("passphrase passed on the command line: %q", args)
+			}
+			if file, ok := strings.CutPrefix(arg, "secret,id=sec0,file="); ok {
+				keyFile = file
+			}
+		}
+		key, err := os.ReadFile(keyFile)
+		if err != nil || string(key) != "hunter2-hunter2" {
+			t.Errorf("key file holds %q, %v", key, err)
+		}
+		return "", os.WriteFile(args[len(args)-1], []byte("encrypted"), 0660)
+	}
+
+	if err := EncryptDisk(context.Background(), path, []byte("hunter2-hunter2")); err != nil {
+		t.Fatalf("EncryptDisk() error = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "encrypted" {
+		t.Errorf("image = %q, want the encrypted copy", data)
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Errorf("key file %s was left behind", keyFile)
+	}
+}
+
+func TestSparsifyDisk(t *testing.T) {
+	originalExecute, originalLookPath := execute, lookPath
+	t.Cleanup(func() { execute, lookPath = originalExecute, originalLookPath })
+
+	var got []string
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		got = append([]string{command}, args...)
+		return "", nil
+	}
+
+	lookPath = func(file string) (string, error) { return "", exec.ErrNotFound }
+	if err := SparsifyDisk(context.Background(), "/data/disk.qcow2"); !errors.Is(err, ErrSparsifyUnavailable) {
+		t.Fatalf("SparsifyDisk() without virt-sparsify = %v, want ErrSparsifyUnavailable", err)
+	}
+	if got != nil {
+		t.Errorf("ran %q without virt-sparsify", got)
+	}
+
+	lookPath = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	if err := SparsifyDisk(context.Background(), "/data/disk.qcow2"); err != nil {
+		t.Fatalf("SparsifyDisk() error = %v", err)
+	}
+	if want := []string{"virt-sparsify", "--in-place", "/data/disk.qcow2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
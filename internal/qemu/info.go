@@ -0,0 +1,64 @@
+package qemu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCommandNotFound is returned when the guest agent doesn't know a command
+// or has it disabled by its policy.
+var ErrCommandNotFound = errors.New("guest agent command not available")
+
+// AgentInfo is the version and command set of a guest agent. Legacy agents
+// without guest-info answer pings but report neither, callers then have to
+// try commands and handle ErrCommandNotFound.
+type AgentInfo struct {
+	Version  string          `json:"version,omitempty"`
+	Commands map[string]bool `json:"commands,omitempty"` // Name to whether the command is enabled
+	Legacy   bool            `json:"legacy,omitempty"`
+}
+
+// Supports reports whether the agent has command and it is enabled.
+func (i *AgentInfo) Supports(command string) bool {
+	return i.Commands[command]
+}
+
+// GetAgentInfo returns the agent version and supported commands of vm.
+func GetAgentInfo(ctx context.Context, vm string) (*AgentInfo, error) {
+	out, err := agentCommand(ctx, vm, "guest-info", nil)
+	if errors.Is(err, ErrCommandNotFound) {
+		// Fall back to a ping so an unreachable agent is still an error
+		if err := GuestPing(ctx, vm); err != nil {
+			return nil, err
+		}
+		return &AgentInfo{Legacy: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res AgentInfoResponse
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		return nil, fmt.Errorf("failed to parse agent info: %w", err)
+	}
+	info := &AgentInfo{
+		Version:  res.Return.Version,
+		Commands: make(map[string]bool, len(res.Return.SupportedCommands)),
+	}
+	for _, command := range res.Return.SupportedCommands {
+		info.Commands[command.Name] = command.Enabled
+	}
+	return info, nil
+}
+
+// commandNotFound reports whether a failed agent command was rejected because
+// the agent lacks it, as opposed to the agent not answering.
+func commandNotFound(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "CommandNotFound") ||
+		strings.Contains(message, "has not been found") ||
+		strings.Contains(message, "has been disabled")
+}
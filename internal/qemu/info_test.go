@@ -0,0 +1,75 @@
+package qemu
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetAgentInfo(t *testing.T) {
+	original := execute
+	t.Cleanup(func() { execute = original })
+
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		return `{"return": {"version": "8.2.2", "supported_commands": [
+			{"name": "guest-ping", "enabled": true, "success-response": true},
+			{"name": "guest-exec", "enabled": false, "success-response": true}
+		]}}`, nil
+	}
+	info, err := GetAgentInfo(context.Background(), "vm1")
+	if err != nil {
+		t.Fatalf("GetAgentInfo() error = %v", err)
+	}
+	want := &AgentInfo{Version: "8.2.2", Commands: map[string]bool{"guest-ping": true, "guest-exec": false}}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("GetAgentInfo() = %+v, want %+v", info, want)
+	}
+	if !info.Supports("guest-ping") || info.Supports("guest-exec") || info.Supports("guest-fstrim") {
+		t.Errorf("Supports() mismatch for %+v", info.Commands)
+	}
+}
+
+func TestGetAgentInfoLegacyAgent(t *testing.T) {
+	original := execute
+	t.Cleanup(func() { execute = original })
+
+	var commands []string
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		commands = append(commands, args[2])
+		if strings.Contains(args[2], "guest-info") {
+			return "", errors.New("error: internal error: unable to execute QEMU agent command 'guest-info': The command guest-info has not been found")
+		}
+		return `{"return": {}}`, nil
+	}
+	info, err := GetAgentInfo(context.Background(), "vm1")
+	if err != nil {
+		t.Fatalf("GetAgentInfo() error = %v", err)
+	}
+	if !info.Legacy || info.Commands != nil {
+		t.Errorf("GetAgentInfo() = %+v, want a legacy result", info)
+	}
+	if len(commands) != 2 || !strings.Contains(commands[1], "guest-ping") {
+		t.Errorf("commands = %q, want guest-info then guest-ping", commands)
+	}
+}
+
+func TestAgentCommandNotFound(t *testing.T) {
+	original := execute
+	t.Cleanup(func() { execute = original })
+
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		return "", errors.New("error: internal error: unable to execute QEMU agent command 'guest-exec': The command guest-exec has been disabled for this instance")
+	}
+	if _, err := agentCommand(context.Background(), "vm1", "guest-exec", nil); !errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("agentCommand() error = %v, want ErrCommandNotFound", err)
+	}
+
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		return "", errors.New("error: Guest agent is not responding: QEMU guest agent is not connected")
+	}
+	if _, err := agentCommand(context.Background(), "vm1", "guest-info", nil); errors.Is(err, ErrCommandNotFound) {
+		t.Errorf("agentCommand() error = %v, want an unreachable agent error", err)
+	}
+}
@@ -0,0 +1,165 @@
+package qemu
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processTimeout bounds the ps/tasklist and kill/taskkill runs in the guest.
+const processTimeout = 10 * time.Second
+
+const (
+	windowsTasklist = `C:\Windows\System32\tasklist.exe`
+	windowsTaskkill = `C:\Windows\System32\taskkill.exe`
+)
+
+// ProcessSignals are the signals a guest process can be sent. Windows guests
+// only tell KILL, which forces the process to end, from the others.
+var ProcessSignals = []string{"TERM", "KILL", "HUP", "INT", "QUIT", "USR1", "USR2", "STOP", "CONT"}
+
+// ErrKillFailed is returned when kill or taskkill ran but refused, e.g.
+// because the process doesn't exist.
+var ErrKillFailed = errors.New("failed to signal guest process")
+
+// GuestProcess is a process running inside the guest.
+type GuestProcess struct {
+	PID        int     `json:"pid"`
+	User       string  `json:"user,omitempty"` // Not reported by tasklist
+	CPUPercent float64 `json:"cpu_percent"`    // Linux only
+	RSSBytes   int64   `json:"rss_bytes"`
+	Command    string  `json:"command"`
+}
+
+// isWindowsGuest reports whether vm runs Windows, which has no ps or kill.
+func isWindowsGuest(ctx context.Context, vm string) (bool, error) {
+	osInfo, err := GetOSInfo(ctx, vm)
+	if err != nil {
+		return false, err
+	}
+	return osInfo.ID == "mswindows", nil
+}
+
+// ListGuestProcesses lists the processes running inside the guest with ps,
+// or tasklist on Windows guests.
+func ListGuestProcesses(ctx context.Context, vm string) ([]GuestProcess, error) {
+	windows, err := isWindowsGuest(ctx, vm)
+	if err != nil {
+		return nil, err
+	}
+
+	if windows {
+		result, err := RunGuestCommand(ctx, vm, windowsTasklist, []string{"/FO", "CSV", "/NH"}, nil, processTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if result.ExitCode != 0 {
+			return nil, fmt.Errorf("tasklist exited with %d: %s", result.ExitCode, result.Stderr)
+		}
+		return parseTasklist(result.Stdout)
+	}
+
+	result, err := RunGuestCommand(ctx, vm, "ps", []string{"-eo", "pid=,user=,pcpu=,rss=,args="}, nil, processTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if result.ExitCode != 0 {
+		return nil, fmt.Errorf("ps exited with %d: %s", result.ExitCode, result.Stderr)
+	}
+	return parsePs(result.Stdout), nil
+}
+
+// parsePs parses `ps -eo pid=,user=,pcpu=,rss=,args=` output, RSS is in KiB.
+func parsePs(out string) []GuestProcess {
+	processes := []GuestProcess{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		cpu, _ := strconv.ParseFloat(fields[2], 64)
+		rss, _ := strconv.ParseInt(fields[3], 10, 64)
+		processes = append(processes, GuestProcess{
+			PID:        pid,
+			User:       fields[1],
+			CPUPercent: cpu,
+			RSSBytes:   rss * 1024,
+			// Arguments may contain spaces
+			Command: strings.Join(fields[4:], " "),
+		})
+	}
+	return processes
+}
+
+// parseTasklist parses `tasklist /FO CSV /NH` output: image name, PID,
+// session name, session number and memory usage such as "12,345 K".
+func parseTasklist(out string) ([]GuestProcess, error) {
+	reader := csv.NewReader(strings.NewReader(out))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tasklist output: %w", err)
+	}
+
+	processes := []GuestProcess{}
+	for _, record := range records {
+		if len(record) < 5 {
+			continue
+		}
+		pid, err := strconv.Atoi(record[1])
+		if err != nil {
+			continue
+		}
+		// The thousands separator depends on the guest's locale
+		digits := strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, record[4])
+		kib, _ := strconv.ParseInt(digits, 10, 64)
+		processes = append(processes, GuestProcess{PID: pid, RSSBytes: kib * 1024, Command: record[0]})
+	}
+	return processes, nil
+}
+
+// KillGuestProcess sends signal, one of ProcessSignals, to the guest process
+// pid with kill, or ends it with taskkill on Windows guests. Both are run
+// without a shell, the arguments are never interpreted.
+func KillGuestProcess(ctx context.Context, vm string, pid int, signal string) error {
+	if pid <= 1 {
+		return fmt.Errorf("invalid PID %d", pid)
+	}
+	if !slices.Contains(ProcessSignals, signal) {
+		return fmt.Errorf("unsupported signal %q", signal)
+	}
+	windows, err := isWindowsGuest(ctx, vm)
+	if err != nil {
+		return err
+	}
+
+	path, args := "kill", []string{"-s", signal, strconv.Itoa(pid)}
+	if windows {
+		path, args = windowsTaskkill, []string{"/PID", strconv.Itoa(pid)}
+		if signal == "KILL" {
+			args = append(args, "/F")
+		}
+	}
+	result, err := RunGuestCommand(ctx, vm, path, args, nil, processTimeout)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%w %d: %s", ErrKillFailed, pid, strings.TrimSpace(result.Stderr+result.Stdout))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package qemu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePs(t *testing.T) {
+	out := `    1 root      0.0 11520 /sbin/init splash
+  812 www-data  2.5 40960 nginx: worker process
+ garbage
+`
+	want := []GuestProcess{
+		{PID: 1, User: "root", RSSBytes: 11520 * 1024, Command: "/sbin/init splash"},
+		{PID: 812, User: "www-data", CPUPercent: 2.5, RSSBytes: 40960 * 1024, Command: "nginx: worker process"},
+	}
+	if got := parsePs(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePs() = %+v; want %+v", got, want)
+	}
+}
+
+func TestParseTasklist(t *testing.T) {
+	out := "\"System Idle Process\",\"0\",\"Services\",\"0\",\"8 K\"\r\n\"svchost.exe\",\"1234\",\"Services\",\"0\",\"12,345 K\"\r\n"
+	want := []GuestProcess{
+		{PID: 0, RSSBytes: 8 * 1024, Command: "System Idle Process"},
+		{PID: 1234, RSSBytes: 12345 * 1024, Command: "svchost.exe"},
+	}
+	got, err := parseTasklist(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTasklist() = %+v; want %+v", got, want)
+	}
+}
+
+func TestKillGuestProcess(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	tests := []struct {
+		name     string
+		osID     string
+		pid      int
+		signal   string
+		exitCode int
+		wantExec []interface{}
+		wantErr  error
+	}{
+		{"linux", "ubuntu", 812, "TERM", 0, []interface{}{"kill", []interface{}{"-s", "TERM", "812"}}, nil},
+		{"windows", "mswindows", 1234, "KILL", 0, []interface{}{windowsTaskkill, []interface{}{"/PID", "1234", "/F"}}, nil},
+		{"no such process", "ubuntu", 999, "KILL", 1, []interface{}{"kill", []interface{}{"-s", "KILL", "999"}}, ErrKillFailed},
+		{"init", "ubuntu", 1, "KILL", 0, nil, nil},
+		{"injected signal", "ubuntu", 812, "TERM; reboot", 0, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotExec []interface{}
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				var payload struct {
+					Execute   string                 `json:"execute"`
+					Arguments map[string]interface{} `json:"arguments"`
+				}
+				if err := json.Unmarshal([]byte(args[2]), &payload); err != nil {
+					t.Fatalf("invalid agent command %v: %v", args, err)
+				}
+				switch payload.Execute {
+				case "guest-get-osinfo":
+					return `{"return": {"id": "` + tt.osID + `"}}`, nil
+				case "guest-exec":
+					gotExec = []interface{}{payload.Arguments["path"], payload.Arguments["arg"]}
+					return `{"return": {"pid": 42}}`, nil
+				case "guest-exec-status":
+					exit, _ := json.Marshal(tt.exitCode)
+					return `{"return": {"exited": true, "exitcode": ` + string(exit) + `}}`, nil
+				}
+				t.Fatalf("unexpected agent command %s", payload.Execute)
+				return "", nil
+			}
+
+			err := KillGuestProcess(context.Background(), "vm1", tt.pid, tt.signal)
+			if tt.wantExec == nil {
+				if err == nil || gotExec != nil {
+					t.Fatalf("expected a rejection without running anything, got %v and %v", err, gotExec)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("KillGuestProcess() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(gotExec, tt.wantExec) {
+				t.Errorf("ran %v, want %v", gotExec, tt.wantExec)
+			}
+		})
+	}
+}
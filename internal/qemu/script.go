@@ -0,0 +1,85 @@
+package qemu
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// scriptCleanupTimeout bounds the command deleting a script after it ran.
+const scriptCleanupTimeout = 10 * time.Second
+
+// scriptInterpreter runs uploaded scripts of one language.
+type scriptInterpreter struct {
+	dir     string   // Guest directory scripts are uploaded to
+	ext     string   // Extension the interpreter expects
+	path    string   // Interpreter binary
+	args    []string // Arguments before the script path
+	windows bool
+}
+
+var scriptInterpreters = map[string]scriptInterpreter{
+	"bash":       {dir: "/tmp", ext: ".sh", path: "/bin/bash"},
+	"sh":         {dir: "/tmp", ext: ".sh", path: "/bin/sh"},
+	"powershell": {dir: `C:\Windows\Temp`, ext: ".ps1", path: windowsPowerShell, args: []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File"}, windows: true},
+}
+
+// ScriptInterpreters returns the interpreters RunGuestScript accepts.
+func ScriptInterpreters() []string {
+	names := make([]string, 0, len(scriptInterpreters))
+	for name := range scriptInterpreters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// RunGuestScript uploads script to a temporary file in the guest, runs it
+// with interpreter and waits up to timeout for it to exit. The file is
+// deleted afterwards, even when the script fails or times out.
+func RunGuestScript(ctx context.Context, vm string, interpreter string, script []byte, timeout time.Duration) (*GuestExecResult, error) {
+	in, ok := scriptInterpreters[interpreter]
+	if !ok {
+		return nil, fmt.Errorf("unknown interpreter %q", interpreter)
+	}
+
+	// A random name so concurrent runs don't collide and the path can't be
+	// prepared by a guest user
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate script name: %w", err)
+	}
+	separator := "/"
+	if in.windows {
+		separator = `\`
+	}
+	path := in.dir + separator + "libvirt-controller-" + hex.EncodeToString(suffix) + in.ext
+
+	if err := WriteGuestFile(ctx, vm, path, script); err != nil {
+		return nil, fmt.Errorf("failed to upload script: %w", err)
+	}
+	defer removeGuestScript(context.WithoutCancel(ctx), vm, in, path)
+
+	return RunGuestCommand(ctx, vm, in.path, append(slices.Clone(in.args), path), nil, timeout)
+}
+
+// removeGuestScript deletes an uploaded script. Failures are only logged, the
+// guest clears its temp directory eventually.
+func removeGuestScript(ctx context.Context, vm string, in scriptInterpreter, path string) {
+	command, args := "/bin/rm", []string{"-f", path}
+	if in.windows {
+		// The path is generated, it needs no escaping inside single quotes
+		command, args = windowsPowerShell, []string{"-NoProfile", "-NonInteractive", "-Command", "Remove-Item -LiteralPath '" + path + "' -Force"}
+	}
+	result, err := RunGuestCommand(ctx, vm, command, args, nil, scriptCleanupTimeout)
+	if err == nil && result.ExitCode != 0 {
+		err = fmt.Errorf("exit code %d: %s", result.ExitCode, result.Stderr)
+	}
+	if err != nil {
+		log.Printf("Failed to delete script %s from %s: %v", path, vm, err)
+	}
+}
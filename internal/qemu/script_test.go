@@ -0,0 +1,84 @@
+package qemu
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunGuestScript(t *testing.T) {
+	original := execute
+	defer func() { execute = original }()
+
+	var uploaded strings.Builder
+	var execs [][]string
+	closed := false
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		var payload struct {
+			Execute   string                 `json:"execute"`
+			Arguments map[string]interface{} `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(args[2]), &payload); err != nil {
+			t.Fatalf("invalid agent command %v: %v", args, err)
+		}
+		switch payload.Execute {
+		case "guest-file-open":
+			if payload.Arguments["mode"] != "w" {
+				t.Errorf("file opened with mode %v", payload.Arguments["mode"])
+			}
+			return `{"return": 1000}`, nil
+		case "guest-file-write":
+			data, _ := base64.StdEncoding.DecodeString(payload.Arguments["buf-b64"].(string))
+			uploaded.Write(data)
+			return `{"return": {"count": ` + strconv.Itoa(len(data)) + `, "eof": false}}`, nil
+		case "guest-file-close":
+			closed = true
+			return `{"return": {}}`, nil
+		case "guest-exec":
+			execArgs := []string{payload.Arguments["path"].(string)}
+			for _, a := range payload.Arguments["arg"].([]interface{}) {
+				execArgs = append(execArgs, a.(string))
+			}
+			execs = append(execs, execArgs)
+			return `{"return": {"pid": ` + strconv.Itoa(len(execs)) + `}}`, nil
+		case "guest-exec-status":
+			out := base64.StdEncoding.EncodeToString([]byte("hello\n"))
+			return `{"return": {"exited": true, "exitcode": 3, "out-data": "` + out + `"}}`, nil
+		}
+		t.Fatalf("unexpected agent command %s", payload.Execute)
+		return "", nil
+	}
+
+	script := strings.Repeat("echo hello\n", guestFileChunkSize/5) // Spans several writes
+	result, err := RunGuestScript(context.Background(), "vm1", "bash", []byte(script), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 || result.Stdout != "hello\n" {
+		t.Errorf("result = %+v", result)
+	}
+	if uploaded.String() != script || !closed {
+		t.Errorf("uploaded %d of %d bytes, closed = %t", uploaded.Len(), len(script), closed)
+	}
+
+	if len(execs) != 2 {
+		t.Fatalf("ran %v, want the script and its cleanup", execs)
+	}
+	path := execs[0][1]
+	if execs[0][0] != "/bin/bash" || !strings.HasPrefix(path, "/tmp/libvirt-controller-") || !strings.HasSuffix(path, ".sh") {
+		t.Errorf("script ran as %v", execs[0])
+	}
+	if strings.Join(execs[1], " ") != "/bin/rm -f "+path {
+		t.Errorf("cleanup ran as %v", execs[1])
+	}
+}
+
+func TestRunGuestScriptUnknownInterpreter(t *testing.T) {
+	if _, err := RunGuestScript(context.Background(), "vm1", "perl", []byte("print 1"), time.Second); err == nil {
+		t.Error("expected an error for an unknown interpreter")
+	}
+}
@@ -1,9 +1,29 @@
 package qemu
 
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
 type HostnameResponse struct {
 	Return string `json:"return"`
 }
 
+// AgentCommandInfo is one entry of supported_commands in guest-info.
+type AgentCommandInfo struct {
+	Name            string `json:"name"`
+	Enabled         bool   `json:"enabled"`
+	SuccessResponse bool   `json:"success-response"`
+}
+
+type AgentInfoResponse struct {
+	Return struct {
+		Version           string             `json:"version"`
+		SupportedCommands []AgentCommandInfo `json:"supported_commands"`
+	} `json:"return"`
+}
+
 type OSInfo struct {
 	Name          string `json:"name"`
 	KernelRelease string `json:"kernel-release"`
@@ -23,6 +43,29 @@ type FileSystemInfo struct {
 	FilesystemType    string `json:"filesystem-type"`
 	LogicalBlockSize  int    `json:"logical-block-size"`
 	PhysicalBlockSize int    `json:"physical-block-size"`
+	UsedBytes         *int64 `json:"used-bytes,omitempty"` // Reported by qemu-ga 3.0+, else filled in by GetFileSystemUsage
+	TotalBytes        *int64 `json:"total-bytes,omitempty"`
+
+	Disks []GuestDiskAddress `json:"disk,omitempty"` // Disks the filesystem lives on
+}
+
+// GuestDiskAddress locates a disk in the guest. For virtio disks the PCI
+// controller is the disk itself, for SCSI and SATA disks their controller.
+type GuestDiskAddress struct {
+	PCIController GuestPCIAddress `json:"pci-controller"`
+	BusType       string          `json:"bus-type"` // virtio, scsi, sata, ide, ...
+	Bus           int             `json:"bus"`
+	Target        int             `json:"target"`
+	Unit          int             `json:"unit"`
+	Serial        string          `json:"serial,omitempty"`
+	Dev           string          `json:"dev,omitempty"` // e.g. /dev/vda, reported by qemu-ga 5.2+
+}
+
+type GuestPCIAddress struct {
+	Domain   int `json:"domain"`
+	Bus      int `json:"bus"`
+	Slot     int `json:"slot"`
+	Function int `json:"function"`
 }
 
 type FSInfoResponse struct {
@@ -48,17 +91,133 @@ type GuestTime struct {
 	Nanoseconds int64 `json:"nanoseconds"`
 }
 
+// UnmarshalJSON reads the nanoseconds since the epoch guest-get-time returns.
+func (t *GuestTime) UnmarshalJSON(data []byte) error {
+	var ns int64
+	if err := json.Unmarshal(data, &ns); err == nil {
+		t.Seconds, t.Nanoseconds = ns/int64(time.Second), ns%int64(time.Second)
+		return nil
+	}
+	type plain GuestTime
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// Time returns the guest clock as a time.Time.
+func (t GuestTime) Time() time.Time {
+	return time.Unix(t.Seconds, t.Nanoseconds)
+}
+
+// MarshalJSON adds the guest clock as an RFC 3339 "time" to the numeric
+// fields, which are kept for existing clients.
+func (t GuestTime) MarshalJSON() ([]byte, error) {
+	type plain GuestTime
+	return json.Marshal(struct {
+		plain
+		Time string `json:"time"`
+	}{plain(t), formatTime(t.Time())})
+}
+
 type TimeResponse struct {
 	Return GuestTime `json:"return"`
 }
 
 type GuestUser struct {
-	User      string `json:"user"`
-	Domain    string `json:"domain"`
-	LoginTime int64  `json:"login-time"`
-	UserID    int    `json:"user-id"`
+	User      string  `json:"user"`
+	Domain    string  `json:"domain"`
+	LoginTime float64 `json:"login-time"` // Seconds since the epoch, with a fraction
+	UserID    int     `json:"user-id"`
+}
+
+// MarshalJSON adds the login time as an RFC 3339 "logged_in_at" to the
+// numeric login-time, which is kept for existing clients.
+func (u GuestUser) MarshalJSON() ([]byte, error) {
+	type plain GuestUser
+	sec, frac := math.Modf(u.LoginTime)
+	return json.Marshal(struct {
+		plain
+		LoggedInAt string `json:"logged_in_at"`
+	}{plain(u), formatTime(time.Unix(int64(sec), int64(frac*float64(time.Second))))})
+}
+
+// formatTime formats the times in responses, as RFC 3339 in UTC.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
 }
 
 type UserResponse struct {
 	Return []GuestUser `json:"return"`
 }
+
+type GuestExecPID struct {
+	PID int `json:"pid"`
+}
+
+type GuestExecResponse struct {
+	Return GuestExecPID `json:"return"`
+}
+
+type GuestExecState struct {
+	Exited       bool   `json:"exited"`
+	ExitCode     int    `json:"exitcode"`
+	Signal       int    `json:"signal"`
+	OutData      string `json:"out-data"`
+	ErrData      string `json:"err-data"`
+	OutTruncated bool   `json:"out-truncated"`
+	ErrTruncated bool   `json:"err-truncated"`
+}
+
+type GuestExecStatusResponse struct {
+	Return GuestExecState `json:"return"`
+}
+
+type GuestFileOpenResponse struct {
+	Return int `json:"return"` // Handle of the opened file
+}
+
+type GuestFileWriteResponse struct {
+	Return struct {
+		Count int  `json:"count"`
+		EOF   bool `json:"eof"`
+	} `json:"return"`
+}
+
+// GuestExecResult is the decoded outcome of a finished guest-exec command.
+type GuestExecResult struct {
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+type FstrimPath struct {
+	Path    string `json:"path"`
+	Trimmed *int64 `json:"trimmed,omitempty"` // Not reported by every guest OS
+	Minimum *int64 `json:"minimum,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type FstrimResult struct {
+	Paths []FstrimPath `json:"paths"`
+}
+
+type FstrimResponse struct {
+	Return FstrimResult `json:"return"`
+}
+
+// FreezeStatus is the state of the guest filesystems reported by
+// guest-fsfreeze-status.
+type FreezeStatus string
+
+const (
+	FreezeStatusFrozen FreezeStatus = "frozen"
+	FreezeStatusThawed FreezeStatus = "thawed"
+)
+
+type FreezeStatusResponse struct {
+	Return FreezeStatus `json:"return"`
+}
+
+// FreezeCountResponse is returned by guest-fsfreeze-freeze and
+// guest-fsfreeze-thaw with the number of affected filesystems.
+type FreezeCountResponse struct {
+	Return int `json:"return"`
+}
@@ -0,0 +1,44 @@
+package qemu
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGuestTimeJSON(t *testing.T) {
+	out, err := json.Marshal(GuestTime{Seconds: 1700000000, Nanoseconds: 123456789})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `{"seconds":1700000000,"nanoseconds":123456789,"time":"2023-11-14T22:13:20.123456789Z"}`
+	if string(out) != want {
+		t.Errorf("Marshal() = %s, want %s", out, want)
+	}
+
+	// Responses of the controller read back like the agent's
+	var back GuestTime
+	if err := json.Unmarshal(out, &back); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if back != (GuestTime{Seconds: 1700000000, Nanoseconds: 123456789}) {
+		t.Errorf("Unmarshal() = %+v", back)
+	}
+}
+
+func TestGuestUserJSON(t *testing.T) {
+	var res UserResponse
+	agent := `{"return": [{"user": "root", "login-time": 1700000000.5}, {"user": "Administrator", "domain": "WIN", "login-time": 1700000100}]}`
+	if err := json.Unmarshal([]byte(agent), &res); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	out, err := json.Marshal(res.Return)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `[{"user":"root","domain":"","login-time":1700000000.5,"user-id":0,"logged_in_at":"2023-11-14T22:13:20.5Z"},` +
+		`{"user":"Administrator","domain":"WIN","login-time":1700000100,"user-id":0,"logged_in_at":"2023-11-14T22:15:00Z"}]`
+	if string(out) != want {
+		t.Errorf("Marshal() = %s, want %s", out, want)
+	}
+}
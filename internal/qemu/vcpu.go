@@ -0,0 +1,60 @@
+package qemu
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vcpuOnlineTimeout bounds the guest command that onlines hotplugged CPUs.
+const vcpuOnlineTimeout = 30 * time.Second
+
+// onlineVCPUsScript onlines every offline CPU and prints the CPUs that are
+// online afterwards. cpu0 usually has no online file and is always online.
+const onlineVCPUsScript = `for f in /sys/devices/system/cpu/cpu[0-9]*/online; do
+  [ "$(cat "$f")" = 0 ] && echo 1 > "$f"
+done
+cat /sys/devices/system/cpu/online`
+
+// OnlineVCPUs onlines the CPUs hotplugged into a Linux guest, which unlike
+// hotplugged memory aren't always onlined by the guest itself, and returns how
+// many CPUs are online inside the guest.
+func OnlineVCPUs(ctx context.Context, vm string) (int, error) {
+	result, err := RunGuestCommand(ctx, vm, "/bin/sh", []string{"-c", onlineVCPUsScript}, nil, vcpuOnlineTimeout)
+	if err != nil {
+		return 0, err
+	}
+	// A CPU that refuses to come online fails its write but the list is still printed
+	if result.Stdout == "" {
+		return 0, fmt.Errorf("onlining CPUs exited with %d: %s", result.ExitCode, result.Stderr)
+	}
+	return parseCPUList(result.Stdout)
+}
+
+// parseCPUList counts the CPUs in a kernel CPU list such as "0-3,6,8-9".
+func parseCPUList(list string) (int, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return 0, nil
+	}
+
+	count := 0
+	for _, part := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CPU list %q", list)
+		}
+		end, err := strconv.Atoi(last)
+		if err != nil || end < start {
+			return 0, fmt.Errorf("invalid CPU list %q", list)
+		}
+		count += end - start + 1
+	}
+	return count, nil
+}
@@ -0,0 +1,30 @@
+package qemu
+
+import "testing"
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    int
+		wantErr bool
+	}{
+		{"0\n", 1, false},
+		{"0-3", 4, false},
+		{"0-3,6,8-9\n", 7, false},
+		{"", 0, false},
+		{"0-", 0, true},
+		{"3-1", 0, true},
+		{"a", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCPUList(tt.list)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCPUList(%q) error = %v, wantErr %t", tt.list, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCPUList(%q) = %d; want %d", tt.list, got, tt.want)
+		}
+	}
+}
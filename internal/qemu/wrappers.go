@@ -1,19 +1,57 @@
 package qemu
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
 
 	"libvirt-controller/internal/cmdutil"
+	"libvirt-controller/internal/config"
 )
 
-func GuestPing(vm string) error {
-	_, err := cmdutil.Execute("virsh", "qemu-agent-command", vm, `{"execute":"guest-ping"}`, "--pretty")
+const defaultAgentCommandTimeout = 10 * time.Second
+
+// execute runs external commands; swapped out in tests.
+var execute = cmdutil.ExecuteContext
+
+// agentCommandTimeout is how long virsh waits for the guest agent to answer.
+// An installed but hung agent otherwise blocks the call indefinitely, 0
+// restores that behaviour.
+func agentCommandTimeout() time.Duration {
+	return config.Seconds("AGENT_COMMAND_TIMEOUT", defaultAgentCommandTimeout)
+}
+
+// agentCommand sends a QMP command to the guest agent of vm through virsh.
+func agentCommand(ctx context.Context, vm string, command string, arguments map[string]interface{}) (string, error) {
+	payload := map[string]interface{}{"execute": command}
+	if arguments != nil {
+		payload["arguments"] = arguments
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode agent command: %w", err)
+	}
+	args := []string{"qemu-agent-command", vm, string(body)}
+	if timeout := agentCommandTimeout(); timeout > 0 {
+		args = append(args, "--timeout", strconv.Itoa(int(timeout/time.Second)))
+	}
+	args = append(args, "--pretty")
+	out, err := execute(ctx, "virsh", cmdutil.VirshArgs(ctx, args...)...)
+	if err != nil && commandNotFound(err) {
+		return out, fmt.Errorf("%w: %s: %v", ErrCommandNotFound, command, err)
+	}
+	return out, err
+}
+
+func GuestPing(ctx context.Context, vm string) error {
+	_, err := agentCommand(ctx, vm, "guest-ping", nil)
 	return err
 }
 
-func GetHostName(vm string) (string, error) {
-	out, err := cmdutil.Execute("virsh", "qemu-agent-command", vm, `{"execute":"guest-get-host-name"}`, "--pretty")
+func GetHostName(ctx context.Context, vm string) (string, error) {
+	out, err := agentCommand(ctx, vm, "guest-get-host-name", nil)
 	if err != nil {
 		return "", err
 	}
@@ -25,8 +63,8 @@ func GetHostName(vm string) (string, error) {
 	return res.Return, nil
 }
 
-func GetOSInfo(vm string) (*OSInfo, error) {
-	out, err := cmdutil.Execute("virsh", "qemu-agent-command", vm, `{"execute":"guest-get-osinfo"}`, "--pretty")
+func GetOSInfo(ctx context.Context, vm string) (*OSInfo, error) {
+	out, err := agentCommand(ctx, vm, "guest-get-osinfo", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -38,8 +76,8 @@ func GetOSInfo(vm string) (*OSInfo, error) {
 	return &res.Return, nil
 }
 
-func GetFileSystemInfo(vm string) ([]FileSystemInfo, error) {
-	out, err := cmdutil.Execute("virsh", "qemu-agent-command", vm, `{"execute":"guest-get-fsinfo"}`, "--pretty")
+func GetFileSystemInfo(ctx context.Context, vm string) ([]FileSystemInfo, error) {
+	out, err := agentCommand(ctx, vm, "guest-get-fsinfo", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -51,8 +89,8 @@ func GetFileSystemInfo(vm string) ([]FileSystemInfo, error) {
 	return res.Return, nil
 }
 
-func GetNetworkInterfaces(vm string) ([]NetworkInterface, error) {
-	out, err := cmdutil.Execute("virsh", "qemu-agent-command", vm, `{"execute":"guest-network-get-interfaces"}`, "--pretty")
+func GetNetworkInterfaces(ctx context.Context, vm string) ([]NetworkInterface, error) {
+	out, err := agentCommand(ctx, vm, "guest-network-get-interfaces", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -64,8 +102,8 @@ func GetNetworkInterfaces(vm string) ([]NetworkInterface, error) {
 	return res.Return, nil
 }
 
-func GetGuestTime(vm string) (*GuestTime, error) {
-	out, err := cmdutil.Execute("virsh", "qemu-agent-command", vm, `{"execute":"guest-get-time"}`, "--pretty")
+func GetGuestTime(ctx context.Context, vm string) (*GuestTime, error) {
+	out, err := agentCommand(ctx, vm, "guest-get-time", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -77,8 +115,14 @@ func GetGuestTime(vm string) (*GuestTime, error) {
 	return &res.Return, nil
 }
 
-func GetLoggedInUsers(vm string) ([]GuestUser, error) {
-	out, err := cmdutil.Execute("virsh", "qemu-agent-command", vm, `{"execute":"guest-get-users"}`, "--pretty")
+// SetGuestTime sets the guest clock to t.
+func SetGuestTime(ctx context.Context, vm string, t time.Time) error {
+	_, err := agentCommand(ctx, vm, "guest-set-time", map[string]interface{}{"time": t.UnixNano()})
+	return err
+}
+
+func GetLoggedInUsers(ctx context.Context, vm string) ([]GuestUser, error) {
+	out, err := agentCommand(ctx, vm, "guest-get-users", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -89,3 +133,24 @@ func GetLoggedInUsers(vm string) ([]GuestUser, error) {
 	}
 	return res.Return, nil
 }
+
+// Fstrim discards unused blocks on all mounted guest filesystems so thin
+// provisioned disks can release the space. minimum is the smallest free
+// extent in bytes to discard, 0 lets the guest decide.
+func Fstrim(ctx context.Context, vm string, minimum int64) (*FstrimResult, error) {
+	var arguments map[string]interface{}
+	if minimum > 0 {
+		arguments = map[string]interface{}{"minimum": minimum}
+	}
+
+	out, err := agentCommand(ctx, vm, "guest-fstrim", arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	var res FstrimResponse
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		return nil, fmt.Errorf("failed to parse fstrim result: %w", err)
+	}
+	return &res.Return, nil
+}
@@ -0,0 +1,58 @@
+package qemu
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAgentCommandTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"default", "", []string{"--timeout", "10", "--pretty"}},
+		{"configured", "3", []string{"--timeout", "3", "--pretty"}},
+		{"disabled", "0", []string{"--pretty"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AGENT_COMMAND_TIMEOUT", tt.value)
+			t.Setenv("LIBVIRT_URI", "")
+			original := execute
+			t.Cleanup(func() { execute = original })
+
+			var got []string
+			execute = func(ctx context.Context, command string, args ...string) (string, error) {
+				got = args
+				return `{"return": {}}`, nil
+			}
+			if err := GuestPing(context.Background(), "vm1"); err != nil {
+				t.Fatalf("GuestPing() error = %v", err)
+			}
+
+			want := append([]string{"qemu-agent-command", "vm1", `{"execute":"guest-ping"}`}, tt.want...)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("virsh args = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetGuestTime(t *testing.T) {
+	original := execute
+	t.Cleanup(func() { execute = original })
+	execute = func(ctx context.Context, command string, args ...string) (string, error) {
+		return `{"return": 1700000000123456789}`, nil
+	}
+
+	got, err := GetGuestTime(context.Background(), "vm1")
+	if err != nil {
+		t.Fatalf("GetGuestTime() error = %v", err)
+	}
+	if want := (GuestTime{Seconds: 1700000000, Nanoseconds: 123456789}); *got != want {
+		t.Errorf("GetGuestTime() = %+v, want %+v", *got, want)
+	}
+}
@@ -0,0 +1,307 @@
+// Package quota caps the vCPUs, memory and disk space a tenant can allocate.
+// Tenants authenticate with their own token listed in QUOTA_FILE, requests
+// made with AUTH_TOKEN are not charged to anyone.
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/metadata"
+)
+
+// Limits caps what one tenant may allocate, 0 leaves a resource unlimited.
+type Limits struct {
+	Tenant   string `json:"tenant"`
+	VCPUs    int64  `json:"vcpus,omitempty"`
+	MemoryMB int64  `json:"memory_mb,omitempty"`
+	DiskGB   int64  `json:"disk_gb,omitempty"`
+}
+
+// Allocation is what a single domain or disk holds.
+type Allocation struct {
+	VCPUs    int64 `json:"vcpus,omitempty"`
+	MemoryMB int64 `json:"memory_mb,omitempty"`
+	DiskGB   int64 `json:"disk_gb,omitempty"`
+}
+
+func (a Allocation) add(b Allocation) Allocation {
+	return Allocation{VCPUs: a.VCPUs + b.VCPUs, MemoryMB: a.MemoryMB + b.MemoryMB, DiskGB: a.DiskGB + b.DiskGB}
+}
+
+// DomainAllocation is what a domain definition allocates. Disks are charged
+// separately when they are created.
+func DomainAllocation(domain *domainxml.Domain) (Allocation, error) {
+	memoryMB, err := domain.Memory.MiB()
+	if err != nil {
+		return Allocation{}, err
+	}
+	return Allocation{VCPUs: int64(domain.VCPU), MemoryMB: memoryMB}, nil
+}
+
+// DomainKey and DiskKey name the entries of the index.
+func DomainKey(vmID string) string { return "domain/" + vmID }
+func DiskKey(path string) string   { return "disk/" + filepath.Clean(path) }
+
+// ExceededError is returned when an allocation would take a tenant over its
+// quota.
+type ExceededError struct {
+	Tenant    string
+	Resource  string
+	Limit     int64
+	Requested int64 // Total the tenant would hold
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("quota of tenant %s exceeded: %d %s requested, limit is %d", e.Tenant, e.Requested, e.Resource, e.Limit)
+}
+
+type entry struct {
+	Tenant     string     `json:"tenant"`
+	Allocation Allocation `json:"allocation"`
+}
+
+// Index keeps track of what every tenant has allocated, in memory and in a
+// file once Persist is called.
+type Index struct {
+	mu      sync.Mutex
+	tokens  map[string]string // Token to tenant
+	limits  map[string]Limits // By tenant
+	entries map[string]entry  // By DomainKey or DiskKey
+	path    string
+}
+
+// Default is the index used by the HTTP handlers.
+var Default = NewIndex()
+
+// NewIndex creates an index without tenants.
+func NewIndex() *Index {
+	return &Index{
+		tokens:  make(map[string]string),
+		limits:  make(map[string]Limits),
+		entries: make(map[string]entry),
+	}
+}
+
+// Configure loads the tenants from a JSON file mapping each token to the
+// limits of its tenant.
+func (x *Index) Configure(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read quota file: %w", err)
+	}
+	var tokens map[string]Limits
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return fmt.Errorf("failed to parse quota file %s: %w", path, err)
+	}
+
+	tenants := make(map[string]string, len(tokens))
+	byTenant := make(map[string]Limits, len(tokens))
+	for token, limits := range tokens {
+		if token == "" || limits.Tenant == "" {
+			return errors.New("every quota entry needs a token and a 'tenant'")
+		}
+		if _, ok := byTenant[limits.Tenant]; ok {
+			return fmt.Errorf("tenant %q is listed more than once", limits.Tenant)
+		}
+		tenants[token] = limits.Tenant
+		byTenant[limits.Tenant] = limits
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.tokens, x.limits = tenants, byTenant
+	return nil
+}
+
+// Enabled reports whether any tenants are configured.
+func (x *Index) Enabled() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.tokens) > 0
+}
+
+// Tenant returns the tenant a token belongs to.
+func (x *Index) Tenant(token string) (string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	tenant, ok := x.tokens[token]
+	return tenant, ok
+}
+
+// Lookup returns the owner and allocation of key.
+func (x *Index) Lookup(key string) (string, Allocation, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e, ok := x.entries[key]
+	return e.Tenant, e.Allocation, ok
+}
+
+// Usage sums everything tenant has allocated.
+func (x *Index) Usage(tenant string) Allocation {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.usageLocked(tenant, "")
+}
+
+func (x *Index) usageLocked(tenant string, exclude string) Allocation {
+	var total Allocation
+	for key, e := range x.entries {
+		if e.Tenant == tenant && key != exclude {
+			total = total.add(e.Allocation)
+		}
+	}
+	return total
+}
+
+// Reserve charges alloc for key to tenant, replacing what key held before,
+// unless that takes the tenant over its limits. undo restores the previous
+// entry for when the operation fails afterwards.
+func (x *Index) Reserve(tenant string, key string, alloc Allocation) (undo func(), err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if limits, ok := x.limits[tenant]; ok {
+		total := x.usageLocked(tenant, key).add(alloc)
+		for _, check := range []struct {
+			resource  string
+			limit     int64
+			requested int64
+		}{
+			{"vcpus", limits.VCPUs, total.VCPUs},
+			{"MB of memory", limits.MemoryMB, total.MemoryMB},
+			{"GB of disk", limits.DiskGB, total.DiskGB},
+		} {
+			if check.limit > 0 && check.requested > check.limit {
+				return nil, &ExceededError{Tenant: tenant, Resource: check.resource, Limit: check.limit, Requested: check.requested}
+			}
+		}
+	}
+
+	previous, existed := x.entries[key]
+	x.entries[key] = entry{Tenant: tenant, Allocation: alloc}
+	x.saveLocked()
+	return func() {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		if existed {
+			x.entries[key] = previous
+		} else {
+			delete(x.entries, key)
+		}
+		x.saveLocked()
+	}, nil
+}
+
+// Release forgets key once the domain or disk is deleted.
+func (x *Index) Release(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.entries[key]; ok {
+		delete(x.entries, key)
+		x.saveLocked()
+	}
+}
+
+// Persist loads the index from path and keeps saving it there. Domains are
+// also recounted by LoadDomains, the file is what remembers disks.
+func (x *Index) Persist(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create quota state directory: %w", err)
+	}
+
+	saved := make(map[string]entry)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read quota state: %w", err)
+	default:
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("failed to parse quota state %s: %w", path, err)
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for key, e := range saved {
+		if _, ok := x.entries[key]; !ok {
+			x.entries[key] = e
+		}
+	}
+	x.path = path
+	x.saveLocked()
+	return nil
+}
+
+// LoadDomains counts the domains whose metadata names a tenant, reading the
+// allocation from their definition.
+func (x *Index) LoadDomains() error {
+	dirs, err := filesystem.ListVMDirs()
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for vmID, vmDir := range dirs {
+		m, err := metadata.Load(vmDir)
+		if err != nil || m.Tenant == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(vmDir, "server.xml"))
+		if err != nil {
+			log.Printf("Failed to count quota of %s: %v", vmID, err)
+			continue
+		}
+		domain, err := domainxml.Parse(data)
+		if err != nil {
+			log.Printf("Failed to count quota of %s: %v", vmID, err)
+			continue
+		}
+		alloc, err := DomainAllocation(domain)
+		if err != nil {
+			log.Printf("Failed to count quota of %s: %v", vmID, err)
+			continue
+		}
+		x.entries[DomainKey(vmID)] = entry{Tenant: m.Tenant, Allocation: alloc}
+	}
+	x.saveLocked()
+	return nil
+}
+
+// saveLocked writes the index to its state file, if any. Failures are only
+// logged, the in-memory index stays authoritative. Callers hold x.mu.
+func (x *Index) saveLocked() {
+	if x.path == "" {
+		return
+	}
+	data, err := json.Marshal(x.entries)
+	if err == nil {
+		err = filesystem.SaveFileAtomic(filepath.Dir(x.path), filepath.Base(x.path), data)
+	}
+	if err != nil {
+		log.Printf("failed to save quota state to %s: %v", x.path, err)
+	}
+}
+
+type tenantKey struct{}
+
+// WithTenant stores the tenant a request is made for.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of a request, "" for unrestricted callers.
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
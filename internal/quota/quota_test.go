@@ -0,0 +1,87 @@
+package quota
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestIndex(t *testing.T, config string) *Index {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	x := NewIndex()
+	if err := x.Configure(path); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	return x
+}
+
+func TestReserve(t *testing.T) {
+	x := newTestIndex(t, `{"secret": {"tenant": "acme", "vcpus": 4, "memory_mb": 4096}}`)
+	if tenant, ok := x.Tenant("secret"); !ok || tenant != "acme" {
+		t.Fatalf("Tenant() = %q, %t", tenant, ok)
+	}
+
+	if _, err := x.Reserve("acme", DomainKey("vm-1"), Allocation{VCPUs: 2, MemoryMB: 2048}); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	undo, err := x.Reserve("acme", DomainKey("vm-2"), Allocation{VCPUs: 2, MemoryMB: 1024})
+	if err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	// vm-1 growing to 3 vCPUs would make 5
+	_, err = x.Reserve("acme", DomainKey("vm-1"), Allocation{VCPUs: 3, MemoryMB: 2048})
+	var exceeded *ExceededError
+	if !errors.As(err, &exceeded) || exceeded.Resource != "vcpus" || exceeded.Requested != 5 || exceeded.Limit != 4 {
+		t.Fatalf("Reserve() error = %v, want vcpus exceeded", err)
+	}
+	// Redefining with the same allocation replaces rather than adds
+	if _, err := x.Reserve("acme", DomainKey("vm-1"), Allocation{VCPUs: 2, MemoryMB: 3072}); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	undo()
+	if got := x.Usage("acme"); got != (Allocation{VCPUs: 2, MemoryMB: 3072}) {
+		t.Errorf("Usage() after undo = %+v", got)
+	}
+	x.Release(DomainKey("vm-1"))
+	if got := x.Usage("acme"); got != (Allocation{}) {
+		t.Errorf("Usage() after release = %+v", got)
+	}
+}
+
+func TestPersistKeepsDisks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "quota.json")
+
+	before := NewIndex()
+	if err := before.Persist(path); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	if _, err := before.Reserve("acme", DiskKey("/data/disks/vm-1.img"), Allocation{DiskGB: 20}); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+
+	after := NewIndex()
+	if err := after.Persist(path); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+	if tenant, alloc, ok := after.Lookup(DiskKey("/data/disks/vm-1.img")); !ok || tenant != "acme" || alloc.DiskGB != 20 {
+		t.Errorf("Lookup() = %q, %+v, %t", tenant, alloc, ok)
+	}
+}
+
+func TestConfigureRejectsDuplicateTenants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	config := `{"a": {"tenant": "acme", "vcpus": 4}, "b": {"tenant": "acme", "vcpus": 8}}`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewIndex().Configure(path); err == nil {
+		t.Error("Configure() accepted a tenant listed twice")
+	}
+}
@@ -0,0 +1,92 @@
+package reaper
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/lifecycle"
+	"libvirt-controller/internal/metadata"
+)
+
+// DefaultInterval is how often expired domains are looked for.
+const DefaultInterval = time.Minute
+
+// deleteTimeout bounds the deletion of a single expired domain.
+const deleteTimeout = 2 * time.Minute
+
+// deleteDomain removes an expired domain; swapped out in tests.
+var deleteDomain = lifecycle.Delete
+
+// Run deletes domains whose metadata expiry has passed, scanning
+// definitionsDir every interval until ctx is cancelled.
+func Run(ctx context.Context, definitionsDir string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		Scan(ctx, definitionsDir, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan deletes all domains in definitionsDir that are expired at now and
+// returns their IDs.
+func Scan(ctx context.Context, definitionsDir string, now time.Time) []string {
+	entries, err := os.ReadDir(definitionsDir)
+	if err != nil {
+		log.Printf("reaper: failed to read %s: %v", definitionsDir, err)
+		return nil
+	}
+
+	var reaped []string
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if !entry.IsDir() {
+			continue
+		}
+
+		vmID := entry.Name()
+		vmDir := filepath.Join(definitionsDir, vmID)
+		m, err := metadata.Load(vmDir)
+		if err != nil {
+			log.Printf("reaper: skipping %s: %v", vmID, err)
+			continue
+		}
+		if !m.Expired(now) {
+			continue
+		}
+
+		if err := reap(ctx, vmID, vmDir, m); err != nil {
+			log.Printf("reaper: failed to delete expired domain %s: %v", vmID, err)
+			continue
+		}
+		reaped = append(reaped, vmID)
+	}
+	return reaped
+}
+
+func reap(ctx context.Context, vmID string, vmDir string, m *metadata.Metadata) error {
+	ctx, cancel := context.WithTimeout(ctx, deleteTimeout)
+	defer cancel()
+
+	if err := deleteDomain(ctx, vmID, vmDir); err != nil {
+		return err
+	}
+
+	log.Printf("reaper: deleted domain %s, expired at %s", vmID, m.ExpiresAt.Format(time.RFC3339))
+	events.Notify(vmID, "domain.expired", "Domain deleted after its TTL expired", map[string]interface{}{
+		"expires_at": m.ExpiresAt,
+	})
+	return nil
+}
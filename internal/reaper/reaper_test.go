@@ -0,0 +1,56 @@
+package reaper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/metadata"
+)
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+
+	domains := map[string]*metadata.Metadata{
+		"expired":   {ExpiresAt: &past},
+		"exact":     {ExpiresAt: &now},
+		"alive":     {ExpiresAt: &future},
+		"permanent": {},
+	}
+	for id, m := range domains {
+		vmDir := filepath.Join(dir, id)
+		if err := os.Mkdir(vmDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := metadata.Save(vmDir, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "no-metadata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+	original := deleteDomain
+	defer func() { deleteDomain = original }()
+	deleteDomain = func(ctx context.Context, vmID string, vmDir string) error {
+		deleted = append(deleted, vmID)
+		return nil
+	}
+
+	reaped := Scan(context.Background(), dir, now)
+
+	// os.ReadDir returns entries sorted by name
+	want := []string{"exact", "expired"}
+	if !reflect.DeepEqual(reaped, want) {
+		t.Errorf("Scan() = %v; want %v", reaped, want)
+	}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %v; want %v", deleted, want)
+	}
+}
@@ -0,0 +1,169 @@
+// Package reconcile compares the domains libvirt knows with the definition
+// directories of the storage classes. They drift apart when the controller
+// dies halfway through a define or delete, or when domains are defined with
+// virsh directly.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/libvirt"
+)
+
+// libvirt calls made by the reconciliation; swapped out in tests.
+var (
+	listDomains  = libvirt.ListAllDomains
+	defineDomain = libvirt.DefineDomain
+	dumpXML      = libvirt.DumpXML
+)
+
+// OrphanDir is a definition directory without a libvirt domain.
+type OrphanDir struct {
+	ID            string `json:"id"`
+	Dir           string `json:"dir"`
+	Domain        string `json:"domain"`         // Name in server.xml, the ID when it can't be read
+	HasDefinition bool   `json:"has_definition"` // server.xml is there and parses, so it can be redefined
+}
+
+// Report lists the differences between libvirt and the definitions.
+type Report struct {
+	OrphanDirs       []OrphanDir `json:"orphan_directories"` // Directories libvirt has no domain for
+	UntrackedDomains []string    `json:"untracked_domains"`  // Domains without a directory
+	Repaired         []string    `json:"repaired,omitempty"`
+	Errors           []string    `json:"errors,omitempty"` // Repairs that failed
+}
+
+// Clean reports whether libvirt and the definitions agree.
+func (r *Report) Clean() bool {
+	return len(r.OrphanDirs) == 0 && len(r.UntrackedDomains) == 0
+}
+
+// Check compares the defined libvirt domains with the definition directories.
+func Check(ctx context.Context) (*Report, error) {
+	domains, err := listDomains(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	dirs, err := filesystem.ListVMDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{OrphanDirs: []OrphanDir{}, UntrackedDomains: []string{}}
+	tracked := make(map[string]bool, len(dirs))
+	for vmID, vmDir := range dirs {
+		orphan := OrphanDir{ID: vmID, Dir: vmDir, Domain: vmID}
+		if data, err := os.ReadFile(filepath.Join(vmDir, "server.xml")); err == nil {
+			if domain, err := domainxml.Parse(data); err == nil && domain.Name != "" {
+				orphan.Domain = domain.Name
+				orphan.HasDefinition = true
+			}
+		}
+		tracked[orphan.Domain] = true
+		if !slices.Contains(domains, orphan.Domain) {
+			report.OrphanDirs = append(report.OrphanDirs, orphan)
+		}
+	}
+	for _, domain := range domains {
+		if !tracked[domain] {
+			report.UntrackedDomains = append(report.UntrackedDomains, domain)
+		}
+	}
+
+	sort.Slice(report.OrphanDirs, func(i, j int) bool { return report.OrphanDirs[i].ID < report.OrphanDirs[j].ID })
+	sort.Strings(report.UntrackedDomains)
+	return report, nil
+}
+
+// Repair fixes what report found without deleting anything: orphan
+// directories with a definition are defined in libvirt again and untracked
+// domains get a directory in the default storage class holding their
+// definition. Orphans without a definition are left for an operator.
+func Repair(ctx context.Context, report *Report) {
+	for _, orphan := range report.OrphanDirs {
+		if !orphan.HasDefinition {
+			continue
+		}
+		if _, err := defineDomain(ctx, filepath.Join(orphan.Dir, "server.xml")); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to redefine %s: %v", orphan.ID, err))
+			continue
+		}
+		report.Repaired = append(report.Repaired, orphan.ID)
+	}
+
+	for _, domain := range report.UntrackedDomains {
+		if err := adopt(ctx, domain); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("failed to adopt %s: %v", domain, err))
+			continue
+		}
+		report.Repaired = append(report.Repaired, domain)
+	}
+}
+
+// adopt saves the persistent definition of domain into a new directory.
+func adopt(ctx context.Context, domain string) error {
+	if domain != filepath.Base(domain) || domain == "." || domain == ".." {
+		return fmt.Errorf("%q can't be used as a directory name", domain)
+	}
+	definitionsDir, err := filesystem.StorageClassDir(filesystem.DefaultStorageClass)
+	if err != nil {
+		return err
+	}
+	xml, err := dumpXML(ctx, domain, true)
+	if err != nil {
+		return err
+	}
+
+	vmDir := filepath.Join(definitionsDir, domain)
+	if err := filesystem.CreateDirectory(vmDir, 0755); err != nil {
+		return err
+	}
+	return filesystem.SaveFile(vmDir, "server.xml", []byte(xml))
+}
+
+// Run checks for drift at startup, logging and notifying every difference,
+// and repairs it when asked to.
+func Run(ctx context.Context, repair bool) {
+	report, err := Check(ctx)
+	if err != nil {
+		log.Printf("reconcile: %v", err)
+		return
+	}
+	if report.Clean() {
+		return
+	}
+
+	for _, orphan := range report.OrphanDirs {
+		log.Printf("reconcile: directory %s has no libvirt domain %s", orphan.Dir, orphan.Domain)
+		events.Notify(orphan.ID, "domain.drift_detected", "Definition directory has no libvirt domain", map[string]interface{}{
+			"kind": "orphan_directory",
+			"dir":  orphan.Dir,
+		})
+	}
+	for _, domain := range report.UntrackedDomains {
+		log.Printf("reconcile: libvirt domain %s has no definition directory", domain)
+		events.Notify(domain, "domain.drift_detected", "libvirt domain has no definition directory", map[string]interface{}{
+			"kind": "untracked_domain",
+		})
+	}
+
+	if !repair {
+		return
+	}
+	Repair(ctx, report)
+	for _, id := range report.Repaired {
+		log.Printf("reconcile: repaired %s", id)
+	}
+	for _, e := range report.Errors {
+		log.Printf("reconcile: %s", e)
+	}
+}
@@ -0,0 +1,76 @@
+package reconcile
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckAndRepair(t *testing.T) {
+	originalList, originalDefine, originalDump := listDomains, defineDomain, dumpXML
+	defer func() { listDomains, defineDomain, dumpXML = originalList, originalDefine, originalDump }()
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	definitions := map[string]string{
+		"vm-1":     `<domain type="kvm"><name>vm-1</name></domain>`,
+		"vm-2":     `<domain type="kvm"><name>vm-2</name></domain>`,
+		"renamed":  `<domain type="kvm"><name>web</name></domain>`,
+		"no-xml":   "",
+		"half-del": `<domain type="kvm"><name>half-del</name></domain>`,
+	}
+	for id, xml := range definitions {
+		if err := os.Mkdir(filepath.Join(dir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if xml != "" {
+			if err := os.WriteFile(filepath.Join(dir, id, "server.xml"), []byte(xml), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		if !includeInactive {
+			t.Error("stopped domains have directories as well")
+		}
+		return []string{"vm-1", "vm-2", "web", "manual"}, nil
+	}
+
+	report, err := Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOrphans := []OrphanDir{
+		{ID: "half-del", Dir: filepath.Join(dir, "half-del"), Domain: "half-del", HasDefinition: true},
+		{ID: "no-xml", Dir: filepath.Join(dir, "no-xml"), Domain: "no-xml"},
+	}
+	if !reflect.DeepEqual(report.OrphanDirs, wantOrphans) {
+		t.Errorf("orphans = %+v, want %+v", report.OrphanDirs, wantOrphans)
+	}
+	if !reflect.DeepEqual(report.UntrackedDomains, []string{"manual"}) {
+		t.Errorf("untracked = %v, want [manual]", report.UntrackedDomains)
+	}
+
+	var defined []string
+	defineDomain = func(ctx context.Context, path string) (string, error) {
+		defined = append(defined, path)
+		return "", nil
+	}
+	dumpXML = func(ctx context.Context, domain string, inactive bool) (string, error) {
+		return `<domain type="kvm"><name>` + domain + `</name></domain>`, nil
+	}
+	Repair(context.Background(), report)
+
+	if !reflect.DeepEqual(defined, []string{filepath.Join(dir, "half-del", "server.xml")}) {
+		t.Errorf("defined %v, only the orphan with a definition can be redefined", defined)
+	}
+	if !reflect.DeepEqual(report.Repaired, []string{"half-del", "manual"}) || len(report.Errors) != 0 {
+		t.Errorf("repaired = %v, errors = %v", report.Repaired, report.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "manual", "server.xml")); err != nil {
+		t.Errorf("untracked domain not adopted: %v", err)
+	}
+}
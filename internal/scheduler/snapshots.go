@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+)
+
+// DefaultInterval is how often snapshot schedules are evaluated.
+const DefaultInterval = time.Minute
+
+const (
+	// Automatic snapshots are named auto-<UTC time>, so the schedule state
+	// lives in libvirt and survives restarts of the controller.
+	autoSnapshotPrefix = "auto-"
+	snapshotTimeLayout = "20060102T150405Z"
+
+	// domainTimeout bounds the snapshot work of a single domain per tick.
+	domainTimeout = 10 * time.Minute
+)
+
+// Libvirt calls; swapped out in tests.
+var (
+	listSnapshots  = libvirt.ListSnapshots
+	takeSnapshot   = libvirt.TakeSnapshot
+	deleteSnapshot = libvirt.DeleteSnapshot
+)
+
+// Run evaluates the snapshot schedules stored in the metadata of every
+// domain in definitionsDir each interval until ctx is cancelled.
+func Run(ctx context.Context, definitionsDir string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		Tick(ctx, definitionsDir, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick snapshots every domain whose schedule is due at now and prunes
+// automatic snapshots beyond the keep count.
+func Tick(ctx context.Context, definitionsDir string, now time.Time) {
+	entries, err := os.ReadDir(definitionsDir)
+	if err != nil {
+		log.Printf("scheduler: failed to read %s: %v", definitionsDir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if !entry.IsDir() {
+			continue
+		}
+
+		vmID := entry.Name()
+		m, err := metadata.Load(filepath.Join(definitionsDir, vmID))
+		if err != nil {
+			log.Printf("scheduler: skipping %s: %v", vmID, err)
+			continue
+		}
+		if m.SnapshotSchedule == nil {
+			continue
+		}
+
+		if err := runSchedule(ctx, vmID, m.SnapshotSchedule, now); err != nil {
+			log.Printf("scheduler: snapshot schedule of %s failed: %v", vmID, err)
+		}
+	}
+}
+
+func runSchedule(ctx context.Context, vmID string, schedule *metadata.SnapshotSchedule, now time.Time) error {
+	interval, err := schedule.Interval()
+	if err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, domainTimeout)
+	defer cancel()
+
+	names, err := listSnapshots(ctx, vmID)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	auto := autoSnapshots(names)
+
+	if len(auto) == 0 || !now.Before(auto[len(auto)-1].takenAt.Add(interval)) {
+		name := autoSnapshotPrefix + now.UTC().Format(snapshotTimeLayout)
+		if _, err := takeSnapshot(ctx, vmID, name, false); err != nil {
+			return fmt.Errorf("failed to take snapshot %s: %w", name, err)
+		}
+		log.Printf("scheduler: created snapshot %s of %s", name, vmID)
+		events.Notify(vmID, "domain.snapshot_created", "Scheduled snapshot created", map[string]interface{}{"snapshot": name})
+		auto = append(auto, autoSnapshot{name: name, takenAt: now})
+	}
+
+	// Prune the oldest automatic snapshots, manual ones are never touched
+	for len(auto) > schedule.Keep {
+		oldest := auto[0]
+		if _, err := deleteSnapshot(ctx, vmID, oldest.name); err != nil {
+			return fmt.Errorf("failed to delete snapshot %s: %w", oldest.name, err)
+		}
+		log.Printf("scheduler: pruned snapshot %s of %s", oldest.name, vmID)
+		events.Notify(vmID, "domain.snapshot_deleted", "Scheduled snapshot pruned", map[string]interface{}{"snapshot": oldest.name})
+		auto = auto[1:]
+	}
+	return nil
+}
+
+type autoSnapshot struct {
+	name    string
+	takenAt time.Time
+}
+
+// autoSnapshots picks the automatic snapshots out of names, oldest first.
+func autoSnapshots(names []string) []autoSnapshot {
+	var auto []autoSnapshot
+	for _, name := range names {
+		stamp, ok := strings.CutPrefix(name, autoSnapshotPrefix)
+		if !ok {
+			continue
+		}
+		takenAt, err := time.Parse(snapshotTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		auto = append(auto, autoSnapshot{name: name, takenAt: takenAt})
+	}
+	sort.Slice(auto, func(i, j int) bool { return auto[i].takenAt.Before(auto[j].takenAt) })
+	return auto
+}
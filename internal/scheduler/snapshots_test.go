@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/metadata"
+)
+
+// fakeLibvirt records snapshot calls against an in-memory snapshot list.
+type fakeLibvirt struct {
+	snapshots []string
+	taken     []string
+	deleted   []string
+}
+
+func (f *fakeLibvirt) install(t *testing.T) {
+	t.Helper()
+	origList, origTake, origDelete := listSnapshots, takeSnapshot, deleteSnapshot
+	t.Cleanup(func() { listSnapshots, takeSnapshot, deleteSnapshot = origList, origTake, origDelete })
+
+	listSnapshots = func(ctx context.Context, domain string) ([]string, error) {
+		return append([]string(nil), f.snapshots...), nil
+	}
+	takeSnapshot = func(ctx context.Context, domain, name string, quiesce bool) (string, error) {
+		f.taken = append(f.taken, name)
+		f.snapshots = append(f.snapshots, name)
+		return "", nil
+	}
+	deleteSnapshot = func(ctx context.Context, domain, name string) (string, error) {
+		f.deleted = append(f.deleted, name)
+		return "", nil
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	schedule := &metadata.SnapshotSchedule{Every: "6h", Keep: 2}
+
+	tests := []struct {
+		name        string
+		snapshots   []string
+		wantTaken   []string
+		wantDeleted []string
+	}{
+		{
+			name:      "first snapshot",
+			snapshots: []string{"manual"},
+			wantTaken: []string{"auto-20250101T120000Z"},
+		},
+		{
+			name:      "not due yet",
+			snapshots: []string{"auto-20250101T070000Z"},
+		},
+		{
+			name:      "due exactly",
+			snapshots: []string{"auto-20250101T060000Z"},
+			wantTaken: []string{"auto-20250101T120000Z"},
+		},
+		{
+			name:        "prunes oldest automatic snapshots",
+			snapshots:   []string{"auto-20241231T180000Z", "manual", "auto-20241231T120000Z", "auto-20250101T000000Z"},
+			wantTaken:   []string{"auto-20250101T120000Z"},
+			wantDeleted: []string{"auto-20241231T120000Z", "auto-20241231T180000Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeLibvirt{snapshots: tt.snapshots}
+			f.install(t)
+
+			if err := runSchedule(context.Background(), "vm1", schedule, now); err != nil {
+				t.Fatalf("runSchedule() error = %v", err)
+			}
+			if !reflect.DeepEqual(f.taken, tt.wantTaken) {
+				t.Errorf("taken %v; want %v", f.taken, tt.wantTaken)
+			}
+			if !reflect.DeepEqual(f.deleted, tt.wantDeleted) {
+				t.Errorf("deleted %v; want %v", f.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
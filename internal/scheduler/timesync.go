@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/qemu"
+)
+
+const (
+	// defaultDriftNotify is the corrected drift that emits a webhook.
+	defaultDriftNotify = 5 * time.Second
+
+	// timeSyncTimeout bounds the time sync of a single domain per tick.
+	timeSyncTimeout = 30 * time.Second
+)
+
+// Libvirt and guest agent calls of the time sync; swapped out in tests.
+var (
+	domainState  = libvirt.GetDomainState
+	guestPing    = qemu.GuestPing
+	getGuestTime = qemu.GetGuestTime
+	setGuestTime = qemu.SetGuestTime
+)
+
+// TimeSync sets the guest clocks of the domains with a sync_time_every in
+// their metadata to the host's, so they recover from suspends and host clock
+// changes. When each domain was last synced is only kept in memory, after a
+// restart every domain is synced on the first tick.
+type TimeSync struct {
+	definitionsDir string
+	driftNotify    time.Duration
+	synced         map[string]time.Time
+}
+
+// NewTimeSync creates the time sync for the domains in definitionsDir.
+func NewTimeSync(definitionsDir string) *TimeSync {
+	return &TimeSync{
+		definitionsDir: definitionsDir,
+		driftNotify:    config.Seconds("TIME_SYNC_DRIFT_NOTIFY", defaultDriftNotify),
+		synced:         make(map[string]time.Time),
+	}
+}
+
+// Run syncs the due guest clocks each interval until ctx is cancelled.
+func (s *TimeSync) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.Tick(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick syncs the clock of every running domain whose sync is due at now.
+func (s *TimeSync) Tick(ctx context.Context, now time.Time) {
+	entries, err := os.ReadDir(s.definitionsDir)
+	if err != nil {
+		log.Printf("time sync: failed to read %s: %v", s.definitionsDir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if !entry.IsDir() {
+			continue
+		}
+
+		vmID := entry.Name()
+		m, err := metadata.Load(filepath.Join(s.definitionsDir, vmID))
+		if err != nil {
+			log.Printf("time sync: skipping %s: %v", vmID, err)
+			continue
+		}
+		interval, err := m.TimeSyncInterval()
+		if err != nil {
+			log.Printf("time sync: skipping %s: invalid sync_time_every: %v", vmID, err)
+			continue
+		}
+		if interval == 0 {
+			delete(s.synced, vmID)
+			continue
+		}
+		if last, ok := s.synced[vmID]; ok && now.Before(last.Add(interval)) {
+			continue
+		}
+
+		synced, err := s.sync(ctx, vmID)
+		if err != nil {
+			log.Printf("time sync: failed to sync %s: %v", vmID, err)
+			continue
+		}
+		if synced {
+			s.synced[vmID] = now
+		}
+	}
+}
+
+// sync sets the guest clock of vmID to the host's. It reports false without
+// an error for domains that aren't running or have no responsive agent.
+func (s *TimeSync) sync(ctx context.Context, vmID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeSyncTimeout)
+	defer cancel()
+
+	state, err := domainState(ctx, vmID)
+	if err != nil {
+		return false, err
+	}
+	if state != libvirt.StateRunning {
+		return false, nil
+	}
+	if err := guestPing(ctx, vmID); err != nil {
+		return false, nil
+	}
+
+	guestTime, err := getGuestTime(ctx, vmID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read guest time: %w", err)
+	}
+	hostTime := time.Now()
+	if err := setGuestTime(ctx, vmID, hostTime); err != nil {
+		return false, fmt.Errorf("failed to set guest time: %w", err)
+	}
+
+	drift := guestTime.Time().Sub(hostTime)
+	if drift.Abs() >= s.driftNotify {
+		log.Printf("time sync: corrected a drift of %s on %s", drift.Round(time.Millisecond), vmID)
+		events.Notify(vmID, "domain.time_synced", "Guest clock drift corrected", map[string]interface{}{
+			"drift_seconds": drift.Seconds(),
+		})
+	}
+	return true, nil
+}
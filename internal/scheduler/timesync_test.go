@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/qemu"
+)
+
+func TestTimeSyncTick(t *testing.T) {
+	origState, origPing, origGet, origSet := domainState, guestPing, getGuestTime, setGuestTime
+	t.Cleanup(func() { domainState, guestPing, getGuestTime, setGuestTime = origState, origPing, origGet, origSet })
+
+	dir := t.TempDir()
+	domains := map[string]*metadata.Metadata{
+		"synced":   {SyncTimeEvery: "1h"},
+		"stopped":  {SyncTimeEvery: "1h"},
+		"no-agent": {SyncTimeEvery: "1h"},
+		"off":      {},
+	}
+	for vmID, m := range domains {
+		vmDir := filepath.Join(dir, vmID)
+		if err := filesystem.CreateDirectory(vmDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := metadata.Save(vmDir, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var set []string
+	domainState = func(ctx context.Context, domain string) (libvirt.DomainState, error) {
+		if domain == "stopped" {
+			return libvirt.StateShutOff, nil
+		}
+		return libvirt.StateRunning, nil
+	}
+	guestPing = func(ctx context.Context, vm string) error {
+		if vm == "no-agent" {
+			return errors.New("error: Guest agent is not responding")
+		}
+		return nil
+	}
+	getGuestTime = func(ctx context.Context, vm string) (*qemu.GuestTime, error) {
+		return &qemu.GuestTime{Seconds: time.Now().Add(-time.Minute).Unix()}, nil
+	}
+	setGuestTime = func(ctx context.Context, vm string, t time.Time) error {
+		set = append(set, vm)
+		return nil
+	}
+
+	s := NewTimeSync(dir)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		now  time.Time
+		want []string
+	}{
+		{now, []string{"synced"}},
+		{now.Add(30 * time.Minute), nil},
+		{now.Add(time.Hour), []string{"synced"}},
+	}
+	for i, step := range steps {
+		set = nil
+		s.Tick(context.Background(), step.now)
+		if !reflect.DeepEqual(set, step.want) {
+			t.Errorf("tick %d synced %v; want %v", i, set, step.want)
+		}
+	}
+}
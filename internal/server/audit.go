@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"libvirt-controller/internal/audit"
+	"libvirt-controller/internal/helpers"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxAuditBodyBytes is the largest body whose fields are recorded, larger
+// ones are audited without parameters.
+const maxAuditBodyBytes = 64 << 10
+
+type identityKey struct{}
+
+// withIdentity stores who sent the request for the audit log.
+func withIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// tokenIdentity names a bearer token without revealing it.
+func tokenIdentity(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:4])
+}
+
+func requestIdentity(ctx context.Context) string {
+	if identity, ok := ctx.Value(identityKey{}).(string); ok {
+		return identity
+	}
+	return "anonymous"
+}
+
+// Audit records every mutating request on a domain in the audit log of its
+// VM directory. It must run after DomainMiddleware.
+func Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r, params := auditParams(r)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		vmDir, ok := helpers.GetVMDir(r.Context())
+		if !ok {
+			return
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		result := "success"
+		if status >= http.StatusBadRequest {
+			result = "failure"
+		}
+
+		entry := audit.Entry{
+			Time:      time.Now().UTC(),
+			Action:    r.Method + " " + chi.RouteContext(r.Context()).RoutePattern(),
+			Identity:  requestIdentity(r.Context()),
+			RequestID: middleware.GetReqID(r.Context()),
+			Status:    status,
+			Result:    result,
+			Params:    audit.Redact(params),
+		}
+		// A deleted domain takes its audit log with it
+		if err := audit.Record(vmDir, entry); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to record audit entry for %s: %v", vmDir, err)
+		}
+	})
+}
+
+// auditParams collects the query parameters and, for small JSON bodies, the
+// top level body fields of r. The body is buffered so the handler can still
+// read it.
+func auditParams(r *http.Request) (*http.Request, map[string]interface{}) {
+	params := make(map[string]interface{})
+	for key, values := range r.URL.Query() {
+		if len(values) == 1 {
+			params[key] = values[0]
+		} else {
+			params[key] = values
+		}
+	}
+
+	if r.ContentLength > 0 && r.ContentLength <= maxAuditBodyBytes {
+		data, err := io.ReadAll(r.Body)
+		replay := func() io.ReadCloser {
+			if err != nil {
+				return io.NopCloser(io.MultiReader(bytes.NewReader(data), errorReader{err}))
+			}
+			return io.NopCloser(bytes.NewReader(data))
+		}
+		r.Body = replay()
+		// Routes with their own body limit read the unlimited body instead
+		if _, ok := r.Context().Value(bodyKey{}).(io.ReadCloser); ok {
+			r = r.WithContext(context.WithValue(r.Context(), bodyKey{}, replay()))
+		}
+
+		var body map[string]interface{}
+		if err == nil && json.Unmarshal(data, &body) == nil {
+			for key, value := range body {
+				params[key] = value
+			}
+		}
+	}
+
+	if len(params) == 0 {
+		return r, nil
+	}
+	return r, params
+}
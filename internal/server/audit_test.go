@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/audit"
+	"libvirt-controller/internal/helpers"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAudit(t *testing.T) {
+	t.Setenv("AUTH_TOKEN", "secret-token")
+	dir := t.TempDir()
+
+	r := chi.NewRouter()
+	r.Use(AuthMiddleware)
+	r.Route("/v1/domain/{id}", func(r chi.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := context.WithValue(r.Context(), helpers.VMIDKey, chi.URLParam(r, "id"))
+				next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, helpers.VMDirKey, dir)))
+			})
+		})
+		r.Use(Audit)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		r.Post("/reset-password", func(w http.ResponseWriter, r *http.Request) {
+			// The handler still sees the whole body
+			body, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(body), "hunter2") {
+				t.Errorf("handler got body %q", body)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/v1/domain/vm-1/", nil),
+		httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/reset-password", strings.NewReader(`{"username": "root", "password": "hunter2"}`)),
+	} {
+		req.Header.Set("Authorization", "Bearer secret-token")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries, err := audit.Read(dir, 0)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want only the POST: %+v", len(entries), entries)
+	}
+	e := entries[0]
+	if e.Action != "POST /v1/domain/{id}/reset-password" || e.Status != http.StatusBadGateway || e.Result != "failure" {
+		t.Errorf("got %+v", e)
+	}
+	if e.Identity != tokenIdentity("secret-token") || strings.Contains(e.Identity, "secret-token") {
+		t.Errorf("identity = %q", e.Identity)
+	}
+	if e.Params["username"] != "root" || e.Params["password"] != audit.Redacted {
+		t.Errorf("params = %v, want the password redacted", e.Params)
+	}
+}
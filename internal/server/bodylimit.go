@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"libvirt-controller/internal/config"
+)
+
+const (
+	defaultMaxBodyBytes      = 1 << 20
+	defaultMaxLargeBodyBytes = 16 << 20
+)
+
+// maxBodyBytes limits the body of ordinary API calls.
+func maxBodyBytes() int64 {
+	return int64(config.Int("MAX_BODY_BYTES", defaultMaxBodyBytes))
+}
+
+// maxLargeBodyBytes limits the body of calls carrying domain XML or
+// cloud-init documents.
+func maxLargeBodyBytes() int64 {
+	return int64(config.Int("MAX_LARGE_BODY_BYTES", defaultMaxLargeBodyBytes))
+}
+
+type bodyKey struct{}
+
+// MaxBodySize limits request bodies to n bytes. Larger bodies fail to read
+// with an *http.MaxBytesError, which the handlers answer with 413 Request
+// Entity Too Large: right away on the first read when Content-Length
+// announces them, otherwise once the handler reads past the limit.
+func MaxBodySize(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Routes may still replace the limit, see RouteMaxBodySize
+			body := &limitedBody{w: w, body: r.Body, limit: n, contentLength: r.ContentLength}
+			r.Body = body
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyKey{}, body)))
+		})
+	}
+}
+
+// RouteMaxBodySize replaces the limit set by MaxBodySize for a single route.
+// Without MaxBodySize in front of it, it limits the body itself.
+func RouteMaxBodySize(n int64) func(http.Handler) http.Handler {
+	limit := MaxBodySize(n)
+	return func(next http.Handler) http.Handler {
+		limited := limit(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := r.Context().Value(bodyKey{}).(*limitedBody)
+			if !ok {
+				limited.ServeHTTP(w, r)
+				return
+			}
+			body.limit = n
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody applies the limit of a request body on the first read, once
+// every middleware had the chance to replace it, so a single limit is in
+// effect per request.
+type limitedBody struct {
+	w             http.ResponseWriter
+	body          io.ReadCloser
+	contentLength int64
+	limit         int64
+	limited       io.ReadCloser
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limited == nil {
+		if b.contentLength > b.limit {
+			return 0, &http.MaxBytesError{Limit: b.limit}
+		}
+		b.limited = http.MaxBytesReader(b.w, b.body, b.limit)
+	}
+	return b.limited.Read(p)
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
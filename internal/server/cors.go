@@ -0,0 +1,43 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"libvirt-controller/internal/config"
+
+	"github.com/go-chi/cors"
+)
+
+// corsOrigins reads the origins browsers may call the API from out of
+// CORS_ORIGINS, a comma separated list of exact origins such as
+// "https://panel.example.com". Wildcards and malformed entries are skipped.
+func corsOrigins() []string {
+	var origins []string
+	for _, item := range config.List("CORS_ORIGINS") {
+		u, err := url.Parse(item)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.Host[0] == '*' {
+			log.Printf("invalid entry %q in CORS_ORIGINS, expected an exact origin like https://panel.example.com", item)
+			continue
+		}
+		origins = append(origins, item)
+	}
+	return origins
+}
+
+// CORS lets browsers on the listed origins call the API with credentials.
+// Without origins no CORS headers are sent at all, so browsers only allow
+// same-origin requests.
+func CORS(origins []string) func(http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return cors.Handler(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           300,
+	})
+}
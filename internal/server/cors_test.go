@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCorsOrigins(t *testing.T) {
+	t.Setenv("CORS_ORIGINS", "https://panel.example.com, https://*, http://localhost:3000, panel.example.com")
+	want := []string{"https://panel.example.com", "http://localhost:3000"}
+	if got := corsOrigins(); !reflect.DeepEqual(got, want) {
+		t.Errorf("corsOrigins() = %q, want %q", got, want)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins string
+		origin  string
+		allowed bool
+	}{
+		{"listed origin", "https://panel.example.com", "https://panel.example.com", true},
+		{"other origin", "https://panel.example.com", "https://evil.example.com", false},
+		{"no origins configured", "", "https://panel.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ORIGINS", tt.origins)
+			h := (&Server{}).RegisterRoutes()
+
+			req := httptest.NewRequest(http.MethodOptions, "/v1/inventory", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			got := rec.Header().Get("Access-Control-Allow-Origin")
+			if tt.allowed && got != tt.origin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.origin)
+			}
+			if !tt.allowed && got != "" {
+				t.Errorf("Access-Control-Allow-Origin = %q for a disallowed origin", got)
+			}
+			if credentials := rec.Header().Get("Access-Control-Allow-Credentials"); credentials != "" && !tt.allowed {
+				t.Errorf("credentials allowed for a disallowed origin")
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+// requireRunning guards the guest agent handlers; swapped out in tests.
+var requireRunning = libvirt.RequireRunning
+
+// requireAgent responds with a 409 when vmID isn't running, as its guest
+// agent can't answer. It reports whether the handler can go ahead.
+func requireAgent(w http.ResponseWriter, r *http.Request, vmID string) bool {
+	if err := requireRunning(r.Context(), vmID); err != nil {
+		libvirtErrorResponse(w, "Guest agent unavailable", err)
+		return false
+	}
+	return true
+}
+
+// FstrimHandler asks the guest agent to discard unused filesystem blocks
+func FstrimHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	var minimum int64
+	if value := r.URL.Query().Get("minimum"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			utils.JSONErrorResponse(w, "'minimum' must be a non-negative number of bytes", http.StatusBadRequest)
+			return
+		}
+		minimum = n
+	}
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	result, err := qemu.Fstrim(r.Context(), vmID, minimum)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to trim guest filesystems: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Sum what the guest reported, some guests only report success per path
+	var trimmedBytes int64
+	trimmed, failed := 0, 0
+	for _, p := range result.Paths {
+		if p.Error != "" {
+			failed++
+			continue
+		}
+		trimmed++
+		if p.Trimmed != nil {
+			trimmedBytes += *p.Trimmed
+		}
+	}
+
+	response := map[string]interface{}{
+		"success":             failed == 0,
+		"trimmed_filesystems": trimmed,
+		"failed_filesystems":  failed,
+		"trimmed_bytes":       trimmedBytes,
+		"paths":               result.Paths,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// FsfreezeHandler freezes the guest filesystems ahead of an external backup.
+// It only reports success once the guest confirms the filesystems are frozen.
+func FsfreezeHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	frozen, err := qemu.FreezeFilesystems(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to freeze guest filesystems: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	status, err := qemu.GetFreezeStatus(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to confirm freeze status: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if status != qemu.FreezeStatusFrozen {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Guest reports filesystems as %s after freeze", status), http.StatusConflict)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":            true,
+		"frozen_filesystems": frozen,
+		"status":             status,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// FsthawHandler thaws the guest filesystems after a backup
+func FsthawHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	thawed, err := qemu.ThawFilesystems(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to thaw guest filesystems: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	status, err := qemu.GetFreezeStatus(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to confirm freeze status: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":            status == qemu.FreezeStatusThawed,
+		"thawed_filesystems": thawed,
+		"status":             status,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// FreezeStatusHandler reports whether the guest filesystems are frozen
+func FreezeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	status, err := qemu.GetFreezeStatus(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to get freeze status: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{"id": vmID, "status": status}, http.StatusOK)
+}
+
+// GetAgentInfoHandler reports the guest agent version and which commands it
+// supports, so callers can skip commands an older agent lacks
+func GetAgentInfoHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	info, err := qemu.GetAgentInfo(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to get guest agent info: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{
+		"id":       vmID,
+		"version":  info.Version,
+		"commands": info.Commands,
+		"legacy":   info.Legacy,
+	}, http.StatusOK)
+}
+
+const (
+	defaultAgentPingTimeout     = 2 * time.Second
+	defaultAgentPingConcurrency = 8
+)
+
+// AgentHealth is the result of pinging the guest agent of one domain.
+type AgentHealth struct {
+	Available bool   `json:"available"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// pingAgents pings the guest agent of every domain, running at most
+// concurrency virsh processes at a time.
+func pingAgents(ctx context.Context, domains []string, timeout time.Duration, concurrency int) map[string]AgentHealth {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]AgentHealth, len(domains))
+		slots   = make(chan struct{}, max(concurrency, 1))
+	)
+	for _, domain := range domains {
+		wg.Add(1)
+		go func(domain string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			pingCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := qemu.GuestPing(pingCtx, domain)
+			health := AgentHealth{Available: err == nil, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				health.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[domain] = health
+			mu.Unlock()
+		}(domain)
+	}
+	wg.Wait()
+	return results
+}
+
+// AgentsHealthHandler reports which running domains have a responsive guest agent
+func AgentsHealthHandler(w http.ResponseWriter, r *http.Request) {
+	domains, err := libvirt.ListAllDomains(r.Context(), false)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to list domains: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	timeout := config.Seconds("AGENT_PING_TIMEOUT", defaultAgentPingTimeout)
+	concurrency := config.Int("AGENT_PING_CONCURRENCY", defaultAgentPingConcurrency)
+	agents := pingAgents(r.Context(), domains, timeout, concurrency)
+
+	available := 0
+	for _, health := range agents {
+		if health.Available {
+			available++
+		}
+	}
+
+	response := map[string]interface{}{
+		"agents":    agents,
+		"total":     len(agents),
+		"available": available,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"libvirt-controller/internal/audit"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/server/utils"
+)
+
+// defaultAuditLimit is how many entries GetAuditLogHandler returns unless
+// ?limit= asks for a different number.
+const defaultAuditLimit = 100
+
+// GetAuditLogHandler returns the newest mutating operations on a domain,
+// oldest first
+func GetAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			utils.JSONErrorResponse(w, "Invalid 'limit' parameter, must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := audit.Read(vmDir, limit)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read audit log: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{"id": vmID, "entries": entries}, http.StatusOK)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/jobs"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+// Agent, libvirt and copy calls made by backups; swapped out in tests.
+var (
+	freezeFilesystems      = qemu.FreezeFilesystems
+	thawFilesystems        = qemu.ThawFilesystems
+	createExternalSnapshot = libvirt.CreateExternalSnapshot
+	blockCommit            = libvirt.BlockCommit
+	pushFile               = helpers.PushFile
+)
+
+type BackupDomainRequest struct {
+	Target string   `json:"target"`          // Absolute directory or ssh://[user@]host[:port]/dir
+	Disks  []string `json:"disks,omitempty"` // Target devices to back up, every file backed disk when empty
+}
+
+func (req *BackupDomainRequest) Validate() error {
+	if req.Target == "" {
+		return utils.FieldError("target", "is required")
+	}
+	if _, err := helpers.ParseBackupTarget(req.Target); err != nil {
+		return utils.FieldError("target", "%s", err)
+	}
+	return nil
+}
+
+// backupDisk is a disk being backed up and the overlay taking its writes
+// while it is copied.
+type backupDisk struct {
+	Target  string
+	Source  string
+	Overlay string
+}
+
+// backupPlan is everything a backup job needs, resolved before it starts.
+type backupPlan struct {
+	Target helpers.BackupTarget
+	Stamp  string // Names the snapshot, overlays and backup files
+	Disks  []backupDisk
+	Others []string // Targets of the devices left out of the snapshot
+}
+
+// BackupDomainHandler pushes a consistent copy of the disks of a running
+// domain to a backup target as a background job
+func BackupDomainHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req BackupDomainRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+	target, _ := helpers.ParseBackupTarget(req.Target)
+
+	state, err := domainState(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get domain state", err)
+		return
+	}
+	if state != libvirt.StateRunning {
+		utils.JSONErrorResponse(w, "Backups need a running domain, the images of a stopped domain can be copied directly", http.StatusConflict)
+		return
+	}
+
+	devices, err := listBlockDevices(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list block devices", err)
+		return
+	}
+
+	plan := backupPlan{Target: target, Stamp: time.Now().UTC().Format("20060102T150405Z")}
+	for _, d := range devices {
+		selected := slices.Contains(req.Disks, d.Target) || (len(req.Disks) == 0 && d.Device == "disk")
+		if !selected {
+			plan.Others = append(plan.Others, d.Target)
+			continue
+		}
+		if d.Device != "disk" || d.Type != "file" {
+			utils.JSONRequestErrorResponse(w, utils.FieldError("disks", "%s is not a file backed disk", d.Target))
+			return
+		}
+		plan.Disks = append(plan.Disks, backupDisk{
+			Target:  d.Target,
+			Source:  d.Source,
+			Overlay: d.Source + ".backup-" + plan.Stamp,
+		})
+	}
+	for _, name := range req.Disks {
+		if !slices.ContainsFunc(plan.Disks, func(d backupDisk) bool { return d.Target == name }) {
+			utils.JSONRequestErrorResponse(w, utils.FieldError("disks", "%s is not attached to the domain", name))
+			return
+		}
+	}
+	if len(plan.Disks) == 0 {
+		utils.JSONErrorResponse(w, "The domain has no disks to back up", http.StatusConflict)
+		return
+	}
+
+	job := jobs.Default.Start(r.Context(), "domain.backup", vmID, func(ctx context.Context, report jobs.Reporter) error {
+		return runBackup(ctx, vmID, plan, report)
+	})
+
+	acceptedJobResponse(w, job)
+}
+
+// runBackup freezes the guest just long enough to move the writes of every
+// disk into an overlay, copies the now unchanging images to the target and
+// merges the overlays back. Once the overlays exist they are always committed,
+// and the guest is always thawed, whichever step fails.
+func runBackup(ctx context.Context, vmID string, plan backupPlan, report jobs.Reporter) (err error) {
+	targets := make([]string, len(plan.Disks))
+	overlays := make(map[string]string, len(plan.Disks))
+	for i, d := range plan.Disks {
+		targets[i] = d.Target
+		overlays[d.Target] = d.Overlay
+	}
+	data := map[string]interface{}{
+		"target": plan.Target.String(),
+		"disks":  targets,
+	}
+	events.Notify(vmID, "domain.backup_started", "Domain backup started", data)
+	defer func() {
+		if err != nil {
+			events.Notify(vmID, "domain.backup_failed", fmt.Sprintf("Domain backup failed: %s", err), data)
+		}
+	}()
+
+	// Cleanup has to finish even when the job is cancelled
+	cleanupCtx := context.WithoutCancel(ctx)
+
+	report(0, "Freezing guest filesystems")
+	if _, err := freezeFilesystems(ctx, vmID); err != nil {
+		return fmt.Errorf("failed to freeze guest filesystems: %w", err)
+	}
+	_, snapshotErr := createExternalSnapshot(ctx, vmID, "backup-"+plan.Stamp, overlays, plan.Others)
+	_, thawErr := thawFilesystems(cleanupCtx, vmID)
+	if thawErr != nil {
+		thawErr = fmt.Errorf("failed to thaw guest filesystems, thaw them manually: %w", thawErr)
+	}
+	if snapshotErr != nil {
+		return errors.Join(fmt.Errorf("failed to create overlays: %w", snapshotErr), thawErr)
+	}
+	if thawErr != nil {
+		return errors.Join(thawErr, commitOverlays(cleanupCtx, vmID, plan.Disks))
+	}
+	events.Notify(vmID, "domain.backup_snapshot_created", "Backup overlays created, guest thawed", data)
+
+	files := make([]string, len(plan.Disks))
+	for i, d := range plan.Disks {
+		report(10+70*float64(i)/float64(len(plan.Disks)), fmt.Sprintf("Copying %s", d.Target))
+		files[i] = fmt.Sprintf("%s-%s-%s%s", vmID, d.Target, plan.Stamp, filepath.Ext(d.Source))
+		if err := pushFile(ctx, d.Source, plan.Target, files[i]); err != nil {
+			return errors.Join(err, commitOverlays(cleanupCtx, vmID, plan.Disks))
+		}
+	}
+	data["files"] = files
+	events.Notify(vmID, "domain.backup_copied", "Backup copied to the target", data)
+
+	report(80, "Committing overlays")
+	if err := commitOverlays(cleanupCtx, vmID, plan.Disks); err != nil {
+		return err
+	}
+
+	report(100, fmt.Sprintf("Backed up %d disks to %s", len(plan.Disks), plan.Target))
+	events.Notify(vmID, "domain.backup_completed", "Domain backup completed", data)
+	return nil
+}
+
+// commitOverlays merges the backup overlays into their disks and deletes
+// them. It carries on past failures so one stuck disk doesn't leave the others
+// on their overlays.
+func commitOverlays(ctx context.Context, vmID string, disks []backupDisk) error {
+	var errs []error
+	for _, d := range disks {
+		if _, err := blockCommit(ctx, vmID, d.Target); err != nil {
+			errs = append(errs, fmt.Errorf("failed to commit overlay %s of %s, the domain still writes to it: %w", d.Overlay, d.Target, err))
+			continue
+		}
+		if err := os.Remove(d.Overlay); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to delete overlay %s: %w", d.Overlay, err))
+		}
+	}
+	return errors.Join(errs...)
+}
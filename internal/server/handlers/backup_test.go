@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/helpers"
+)
+
+// fakeBackup records the backup steps in the order they run.
+func fakeBackup(t *testing.T, fail string) *[]string {
+	t.Helper()
+	originalFreeze, originalThaw, originalSnapshot, originalCommit, originalPush := freezeFilesystems, thawFilesystems, createExternalSnapshot, blockCommit, pushFile
+	t.Cleanup(func() {
+		freezeFilesystems, thawFilesystems, createExternalSnapshot, blockCommit, pushFile = originalFreeze, originalThaw, originalSnapshot, originalCommit, originalPush
+	})
+
+	var steps []string
+	step := func(name string) error {
+		steps = append(steps, name)
+		if name == fail {
+			return errors.New(name + " failed")
+		}
+		return nil
+	}
+	freezeFilesystems = func(ctx context.Context, vm string) (int, error) { return 1, step("freeze") }
+	thawFilesystems = func(ctx context.Context, vm string) (int, error) { return 1, step("thaw") }
+	createExternalSnapshot = func(ctx context.Context, domain string, name string, overlays map[string]string, others []string) (string, error) {
+		return "", step("snapshot")
+	}
+	blockCommit = func(ctx context.Context, domain string, target string) (string, error) {
+		return "", step("commit " + target)
+	}
+	pushFile = func(ctx context.Context, src string, target helpers.BackupTarget, name string) error {
+		return step("copy " + name)
+	}
+	return &steps
+}
+
+func TestRunBackup(t *testing.T) {
+	dir := t.TempDir()
+	plan := backupPlan{
+		Target: helpers.BackupTarget{Dir: "/mnt/backups"},
+		Stamp:  "20260101T000000Z",
+		Disks: []backupDisk{
+			{Target: "vda", Source: dir + "/disk.qcow2", Overlay: dir + "/disk.qcow2.backup"},
+			{Target: "vdb", Source: dir + "/data.qcow2", Overlay: dir + "/data.qcow2.backup"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		fail      string
+		wantSteps []string
+		wantErr   string
+	}{
+		{
+			name:      "success",
+			wantSteps: []string{"freeze", "snapshot", "thaw", "copy vm-1-vda-20260101T000000Z.qcow2", "copy vm-1-vdb-20260101T000000Z.qcow2", "commit vda", "commit vdb"},
+		},
+		{
+			name:      "freeze fails",
+			fail:      "freeze",
+			wantSteps: []string{"freeze"},
+			wantErr:   "freeze failed",
+		},
+		{
+			name:      "snapshot fails",
+			fail:      "snapshot",
+			wantSteps: []string{"freeze", "snapshot", "thaw"},
+			wantErr:   "snapshot failed",
+		},
+		{
+			name:      "copy fails",
+			fail:      "copy vm-1-vda-20260101T000000Z.qcow2",
+			wantSteps: []string{"freeze", "snapshot", "thaw", "copy vm-1-vda-20260101T000000Z.qcow2", "commit vda", "commit vdb"},
+			wantErr:   "copy vm-1-vda-20260101T000000Z.qcow2 failed",
+		},
+		{
+			name:      "commit fails",
+			fail:      "commit vda",
+			wantSteps: []string{"freeze", "snapshot", "thaw", "copy vm-1-vda-20260101T000000Z.qcow2", "copy vm-1-vdb-20260101T000000Z.qcow2", "commit vda", "commit vdb"},
+			wantErr:   "still writes to it",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps := fakeBackup(t, tt.fail)
+			err := runBackup(context.Background(), "vm-1", plan, func(float64, string) {})
+
+			if !reflect.DeepEqual(*steps, tt.wantSteps) {
+				t.Errorf("steps = %q, want %q", *steps, tt.wantSteps)
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("runBackup() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("runBackup() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
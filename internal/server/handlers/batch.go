@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/server/utils"
+)
+
+// defaultBatchMaxDomains is how many domains a batch may touch without
+// 'confirm', unless BATCH_MAX_DOMAINS is set.
+const defaultBatchMaxDomains = 10
+
+// batchAction is a lifecycle operation a batch can apply. Domains already in
+// the target state (benign) count as successful and unchanged.
+type batchAction struct {
+	op     func(context.Context, string) (string, error)
+	benign error
+}
+
+// batchActions maps the action names of BatchRequest to their operation. It
+// is built per request so tests can swap the operations.
+func batchActions() map[string]batchAction {
+	return map[string]batchAction{
+		"start":    {startDomain, libvirt.ErrAlreadyRunning},
+		"shutdown": {shutdownDomain, libvirt.ErrAlreadyStopped},
+		"stop":     {destroyDomain, libvirt.ErrAlreadyStopped},
+		"reboot":   {rebootDomain, nil},
+		"reset":    {resetDomain, nil},
+	}
+}
+
+type BatchRequest struct {
+	Action  string `json:"action"`            // start, shutdown, stop, reboot or reset
+	Confirm bool   `json:"confirm,omitempty"` // Required to act on more than BATCH_MAX_DOMAINS domains
+}
+
+func (req *BatchRequest) Validate() error {
+	if _, ok := batchActions()[req.Action]; !ok {
+		return utils.FieldError("action", "must be one of start, shutdown, stop, reboot or reset")
+	}
+	return nil
+}
+
+// BatchResult is the outcome of a batch action on one domain.
+type BatchResult struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// parseLabelSelector parses label selectors such as "env=staging". Every
+// value may hold several comma separated pairs, all of which must match.
+func parseLabelSelector(values []string) (map[string]string, error) {
+	selector := make(map[string]string)
+	for _, value := range values {
+		for _, pair := range strings.Split(value, ",") {
+			key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid label selector %q, expected key=value", pair)
+			}
+			if previous, seen := selector[key]; seen && previous != val {
+				return nil, fmt.Errorf("label %q is selected with conflicting values", key)
+			}
+			selector[key] = val
+		}
+	}
+	if len(selector) == 0 {
+		return nil, fmt.Errorf("'label' is required, e.g. ?label=env=staging")
+	}
+	return selector, nil
+}
+
+// matchingDomains returns the sorted IDs of all domains whose metadata
+// labels contain every pair of selector.
+func matchingDomains(selector map[string]string) ([]string, error) {
+	dirs, err := filesystem.ListVMDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for id, dir := range dirs {
+		m, err := metadata.Load(dir)
+		if err != nil {
+			log.Printf("Skipping %s in batch selection: %v", id, err)
+			continue
+		}
+		matches := true
+		for key, val := range selector {
+			if label, ok := m.Labels[key]; !ok || label != val {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// BatchDomainHandler applies a lifecycle action to every domain whose labels
+// match the ?label= selector
+func BatchDomainHandler(w http.ResponseWriter, r *http.Request) {
+	selector, err := parseLabelSelector(r.URL.Query()["label"])
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Decode and validate the JSON request
+	var req BatchRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if len(filesystem.StorageClasses()) == 0 {
+		utils.JSONErrorResponse(w, "DEFINITIONS_DIR environment variable not set", http.StatusInternalServerError)
+		return
+	}
+	ids, err := matchingDomains(selector)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to select domains: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// A too broad selector must not take down the whole host by accident
+	if limit := config.Int("BATCH_MAX_DOMAINS", defaultBatchMaxDomains); len(ids) > limit && !req.Confirm {
+		response := map[string]interface{}{
+			"error":   fmt.Sprintf("The selector matches %d domains, more than the limit of %d, set 'confirm' to proceed", len(ids), limit),
+			"domains": ids,
+		}
+		utils.JSONResponse(w, response, http.StatusConflict)
+		return
+	}
+
+	action := batchActions()[req.Action]
+	results := make([]BatchResult, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runBatchAction(r.Context(), req.Action, action, id)
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+	response := map[string]interface{}{
+		"success":   succeeded == len(results),
+		"action":    req.Action,
+		"matched":   len(results),
+		"succeeded": succeeded,
+		"results":   results,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+func runBatchAction(ctx context.Context, name string, action batchAction, vmID string) BatchResult {
+	_, err := action.op(ctx, vmID)
+	switch {
+	case err == nil:
+		return BatchResult{ID: vmID, Success: true, Changed: true}
+	case action.benign != nil && errors.Is(err, action.benign):
+		return BatchResult{ID: vmID, Success: true}
+	default:
+		log.Printf("Failed to %s VM %s in batch: %v", name, vmID, err)
+		return BatchResult{ID: vmID, Error: err.Error()}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"single", []string{"env=staging"}, map[string]string{"env": "staging"}, false},
+		{"comma separated", []string{"env=staging,tier=web"}, map[string]string{"env": "staging", "tier": "web"}, false},
+		{"repeated", []string{"env=staging", "tier=web"}, map[string]string{"env": "staging", "tier": "web"}, false},
+		{"missing", nil, nil, true},
+		{"no value", []string{"env"}, nil, true},
+		{"no key", []string{"=staging"}, nil, true},
+		{"conflicting", []string{"env=staging", "env=prod"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabelSelector(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLabelSelector() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabelSelector() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchDomainHandler(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	t.Setenv("BATCH_MAX_DOMAINS", "2")
+	labels := map[string]map[string]string{
+		"vm-1": {"env": "staging"},
+		"vm-2": {"env": "staging", "tier": "web"},
+		"vm-3": {"env": "prod"},
+		"vm-4": {"env": "staging"},
+	}
+	for id, l := range labels {
+		vmDir := filepath.Join(dir, id)
+		if err := os.Mkdir(vmDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := metadata.Save(vmDir, &metadata.Metadata{Labels: l}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	original := destroyDomain
+	defer func() { destroyDomain = original }()
+	var mu sync.Mutex
+	var stopped []string
+	destroyDomain = func(ctx context.Context, domain string) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = append(stopped, domain)
+		if domain == "vm-4" {
+			return "", virshError(libvirt.ErrAlreadyStopped, "error: Requested operation is not valid: domain is not running")
+		}
+		return "", nil
+	}
+
+	batch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/domain/batch?label=env=staging", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		BatchDomainHandler(rec, req)
+		return rec
+	}
+
+	// Three matches exceed the limit of two
+	if rec := batch(`{"action": "stop"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if len(stopped) != 0 {
+		t.Fatalf("domains stopped without confirmation: %v", stopped)
+	}
+
+	rec := batch(`{"action": "stop", "confirm": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var got struct {
+		Success bool          `json:"success"`
+		Results []BatchResult `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response body %q: %v", rec.Body, err)
+	}
+	want := []BatchResult{
+		{ID: "vm-1", Success: true, Changed: true},
+		{ID: "vm-2", Success: true, Changed: true},
+		{ID: "vm-4", Success: true},
+	}
+	if !got.Success || !reflect.DeepEqual(got.Results, want) {
+		t.Errorf("got %+v, want success with %+v", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"libvirt-controller/internal/consolelog"
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/server/utils"
+)
+
+// ScreenshotHandler captures the domain console and returns it as a PNG image
+func ScreenshotHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Capture into a private temp directory, virsh picks the file format itself
+	tmpDir, err := os.MkdirTemp("", "screenshot-")
+	if err != nil {
+		utils.JSONErrorResponse(w, "Failed to create temporary directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imagePath := filepath.Join(tmpDir, "screen")
+	if _, err := libvirt.Screenshot(r.Context(), vmID, imagePath); err != nil {
+		switch {
+		case errors.Is(err, libvirt.ErrNoGraphicalConsole), errors.Is(err, libvirt.ErrDomainNotRunning):
+			utils.JSONErrorResponse(w, err.Error(), http.StatusConflict)
+		default:
+			libvirtErrorResponse(w, "Failed to take screenshot", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		utils.JSONErrorResponse(w, "Failed to read screenshot", http.StatusInternalServerError)
+		return
+	}
+
+	// QEMU usually writes PPM which browsers can't display, so convert it
+	switch {
+	case helpers.IsPNG(data):
+	case helpers.IsPPM(data):
+		img, err := helpers.DecodePPM(bytes.NewReader(data))
+		if err != nil {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to decode screenshot: %s", err), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			utils.JSONErrorResponse(w, "Failed to encode screenshot", http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+	default:
+		utils.JSONErrorResponse(w, "Unsupported screenshot format", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		log.Printf("error writing screenshot for %s: %v", vmID, err)
+	}
+}
+
+// ConsoleLogHandler returns the recent serial console output captured for
+// domains with capture_console set in their metadata
+func ConsoleLogHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	m, err := metadata.Load(vmDir)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to load metadata: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !m.CaptureConsole {
+		utils.JSONErrorResponse(w, "Console capture is not enabled, set capture_console in the metadata", http.StatusConflict)
+		return
+	}
+
+	// Without a file the serial port writes to the output would stay empty
+	definition, err := dumpXML(r.Context(), vmID, true)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to read domain definition", err)
+		return
+	}
+	domain, err := domainxml.Parse([]byte(definition))
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to parse domain definition: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if consolelog.ConsoleFile(domain) == "" {
+		utils.JSONErrorResponse(w, "Domain has no serial console writing to a file, add a file serial or a console_log", http.StatusConflict)
+		return
+	}
+
+	// Nothing is captured until the capturer picked up the metadata
+	output, truncated, _ := consolelog.Default.Read(vmID)
+	response := map[string]interface{}{
+		"id":        vmID,
+		"output":    string(output),
+		"truncated": truncated,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/metadata"
+)
+
+func TestConsoleLogHandler(t *testing.T) {
+	original := dumpXML
+	defer func() { dumpXML = original }()
+
+	tests := []struct {
+		name       string
+		capture    bool
+		devices    string
+		wantStatus int
+		wantError  string
+	}{
+		{"capture off", false, `<serial type='file'><source path='/var/log/vm-1.log'/></serial>`, http.StatusConflict, "not enabled"},
+		{"file serial", true, `<serial type='file'><source path='/var/log/vm-1.log'/></serial>`, http.StatusOK, ""},
+		{"pty serial with log", true, `<serial type='pty'><log file='/var/log/vm-1.log'/></serial>`, http.StatusOK, ""},
+		{"pty serial", true, `<serial type='pty'/>`, http.StatusConflict, "no serial console writing to a file"},
+		{"no serial", true, ``, http.StatusConflict, "no serial console writing to a file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumpXML = func(ctx context.Context, domain string, inactive bool) (string, error) {
+				return "<domain type='kvm'><name>vm-1</name><devices>" + tt.devices + "</devices></domain>", nil
+			}
+			vmDir := t.TempDir()
+			if err := metadata.Save(vmDir, &metadata.Metadata{CaptureConsole: tt.capture}); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/domain/vm-1/console/log", nil)
+			ctx := context.WithValue(req.Context(), helpers.VMIDKey, "vm-1")
+			req = req.WithContext(context.WithValue(ctx, helpers.VMDirKey, vmDir))
+			rec := httptest.NewRecorder()
+			ConsoleLogHandler(rec, req)
+
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantError) {
+				t.Errorf("status = %d, want %d with %q: %s", rec.Code, tt.wantStatus, tt.wantError, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/server/utils"
+)
+
+type DomainInfo struct {
+	Status    string `json:"status"`
+	Autostart bool   `json:"autostart"`
+	helpers.DomainResources
+}
+
+// DomainDescription aggregates everything known about a domain. Sections
+// that could not be collected are null and their error is listed in Errors.
+type DomainDescription struct {
+	ID         string                     `json:"id"`
+	Info       *DomainInfo                `json:"info"`
+	Disks      []libvirt.BlockDevice      `json:"disks"`
+	Interfaces []libvirt.InterfaceAddress `json:"interfaces"`
+	Snapshots  []string                   `json:"snapshots"`
+	Metadata   *metadata.Metadata         `json:"metadata"`
+	Errors     map[string]string          `json:"errors,omitempty"`
+}
+
+// describeDomain collects all sections of a domain description concurrently.
+func describeDomain(ctx context.Context, vmID string, vmDir string) DomainDescription {
+	desc := DomainDescription{ID: vmID}
+
+	// Every section writes only its own field, errors go through the mutex
+	sections := map[string]func() error{
+		"info": func() error {
+			domInfo, err := libvirt.GetDomainInfo(ctx, vmID)
+			if err != nil {
+				return err
+			}
+			status, err := helpers.ParseDomainStatus(domInfo)
+			if err != nil {
+				return err
+			}
+			resources, err := helpers.ParseDomainResources(domInfo)
+			if err != nil {
+				return err
+			}
+			autostart, _ := helpers.ParseDominfoField(domInfo, "Autostart")
+			desc.Info = &DomainInfo{
+				Status:          status,
+				Autostart:       autostart == "enable",
+				DomainResources: *resources,
+			}
+			return nil
+		},
+		"disks": func() error {
+			disks, err := libvirt.ListBlockDevices(ctx, vmID)
+			desc.Disks = disks
+			return err
+		},
+		"interfaces": func() error {
+			addresses, err := libvirt.GetInterfaceAddresses(ctx, vmID, "lease")
+			if err == nil && len(addresses) == 0 {
+				// Bridged guests have no DHCP lease on this host
+				addresses, err = libvirt.GetInterfaceAddresses(ctx, vmID, "arp")
+			}
+			desc.Interfaces = addresses
+			return err
+		},
+		"snapshots": func() error {
+			snapshots, err := libvirt.ListSnapshots(ctx, vmID)
+			desc.Snapshots = snapshots
+			return err
+		},
+		"metadata": func() error {
+			m, err := metadata.Load(vmDir)
+			desc.Metadata = m
+			return err
+		},
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errors = make(map[string]string)
+	)
+	for name, collect := range sections {
+		wg.Add(1)
+		go func(name string, collect func() error) {
+			defer wg.Done()
+			if err := collect(); err != nil {
+				mu.Lock()
+				errors[name] = err.Error()
+				mu.Unlock()
+			}
+		}(name, collect)
+	}
+	wg.Wait()
+
+	// Failed sections are reported as null rather than partial data
+	for name := range errors {
+		switch name {
+		case "info":
+			desc.Info = nil
+		case "disks":
+			desc.Disks = nil
+		case "interfaces":
+			desc.Interfaces = nil
+		case "snapshots":
+			desc.Snapshots = nil
+		case "metadata":
+			desc.Metadata = nil
+		}
+	}
+	if len(errors) > 0 {
+		desc.Errors = errors
+	}
+	return desc
+}
+
+// DescribeDomainHandler returns an aggregated view of a domain in one call
+func DescribeDomainHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	utils.JSONResponse(w, describeDomain(r.Context(), vmID, vmDir), http.StatusOK)
+}
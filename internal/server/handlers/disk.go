@@ -1,47 +1,147 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/domainxml"
 	"libvirt-controller/internal/filesystem"
 	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/quota"
 	"libvirt-controller/internal/server/utils"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// defaultMaxDiskSizeGB bounds disk sizes unless MAX_DISK_SIZE_GB is set.
+const defaultMaxDiskSizeGB = 4096
+
+// validateDiskSize checks a requested disk size in GB against the limits.
+func validateDiskSize(size int) error {
+	if size <= 0 {
+		return utils.FieldError("size", "must be > 0")
+	}
+	if maxSize := config.Int("MAX_DISK_SIZE_GB", defaultMaxDiskSizeGB); size > maxSize {
+		return utils.FieldError("size", "must not exceed %d GB", maxSize)
+	}
+	return nil
+}
+
 type CreateDiskRequest struct {
-	Name     string `json:"name"`
-	Size     int    `json:"size"`
-	Path     string `json:"path"`
-	ImageURL string `json:"image_url,omitempty"`
+	Name     string        `json:"name"`
+	Size     int           `json:"size"`
+	Path     string        `json:"path"`
+	ImageURL string        `json:"image_url,omitempty"` // http(s):// or s3://bucket/key
+	Auth     *DownloadAuth `json:"auth,omitempty"`      // Credentials for a private image source
+	Secret   string        `json:"secret,omitempty"`    // UUID of a secret from POST /v1/secret, LUKS encrypts the disk
+}
+
+// DownloadAuth authenticates the image download. It is never logged and
+// bypasses the image cache.
+type DownloadAuth struct {
+	BearerToken string            `json:"bearer_token,omitempty"`
+	Username    string            `json:"username,omitempty"` // Basic auth
+	Password    string            `json:"password,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"` // Extra request headers, e.g. an API key
+}
+
+// headerNamePattern matches valid HTTP header names.
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+func (a *DownloadAuth) Validate() error {
+	if a.BearerToken != "" && (a.Username != "" || a.Password != "") {
+		return utils.FieldError("auth", "must use either bearer_token or username and password")
+	}
+	if a.Password != "" && a.Username == "" {
+		return utils.FieldError("auth.username", "is required with a password")
+	}
+	for name, value := range a.Headers {
+		if !headerNamePattern.MatchString(name) {
+			return utils.FieldError("auth.headers", "%q is not a valid header name", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return utils.FieldError("auth.headers", "value of %q must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
+// Header returns the request headers carrying the credentials.
+func (a *DownloadAuth) Header() http.Header {
+	header := make(http.Header)
+	for name, value := range a.Headers {
+		header.Set(name, value)
+	}
+	switch {
+	case a.BearerToken != "":
+		header.Set("Authorization", "Bearer "+a.BearerToken)
+	case a.Username != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(a.Username+":"+a.Password)))
+	}
+	return header
+}
+
+func (req *CreateDiskRequest) Validate() error {
+	if req.Name == "" {
+		return utils.FieldError("name", "is required")
+	}
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	if req.ImageURL == "" {
+		return utils.FieldError("image_url", "is required")
+	}
+	if req.Auth != nil {
+		if err := req.Auth.Validate(); err != nil {
+			return err
+		}
+	}
+	if req.Secret != "" && !domainxml.IsUUID(req.Secret) {
+		return utils.FieldError("secret", "must be a UUID")
+	}
+	return validateDiskSize(req.Size)
 }
 
 // CreateDiskHandler handles creating a disk for a VM
 func CreateDiskHandler(w http.ResponseWriter, r *http.Request) {
-	// Read raw request body
-	rawBody, err := io.ReadAll(r.Body)
-	if err != nil {
-		utils.JSONErrorResponse(w, "Failed to read request body", http.StatusInternalServerError)
+	// Decode and validate the JSON request
+	var req CreateDiskRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
-
-	// Ensure body is not empty
-	if len(rawBody) == 0 {
-		utils.JSONErrorResponse(w, "Empty request body", http.StatusBadRequest)
+	imagePath := filepath.Join(req.Path, req.Name)
+	if !checkDiskAccess(w, r.Context(), "path", imagePath) {
 		return
 	}
 
-	// Decode JSON request from rawBody
-	var req CreateDiskRequest
-	if err := json.Unmarshal(rawBody, &req); err != nil {
-		utils.JSONErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
-		log.Println("JSON Unmarshal error:", err) // Print error for debugging
+	// Read the passphrase before downloading anything
+	var passphrase []byte
+	if req.Secret != "" {
+		var err error
+		if passphrase, err = secretValue(r.Context(), req.Secret); err != nil {
+			libvirtErrorResponse(w, "Failed to read secret", err)
+			return
+		}
+	}
+
+	// The image is downloaded into the cache first, when one is configured
+	dirs := []string{req.Path}
+	if cacheDir := os.Getenv("CACHE_DIR"); cacheDir != "" {
+		dirs = append(dirs, cacheDir)
+	}
+	if !checkStorage(w, "DISK_CREATE_MIN_FREE_MB", defaultDiskCreateMinFreeMB, dirs...) {
 		return
 	}
 
@@ -54,64 +154,140 @@ func CreateDiskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Process disk image
-	imagePath := filepath.Join(req.Path, req.Name)
+	undoQuota, ok := reserveQuota(w, quota.TenantFrom(r.Context()), quota.DiskKey(imagePath), quota.Allocation{DiskGB: int64(req.Size)})
+	if !ok {
+		return
+	}
 
-	if err := filesystem.DownloadCachedFile(req.ImageURL, imagePath, 0660); err != nil {
-		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to download image from URL %s: %v", req.ImageURL, err), http.StatusInternalServerError)
+	var header http.Header
+	if req.Auth != nil {
+		header = req.Auth.Header()
+	}
+	if err := filesystem.DownloadCachedFile(r.Context(), req.ImageURL, imagePath, 0660, header); err != nil {
+		undoQuota()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to download image from URL %s: %v", filesystem.RedactURL(req.ImageURL), err), http.StatusInternalServerError)
 		return
 	}
 
-	if err := helpers.ResizeDisk(imagePath, req.Size); err != nil {
+	sizeGB, resized, err := growDisk(r.Context(), imagePath, req.Size)
+	if err != nil {
+		undoQuota()
 		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to resize disk at %s: %v", imagePath, err), http.StatusInternalServerError)
 		return
 	}
 
+	// A base image larger than requested is charged with its own size
+	if sizeGB > req.Size {
+		undoLarger, ok := reserveQuota(w, quota.TenantFrom(r.Context()), quota.DiskKey(imagePath), quota.Allocation{DiskGB: int64(sizeGB)})
+		if !ok {
+			undoQuota()
+			os.Remove(imagePath)
+			return
+		}
+		undoRequested := undoQuota
+		undoQuota = func() {
+			undoLarger()
+			undoRequested()
+		}
+	}
+
+	// Encrypted after resizing, qemu-img can only resize it unlocked
+	if passphrase != nil {
+		if err := encryptDisk(r.Context(), imagePath, passphrase); err != nil {
+			// Don't leave an unencrypted disk behind
+			undoQuota()
+			os.Remove(imagePath)
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to encrypt disk at %s: %v", imagePath, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Respond with success
+	disk := map[string]interface{}{
+		"name":    req.Name,
+		"path":    imagePath,
+		"size":    sizeGB,
+		"resized": resized,
+	}
+	if req.Secret != "" {
+		disk["secret"] = req.Secret
+	}
 	response := map[string]interface{}{
 		"success": true,
 		"message": "Disk created successfully",
-		"disk": map[string]interface{}{
-			"name": req.Name,
-			"path": imagePath,
-			"size": req.Size,
-		},
+		"disk":    disk,
 	}
 	utils.JSONResponse(w, response, http.StatusCreated)
 }
 
+// resizeDisk resizes images with qemu-img; swapped out in tests.
+var resizeDisk = helpers.ResizeDisk
+
+// growDisk resizes a new image to sizeGB unless it's already as large, and
+// returns the resulting size in GB. Shrinking would cut off the filesystem of
+// a base image larger than the requested size, and resizing to the same size
+// needlessly rewrites the image. An image whose size can't be read is resized
+// as before.
+func growDisk(ctx context.Context, path string, sizeGB int) (newSizeGB int, resized bool, err error) {
+	requested := int64(sizeGB) << 30
+	info, err := diskInfo(ctx, path)
+	switch {
+	case err != nil:
+		log.Printf("Failed to read the size of %s, resizing it anyway: %v", path, err)
+	case info.VirtualSize >= requested:
+		log.Printf("Not resizing %s, its virtual size of %d bytes covers the requested %d GB", path, info.VirtualSize, sizeGB)
+		return bytesToGB(info.VirtualSize), false, nil
+	}
+	if err := resizeDisk(ctx, path, sizeGB); err != nil {
+		return 0, false, err
+	}
+	return sizeGB, true, nil
+}
+
+// bytesToGB converts an image size to GB, rounded up.
+func bytesToGB(bytes int64) int {
+	return int((bytes + 1<<30 - 1) >> 30)
+}
+
 type ResizeDiskRequest struct {
-	Size int    `json:"size"`
-	Path string `json:"path"`
+	Size   int    `json:"size"`
+	Path   string `json:"path"`
+	Live   bool   `json:"live,omitempty"`   // Resize through the running domain so the guest sees it immediately
+	Domain string `json:"domain,omitempty"` // Domain the disk is attached to, required when live
+	Target string `json:"target,omitempty"` // Target device of the disk in the domain (e.g. vda), required when live
+}
+
+func (req *ResizeDiskRequest) Validate() error {
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	if req.Live {
+		if req.Domain == "" {
+			return utils.FieldError("domain", "is required for a live resize")
+		}
+		if req.Target == "" {
+			return utils.FieldError("target", "is required for a live resize")
+		}
+	}
+	return validateDiskSize(req.Size)
 }
 
 // ResizeDiskHandler handles resizing a disk for a VM
 func ResizeDiskHandler(w http.ResponseWriter, r *http.Request) {
 	diskID := chi.URLParam(r, "id") // get disk ID from path
 
-	// Read raw request body
-	rawBody, err := io.ReadAll(r.Body)
-	if err != nil {
-		utils.JSONErrorResponse(w, "Failed to read request body", http.StatusInternalServerError)
-		return
-	}
-
-	// Ensure body is not empty
-	if len(rawBody) == 0 {
-		utils.JSONErrorResponse(w, "Empty request body", http.StatusBadRequest)
-		return
-	}
-
-	// Decode JSON request from rawBody
+	// Decode and validate the JSON request
 	var req ResizeDiskRequest
-	if err := json.Unmarshal(rawBody, &req); err != nil {
-		utils.JSONErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
-		log.Println("JSON Unmarshal error:", err) // Print error for debugging
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
 
 	// Construct file path
 	filePath := filepath.Join(req.Path, diskID+".img")
+	if !checkDiskAccess(w, r.Context(), "path", filePath) {
+		return
+	}
 
 	// Validate the disk file existence
 	if !filesystem.FileExists(filePath) {
@@ -119,8 +295,30 @@ func ResizeDiskHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A resize to the current size is almost always a client mistake
+	info, err := diskInfo(r.Context(), filePath)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to inspect disk at %s: %v", filePath, err), http.StatusInternalServerError)
+		return
+	}
+	if info.VirtualSize == int64(req.Size)<<30 {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("size", "must differ from the current size of %d GB", req.Size))
+		return
+	}
+
+	if req.Live {
+		resizeAttachedDisk(w, r, req, filePath, info.VirtualSize)
+		return
+	}
+
+	undoQuota, ok := reserveDiskQuota(w, r.Context(), filePath, req.Size)
+	if !ok {
+		return
+	}
+
 	// Resize the disk
-	if err := helpers.ResizeDisk(filePath, req.Size); err != nil {
+	if err := resizeDisk(r.Context(), filePath, req.Size); err != nil {
+		undoQuota()
 		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to resize disk at %s: %v", req.Path, err), http.StatusInternalServerError)
 		return
 	}
@@ -133,54 +331,413 @@ func ResizeDiskHandler(w http.ResponseWriter, r *http.Request) {
 	utils.JSONResponse(w, response, http.StatusOK)
 }
 
-type DeleteDiskRequest struct {
-	Path string `json:"path"`
+// resizeAttachedDisk grows a disk in use by a running domain through libvirt.
+func resizeAttachedDisk(w http.ResponseWriter, r *http.Request, req ResizeDiskRequest, filePath string, currentSize int64) {
+	// Shrinking underneath a running guest destroys its filesystem
+	if int64(req.Size)<<30 < currentSize {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("size", "must be larger than the current size for a live resize"))
+		return
+	}
+
+	// Make sure the target really is this image, blockresize would happily grow another disk
+	devices, err := libvirt.ListBlockDevices(r.Context(), req.Domain)
+	if err != nil {
+		libvirtErrorResponse(w, fmt.Sprintf("Failed to list block devices of %s", req.Domain), err)
+		return
+	}
+	attached := false
+	for _, dev := range devices {
+		if dev.Target == req.Target && filepath.Clean(dev.Source) == filepath.Clean(filePath) {
+			attached = true
+			break
+		}
+	}
+	if !attached {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("target", "%s of domain %s is not the disk at %s", req.Target, req.Domain, filePath))
+		return
+	}
+
+	undoQuota, ok := reserveDiskQuota(w, r.Context(), filePath, req.Size)
+	if !ok {
+		return
+	}
+
+	if err := helpers.LiveResizeDisk(r.Context(), req.Domain, req.Target, req.Size); err != nil {
+		undoQuota()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to resize disk at %s: %v", req.Path, err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Disk at %s successfully resized to %d GB", filePath, req.Size),
+		"live":    true,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
 }
 
-// DeleteDiskHandler handles deleting a VM disk
-func DeleteDiskHandler(w http.ResponseWriter, r *http.Request) {
-	diskID := chi.URLParam(r, "id") // get disk ID from path
+// reserveDiskQuota charges a resize to the calling tenant, which must own the
+// disk. A disk charged to nobody yet is charged to it with its full new size.
+// Resizes with the admin token stay with the tenant that created the disk.
+func reserveDiskQuota(w http.ResponseWriter, ctx context.Context, filePath string, sizeGB int) (undo func(), ok bool) {
+	key := quota.DiskKey(filePath)
+	tenant := quota.TenantFrom(ctx)
+	owner, _, found := quota.Default.Lookup(key)
+	switch {
+	case tenant == "" && !found:
+		return func() {}, true
+	case tenant == "":
+		tenant = owner
+	case found && owner != tenant:
+		utils.JSONErrorResponse(w, fmt.Sprintf("Disk image %s belongs to another tenant", filePath), http.StatusForbidden)
+		return nil, false
+	}
+	return reserveQuota(w, tenant, key, quota.Allocation{DiskGB: int64(sizeGB)})
+}
+
+type CheckDiskRequest struct {
+	Path   string `json:"path"`             // Path of the image file
+	Repair bool   `json:"repair,omitempty"` // Repair leaks and corruptions
+}
+
+func (req *CheckDiskRequest) Validate() error {
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	return nil
+}
+
+// CheckDiskHandler checks a disk image for leaked clusters and corruptions,
+// optionally repairing them
+func CheckDiskHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req CheckDiskRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+	if !checkDiskAccess(w, r.Context(), "path", req.Path) {
+		return
+	}
+
+	if !filesystem.FileExists(req.Path) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Disk image %s does not exist", req.Path), http.StatusNotFound)
+		return
+	}
+
+	// qemu holds a lock on the images of running domains and a repair
+	// underneath the guest would corrupt the image for real
+	domain, err := findDomainUsingDisk(r.Context(), req.Path)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to check whether the disk is in use", err)
+		return
+	}
+	if domain != "" {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Disk image %s is in use by running domain '%s'", req.Path, domain), http.StatusConflict)
+		return
+	}
+
+	check, err := checkDisk(r.Context(), req.Path, req.Repair)
+	if err != nil {
+		if errors.Is(err, qemu.ErrCheckNotSupported) {
+			utils.JSONRequestErrorResponse(w, utils.FieldError("path", "%s", err))
+			return
+		}
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to check disk at %s: %v", req.Path, err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":  true,
+		"healthy":  check.CheckErrors == 0 && check.Corruptions == 0 && check.Leaks == 0,
+		"repaired": req.Repair,
+		"check":    check,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// checkDisk runs qemu-img check; swapped out in tests.
+var checkDisk = qemu.CheckDisk
+
+type SparsifyDiskRequest struct {
+	Path string `json:"path"` // Path of the image file
+}
+
+func (req *SparsifyDiskRequest) Validate() error {
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	return nil
+}
+
+// SparsifyDiskHandler releases the unused space of an offline disk image and
+// reports its size on the host before and after
+func SparsifyDiskHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req SparsifyDiskRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+	if !checkDiskAccess(w, r.Context(), "path", req.Path) {
+		return
+	}
+
+	if !filesystem.FileExists(req.Path) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Disk image %s does not exist", req.Path), http.StatusNotFound)
+		return
+	}
+
+	// Running domains reclaim space themselves with fstrim
+	domain, err := findDomainUsingDisk(r.Context(), req.Path)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to check whether the disk is in use", err)
+		return
+	}
+	if domain != "" {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Disk image %s is in use by running domain '%s'", req.Path, domain), http.StatusConflict)
+		return
+	}
+
+	before, err := diskInfo(r.Context(), req.Path)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read disk info: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := sparsifyDisk(r.Context(), req.Path); err != nil {
+		if errors.Is(err, qemu.ErrSparsifyUnavailable) {
+			utils.JSONErrorResponse(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to sparsify disk at %s: %v", req.Path, err), http.StatusInternalServerError)
+		return
+	}
+	after, err := diskInfo(r.Context(), req.Path)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read disk info: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":         true,
+		"path":            req.Path,
+		"before_bytes":    before.ActualSize,
+		"after_bytes":     after.ActualSize,
+		"reclaimed_bytes": max(before.ActualSize-after.ActualSize, 0),
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
 
-	// Read raw request body
-	rawBody, err := io.ReadAll(r.Body)
+// sparsifyDisk runs virt-sparsify; swapped out in tests.
+var sparsifyDisk = qemu.SparsifyDisk
+
+// findDomainUsingDisk returns the running domain that has the image at path
+// attached, or "" when none does.
+func findDomainUsingDisk(ctx context.Context, path string) (string, error) {
+	domains, err := listDomains(ctx, false)
 	if err != nil {
-		utils.JSONErrorResponse(w, "Failed to read request body", http.StatusInternalServerError)
+		return "", err
+	}
+	for _, domain := range domains {
+		devices, err := listBlockDevices(ctx, domain)
+		if err != nil {
+			if errors.Is(err, libvirt.ErrDomainNotFound) {
+				continue // Stopped and undefined since it was listed
+			}
+			return "", err
+		}
+		for _, dev := range devices {
+			if filepath.Clean(dev.Source) == filepath.Clean(path) {
+				return domain, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// maxBackingChainDepth bounds the backing chains followed by findDiskReferences,
+// in case an image was made to back itself.
+const maxBackingChainDepth = 32
+
+// diskInfo reads image metadata with qemu-img; swapped out in tests.
+var diskInfo = qemu.GetDiskInfo
+
+// DiskReference is a domain using an image, attached directly or somewhere
+// down the backing chain of one of its disks.
+type DiskReference struct {
+	Domain string `json:"domain"`
+	Target string `json:"target"`
+	Source string `json:"source"` // Image attached to the domain
+	Depth  int    `json:"depth"`  // 0 when attached directly, else the position in the backing chain of Source
+}
+
+// samePath compares image paths, following symlinks where they resolve.
+func samePath(a string, b string) bool {
+	resolve := func(p string) string {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return resolved
+		}
+		return filepath.Clean(p)
+	}
+	return resolve(a) == resolve(b)
+}
+
+// findDiskReferences returns every disk of every defined domain that is the
+// image at path or is backed by it. Images that can't be read are only
+// checked as attached, their backing chain is skipped.
+func findDiskReferences(ctx context.Context, path string) ([]DiskReference, error) {
+	references, err := findAllDiskReferences(ctx, []string{path})
+	if err != nil {
+		return nil, err
+	}
+	if references[path] == nil {
+		return []DiskReference{}, nil
+	}
+	return references[path], nil
+}
+
+// findAllDiskReferences is findDiskReferences for several paths at once,
+// following every backing chain only once. The result is keyed by path and
+// holds no entry for paths without references.
+func findAllDiskReferences(ctx context.Context, paths []string) (map[string][]DiskReference, error) {
+	domains, err := listDomains(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	references := make(map[string][]DiskReference)
+	for _, domain := range domains {
+		devices, err := listBlockDevices(ctx, domain)
+		if err != nil {
+			if errors.Is(err, libvirt.ErrDomainNotFound) {
+				continue // Undefined since it was listed
+			}
+			return nil, err
+		}
+		for _, dev := range devices {
+			if dev.Type != "file" || dev.Source == "-" {
+				continue
+			}
+			found := make(map[string]bool)
+			image := dev.Source
+			for depth := 0; image != "" && depth <= maxBackingChainDepth; depth++ {
+				for _, path := range paths {
+					if !found[path] && samePath(image, path) {
+						references[path] = append(references[path], DiskReference{Domain: domain, Target: dev.Target, Source: dev.Source, Depth: depth})
+						found[path] = true
+					}
+				}
+				if len(found) == len(paths) {
+					break
+				}
+				info, err := diskInfo(ctx, image)
+				if err != nil {
+					log.Printf("Failed to read the backing chain of %s: %v", image, err)
+					break
+				}
+				image = info.FullBackingFilename
+			}
+		}
+	}
+	return references, nil
+}
+
+// DiskReferencesHandler lists the domains using an image directly or as a
+// backing file
+func DiskReferencesHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		utils.JSONErrorResponse(w, "'path' is required", http.StatusBadRequest)
+		return
+	}
+	if !filepath.IsAbs(path) {
+		utils.JSONErrorResponse(w, "'path' must be an absolute path", http.StatusBadRequest)
+		return
+	}
+	if !checkDiskAccess(w, r.Context(), "path", path) {
 		return
 	}
 
-	// Ensure body is not empty
-	if len(rawBody) == 0 {
-		utils.JSONErrorResponse(w, "Empty request body", http.StatusBadRequest)
+	references, err := findDiskReferences(r.Context(), path)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list disk references", err)
 		return
 	}
 
-	// Decode JSON request from rawBody
+	response := map[string]interface{}{
+		"path":       path,
+		"referenced": len(references) > 0,
+		"references": references,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+type DeleteDiskRequest struct {
+	Path  string `json:"path"`
+	Force bool   `json:"force,omitempty"` // Delete even if domains still use the image
+}
+
+func (req *DeleteDiskRequest) Validate() error {
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	return nil
+}
+
+// DeleteDiskHandler handles deleting a VM disk
+func DeleteDiskHandler(w http.ResponseWriter, r *http.Request) {
+	diskID := chi.URLParam(r, "id") // get disk ID from path
+
+	// Decode and validate the JSON request
 	var req DeleteDiskRequest
-	if err := json.Unmarshal(rawBody, &req); err != nil {
-		utils.JSONErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
-		log.Println("JSON Unmarshal error:", err) // Print error for debugging
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
 
 	// Construct file path
 	filePath := filepath.Join(req.Path, diskID+".img")
+	if !checkDiskAccess(w, r.Context(), "path", filePath) {
+		return
+	}
 
 	if !filesystem.FileExists(filePath) {
 		utils.JSONErrorResponse(w, fmt.Sprintf("Disk image %s does not exist", filePath), http.StatusNotFound)
 		return
 	}
 
+	// Deleting an image other disks are backed by breaks them as well
+	references, err := findDiskReferences(r.Context(), filePath)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to check whether the disk is in use", err)
+		return
+	}
+	if len(references) > 0 && !req.Force {
+		response := map[string]interface{}{
+			"success":    false,
+			"error":      fmt.Sprintf("Disk %s is still used by %d disks, set 'force' to delete it anyway", filePath, len(references)),
+			"references": references,
+		}
+		utils.JSONResponse(w, response, http.StatusConflict)
+		return
+	}
+
 	// Delete the disk file
 	if err := filesystem.DeleteFile(filepath.Dir(filePath), filepath.Base(filePath)); err != nil {
 		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to delete disk at %s: %v", req.Path, err), http.StatusInternalServerError)
 		return
 	}
+	quota.Default.Release(quota.DiskKey(filePath))
 
 	// Respond with success
 	response := map[string]interface{}{
 		"success": true,
 		"message": fmt.Sprintf("Disk at %s successfully deleted", filePath),
 	}
+	if len(references) > 0 {
+		response["warning"] = "The disk was still in use, the domains referencing it won't start"
+		response["references"] = references
+	}
 	utils.JSONResponse(w, response, http.StatusOK)
 }
 
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"libvirt-controller/internal/audit"
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/quota"
+	"libvirt-controller/internal/server/utils"
+)
+
+// maxBatchDeletePaths bounds the images a single batch delete may remove.
+const maxBatchDeletePaths = 100
+
+type BatchDeleteDiskRequest struct {
+	Paths []string `json:"paths"`
+	Force bool     `json:"force,omitempty"` // Delete images domains still use
+}
+
+func (req *BatchDeleteDiskRequest) Validate() error {
+	if len(req.Paths) == 0 {
+		return utils.FieldError("paths", "is required")
+	}
+	if len(req.Paths) > maxBatchDeletePaths {
+		return utils.FieldError("paths", "must hold at most %d paths", maxBatchDeletePaths)
+	}
+	seen := make(map[string]bool)
+	for i, path := range req.Paths {
+		field := fmt.Sprintf("paths[%d]", i)
+		if !filepath.IsAbs(path) {
+			return utils.FieldError(field, "must be an absolute path")
+		}
+		if seen[filepath.Clean(path)] {
+			return utils.FieldError(field, "is listed more than once")
+		}
+		seen[filepath.Clean(path)] = true
+		if !filesystem.InStorageClass(path) {
+			return utils.FieldError(field, "must be inside DEFINITIONS_DIR or a storage class directory")
+		}
+	}
+	return nil
+}
+
+// DiskDeleteResult is the outcome of deleting one image of a batch.
+type DiskDeleteResult struct {
+	Path           string          `json:"path"`
+	Deleted        bool            `json:"deleted"`
+	ReclaimedBytes int64           `json:"reclaimed_bytes"`
+	Error          string          `json:"error,omitempty"`
+	References     []DiskReference `json:"references,omitempty"` // Domains using the image
+}
+
+// BatchDeleteDiskHandler deletes several disk images at once, refusing the
+// ones domains still use unless 'force' is set
+func BatchDeleteDiskHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req BatchDeleteDiskRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	// Deleting an image other disks are backed by breaks them as well
+	references, err := findAllDiskReferences(r.Context(), req.Paths)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to check whether the disks are in use", err)
+		return
+	}
+
+	results := make([]DiskDeleteResult, len(req.Paths))
+	var wg sync.WaitGroup
+	for i, path := range req.Paths {
+		// Tenants only delete their own images, even with 'force'
+		owned, err := ownsDisk(r.Context(), path)
+		if err != nil {
+			results[i] = DiskDeleteResult{Path: path, Error: fmt.Sprintf("Failed to load metadata: %s", err)}
+			continue
+		}
+		if !owned {
+			results[i] = DiskDeleteResult{Path: path, Error: "Disk image belongs to another tenant"}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = deleteDisk(r.Context(), path, references[path], req.Force)
+		}()
+	}
+	wg.Wait()
+
+	deleted, reclaimed := 0, int64(0)
+	for _, result := range results {
+		if result.Deleted {
+			deleted++
+			reclaimed += result.ReclaimedBytes
+		}
+	}
+	events.Notify("", "disk.batch_deleted", fmt.Sprintf("%d of %d disks deleted", deleted, len(results)), map[string]interface{}{
+		"deleted":         deleted,
+		"failed":          len(results) - deleted,
+		"reclaimed_bytes": reclaimed,
+	})
+
+	response := map[string]interface{}{
+		"success":         deleted == len(results),
+		"deleted":         deleted,
+		"failed":          len(results) - deleted,
+		"reclaimed_bytes": reclaimed,
+		"results":         results,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// deleteDisk deletes the image at path unless domains use it and force isn't
+// set. The space reclaimed is what the image allocated on the host.
+func deleteDisk(ctx context.Context, path string, references []DiskReference, force bool) DiskDeleteResult {
+	result := DiskDeleteResult{Path: path, References: references}
+	stat, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		result.Error = "Disk image does not exist"
+		return result
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if !stat.Mode().IsRegular() || controllerFile(filepath.Base(path)) {
+		result.Error = "Not a disk image file"
+		return result
+	}
+	info, err := diskInfo(ctx, path)
+	if err != nil {
+		result.Error = fmt.Sprintf("Not a disk image file: %s", err)
+		return result
+	}
+	if len(references) > 0 && !force {
+		result.Error = fmt.Sprintf("Disk is still used by %d disks, set 'force' to delete it anyway", len(references))
+		return result
+	}
+
+	size := info.ActualSize
+	if err := os.Remove(path); err != nil {
+		log.Printf("Failed to delete disk %s in batch: %v", path, err)
+		result.Error = err.Error()
+		return result
+	}
+	quota.Default.Release(quota.DiskKey(path))
+	result.Deleted, result.ReclaimedBytes = true, size
+	return result
+}
+
+// controllerFile reports whether name is one of the files the controller
+// keeps in a VM directory. qemu-img reads any file as a raw image, so these
+// are refused by name.
+func controllerFile(name string) bool {
+	switch name {
+	case definitionFile, metadata.FileName, audit.FileName, "cloud-init.iso", "meta-data", "vendor-data", "user-data", "network-config":
+		return true
+	}
+	return strings.HasPrefix(name, backupFile)
+}
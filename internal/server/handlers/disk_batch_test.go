@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/quota"
+)
+
+func TestBatchDeleteDisks(t *testing.T) {
+	originalList, originalDevices, originalInfo := listDomains, listBlockDevices, diskInfo
+	defer func() { listDomains, listBlockDevices, diskInfo = originalList, originalDevices, originalInfo }()
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	orphan, used, missing := filepath.Join(dir, "orphan.qcow2"), filepath.Join(dir, "used.qcow2"), filepath.Join(dir, "missing.qcow2")
+	for _, image := range []string{orphan, used} {
+		if err := os.WriteFile(image, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return []string{"vm-1"}, nil }
+	listBlockDevices = func(ctx context.Context, domain string) ([]libvirt.BlockDevice, error) {
+		return []libvirt.BlockDevice{{Type: "file", Device: "disk", Target: "vda", Source: used}}, nil
+	}
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		return &qemu.DiskInfo{Filename: path, ActualSize: 1 << 30}, nil
+	}
+
+	tests := []struct {
+		name        string
+		force       bool
+		wantDeleted map[string]bool
+		wantBytes   int64
+	}{
+		{"refuses used disks", false, map[string]bool{orphan: true}, 1 << 30},
+		{"force", true, map[string]bool{used: true}, 1 << 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(BatchDeleteDiskRequest{Paths: []string{orphan, used, missing}, Force: tt.force})
+			req := httptest.NewRequest(http.MethodPost, "/v1/disk/batch-delete", strings.NewReader(string(body)))
+			rec := httptest.NewRecorder()
+			BatchDeleteDiskHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var resp struct {
+				ReclaimedBytes int64              `json:"reclaimed_bytes"`
+				Results        []DiskDeleteResult `json:"results"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.ReclaimedBytes != tt.wantBytes {
+				t.Errorf("reclaimed_bytes = %d, want %d", resp.ReclaimedBytes, tt.wantBytes)
+			}
+			for _, result := range resp.Results {
+				if result.Deleted != tt.wantDeleted[result.Path] {
+					t.Errorf("%s: deleted = %v, error %q", result.Path, result.Deleted, result.Error)
+				}
+				if result.Path == used && len(result.References) != 1 {
+					t.Errorf("%s: references = %+v", result.Path, result.References)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchDeleteRefusesControllerFiles(t *testing.T) {
+	originalList, originalInfo := listDomains, diskInfo
+	defer func() { listDomains, diskInfo = originalList, originalInfo }()
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	vmDir := filepath.Join(dir, "vm-1")
+	if err := os.Mkdir(vmDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, name := range []string{"server.xml", "server.xml.bak.1", "metadata.json", "user-data", "notes.txt"} {
+		path := filepath.Join(vmDir, name)
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return nil, nil }
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		if filepath.Base(path) == "notes.txt" {
+			return nil, errors.New("qemu-img: Could not open: Image is not in qcow2 format")
+		}
+		return &qemu.DiskInfo{Filename: path, Format: "raw"}, nil
+	}
+
+	body, _ := json.Marshal(BatchDeleteDiskRequest{Paths: paths, Force: true})
+	req := httptest.NewRequest(http.MethodPost, "/v1/disk/batch-delete", strings.NewReader(string(body)))
+	rec := httptest.NewRecorder()
+	BatchDeleteDiskHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was deleted", path)
+		}
+	}
+}
+
+func TestBatchDeleteRefusesOtherTenants(t *testing.T) {
+	originalList, originalInfo := listDomains, diskInfo
+	defer func() { listDomains, diskInfo = originalList, originalInfo }()
+	withQuota(t)
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	vmDir := filepath.Join(dir, "vm-2")
+	if err := os.Mkdir(vmDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.Save(vmDir, &metadata.Metadata{Tenant: "globex"}); err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(vmDir, "disk.img")
+	if err := os.WriteFile(image, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return nil, nil }
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		return &qemu.DiskInfo{Filename: path, Format: "raw"}, nil
+	}
+
+	body, _ := json.Marshal(BatchDeleteDiskRequest{Paths: []string{image}, Force: true})
+	req := httptest.NewRequest(http.MethodPost, "/v1/disk/batch-delete", strings.NewReader(string(body)))
+	req = req.WithContext(quota.WithTenant(req.Context(), "acme"))
+	rec := httptest.NewRecorder()
+	BatchDeleteDiskHandler(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "belongs to another tenant") {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(image); err != nil {
+		t.Errorf("the image of another tenant was deleted")
+	}
+}
+
+func TestBatchDeleteDiskRequestValidate(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		valid bool
+	}{
+		{"inside", []string{filepath.Join(dir, "vm-1", "..", "a.img"), filepath.Join(dir, "b.img")}, true},
+		{"empty", nil, false},
+		{"relative", []string{"a.img"}, false},
+		{"duplicate", []string{filepath.Join(dir, "a.img"), filepath.Join(dir, ".", "a.img")}, false},
+		{"outside", []string{filepath.Join(outside, "a.img")}, false},
+		{"through a symlink", []string{filepath.Join(dir, "link", "a.img")}, false},
+		{"the base itself", []string{dir}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := BatchDeleteDiskRequest{Paths: tt.paths}
+			if err := req.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/quota"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDiskRequestSizeValidation(t *testing.T) {
+	t.Setenv("MAX_DISK_SIZE_GB", "100")
+
+	cases := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{"zero", 0, true},
+		{"negative", -10, true},
+		{"oversized", 101, true},
+		{"at limit", 100, false},
+		{"typical", 20, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			create := &CreateDiskRequest{Name: "disk.img", Path: "/data/disks", ImageURL: "https://example.com/image.img", Size: tc.size}
+			if err := create.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("CreateDiskRequest.Validate() with size %d: error = %v, wantErr %t", tc.size, err, tc.wantErr)
+			}
+
+			resize := &ResizeDiskRequest{Path: "/data/disks", Size: tc.size}
+			if err := resize.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("ResizeDiskRequest.Validate() with size %d: error = %v, wantErr %t", tc.size, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestResizeDiskRequestLiveValidation(t *testing.T) {
+	cases := []struct {
+		name    string
+		req     ResizeDiskRequest
+		wantErr bool
+	}{
+		{"offline", ResizeDiskRequest{Path: "/data/disks", Size: 20}, false},
+		{"live", ResizeDiskRequest{Path: "/data/disks", Size: 20, Live: true, Domain: "vm-1", Target: "vda"}, false},
+		{"live without domain", ResizeDiskRequest{Path: "/data/disks", Size: 20, Live: true, Target: "vda"}, true},
+		{"live without target", ResizeDiskRequest{Path: "/data/disks", Size: 20, Live: true, Domain: "vm-1"}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.req.Validate(); (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestResizeDiskHandler(t *testing.T) {
+	originalInfo, originalResize := diskInfo, resizeDisk
+	defer func() { diskInfo, resizeDisk = originalInfo, originalResize }()
+	t.Setenv("MAX_DISK_SIZE_GB", "100")
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	if err := os.WriteFile(filepath.Join(dir, "disk-1.img"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		return &qemu.DiskInfo{VirtualSize: 20 << 30}, nil
+	}
+
+	tests := []struct {
+		name       string
+		size       int
+		wantStatus int
+		wantError  string
+	}{
+		{"zero", 0, http.StatusBadRequest, "must be \\u003e 0"},
+		{"negative", -5, http.StatusBadRequest, "must be \\u003e 0"},
+		{"oversized", 101, http.StatusBadRequest, "must not exceed 100 GB"},
+		{"unchanged", 20, http.StatusBadRequest, "must differ from the current size of 20 GB"},
+		{"grow", 40, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resized := 0
+			resizeDisk = func(ctx context.Context, path string, sizeGB int) error {
+				resized = sizeGB
+				return nil
+			}
+
+			body := fmt.Sprintf(`{"path": %q, "size": %d}`, dir, tt.size)
+			req := httptest.NewRequest(http.MethodPost, "/v1/disk/disk-1/resize", strings.NewReader(body))
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", "disk-1")
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+			rec := httptest.NewRecorder()
+			ResizeDiskHandler(rec, req)
+
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantError) {
+				t.Fatalf("status = %d, want %d with %q: %s", rec.Code, tt.wantStatus, tt.wantError, rec.Body)
+			}
+			if want := map[bool]int{true: tt.size}[tt.wantStatus == http.StatusOK]; resized != want {
+				t.Errorf("resized to %d GB, want %d", resized, want)
+			}
+		})
+	}
+}
+
+func TestCreateDiskInsufficientStorage(t *testing.T) {
+	t.Setenv("DISK_CREATE_MIN_FREE_MB", "1099511627776") // 1 EiB
+	t.Setenv("CACHE_DIR", "")
+	path := filepath.Join(t.TempDir(), "disks")
+	t.Setenv("DEFINITIONS_DIR", filepath.Dir(path))
+
+	body := `{"name": "disk.img", "size": 20, "path": "` + path + `", "image_url": "https://example.com/image.img"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/disk/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	CreateDiskHandler(rec, req)
+
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusInsufficientStorage, rec.Body)
+	}
+	var got struct {
+		RequiredBytes  uint64 `json:"required_bytes"`
+		AvailableBytes uint64 `json:"available_bytes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid response body %q: %v", rec.Body, err)
+	}
+	if got.RequiredBytes != 1<<60 || got.AvailableBytes == 0 {
+		t.Errorf("got %+v, want 1 EiB required and the available bytes", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("nothing must be created without enough storage")
+	}
+}
+
+func TestGrowDisk(t *testing.T) {
+	originalInfo, originalResize := diskInfo, resizeDisk
+	defer func() { diskInfo, resizeDisk = originalInfo, originalResize }()
+
+	tests := []struct {
+		name        string
+		virtualSize int64
+		infoErr     error
+		wantResized bool
+		wantSize    int
+	}{
+		{"smaller", 2 << 30, nil, true, 20},
+		{"same size", 20 << 30, nil, false, 20},
+		{"larger", 40 << 30, nil, false, 40},
+		{"larger by a fraction", 40<<30 + 1, nil, false, 41},
+		{"unreadable", 0, errors.New("qemu-img: Could not open"), true, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+				if tt.infoErr != nil {
+					return nil, tt.infoErr
+				}
+				return &qemu.DiskInfo{Filename: path, VirtualSize: tt.virtualSize}, nil
+			}
+			called := false
+			resizeDisk = func(ctx context.Context, path string, sizeGB int) error {
+				called = true
+				return nil
+			}
+
+			size, resized, err := growDisk(context.Background(), "/data/disks/vm-1.qcow2", 20)
+			if err != nil {
+				t.Fatalf("growDisk() error = %v", err)
+			}
+			if size != tt.wantSize {
+				t.Errorf("size = %d GB, want %d", size, tt.wantSize)
+			}
+			if resized != tt.wantResized || called != tt.wantResized {
+				t.Errorf("resized = %v, resize called %v; want %v", resized, called, tt.wantResized)
+			}
+		})
+	}
+}
+
+func TestCheckDiskRefusesAttachedDisk(t *testing.T) {
+	originalList, originalDevices, originalCheck := listDomains, listBlockDevices, checkDisk
+	defer func() { listDomains, listBlockDevices, checkDisk = originalList, originalDevices, originalCheck }()
+
+	image := filepath.Join(t.TempDir(), "disk.qcow2")
+	t.Setenv("DEFINITIONS_DIR", filepath.Dir(image))
+	if err := os.WriteFile(image, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		if includeInactive {
+			t.Error("only running domains hold the disk")
+		}
+		return []string{"vm-1"}, nil
+	}
+	listBlockDevices = func(ctx context.Context, domain string) ([]libvirt.BlockDevice, error) {
+		return []libvirt.BlockDevice{{Type: "file", Device: "disk", Target: "vda", Source: image}}, nil
+	}
+	checkDisk = func(ctx context.Context, path string, repair bool) (*qemu.DiskCheck, error) {
+		t.Fatal("qemu-img check must not run on an attached disk")
+		return nil, nil
+	}
+
+	body := `{"path": "` + image + `", "repair": true}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/disk/check", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	CheckDiskHandler(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+}
+
+func TestSparsifyDisk(t *testing.T) {
+	originalList, originalInfo, originalSparsify := listDomains, diskInfo, sparsifyDisk
+	defer func() { listDomains, diskInfo, sparsifyDisk = originalList, originalInfo, originalSparsify }()
+
+	image := filepath.Join(t.TempDir(), "disk.qcow2")
+	t.Setenv("DEFINITIONS_DIR", filepath.Dir(image))
+	if err := os.WriteFile(image, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return nil, nil }
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{"sparsified", nil, http.StatusOK, `"reclaimed_bytes":6442450944`},
+		{"not installed", qemu.ErrSparsifyUnavailable, http.StatusNotImplemented, "libguestfs-tools"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := int64(10 << 30)
+			diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+				return &qemu.DiskInfo{Format: "qcow2", ActualSize: actual}, nil
+			}
+			sparsifyDisk = func(ctx context.Context, path string) error {
+				if tt.err == nil {
+					actual = 4 << 30
+				}
+				return tt.err
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/disk/sparsify", strings.NewReader(`{"path": "`+image+`"}`))
+			rec := httptest.NewRecorder()
+			SparsifyDiskHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body %s does not contain %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDiskReferences(t *testing.T) {
+	originalList, originalDevices, originalInfo := listDomains, listBlockDevices, diskInfo
+	defer func() { listDomains, listBlockDevices, diskInfo = originalList, originalDevices, originalInfo }()
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	base := filepath.Join(dir, "base.img")
+	if err := os.WriteFile(base, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	overlay1, overlay2, other := filepath.Join(dir, "vm-1.qcow2"), filepath.Join(dir, "vm-2.qcow2"), filepath.Join(dir, "other.qcow2")
+	backing := map[string]string{overlay1: base, overlay2: overlay1}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		if !includeInactive {
+			t.Error("stopped domains reference disks as well")
+		}
+		return []string{"vm-1", "vm-2", "vm-3"}, nil
+	}
+	listBlockDevices = func(ctx context.Context, domain string) ([]libvirt.BlockDevice, error) {
+		switch domain {
+		case "vm-1":
+			return []libvirt.BlockDevice{{Type: "file", Device: "disk", Target: "vda", Source: overlay1}, {Type: "file", Device: "cdrom", Target: "sda", Source: "-"}}, nil
+		case "vm-2":
+			return []libvirt.BlockDevice{{Type: "file", Device: "disk", Target: "vda", Source: overlay2}}, nil
+		}
+		return []libvirt.BlockDevice{{Type: "file", Device: "disk", Target: "vdb", Source: other}}, nil
+	}
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		return &qemu.DiskInfo{Filename: path, FullBackingFilename: backing[path]}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/disk/references?path="+base, nil)
+	rec := httptest.NewRecorder()
+	DiskReferencesHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Referenced bool            `json:"referenced"`
+		References []DiskReference `json:"references"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := []DiskReference{
+		{Domain: "vm-1", Target: "vda", Source: overlay1, Depth: 1},
+		{Domain: "vm-2", Target: "vda", Source: overlay2, Depth: 2},
+	}
+	if !body.Referenced || !reflect.DeepEqual(body.References, want) {
+		t.Errorf("references = %+v, want %+v", body.References, want)
+	}
+
+	// Deleting the base image is refused unless forced
+	for _, force := range []bool{false, true} {
+		body := fmt.Sprintf(`{"path": %q, "force": %t}`, dir, force)
+		req := httptest.NewRequest(http.MethodDelete, "/v1/disk/base", strings.NewReader(body))
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("id", "base")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+		rec := httptest.NewRecorder()
+		DeleteDiskHandler(rec, req)
+
+		wantStatus := http.StatusConflict
+		if force {
+			wantStatus = http.StatusOK
+		}
+		if rec.Code != wantStatus {
+			t.Fatalf("force=%t: status = %d, want %d: %s", force, rec.Code, wantStatus, rec.Body)
+		}
+		if _, err := os.Stat(base); os.IsNotExist(err) != force {
+			t.Errorf("force=%t: image deleted = %t", force, os.IsNotExist(err))
+		}
+	}
+}
+
+func TestDeleteDiskChecksOwnership(t *testing.T) {
+	original := listDomains
+	defer func() { listDomains = original }()
+	withQuota(t)
+	dir, outside := t.TempDir(), t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	for vmID, tenant := range map[string]string{"vm-1": "acme", "vm-2": "globex"} {
+		vmDir := filepath.Join(dir, vmID)
+		if err := os.Mkdir(vmDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := metadata.Save(vmDir, &metadata.Metadata{Tenant: tenant}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, image := range []string{"vm-1/disk.img", "vm-2/disk.img", "vm-2/shared.img"} {
+		if err := os.WriteFile(filepath.Join(dir, image), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "disk.img"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Charged to acme although it lives in the directory of globex
+	if _, err := quota.Default.Reserve("acme", quota.DiskKey(filepath.Join(dir, "vm-2/shared.img")), quota.Allocation{}); err != nil {
+		t.Fatal(err)
+	}
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return nil, nil }
+
+	tests := []struct {
+		name       string
+		path       string
+		id         string
+		tenant     string
+		wantStatus int
+	}{
+		{"own disk", filepath.Join(dir, "vm-1"), "disk", "acme", http.StatusOK},
+		{"disk of another tenant", filepath.Join(dir, "vm-2"), "disk", "acme", http.StatusForbidden},
+		{"disk charged to the caller", filepath.Join(dir, "vm-2"), "shared", "acme", http.StatusOK},
+		{"admin", filepath.Join(dir, "vm-2"), "disk", "", http.StatusOK},
+		{"outside the storage classes", outside, "disk", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"path": %q, "force": true}`, tt.path)
+			req := httptest.NewRequest(http.MethodDelete, "/v1/disk/"+tt.id, strings.NewReader(body))
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("id", tt.id)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+			if tt.tenant != "" {
+				ctx = quota.WithTenant(ctx, tt.tenant)
+			}
+			rec := httptest.NewRecorder()
+			DeleteDiskHandler(rec, req.WithContext(ctx))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			_, err := os.Stat(filepath.Join(tt.path, tt.id+".img"))
+			if deleted := os.IsNotExist(err); deleted != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("image deleted = %t", deleted)
+			}
+		})
+	}
+}
+
+func TestResizeDiskChargesCaller(t *testing.T) {
+	originalInfo, originalResize := diskInfo, resizeDisk
+	defer func() { diskInfo, resizeDisk = originalInfo, originalResize }()
+	original := quota.Default
+	t.Cleanup(func() { quota.Default = original })
+	quota.Default = quota.NewIndex()
+	quotaFile := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(quotaFile, []byte(`{"token": {"tenant": "acme", "disk_gb": 30}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Default.Configure(quotaFile); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("STORAGE_CLASSES", "")
+	t.Setenv("MAX_DISK_SIZE_GB", "100")
+	vmDir := filepath.Join(dir, "vm-1")
+	if err := os.Mkdir(vmDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.Save(vmDir, &metadata.Metadata{Tenant: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"disk-1.img", "disk-2.img"} {
+		if err := os.WriteFile(filepath.Join(vmDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// disk-2 lives in the directory of acme but is charged to globex
+	if _, err := quota.Default.Reserve("globex", quota.DiskKey(filepath.Join(vmDir, "disk-2.img")), quota.Allocation{DiskGB: 20}); err != nil {
+		t.Fatal(err)
+	}
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		return &qemu.DiskInfo{VirtualSize: 20 << 30}, nil
+	}
+	resizeDisk = func(ctx context.Context, path string, sizeGB int) error { return nil }
+
+	tests := []struct {
+		name       string
+		id         string
+		size       int
+		wantStatus int
+	}{
+		// Unindexed disks are charged in full, not resized for free
+		{"over the quota", "disk-1", 40, http.StatusForbidden},
+		{"within the quota", "disk-1", 25, http.StatusOK},
+		{"charged to another tenant", "disk-2", 25, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"path": %q, "size": %d}`, vmDir, tt.size)
+			req := httptest.NewRequest(http.MethodPost, "/v1/disk/"+tt.id+"/resize", strings.NewReader(body))
+			routeCtx := chi.NewRouteContext()
+			routeCtx.URLParams.Add("id", tt.id)
+			ctx := context.WithValue(quota.WithTenant(req.Context(), "acme"), chi.RouteCtxKey, routeCtx)
+			rec := httptest.NewRecorder()
+			ResizeDiskHandler(rec, req.WithContext(ctx))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+
+	owner, alloc, found := quota.Default.Lookup(quota.DiskKey(filepath.Join(vmDir, "disk-1.img")))
+	if !found || owner != "acme" || alloc.DiskGB != 25 {
+		t.Errorf("disk-1 charged %+v to %q (found %t), want 25 GB to acme", alloc, owner, found)
+	}
+}
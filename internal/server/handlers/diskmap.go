@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"net/http"
+	"path"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+// Ways a guest disk is matched to a disk of the domain, from the most to the
+// least reliable.
+const (
+	matchedBySerial     = "serial"
+	matchedByPCIAddress = "pci_address"
+	matchedByDrive      = "drive_address"
+	matchedByName       = "name"
+)
+
+// DiskMapping follows a disk of the domain from the host file to the guest
+// device and the filesystems on it.
+type DiskMapping struct {
+	Target      string                   `json:"target"` // e.g. vda
+	Bus         string                   `json:"bus,omitempty"`
+	Device      string                   `json:"device"`                 // disk, cdrom, ...
+	Source      string                   `json:"source"`                 // Host file, "-" for empty drives
+	GuestDevice string                   `json:"guest_device,omitempty"` // e.g. /dev/vda, empty when unknown
+	MatchedBy   string                   `json:"matched_by,omitempty"`   // How the guest disk was recognized
+	Filesystems []GuestFilesystemMapping `json:"filesystems"`
+}
+
+// GuestFilesystemMapping is a filesystem mounted in the guest.
+type GuestFilesystemMapping struct {
+	Name       string `json:"name"` // Guest block device, e.g. vda1
+	Mountpoint string `json:"mountpoint"`
+	Type       string `json:"type"`
+	UsedBytes  *int64 `json:"used_bytes,omitempty"`
+	TotalBytes *int64 `json:"total_bytes,omitempty"`
+}
+
+// DiskMappingHandler maps the disks of a domain to guest devices and
+// mountpoints. Without a guest agent only the host side is returned.
+func DiskMappingHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	devices, err := listBlockDevices(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list block devices", err)
+		return
+	}
+	// The live XML adds the serials and addresses, disks are still matched
+	// by name without it
+	var domain *domainxml.Domain
+	if data, err := dumpXML(r.Context(), vmID, false); err == nil {
+		domain, _ = domainxml.Parse([]byte(data))
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"id":      vmID,
+		"agent":   true,
+	}
+	filesystems, err := guestFileSystemUsage(r.Context(), vmID)
+	if err != nil {
+		// Stopped domains and guests without the agent
+		response["agent"] = false
+		response["agent_error"] = err.Error()
+	}
+	disks, unmatched := mapDisks(devices, domain, filesystems)
+	response["disks"] = disks
+	if len(unmatched) > 0 {
+		response["unmatched_filesystems"] = unmatched
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// mapDisks attaches the guest filesystems to the disks they live on. A
+// filesystem spanning several disks, e.g. on LVM, is listed with each of
+// them; filesystems on no recognized disk are returned separately.
+func mapDisks(devices []libvirt.BlockDevice, domain *domainxml.Domain, filesystems []qemu.FileSystemInfo) ([]DiskMapping, []GuestFilesystemMapping) {
+	disks := make([]DiskMapping, len(devices))
+	defined := make([]*domainxml.Disk, len(devices))
+	for i, d := range devices {
+		disks[i] = DiskMapping{Target: d.Target, Device: d.Device, Source: d.Source, Filesystems: []GuestFilesystemMapping{}}
+		if domain == nil {
+			continue
+		}
+		for j := range domain.Devices.Disks {
+			if disk := &domain.Devices.Disks[j]; disk.Target.Dev == d.Target {
+				defined[i] = disk
+				disks[i].Bus = disk.Target.Bus
+			}
+		}
+	}
+
+	var unmatched []GuestFilesystemMapping
+	for _, fs := range filesystems {
+		mapping := GuestFilesystemMapping{
+			Name:       fs.Name,
+			Mountpoint: fs.Mountpoint,
+			Type:       fs.FilesystemType,
+			UsedBytes:  fs.UsedBytes,
+			TotalBytes: fs.TotalBytes,
+		}
+		found := false
+		for _, guestDisk := range fs.Disks {
+			i, matchedBy := matchGuestDisk(devices, defined, guestDisk)
+			if i < 0 {
+				continue
+			}
+			found = true
+			disk := &disks[i]
+			if disk.MatchedBy == "" {
+				disk.GuestDevice, disk.MatchedBy = guestDisk.Dev, matchedBy
+			}
+			// A filesystem on several partitions of one disk is listed once
+			if n := len(disk.Filesystems); n == 0 || disk.Filesystems[n-1] != mapping {
+				disk.Filesystems = append(disk.Filesystems, mapping)
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, mapping)
+		}
+	}
+	return disks, unmatched
+}
+
+// matchGuestDisk returns the index of the domain disk guestDisk is and how it
+// was recognized, -1 when it's none of them. Serials and addresses identify a
+// disk, the guest's name for it only usually matches the target.
+func matchGuestDisk(devices []libvirt.BlockDevice, defined []*domainxml.Disk, guestDisk qemu.GuestDiskAddress) (int, string) {
+	for i, disk := range defined {
+		if disk != nil && guestDisk.Serial != "" && disk.Serial == guestDisk.Serial {
+			return i, matchedBySerial
+		}
+	}
+	for i, disk := range defined {
+		if disk == nil || disk.Target.Bus != guestDisk.BusType {
+			continue
+		}
+		switch guestDisk.BusType {
+		case "virtio":
+			pci := guestDisk.PCIController
+			want := domainxml.PCIAddress{Domain: pci.Domain, Bus: pci.Bus, Slot: pci.Slot, Function: pci.Function}
+			if addr, ok := disk.Address.PCI(); ok && addr == want {
+				return i, matchedByPCIAddress
+			}
+		case "scsi":
+			// The controller isn't compared, the guest only knows its PCI address
+			if bus, target, unit, ok := disk.Address.Drive(); ok && bus == guestDisk.Bus && target == guestDisk.Target && unit == guestDisk.Unit {
+				return i, matchedByDrive
+			}
+		}
+	}
+	if guestDisk.Dev != "" {
+		for i, d := range devices {
+			if path.Base(guestDisk.Dev) == d.Target {
+				return i, matchedByName
+			}
+		}
+	}
+	return -1, ""
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+)
+
+const diskMappingXML = `<domain type='kvm'><name>vm-1</name><devices>
+  <disk type='file' device='disk'><source file='/data/vm-1/root.qcow2'/><target dev='vda' bus='virtio'/>
+    <address type='pci' domain='0x0000' bus='0x04' slot='0x00' function='0x0'/></disk>
+  <disk type='file' device='disk'><source file='/data/vm-1/data.qcow2'/><target dev='sda' bus='scsi'/>
+    <address type='drive' controller='0' bus='0' target='0' unit='1'/></disk>
+  <disk type='file' device='disk'><source file='/data/vm-1/logs.qcow2'/><target dev='vdb' bus='virtio'/><serial>logs</serial></disk>
+  <disk type='file' device='cdrom'><target dev='sdb' bus='sata'/></disk>
+</devices></domain>`
+
+var diskMappingDevices = []libvirt.BlockDevice{
+	{Type: "file", Device: "disk", Target: "vda", Source: "/data/vm-1/root.qcow2"},
+	{Type: "file", Device: "disk", Target: "sda", Source: "/data/vm-1/data.qcow2"},
+	{Type: "file", Device: "disk", Target: "vdb", Source: "/data/vm-1/logs.qcow2"},
+	{Type: "file", Device: "cdrom", Target: "sdb", Source: "-"},
+}
+
+func TestMapDisks(t *testing.T) {
+	domain, err := domainxml.Parse([]byte(diskMappingXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filesystems := []qemu.FileSystemInfo{
+		// The guest calls the virtio disk vdb, the PCI address still finds vda
+		{Name: "vdb1", Mountpoint: "/", FilesystemType: "ext4", Disks: []qemu.GuestDiskAddress{
+			{BusType: "virtio", PCIController: qemu.GuestPCIAddress{Bus: 4}, Dev: "/dev/vdb"},
+		}},
+		{Name: "sdb1", Mountpoint: "/srv", FilesystemType: "xfs", Disks: []qemu.GuestDiskAddress{
+			{BusType: "scsi", PCIController: qemu.GuestPCIAddress{Bus: 5}, Unit: 1, Dev: "/dev/sdb"},
+		}},
+		{Name: "dm-0", Mountpoint: "/var/log", FilesystemType: "ext4", Disks: []qemu.GuestDiskAddress{
+			{BusType: "virtio", PCIController: qemu.GuestPCIAddress{Bus: 7}, Serial: "logs", Dev: "/dev/vda"},
+		}},
+		{Name: "tmpfs", Mountpoint: "/tmp", FilesystemType: "tmpfs"},
+	}
+
+	disks, unmatched := mapDisks(diskMappingDevices, domain, filesystems)
+	want := []struct {
+		guestDevice, matchedBy, mountpoint string
+	}{
+		{"/dev/vdb", matchedByPCIAddress, "/"},
+		{"/dev/sdb", matchedByDrive, "/srv"},
+		{"/dev/vda", matchedBySerial, "/var/log"},
+		{"", "", ""},
+	}
+	for i, w := range want {
+		d := disks[i]
+		mountpoint := ""
+		if len(d.Filesystems) == 1 {
+			mountpoint = d.Filesystems[0].Mountpoint
+		}
+		if d.GuestDevice != w.guestDevice || d.MatchedBy != w.matchedBy || mountpoint != w.mountpoint {
+			t.Errorf("disk %s = %s by %q with %+v, want %s by %q at %q", d.Target, d.GuestDevice, d.MatchedBy, d.Filesystems, w.guestDevice, w.matchedBy, w.mountpoint)
+		}
+	}
+	if len(unmatched) != 1 || unmatched[0].Mountpoint != "/tmp" {
+		t.Errorf("unmatched = %+v, want only /tmp", unmatched)
+	}
+}
+
+func TestMapDisksByName(t *testing.T) {
+	filesystems := []qemu.FileSystemInfo{
+		{Name: "vda1", Mountpoint: "/", Disks: []qemu.GuestDiskAddress{{BusType: "virtio", Dev: "/dev/vda"}}},
+		{Name: "vda2", Mountpoint: "/", Disks: []qemu.GuestDiskAddress{{BusType: "virtio", Dev: "/dev/vda"}, {BusType: "virtio", Dev: "/dev/vdb"}}},
+	}
+	disks, unmatched := mapDisks(diskMappingDevices, nil, filesystems)
+	if disks[0].MatchedBy != matchedByName || len(disks[0].Filesystems) != 2 {
+		t.Errorf("vda = %+v, want both filesystems matched by name", disks[0])
+	}
+	if len(disks[2].Filesystems) != 1 || disks[2].Filesystems[0].Name != "vda2" {
+		t.Errorf("vdb = %+v, want the filesystem spanning vda and vdb", disks[2])
+	}
+	if len(unmatched) != 0 {
+		t.Errorf("unmatched = %+v, want none", unmatched)
+	}
+}
+
+func TestDiskMappingWithoutAgent(t *testing.T) {
+	originalList, originalDump, originalFS := listBlockDevices, dumpXML, guestFileSystemUsage
+	defer func() { listBlockDevices, dumpXML, guestFileSystemUsage = originalList, originalDump, originalFS }()
+	listBlockDevices = func(ctx context.Context, domain string) ([]libvirt.BlockDevice, error) {
+		return diskMappingDevices, nil
+	}
+	dumpXML = func(ctx context.Context, domain string, inactive bool) (string, error) {
+		return diskMappingXML, nil
+	}
+	guestFileSystemUsage = func(ctx context.Context, vm string) ([]qemu.FileSystemInfo, error) {
+		return nil, errors.New("error: Guest agent is not responding: QEMU guest agent is not connected")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/domain/vm-1/disk-mapping", nil)
+	req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+	rec := httptest.NewRecorder()
+	DiskMappingHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Agent      bool          `json:"agent"`
+		AgentError string        `json:"agent_error"`
+		Disks      []DiskMapping `json:"disks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Agent || body.AgentError == "" {
+		t.Errorf("agent = %v, agent_error = %q; want the agent reported missing", body.Agent, body.AgentError)
+	}
+	if len(body.Disks) != 4 || body.Disks[0].Source != "/data/vm-1/root.qcow2" || body.Disks[0].Bus != "virtio" {
+		t.Errorf("disks = %+v, want the host side of all four", body.Disks)
+	}
+}
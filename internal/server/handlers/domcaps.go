@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/server/utils"
+)
+
+// domainCapabilities describes the host's domain capabilities; swapped out in tests.
+var domainCapabilities = libvirt.DomainCapabilities
+
+// capsParameter matches architectures and machine types such as x86_64 and
+// pc-q35-8.2, and keeps values looking like virsh flags out.
+var capsParameter = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// DomainCapabilitiesHandler reports the firmware, devices and features the
+// host supports for domains of ?arch= and ?machine=, for choosing the
+// devices of a domain definition
+func DomainCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	arch := r.URL.Query().Get("arch")
+	machine := r.URL.Query().Get("machine")
+	for name, value := range map[string]string{"arch": arch, "machine": machine} {
+		if value != "" && !capsParameter.MatchString(value) {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Invalid '%s' parameter", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	caps, err := domainCapabilities(r.Context(), arch, machine)
+	if errors.Is(err, libvirt.ErrUnsupportedMachine) {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get domain capabilities", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":      true,
+		"capabilities": caps,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
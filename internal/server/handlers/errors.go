@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/server/utils"
+)
+
+// libvirtErrorStatus maps the typed errors of the libvirt package to an HTTP
+// status, anything unrecognized is a 500.
+func libvirtErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, libvirt.ErrDomainNotFound), errors.Is(err, libvirt.ErrSecretNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, libvirt.ErrAlreadyRunning), errors.Is(err, libvirt.ErrAlreadyStopped), errors.Is(err, libvirt.ErrDomainNotRunning):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// libvirtErrorResponse reports a failed libvirt call with the status matching its cause.
+func libvirtErrorResponse(w http.ResponseWriter, message string, err error) {
+	utils.JSONErrorResponse(w, message+": "+err.Error(), libvirtErrorStatus(err))
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"libvirt-controller/internal/libvirt"
+)
+
+func TestLibvirtErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("failed to get domain info: %w", libvirt.ErrDomainNotFound), http.StatusNotFound},
+		{libvirt.ErrAlreadyRunning, http.StatusConflict},
+		{libvirt.ErrAlreadyStopped, http.StatusConflict},
+		{libvirt.ErrDomainNotRunning, http.StatusConflict},
+		{errors.New("command execution failed: error: internal error, exit status 1"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := libvirtErrorStatus(tt.err); got != tt.want {
+				t.Errorf("libvirtErrorStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
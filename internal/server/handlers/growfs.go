@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+// growFilesystem grows a guest partition and filesystem; swapped out in tests.
+var growFilesystem = qemu.GrowFilesystem
+
+type GrowFilesystemRequest struct {
+	Mountpoint string `json:"mountpoint,omitempty"` // e.g. "/"
+	Device     string `json:"device,omitempty"`     // e.g. "/dev/vda1", instead of the mountpoint
+}
+
+func (req *GrowFilesystemRequest) Validate() error {
+	if (req.Mountpoint == "") == (req.Device == "") {
+		return utils.FieldError("mountpoint", "or device is required, but not both")
+	}
+	if req.Mountpoint != "" && !path.IsAbs(req.Mountpoint) {
+		return utils.FieldError("mountpoint", "must be an absolute path")
+	}
+	if req.Device != "" && !strings.HasPrefix(req.Device, "/dev/") {
+		return utils.FieldError("device", "must be a device such as /dev/vda1")
+	}
+	return nil
+}
+
+// GrowFilesystemHandler grows a guest partition and its filesystem into the
+// space a disk resize added, through the guest agent
+func GrowFilesystemHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req GrowFilesystemRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	result, err := growFilesystem(r.Context(), vmID, req.Mountpoint, req.Device)
+	switch {
+	case errors.Is(err, qemu.ErrFilesystemNotFound):
+		utils.JSONErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, qemu.ErrGrowUnsupported):
+		utils.JSONErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, qemu.ErrGrowFailed):
+		// The steps carry the output of the failed command
+		response := map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+			"result":  result,
+		}
+		utils.JSONResponse(w, response, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to grow filesystem: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"result":  result,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
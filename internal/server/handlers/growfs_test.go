@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/qemu"
+)
+
+func TestGrowFilesystemHandler(t *testing.T) {
+	original, originalRunning := growFilesystem, requireRunning
+	defer func() { growFilesystem, requireRunning = original, originalRunning }()
+	requireRunning = func(ctx context.Context, domain string) error { return nil }
+
+	tests := []struct {
+		name       string
+		body       string
+		err        error
+		wantStatus int
+		wantCalled bool
+	}{
+		{"mountpoint", `{"mountpoint": "/"}`, nil, http.StatusOK, true},
+		{"device", `{"device": "/dev/vda1"}`, nil, http.StatusOK, true},
+		{"not mounted", `{"mountpoint": "/srv"}`, fmt.Errorf("%w: nothing mounted at /srv", qemu.ErrFilesystemNotFound), http.StatusNotFound, true},
+		{"lvm", `{"mountpoint": "/"}`, fmt.Errorf("%w: / is on a device mapper", qemu.ErrGrowUnsupported), http.StatusUnprocessableEntity, true},
+		{"growpart failed", `{"mountpoint": "/"}`, fmt.Errorf("%w: growpart exited with 2", qemu.ErrGrowFailed), http.StatusUnprocessableEntity, true},
+		{"neither", `{}`, nil, http.StatusBadRequest, false},
+		{"both", `{"mountpoint": "/", "device": "/dev/vda1"}`, nil, http.StatusBadRequest, false},
+		{"relative mountpoint", `{"mountpoint": "srv"}`, nil, http.StatusBadRequest, false},
+		{"not a device", `{"device": "vda1"}`, nil, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			growFilesystem = func(ctx context.Context, vm, mountpoint, device string) (*qemu.GrowResult, error) {
+				called = true
+				return &qemu.GrowResult{Mountpoint: mountpoint, Steps: []qemu.GrowStep{}}, tt.err
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/grow-filesystem", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			GrowFilesystemHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("called = %v, want %v", called, tt.wantCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+const defaultGuestUpdateTimeout = 30 * time.Minute
+
+// defaultUpdateCommands maps the os-release ID reported by the guest agent to
+// the shell command upgrading all packages.
+var defaultUpdateCommands = map[string]string{
+	"debian":        "DEBIAN_FRONTEND=noninteractive apt-get update && DEBIAN_FRONTEND=noninteractive apt-get -y upgrade",
+	"ubuntu":        "DEBIAN_FRONTEND=noninteractive apt-get update && DEBIAN_FRONTEND=noninteractive apt-get -y upgrade",
+	"fedora":        "dnf -y upgrade",
+	"rhel":          "dnf -y upgrade",
+	"centos":        "dnf -y upgrade",
+	"rocky":         "dnf -y upgrade",
+	"almalinux":     "dnf -y upgrade",
+	"opensuse-leap": "zypper --non-interactive update",
+	"sles":          "zypper --non-interactive update",
+	"alpine":        "apk update && apk upgrade",
+	"arch":          "pacman -Syu --noconfirm",
+}
+
+var errUnsupportedGuestOS = errors.New("package updates are not supported for this guest OS")
+
+// updateCommands returns the default commands, overridden or extended by the
+// JSON object in GUEST_UPDATE_COMMANDS. An empty command disables an OS.
+func updateCommands() map[string]string {
+	commands := make(map[string]string, len(defaultUpdateCommands))
+	for id, cmd := range defaultUpdateCommands {
+		commands[id] = cmd
+	}
+
+	value := os.Getenv("GUEST_UPDATE_COMMANDS")
+	if value == "" {
+		return commands
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		log.Printf("invalid value for GUEST_UPDATE_COMMANDS, using the defaults: %v", err)
+		return commands
+	}
+	for id, cmd := range overrides {
+		if cmd == "" {
+			delete(commands, id)
+			continue
+		}
+		commands[id] = cmd
+	}
+	return commands
+}
+
+// updateCommandFor returns the update command for a guest OS.
+func updateCommandFor(osInfo *qemu.OSInfo) (string, error) {
+	cmd, ok := updateCommands()[osInfo.ID]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", errUnsupportedGuestOS, osInfo.ID)
+	}
+	return cmd, nil
+}
+
+// GuestUpdateHandler upgrades the packages inside the guest with the package
+// manager matching the OS reported by the guest agent
+func GuestUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	osInfo, err := qemu.GetOSInfo(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to detect the guest OS: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	command, err := updateCommandFor(osInfo)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// The commands chain several steps, so they need a shell
+	timeout := config.Seconds("GUEST_UPDATE_TIMEOUT", defaultGuestUpdateTimeout)
+	result, err := qemu.RunGuestCommand(r.Context(), vmID, "/bin/sh", []string{"-c", command}, nil, timeout)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to execute command: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   result.ExitCode == 0,
+		"os":        osInfo.ID,
+		"command":   command,
+		"exit_code": result.ExitCode,
+		"stdout":    result.Stdout,
+		"stderr":    result.Stderr,
+	}
+	status := http.StatusOK
+	if result.ExitCode != 0 {
+		response["error"] = "Package update failed inside the guest"
+		status = http.StatusUnprocessableEntity
+	}
+	utils.JSONResponse(w, response, status)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"libvirt-controller/internal/qemu"
+)
+
+func TestUpdateCommandFor(t *testing.T) {
+	t.Setenv("GUEST_UPDATE_COMMANDS", `{"fedora": "dnf -y --refresh upgrade", "arch": "", "nixos": "nixos-rebuild switch --upgrade"}`)
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr bool
+	}{
+		{id: "ubuntu", want: defaultUpdateCommands["ubuntu"]},
+		{id: "fedora", want: "dnf -y --refresh upgrade"},
+		{id: "nixos", want: "nixos-rebuild switch --upgrade"},
+		{id: "arch", wantErr: true},
+		{id: "mswindows", wantErr: true},
+		{id: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got, err := updateCommandFor(&qemu.OSInfo{ID: tt.id})
+			if tt.wantErr {
+				if !errors.Is(err, errUnsupportedGuestOS) {
+					t.Fatalf("expected errUnsupportedGuestOS, got %q, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
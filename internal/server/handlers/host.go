@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"libvirt-controller/internal/cmdutil"
+	"libvirt-controller/internal/config"
 	"libvirt-controller/internal/server/utils"
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -24,15 +28,68 @@ type DiskUsageStat struct {
 	MountPoint string `json:"mount_point"`
 	Used       uint64 `json:"disk_used"`
 	Total      uint64 `json:"disk_total"`
+	Error      string `json:"error,omitempty"`
+}
+
+// mountUsage reads the usage of a mount point; swapped out in tests.
+var mountUsage = disk.Usage
+
+// defaultMountUsageTimeout bounds each mount unless DISK_USAGE_TIMEOUT is set.
+const defaultMountUsageTimeout = 5 * time.Second
+
+// collectDiskUsage queries all mount points concurrently. A stale network
+// mount blocks statfs indefinitely, so a mount not answering within timeout
+// is reported with an error while its goroutine is left to finish on its own.
+// The result is in the order of mounts.
+func collectDiskUsage(mounts []string, timeout time.Duration) []DiskUsageStat {
+	stats := make([]DiskUsageStat, len(mounts))
+	var wg sync.WaitGroup
+	for i, mount := range mounts {
+		wg.Add(1)
+		go func(i int, mount string) {
+			defer wg.Done()
+			stats[i] = mountUsageWithTimeout(mount, timeout)
+		}(i, mount)
+	}
+	wg.Wait()
+	return stats
+}
+
+func mountUsageWithTimeout(mount string, timeout time.Duration) DiskUsageStat {
+	type result struct {
+		usage *disk.UsageStat
+		err   error
+	}
+	// Buffered so an abandoned lookup doesn't block forever once it returns
+	done := make(chan result, 1)
+	go func() {
+		usage, err := mountUsage(mount)
+		done <- result{usage, err}
+	}()
+
+	stat := DiskUsageStat{MountPoint: mount}
+	select {
+	case res := <-done:
+		if res.err != nil {
+			log.Printf("error getting disk stats for mount %s: %v", mount, res.err)
+			stat.Error = res.err.Error()
+			return stat
+		}
+		stat.Used = res.usage.Used
+		stat.Total = res.usage.Total
+	case <-time.After(timeout):
+		log.Printf("timed out getting disk stats for mount %s", mount)
+		stat.Error = fmt.Sprintf("timed out after %s", timeout)
+	}
+	return stat
 }
 
 // SystemStatsHandler handles system statistics retrieval with disk mount points
 func SystemStatsHandler(w http.ResponseWriter, r *http.Request) {
-	// Decode JSON request
+	// Decode and validate the JSON request
 	var req DiskStatsRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.JSONErrorResponse(w, "Invalid JSON request", http.StatusBadRequest)
-		log.Printf("error decoding request body: %v", err)
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
 
@@ -58,19 +115,7 @@ func SystemStatsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Collect disk usage for specified mount points
-	var diskUsageStats []DiskUsageStat
-	for _, mount := range req.MountPoints {
-		diskStats, err := disk.Usage(mount)
-		if err != nil {
-			log.Printf("error getting disk stats for mount %s: %v", mount, err)
-			continue
-		}
-		diskUsageStats = append(diskUsageStats, DiskUsageStat{
-			MountPoint: mount,
-			Used:       diskStats.Used,
-			Total:      diskStats.Total,
-		})
-	}
+	diskUsageStats := collectDiskUsage(req.MountPoints, config.Seconds("DISK_USAGE_TIMEOUT", defaultMountUsageTimeout))
 
 	stats := struct {
 		CPUUsage    []float64       `json:"cpu_usage"`
@@ -99,12 +144,18 @@ type HashPasswordRequest struct {
 	Password string `json:"password"`
 }
 
+func (req *HashPasswordRequest) Validate() error {
+	if req.Password == "" {
+		return utils.FieldError("password", "is required")
+	}
+	return nil
+}
+
 func HashPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	// Decode JSON request
+	// Decode and validate the JSON request
 	var req HashPasswordRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.JSONErrorResponse(w, "Invalid JSON request", http.StatusBadRequest)
-		log.Printf("error decoding request body: %v", err)
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
 
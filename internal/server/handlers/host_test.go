@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+func TestCollectDiskUsage(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	original := mountUsage
+	defer func() { mountUsage = original }()
+	mountUsage = func(path string) (*disk.UsageStat, error) {
+		switch path {
+		case "/mnt/stale-nfs":
+			<-release // Hangs like statfs on a dead NFS server
+			return nil, errors.New("stale file handle")
+		case "/missing":
+			return nil, errors.New("no such file or directory")
+		default:
+			return &disk.UsageStat{Path: path, Used: 10, Total: 100}, nil
+		}
+	}
+
+	mounts := []string{"/", "/mnt/stale-nfs", "/missing", "/var"}
+	start := time.Now()
+	stats := collectDiskUsage(mounts, 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("a hung mount stalled the collection for %s", elapsed)
+	}
+
+	if len(stats) != len(mounts) {
+		t.Fatalf("expected %d entries; got %d", len(mounts), len(stats))
+	}
+	for i, mount := range mounts {
+		if stats[i].MountPoint != mount {
+			t.Errorf("entry %d: expected mount %s; got %s", i, mount, stats[i].MountPoint)
+		}
+	}
+	for _, i := range []int{0, 3} {
+		if stats[i].Error != "" || stats[i].Total != 100 {
+			t.Errorf("%s: expected usage; got %+v", mounts[i], stats[i])
+		}
+	}
+	for _, i := range []int{1, 2} {
+		if stats[i].Error == "" {
+			t.Errorf("%s: expected an error; got %+v", mounts[i], stats[i])
+		}
+	}
+}
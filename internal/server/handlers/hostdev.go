@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/server/utils"
+)
+
+type HostDeviceRequest struct {
+	Address string `json:"address"` // PCI address, e.g. 0000:01:00.0
+	Live    bool   `json:"live"`    // Also apply to the running domain
+}
+
+func (req *HostDeviceRequest) Validate() error {
+	if req.Address == "" {
+		return utils.FieldError("address", "is required")
+	}
+	if _, err := domainxml.ParsePCIAddress(req.Address); err != nil {
+		return utils.FieldError("address", "%s", err)
+	}
+	return nil
+}
+
+// AttachHostDeviceHandler passes a host PCI device through to the domain
+func AttachHostDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	hostDeviceHandler(w, r, "attach", libvirt.AttachHostDevice)
+}
+
+// DetachHostDeviceHandler removes a passed through PCI device from the domain
+func DetachHostDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	hostDeviceHandler(w, r, "detach", libvirt.DetachHostDevice)
+}
+
+func hostDeviceHandler(w http.ResponseWriter, r *http.Request, action string, apply func(context.Context, string, string, bool) (string, error)) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req HostDeviceRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if _, err := apply(r.Context(), vmID, req.Address, req.Live); err != nil {
+		if errors.Is(err, libvirt.ErrHostDeviceNotReady) {
+			utils.JSONErrorResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		libvirtErrorResponse(w, fmt.Sprintf("Failed to %s host device", action), err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"address": req.Address,
+		"live":    req.Live,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// ListHostDevicesHandler lists the host PCI devices with their driver and
+// IOMMU group, to pick devices for passthrough
+func ListHostDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	capability := r.URL.Query().Get("cap")
+	if capability == "" {
+		capability = "pci"
+	}
+	// Only the PCI capability is parsed into structured fields
+	if capability != "pci" {
+		utils.JSONErrorResponse(w, "'cap' must be pci", http.StatusBadRequest)
+		return
+	}
+
+	devices, err := libvirt.ListNodeDevices(r.Context(), capability)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list host devices", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"devices": devices,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
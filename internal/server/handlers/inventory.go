@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/server/utils"
+)
+
+// InventoryDomain is the reconciliation view of one domain. It is a subset of
+// DomainDescription that can be collected for all domains in batched calls.
+type InventoryDomain struct {
+	ID       string                `json:"id"`
+	Info     *DomainInfo           `json:"info"`
+	Disks    []libvirt.BlockDevice `json:"disks"`
+	Metadata *metadata.Metadata    `json:"metadata"`
+	Errors   map[string]string     `json:"errors,omitempty"`
+}
+
+// Libvirt calls of the inventory besides listDomains; swapped out in tests.
+var (
+	listAutostartDomains = libvirt.ListAutostartDomains
+	domainStats          = libvirt.GetDomainStats
+)
+
+// buildInventory assembles the inventory from a single list, autostart and
+// domstats call, plus the metadata files stored in the storage classes.
+func buildInventory(ctx context.Context, includeInactive bool) ([]InventoryDomain, error) {
+	names, err := listDomains(ctx, includeInactive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	autostartNames, err := listAutostartDomains(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list autostart domains: %w", err)
+	}
+	stats, err := domainStats(ctx, includeInactive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain stats: %w", err)
+	}
+
+	autostart := make(map[string]bool, len(autostartNames))
+	for _, name := range autostartNames {
+		autostart[name] = true
+	}
+
+	inventory := make([]InventoryDomain, 0, len(names))
+	for _, name := range names {
+		entry := InventoryDomain{ID: name}
+		errors := make(map[string]string)
+
+		if fields, ok := stats[name]; ok {
+			state, _ := strconv.Atoi(fields["state.state"])
+			vcpus, _ := strconv.Atoi(fields["vcpu.current"])
+			memory, _ := strconv.ParseUint(fields["balloon.current"], 10, 64)
+			maxMemory, _ := strconv.ParseUint(fields["balloon.maximum"], 10, 64)
+			entry.Info = &DomainInfo{
+				Status:    libvirt.StateName(state),
+				Autostart: autostart[name],
+				DomainResources: helpers.DomainResources{
+					VCPUs:        vcpus,
+					MemoryKiB:    memory,
+					MaxMemoryKiB: maxMemory,
+				},
+			}
+			entry.Disks = libvirt.StatsBlockDevices(fields)
+		} else {
+			// The domain was undefined or stopped between list and domstats
+			errors["info"] = "no statistics reported"
+			errors["disks"] = "no statistics reported"
+		}
+
+		// Domains defined outside the controller have no directory
+		vmDir, _, err := filesystem.FindVMDir(name)
+		if err != nil {
+			errors["metadata"] = err.Error()
+		} else if vmDir != "" {
+			m, err := metadata.Load(vmDir)
+			if err != nil {
+				errors["metadata"] = err.Error()
+			}
+			entry.Metadata = m
+		}
+
+		if len(errors) > 0 {
+			entry.Errors = errors
+		}
+		inventory = append(inventory, entry)
+	}
+	return inventory, nil
+}
+
+// InventoryHandler returns every domain on the host with its state, resources,
+// disks and metadata, so that a reconciler can diff it against desired state
+func InventoryHandler(w http.ResponseWriter, r *http.Request) {
+	if len(filesystem.StorageClasses()) == 0 {
+		utils.JSONErrorResponse(w, "DEFINITIONS_DIR environment variable not set", http.StatusInternalServerError)
+		return
+	}
+
+	includeInactive := false
+	if value := r.URL.Query().Get("includeInactive"); value != "" {
+		var err error
+		includeInactive, err = strconv.ParseBool(value)
+		if err != nil {
+			utils.JSONErrorResponse(w, "Invalid 'includeInactive' parameter, must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	inventory, err := buildInventory(r.Context(), includeInactive)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"domains": inventory,
+		"count":   len(inventory),
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"libvirt-controller/internal/metadata"
+)
+
+func TestBuildInventory(t *testing.T) {
+	originalList, originalAutostart, originalStats := listDomains, listAutostartDomains, domainStats
+	defer func() {
+		listDomains, listAutostartDomains, domainStats = originalList, originalAutostart, originalStats
+	}()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		return []string{"vm-1", "vm-2"}, nil
+	}
+	listAutostartDomains = func(ctx context.Context) ([]string, error) { return []string{"vm-1"}, nil }
+	// vm-2 was undefined between the list and the domstats call, vm-3 was
+	// defined meanwhile
+	domainStats = func(ctx context.Context, includeInactive bool) (map[string]map[string]string, error) {
+		return map[string]map[string]string{
+			"vm-1": {
+				"state.state":     "1",
+				"vcpu.current":    "2",
+				"balloon.current": "1048576",
+				"balloon.maximum": "2097152",
+				"block.count":     "1",
+				"block.0.name":    "vda",
+				"block.0.path":    "/data/vm/vm-1/root.qcow2",
+			},
+			"vm-3": {"state.state": "5"},
+		}, nil
+	}
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	if err := os.MkdirAll(filepath.Join(dir, "vm-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.Save(filepath.Join(dir, "vm-1"), &metadata.Metadata{Labels: map[string]string{"team": "infra"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	inventory, err := buildInventory(context.Background(), true)
+	if err != nil {
+		t.Fatalf("buildInventory() error = %v", err)
+	}
+	if len(inventory) != 2 {
+		t.Fatalf("inventory has %d domains, want the 2 listed: %+v", len(inventory), inventory)
+	}
+
+	vm1 := inventory[0]
+	if vm1.ID != "vm-1" || vm1.Info == nil || vm1.Info.Status != "running" || !vm1.Info.Autostart ||
+		vm1.Info.VCPUs != 2 || vm1.Info.MemoryKiB != 1048576 || vm1.Info.MaxMemoryKiB != 2097152 {
+		t.Errorf("vm-1 = %+v, info %+v", vm1, vm1.Info)
+	}
+	if len(vm1.Disks) != 1 || vm1.Disks[0].Target != "vda" || vm1.Disks[0].Source != "/data/vm/vm-1/root.qcow2" {
+		t.Errorf("vm-1 disks = %+v", vm1.Disks)
+	}
+	if vm1.Metadata == nil || vm1.Metadata.Labels["team"] != "infra" || vm1.Errors != nil {
+		t.Errorf("vm-1 metadata = %+v, errors %v", vm1.Metadata, vm1.Errors)
+	}
+
+	vm2 := inventory[1]
+	if vm2.ID != "vm-2" || vm2.Info != nil || vm2.Errors["info"] != "no statistics reported" || vm2.Errors["disks"] == "" {
+		t.Errorf("vanished vm-2 = %+v, want errors for info and disks", vm2)
+	}
+	if vm2.Metadata != nil {
+		t.Errorf("vm-2 has no directory, got metadata %+v", vm2.Metadata)
+	}
+}
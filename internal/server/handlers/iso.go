@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/server/utils"
+)
+
+// ISO builder calls; swapped out in tests.
+var (
+	checkISOTool = helpers.CheckISOTool
+	buildISO     = helpers.BuildISO
+)
+
+// maxVolumeIDLength is the longest ISO 9660 volume ID.
+const maxVolumeIDLength = 32
+
+type CreateISORequest struct {
+	Path      string    `json:"path"`                // ISO to create
+	VolumeID  string    `json:"volume_id"`           // Label, e.g. "cidata" or "OEMDRV"
+	Files     []ISOFile `json:"files"`               // Contents of the ISO
+	Overwrite bool      `json:"overwrite,omitempty"` // Replace an existing file at path
+}
+
+// ISOFile is a file put on the ISO, given inline or taken from the host.
+type ISOFile struct {
+	Name    string `json:"name"`              // Path on the ISO, e.g. "drivers/setup.inf"
+	Content string `json:"content,omitempty"` // Text of the file
+	Source  string `json:"source,omitempty"`  // Host file or directory to copy instead
+}
+
+func (req *CreateISORequest) Validate() error {
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	if !filepath.IsAbs(req.Path) {
+		return utils.FieldError("path", "must be an absolute path")
+	}
+	if !inStorageClass(req.Path) {
+		return utils.FieldError("path", "must be inside DEFINITIONS_DIR or a storage class directory")
+	}
+	if req.VolumeID == "" {
+		return utils.FieldError("volume_id", "is required")
+	}
+	if len(req.VolumeID) > maxVolumeIDLength {
+		return utils.FieldError("volume_id", "must be at most %d characters", maxVolumeIDLength)
+	}
+	if strings.ContainsFunc(req.VolumeID, func(r rune) bool { return r < ' ' || r > '~' }) {
+		return utils.FieldError("volume_id", "must be printable ASCII")
+	}
+	if len(req.Files) == 0 {
+		return utils.FieldError("files", "is required")
+	}
+
+	names := make(map[string]bool)
+	for i, f := range req.Files {
+		field := fmt.Sprintf("files[%d]", i)
+		// '=' and '\' are special in genisoimage graft points
+		if f.Name == "" || path.IsAbs(f.Name) || path.Clean(f.Name) != f.Name || strings.HasPrefix(f.Name, "../") || f.Name == ".." || strings.ContainsAny(f.Name, "=\\") {
+			return utils.FieldError(field+".name", "must be a relative path without '..', '=' or '\\'")
+		}
+		// genisoimage would take a leading '-' for an option
+		if strings.HasPrefix(f.Name, "-") {
+			return utils.FieldError(field+".name", "must not start with '-'")
+		}
+		if names[f.Name] {
+			return utils.FieldError(field+".name", "%q is used more than once", f.Name)
+		}
+		names[f.Name] = true
+		if (f.Content == "") == (f.Source == "") {
+			return utils.FieldError(field, "needs either content or source")
+		}
+		if f.Source != "" && !filepath.IsAbs(f.Source) {
+			return utils.FieldError(field+".source", "must be an absolute path")
+		}
+		// Symlinks are resolved so a source can't point outside of the classes
+		if f.Source != "" {
+			if resolved, err := filepath.EvalSymlinks(f.Source); err == nil && !filesystem.InStorageClass(resolved) {
+				return utils.FieldError(field+".source", "must be inside DEFINITIONS_DIR or a storage class directory")
+			}
+		}
+	}
+	return nil
+}
+
+// inStorageClass is filesystem.InStorageClass for a path whose directories
+// may not exist yet, they are created inside the class.
+func inStorageClass(p string) bool {
+	p = filepath.Clean(p)
+	for {
+		dir := filepath.Dir(p)
+		if dir == p || filesystem.FileExists(dir) {
+			return filesystem.InStorageClass(p)
+		}
+		p = dir
+	}
+}
+
+// CreateISOHandler builds an ISO from inline files and host files, e.g. a
+// driver disk for an installer
+func CreateISOHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req CreateISORequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if err := checkISOTool(); err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if filesystem.FileExists(req.Path) && !req.Overwrite {
+		utils.JSONErrorResponse(w, fmt.Sprintf("%s already exists, set 'overwrite' to replace it", req.Path), http.StatusConflict)
+		return
+	}
+	for i, f := range req.Files {
+		if f.Source != "" && !filesystem.FileExists(f.Source) {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Source of files[%d] %s does not exist", i, f.Source), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Inline files are staged in a temporary directory for genisoimage
+	staging, err := os.MkdirTemp("", "iso-*")
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to stage ISO files: %s", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	var graftPoints []string
+	for i, f := range req.Files {
+		source := f.Source
+		if source == "" {
+			source = filepath.Join(staging, fmt.Sprintf("file-%d", i))
+			if err := os.WriteFile(source, []byte(f.Content), 0600); err != nil {
+				utils.JSONErrorResponse(w, fmt.Sprintf("Failed to stage ISO files: %s", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		graftPoints = append(graftPoints, f.Name+"="+source)
+	}
+
+	if err := filesystem.CreateDirectory(filepath.Dir(req.Path), 0755); err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to create ISO directory: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if err := buildISO(r.Context(), req.Path, req.VolumeID, graftPoints); err != nil {
+		log.Printf("Failed to build ISO %s: %v", req.Path, err)
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to build ISO: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"path":      req.Path,
+		"volume_id": req.VolumeID,
+		"files":     len(req.Files),
+	}
+	utils.JSONResponse(w, response, http.StatusCreated)
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateISO(t *testing.T) {
+	originalCheck, originalBuild := checkISOTool, buildISO
+	defer func() { checkISOTool, buildISO = originalCheck, originalBuild }()
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	source := filepath.Join(dir, "viostor.inf")
+	if err := os.WriteFile(source, []byte("driver"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	isoPath := filepath.Join(dir, "isos", "drivers.iso")
+
+	checkISOTool = func() error { return nil }
+	var gotVolumeID string
+	var gotContent []string
+	buildISO = func(ctx context.Context, outputPath string, volID string, files []string) error {
+		gotVolumeID = volID
+		for _, graft := range files {
+			name, file, _ := strings.Cut(graft, "=")
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotContent = append(gotContent, name+":"+string(data))
+		}
+		return os.WriteFile(outputPath, []byte("iso"), 0644)
+	}
+
+	body := `{"path": "` + isoPath + `", "volume_id": "OEMDRV", "files": [
+		{"name": "ks.cfg", "content": "text\n"},
+		{"name": "drivers/viostor.inf", "source": "` + source + `"}
+	]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/disk/iso", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	CreateISOHandler(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if gotVolumeID != "OEMDRV" {
+		t.Errorf("volume ID = %q, want OEMDRV", gotVolumeID)
+	}
+	if want := "ks.cfg:text\n,drivers/viostor.inf:driver"; strings.Join(gotContent, ",") != want {
+		t.Errorf("files = %q, want %q", gotContent, want)
+	}
+
+	// The ISO exists now
+	req = httptest.NewRequest(http.MethodPost, "/v1/disk/iso", strings.NewReader(body))
+	rec = httptest.NewRecorder()
+	CreateISOHandler(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d without overwrite, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestCreateISORequestValidate(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	outside := t.TempDir()
+	for _, name := range []string{filepath.Join(dir, "a.inf"), filepath.Join(outside, "b.inf")} {
+		if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "b.inf"), filepath.Join(dir, "link.inf")); err != nil {
+		t.Fatal(err)
+	}
+	iso := filepath.Join(dir, "isos", "a.iso")
+	source := func(path string) []ISOFile { return []ISOFile{{Name: "a", Source: path}} }
+	file := func(name string) []ISOFile { return []ISOFile{{Name: name, Content: "x"}} }
+	tests := []struct {
+		name  string
+		req   CreateISORequest
+		valid bool
+	}{
+		{"valid", CreateISORequest{Path: iso, VolumeID: "cidata", Files: file("dir/a.txt")}, true},
+		{"relative path", CreateISORequest{Path: "a.iso", VolumeID: "cidata", Files: file("a")}, false},
+		{"no volume id", CreateISORequest{Path: iso, Files: file("a")}, false},
+		{"long volume id", CreateISORequest{Path: iso, VolumeID: strings.Repeat("v", 33), Files: file("a")}, false},
+		{"no files", CreateISORequest{Path: iso, VolumeID: "cidata"}, false},
+		{"escaping name", CreateISORequest{Path: iso, VolumeID: "cidata", Files: file("../a")}, false},
+		{"absolute name", CreateISORequest{Path: iso, VolumeID: "cidata", Files: file("/a")}, false},
+		{"graft point name", CreateISORequest{Path: iso, VolumeID: "cidata", Files: file("a=/etc/shadow")}, false},
+		{"content and source", CreateISORequest{Path: iso, VolumeID: "cidata", Files: []ISOFile{{Name: "a", Content: "x", Source: "/b"}}}, false},
+		{"duplicate name", CreateISORequest{Path: iso, VolumeID: "cidata", Files: append(file("a"), file("a")...)}, false},
+		{"outside storage classes", CreateISORequest{Path: filepath.Join(outside, "a.iso"), VolumeID: "cidata", Files: file("a")}, false},
+		{"escaping path", CreateISORequest{Path: dir + "/../etc/a.iso", VolumeID: "cidata", Files: file("a")}, false},
+		{"option name", CreateISORequest{Path: iso, VolumeID: "cidata", Files: file("-output")}, false},
+		{"source", CreateISORequest{Path: iso, VolumeID: "cidata", Files: source(filepath.Join(dir, "a.inf"))}, true},
+		{"source outside storage classes", CreateISORequest{Path: iso, VolumeID: "cidata", Files: source(filepath.Join(outside, "b.inf"))}, false},
+		{"source symlink leaving storage classes", CreateISORequest{Path: iso, VolumeID: "cidata", Files: source(filepath.Join(dir, "link.inf"))}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
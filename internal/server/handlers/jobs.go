@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"libvirt-controller/internal/jobs"
+	"libvirt-controller/internal/quota"
+	"libvirt-controller/internal/server/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ListJobsHandler returns the background jobs known to the controller, only
+// their own to tenants
+func ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	list := jobs.Default.List()
+	if tenant := quota.TenantFrom(r.Context()); tenant != "" {
+		owned := make([]jobs.Job, 0, len(list))
+		for _, job := range list {
+			if job.Tenant == tenant {
+				owned = append(owned, job)
+			}
+		}
+		list = owned
+	}
+	utils.JSONResponse(w, list, http.StatusOK)
+}
+
+// GetJobHandler returns the state of a single background job
+func GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
+
+	// The jobs of other tenants don't exist for the caller
+	job, ok := jobs.Default.Get(jobID)
+	if tenant := quota.TenantFrom(r.Context()); ok && tenant != "" && job.Tenant != tenant {
+		ok = false
+	}
+	if !ok {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Job '%s' not found", jobID), http.StatusNotFound)
+		return
+	}
+	utils.JSONResponse(w, job, http.StatusOK)
+}
+
+// acceptedJobResponse reports a newly started job to the caller
+func acceptedJobResponse(w http.ResponseWriter, job jobs.Job) {
+	response := map[string]interface{}{
+		"success": true,
+		"job":     job,
+		"poll":    "/v1/jobs/" + job.ID,
+	}
+	utils.JSONResponse(w, response, http.StatusAccepted)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"libvirt-controller/internal/jobs"
+	"libvirt-controller/internal/quota"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestJobsAreScopedToTenant(t *testing.T) {
+	original := jobs.Default
+	defer func() { jobs.Default = original }()
+	jobs.Default = jobs.NewStore(jobs.DefaultTTL)
+
+	noop := func(ctx context.Context, report jobs.Reporter) error { return nil }
+	own := jobs.Default.Start(quota.WithTenant(context.Background(), "acme"), "migrate", "vm-1", noop)
+	other := jobs.Default.Start(quota.WithTenant(context.Background(), "globex"), "migrate", "vm-2", noop)
+	jobs.Default.Start(context.Background(), "shutdown-all", "", noop)
+
+	tests := []struct {
+		tenant   string
+		wantJobs int
+	}{
+		{"acme", 1},
+		{"", 3},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs/", nil)
+		if tt.tenant != "" {
+			req = req.WithContext(quota.WithTenant(req.Context(), tt.tenant))
+		}
+		rec := httptest.NewRecorder()
+		ListJobsHandler(rec, req)
+		var list []jobs.Job
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != tt.wantJobs {
+			t.Errorf("tenant %q: listed %d jobs, want %d", tt.tenant, len(list), tt.wantJobs)
+		}
+	}
+
+	for id, wantStatus := range map[string]int{own.ID: http.StatusOK, other.ID: http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/jobs/"+id, nil)
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("jobID", id)
+		ctx := context.WithValue(quota.WithTenant(req.Context(), "acme"), chi.RouteCtxKey, routeCtx)
+		rec := httptest.NewRecorder()
+		GetJobHandler(rec, req.WithContext(ctx))
+		if rec.Code != wantStatus {
+			t.Errorf("job %s: status = %d, want %d", id, rec.Code, wantStatus)
+		}
+	}
+}
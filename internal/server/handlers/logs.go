@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/server/utils"
+)
+
+const (
+	defaultLogLines  = 100
+	maxLogLines      = 10000
+	logPollInterval  = 500 * time.Millisecond
+	defaultLogDir    = "/var/log/libvirt/qemu"
+	logDirEnvVarName = "LIBVIRT_LOG_DIR"
+)
+
+// domainNamePattern matches names that are safe to use as a file name.
+var domainNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+-]*$`)
+
+// domainLogPath returns the qemu log file of a domain, refusing names that
+// could escape the log directory.
+func domainLogPath(vmID string) (string, error) {
+	if !domainNamePattern.MatchString(vmID) || vmID == "." || vmID == ".." {
+		return "", fmt.Errorf("invalid domain name '%s'", vmID)
+	}
+
+	logDir := os.Getenv(logDirEnvVarName)
+	if logDir == "" {
+		logDir = defaultLogDir
+	}
+	path := filepath.Join(logDir, vmID+".log")
+	if filepath.Dir(path) != filepath.Clean(logDir) {
+		return "", fmt.Errorf("invalid domain name '%s'", vmID)
+	}
+	return path, nil
+}
+
+// DomainLogsHandler returns the tail of the domain's qemu log, optionally
+// following new output as server-sent events
+func DomainLogsHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	lines := defaultLogLines
+	if value := r.URL.Query().Get("lines"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxLogLines {
+			utils.JSONErrorResponse(w, fmt.Sprintf("'lines' must be between 0 and %d", maxLogLines), http.StatusBadRequest)
+			return
+		}
+		lines = n
+	}
+
+	path, err := domainLogPath(vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tail, offset, err := filesystem.TailLines(path, lines)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			utils.JSONErrorResponse(w, fmt.Sprintf("No log file found for domain '%s'", vmID), http.StatusNotFound)
+			return
+		}
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read log: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("follow") != "true" {
+		response := map[string]interface{}{
+			"id":    vmID,
+			"path":  path,
+			"lines": tail,
+		}
+		utils.JSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	followLog(w, r, path, tail, offset)
+}
+
+// followLog streams the existing tail and then every new line as SSE events
+// until the client disconnects.
+func followLog(w http.ResponseWriter, r *http.Request, path string, tail []string, offset int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.JSONErrorResponse(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// A log stream outlives the server's write timeout by design
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, line := range tail {
+		fmt.Fprintf(w, "data: %s\n\n", line)
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+
+	var partial string
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.Size() < offset {
+			// The log was truncated or rotated, start over from the beginning
+			offset = 0
+			partial = ""
+		}
+		if info.Size() == offset {
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			continue
+		}
+		reader := bufio.NewReader(f)
+		for {
+			chunk, err := reader.ReadString('\n')
+			offset += int64(len(chunk))
+			if err != nil {
+				// Keep an unterminated line until the rest of it is written
+				partial += chunk
+				break
+			}
+			fmt.Fprintf(w, "data: %s\n\n", partial+chunk[:len(chunk)-1])
+			partial = ""
+		}
+		f.Close()
+		flusher.Flush()
+	}
+}
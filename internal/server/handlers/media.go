@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/server/utils"
+)
+
+// Libvirt calls; swapped out in tests.
+var (
+	listBlockDevices = libvirt.ListBlockDevices
+	ejectMedia       = libvirt.EjectMedia
+	insertMedia      = libvirt.InsertMedia
+	loadMedia        = libvirt.LoadMedia
+	unloadMedia      = libvirt.UnloadMedia
+	attachCDROM      = libvirt.AttachCDROM
+	detachDrive      = libvirt.DetachDrive
+	domainState      = libvirt.GetDomainState
+)
+
+// emptyMediaSource is the source domblklist reports for an empty drive.
+const emptyMediaSource = "-"
+
+// findCloudInitDrive returns the CD-ROM drive holding isoPath, or else the
+// first CD-ROM drive, which is where the domain builder puts the ISO. ok is
+// false when the domain has no CD-ROM drive at all.
+func findCloudInitDrive(devices []libvirt.BlockDevice, isoPath string) (drive libvirt.BlockDevice, ok bool) {
+	for _, d := range devices {
+		if d.Device == "cdrom" && d.Source == isoPath {
+			return d, true
+		}
+	}
+	for _, d := range devices {
+		if d.Device == "cdrom" {
+			return d, true
+		}
+	}
+	return libvirt.BlockDevice{}, false
+}
+
+// EjectCloudInitHandler removes the cloud-init ISO from the running domain
+func EjectCloudInitHandler(w http.ResponseWriter, r *http.Request) {
+	cloudInitMediaHandler(w, r, "eject", func(ctx context.Context, vmID string, target string, isoPath string) (string, error) {
+		return ejectMedia(ctx, vmID, target)
+	})
+}
+
+// InsertCloudInitHandler loads the current cloud-init ISO into the running
+// domain, so the guest can re-read an updated configuration without a reboot
+func InsertCloudInitHandler(w http.ResponseWriter, r *http.Request) {
+	cloudInitMediaHandler(w, r, "insert", insertMedia)
+}
+
+func cloudInitMediaHandler(w http.ResponseWriter, r *http.Request, action string, apply func(context.Context, string, string, string) (string, error)) {
+	vmID := helpers.MustGetVMID(r.Context())
+	vmDir := helpers.MustGetVMDir(r.Context())
+	isoPath := filepath.Join(vmDir, "cloud-init.iso")
+
+	devices, err := listBlockDevices(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list block devices", err)
+		return
+	}
+	drive, ok := findCloudInitDrive(devices, isoPath)
+	if !ok {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Domain '%s' has no CD-ROM device", vmID), http.StatusConflict)
+		return
+	}
+
+	if _, err := apply(r.Context(), vmID, drive.Target, isoPath); err != nil {
+		libvirtErrorResponse(w, fmt.Sprintf("Failed to %s cloud-init ISO on %s", action, drive.Target), err)
+		return
+	}
+
+	// Read the drive back to report the media state libvirt ended up with
+	media := "unknown"
+	devices, err = listBlockDevices(r.Context(), vmID)
+	if err == nil {
+		for _, d := range devices {
+			if d.Target == drive.Target {
+				drive = d
+				media = "inserted"
+				if d.Source == emptyMediaSource {
+					media = "ejected"
+				}
+			}
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"target":  drive.Target,
+		"source":  drive.Source,
+		"media":   media,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+type AttachISORequest struct {
+	Path   string `json:"path"`             // ISO image on the host
+	Target string `json:"target,omitempty"` // CD-ROM drive to load, defaults to the first empty one
+}
+
+func (req *AttachISORequest) Validate() error {
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	if !filepath.IsAbs(req.Path) {
+		return utils.FieldError("path", "must be an absolute path")
+	}
+	if !filesystem.InStorageClass(req.Path) {
+		return utils.FieldError("path", "must be inside DEFINITIONS_DIR or a storage class directory")
+	}
+	info, err := os.Stat(req.Path)
+	if err != nil {
+		return utils.FieldError("path", "%s does not exist", req.Path)
+	}
+	if !info.Mode().IsRegular() {
+		return utils.FieldError("path", "%s is not a file", req.Path)
+	}
+	return nil
+}
+
+// AttachISOHandler loads an ISO into a CD-ROM drive of the domain, e.g. an
+// installer. An empty drive is used when there is one, otherwise a new drive
+// is added, which a running domain only sees after a restart
+func AttachISOHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req AttachISORequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	devices, err := listBlockDevices(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list block devices", err)
+		return
+	}
+
+	var drive *libvirt.BlockDevice
+	for i, d := range devices {
+		if d.Device != "cdrom" {
+			continue
+		}
+		if (req.Target == "" && d.Source == emptyMediaSource) || d.Target == req.Target {
+			drive = &devices[i]
+			break
+		}
+	}
+
+	if drive != nil {
+		if drive.Source != emptyMediaSource {
+			utils.JSONErrorResponse(w, fmt.Sprintf("CD-ROM %s already holds %s, detach it first", drive.Target, drive.Source), http.StatusConflict)
+			return
+		}
+		if _, err := loadMedia(r.Context(), vmID, drive.Target, req.Path); err != nil {
+			libvirtErrorResponse(w, fmt.Sprintf("Failed to load ISO into %s", drive.Target), err)
+			return
+		}
+		response := map[string]interface{}{
+			"success":          true,
+			"target":           drive.Target,
+			"source":           req.Path,
+			"restart_required": false,
+		}
+		utils.JSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	if req.Target != "" {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("target", "%s is not a CD-ROM drive of %s", req.Target, vmID))
+		return
+	}
+
+	target, err := nextFreeTarget(devices, "sd")
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if _, err := attachCDROM(r.Context(), vmID, target, req.Path); err != nil {
+		libvirtErrorResponse(w, "Failed to attach CD-ROM", err)
+		return
+	}
+	state, err := domainState(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get domain state", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":          true,
+		"target":           target,
+		"source":           req.Path,
+		"restart_required": state != libvirt.StateShutOff,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+type DetachISORequest struct {
+	Target string `json:"target"`           // CD-ROM drive to empty
+	Remove bool   `json:"remove,omitempty"` // Also remove the drive from the definition
+}
+
+func (req *DetachISORequest) Validate() error {
+	if req.Target == "" {
+		return utils.FieldError("target", "is required")
+	}
+	return nil
+}
+
+// DetachISOHandler ejects the ISO from a CD-ROM drive of the domain and
+// optionally removes the drive
+func DetachISOHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req DetachISORequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	devices, err := listBlockDevices(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list block devices", err)
+		return
+	}
+	var drive *libvirt.BlockDevice
+	for i, d := range devices {
+		if d.Device == "cdrom" && d.Target == req.Target {
+			drive = &devices[i]
+		}
+	}
+	if drive == nil {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("target", "%s is not a CD-ROM drive of %s", req.Target, vmID))
+		return
+	}
+
+	if drive.Source != emptyMediaSource {
+		if _, err := unloadMedia(r.Context(), vmID, drive.Target); err != nil {
+			libvirtErrorResponse(w, fmt.Sprintf("Failed to eject ISO from %s", drive.Target), err)
+			return
+		}
+	}
+	if req.Remove {
+		if _, err := detachDrive(r.Context(), vmID, drive.Target); err != nil {
+			libvirtErrorResponse(w, fmt.Sprintf("Failed to remove CD-ROM %s", drive.Target), err)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"target":  drive.Target,
+		"ejected": drive.Source,
+		"removed": req.Remove,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// nextFreeTarget returns the first target name with prefix, such as sdb,
+// not used by any of devices.
+func nextFreeTarget(devices []libvirt.BlockDevice, prefix string) (string, error) {
+	used := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		used[d.Target] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		if target := prefix + string(c); !used[target] {
+			return target, nil
+		}
+	}
+	return "", fmt.Errorf("all %sa to %sz targets are in use", prefix, prefix)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+)
+
+func TestFindCloudInitDrive(t *testing.T) {
+	disk := libvirt.BlockDevice{Type: "file", Device: "disk", Target: "vda", Source: "/data/vm-1/disk.qcow2"}
+	installer := libvirt.BlockDevice{Type: "file", Device: "cdrom", Target: "sda", Source: "/data/iso/debian.iso"}
+	cloudInit := libvirt.BlockDevice{Type: "file", Device: "cdrom", Target: "sdb", Source: "/data/vm-1/cloud-init.iso"}
+	ejected := libvirt.BlockDevice{Type: "file", Device: "cdrom", Target: "sdb", Source: "-"}
+
+	tests := []struct {
+		name    string
+		devices []libvirt.BlockDevice
+		want    string
+		wantOK  bool
+	}{
+		{"drive holding the ISO", []libvirt.BlockDevice{disk, installer, cloudInit}, "sdb", true},
+		{"ejected drive", []libvirt.BlockDevice{disk, ejected}, "sdb", true},
+		{"first drive without a match", []libvirt.BlockDevice{disk, installer, ejected}, "sda", true},
+		{"no cdrom", []libvirt.BlockDevice{disk}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := findCloudInitDrive(tt.devices, "/data/vm-1/cloud-init.iso")
+			if ok != tt.wantOK || got.Target != tt.want {
+				t.Errorf("findCloudInitDrive() = %q, %t; want %q, %t", got.Target, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEjectCloudInitWithoutCDROM(t *testing.T) {
+	original := listBlockDevices
+	defer func() { listBlockDevices = original }()
+	listBlockDevices = func(ctx context.Context, domain string) ([]libvirt.BlockDevice, error) {
+		return []libvirt.BlockDevice{{Type: "file", Device: "disk", Target: "vda", Source: "/data/vm-1/disk.qcow2"}}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/cloud-init/eject", nil)
+	ctx := context.WithValue(req.Context(), helpers.VMIDKey, "vm-1")
+	req = req.WithContext(context.WithValue(ctx, helpers.VMDirKey, "/data/vm-1"))
+	rec := httptest.NewRecorder()
+	EjectCloudInitHandler(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+}
+
+func TestAttachISO(t *testing.T) {
+	originalList, originalLoad, originalAttach, originalState := listBlockDevices, loadMedia, attachCDROM, domainState
+	defer func() {
+		listBlockDevices, loadMedia, attachCDROM, domainState = originalList, originalLoad, originalAttach, originalState
+	}()
+
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	iso := filepath.Join(dir, "debian.iso")
+	outside := filepath.Join(t.TempDir(), "debian.iso")
+	for _, path := range []string{iso, outside} {
+		if err := os.WriteFile(path, []byte("iso"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var full []libvirt.BlockDevice
+	for c := 'a'; c <= 'z'; c++ {
+		full = append(full, libvirt.BlockDevice{Type: "file", Device: "disk", Target: "sd" + string(c)})
+	}
+	disk := libvirt.BlockDevice{Type: "file", Device: "disk", Target: "sda", Source: "/data/vm-1/disk.qcow2"}
+	cloudInit := libvirt.BlockDevice{Type: "file", Device: "cdrom", Target: "sdb", Source: "/data/vm-1/cloud-init.iso"}
+	empty := libvirt.BlockDevice{Type: "file", Device: "cdrom", Target: "sdc", Source: "-"}
+
+	tests := []struct {
+		name        string
+		body        string
+		devices     []libvirt.BlockDevice
+		wantStatus  int
+		wantLoaded  string
+		wantAdded   string
+		wantRestart bool
+	}{
+		{"empty drive", `{"path": "` + iso + `"}`, []libvirt.BlockDevice{disk, cloudInit, empty}, http.StatusOK, "sdc", "", false},
+		{"new drive", `{"path": "` + iso + `"}`, []libvirt.BlockDevice{disk, cloudInit}, http.StatusOK, "", "sdc", true},
+		{"occupied target", `{"path": "` + iso + `", "target": "sdb"}`, []libvirt.BlockDevice{disk, cloudInit}, http.StatusConflict, "", "", false},
+		{"missing ISO", `{"path": "` + dir + `/nonexistent.iso"}`, []libvirt.BlockDevice{disk}, http.StatusBadRequest, "", "", false},
+		{"outside storage classes", `{"path": "` + outside + `"}`, []libvirt.BlockDevice{disk}, http.StatusBadRequest, "", "", false},
+		{"no free target", `{"path": "` + iso + `"}`, full, http.StatusConflict, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var loaded, added string
+			listBlockDevices = func(ctx context.Context, domain string) ([]libvirt.BlockDevice, error) {
+				return tt.devices, nil
+			}
+			loadMedia = func(ctx context.Context, domain, target, source string) (string, error) {
+				loaded = target
+				return "", nil
+			}
+			attachCDROM = func(ctx context.Context, domain, target, source string) (string, error) {
+				added = target
+				return "", nil
+			}
+			domainState = func(ctx context.Context, domain string) (libvirt.DomainState, error) {
+				return libvirt.StateRunning, nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/cdrom/attach", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			AttachISOHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if loaded != tt.wantLoaded || added != tt.wantAdded {
+				t.Errorf("loaded %q and added %q, want %q and %q", loaded, added, tt.wantLoaded, tt.wantAdded)
+			}
+			if tt.wantStatus == http.StatusOK && strings.Contains(rec.Body.String(), `"restart_required":true`) != tt.wantRestart {
+				t.Errorf("restart_required wrong in %s", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/lifecycle"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/server/utils"
+)
+
+// GetMetadataHandler returns the controller-side metadata of a domain
+func GetMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	m, err := metadata.Load(vmDir)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to load metadata: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{"id": vmID, "metadata": m}, http.StatusOK)
+}
+
+type UpdateMetadataRequest struct {
+	Labels           map[string]string          `json:"labels"`
+	ExpiresAt        *time.Time                 `json:"expires_at"`
+	SnapshotSchedule *metadata.SnapshotSchedule `json:"snapshot_schedule"`
+	SyncTimeEvery    string                     `json:"sync_time_every"`
+	CaptureConsole   bool                       `json:"capture_console"`
+}
+
+func (req *UpdateMetadataRequest) Validate() error {
+	if req.SnapshotSchedule != nil {
+		if err := req.SnapshotSchedule.Validate(); err != nil {
+			return utils.FieldError("snapshot_schedule", "is invalid: %s", err)
+		}
+	}
+	if err := metadata.ValidateTimeSync(req.SyncTimeEvery); err != nil {
+		return utils.FieldError("sync_time_every", "%s", err)
+	}
+	return nil
+}
+
+// UpdateMetadataHandler replaces the metadata of a domain. Omitted fields
+// are cleared, so an expiry, snapshot schedule or time sync is removed by
+// leaving it out. The storage class and the tenant are kept.
+func UpdateMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	defer lifecycle.Lock(vmID)()
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	// Decode and validate the JSON request
+	var req UpdateMetadataRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	// The storage class and the tenant are set by the controller, not clients
+	m, err := metadata.Load(vmDir)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to load metadata: %s", err), http.StatusInternalServerError)
+		return
+	}
+	m.Labels = req.Labels
+	m.ExpiresAt = req.ExpiresAt
+	m.SnapshotSchedule = req.SnapshotSchedule
+	m.SyncTimeEvery = req.SyncTimeEvery
+	m.CaptureConsole = req.CaptureConsole
+	if err := metadata.Save(vmDir, m); err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to save metadata: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":  true,
+		"id":       vmID,
+		"metadata": m,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/jobs"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/server/utils"
+)
+
+// getJobInfo reads the libvirt job running on a domain; swapped out in tests.
+var getJobInfo = libvirt.GetJobInfo
+
+type MigrateDomainRequest struct {
+	DestinationURI string `json:"destination_uri"`
+	Live           bool   `json:"live"`
+	Persistent     bool   `json:"persistent"`
+	UndefineSource bool   `json:"undefine_source"`
+}
+
+func (req *MigrateDomainRequest) Validate() error {
+	if req.DestinationURI == "" {
+		return utils.FieldError("destination_uri", "is required")
+	}
+	if !strings.Contains(req.DestinationURI, "://") {
+		return utils.FieldError("destination_uri", "must be a libvirt URI such as qemu+ssh://host/system")
+	}
+	return nil
+}
+
+// MigrateDomainHandler starts migrating a domain to another libvirt host
+func MigrateDomainHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req MigrateDomainRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"destination_uri": req.DestinationURI,
+		"live":            req.Live,
+	}
+
+	job := jobs.Default.Start(r.Context(), "domain.migrate", vmID, func(ctx context.Context, report jobs.Reporter) error {
+		events.Notify(vmID, "domain.migration_started", "Domain migration started", data)
+
+		// virsh reports the progress while it runs, no need to poll domjobinfo
+		err := libvirt.MigrateDomain(ctx, vmID, req.DestinationURI, req.Live, req.Persistent, req.UndefineSource, func(percent float64) {
+			report(percent, fmt.Sprintf("Migration %.0f%% complete", percent))
+		})
+		if err != nil {
+			events.Notify(vmID, "domain.migration_failed", fmt.Sprintf("Domain migration failed: %s", err), data)
+			return err
+		}
+
+		events.Notify(vmID, "domain.migrated", "Domain migration completed", data)
+		return nil
+	})
+
+	acceptedJobResponse(w, job)
+}
+
+// GetDomainJobHandler reports the libvirt job currently running on a domain
+func GetDomainJobHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	info, err := getJobInfo(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get job info", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":       vmID,
+		"active":   info.Active(),
+		"progress": info.Progress(),
+		"job":      info,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// AbortDomainJobHandler cancels the libvirt job currently running on a domain
+func AbortDomainJobHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	info, err := getJobInfo(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get job info", err)
+		return
+	}
+	if !info.Active() {
+		utils.JSONErrorResponse(w, "No job is active on the domain", http.StatusConflict)
+		return
+	}
+
+	if _, err := libvirt.AbortJob(r.Context(), vmID); err != nil {
+		libvirtErrorResponse(w, "Failed to abort job", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Aborted %s job", strings.ToLower(info.Operation)),
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
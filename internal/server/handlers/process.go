@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+// Agent calls made for guest processes; swapped out in tests.
+var (
+	listGuestProcesses = qemu.ListGuestProcesses
+	killGuestProcess   = qemu.KillGuestProcess
+)
+
+type KillProcessRequest struct {
+	PID    int    `json:"pid"`
+	Signal string `json:"signal,omitempty"` // TERM (default), KILL, HUP, ...; only KILL forces on Windows
+}
+
+func (req *KillProcessRequest) Validate() error {
+	// Signalling init takes the whole guest down
+	if req.PID <= 1 {
+		return utils.FieldError("pid", "must be > 1")
+	}
+	req.Signal = strings.TrimPrefix(strings.ToUpper(req.Signal), "SIG")
+	if req.Signal == "" {
+		req.Signal = "TERM"
+	}
+	if !slices.Contains(qemu.ProcessSignals, req.Signal) {
+		return utils.FieldError("signal", "must be one of %s", strings.Join(qemu.ProcessSignals, ", "))
+	}
+	return nil
+}
+
+// ListGuestProcessesHandler lists the processes running inside the guest
+func ListGuestProcessesHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	processes, err := listGuestProcesses(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to list guest processes: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"processes": processes,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// KillGuestProcessHandler signals a process inside the guest
+func KillGuestProcessHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req KillProcessRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	if err := killGuestProcess(r.Context(), vmID, req.PID, req.Signal); err != nil {
+		// The process is gone or the guest refused
+		if errors.Is(err, qemu.ErrKillFailed) {
+			utils.JSONErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to signal guest process: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"pid":     req.PID,
+		"signal":  req.Signal,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
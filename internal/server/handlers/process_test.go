@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+)
+
+func TestKillGuestProcessHandler(t *testing.T) {
+	original, originalRunning := killGuestProcess, requireRunning
+	defer func() { killGuestProcess, requireRunning = original, originalRunning }()
+
+	tests := []struct {
+		name       string
+		body       string
+		running    error
+		err        error
+		wantStatus int
+		wantSignal string
+	}{
+		{"default signal", `{"pid": 812}`, nil, nil, http.StatusOK, "TERM"},
+		{"signal name", `{"pid": 812, "signal": "sigkill"}`, nil, nil, http.StatusOK, "KILL"},
+		{"no such process", `{"pid": 999}`, nil, fmt.Errorf("%w 999: kill: (999) - No such process", qemu.ErrKillFailed), http.StatusUnprocessableEntity, "TERM"},
+		{"no agent", `{"pid": 812}`, nil, fmt.Errorf("error: Guest agent is not responding"), http.StatusInternalServerError, "TERM"},
+		{"shut off", `{"pid": 812}`, fmt.Errorf("%w: vm-1 is shut off", libvirt.ErrDomainNotRunning), nil, http.StatusConflict, ""},
+		{"init", `{"pid": 1}`, nil, nil, http.StatusBadRequest, ""},
+		{"injected signal", `{"pid": 812, "signal": "TERM 1; reboot"}`, nil, nil, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotSignal string
+			requireRunning = func(ctx context.Context, domain string) error { return tt.running }
+			killGuestProcess = func(ctx context.Context, vm string, pid int, signal string) error {
+				gotSignal = signal
+				return tt.err
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/processes/kill", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			KillGuestProcessHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if gotSignal != tt.wantSignal {
+				t.Errorf("signal = %q, want %q", gotSignal, tt.wantSignal)
+			}
+		})
+	}
+}
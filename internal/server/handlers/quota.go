@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/quota"
+	"libvirt-controller/internal/server/utils"
+)
+
+// reserveQuota charges alloc for key to tenant. Without a tenant nothing is
+// charged. When the quota would be exceeded the error is written to w and ok
+// is false, otherwise undo gives the reservation back if the operation fails.
+func reserveQuota(w http.ResponseWriter, tenant string, key string, alloc quota.Allocation) (undo func(), ok bool) {
+	if tenant == "" {
+		return func() {}, true
+	}
+
+	undo, err := quota.Default.Reserve(tenant, key, alloc)
+	if err != nil {
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			utils.JSONErrorResponse(w, "Quota exceeded: "+exceeded.Error(), http.StatusForbidden)
+			return nil, false
+		}
+		utils.JSONErrorResponse(w, "Failed to reserve quota: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return undo, true
+}
+
+// domainTenant returns the tenant charged for domain vmID: the one holding
+// its reservation, or the caller's for a domain not charged yet.
+func domainTenant(ctx context.Context, vmID string) string {
+	if owner, _, ok := quota.Default.Lookup(quota.DomainKey(vmID)); ok {
+		return owner
+	}
+	return quota.TenantFrom(ctx)
+}
+
+// ownsDomain reports whether the caller may use the domain in vmDir. The
+// admin token may use every domain, a tenant only those it defined.
+func ownsDomain(ctx context.Context, vmDir string) (bool, error) {
+	tenant := quota.TenantFrom(ctx)
+	if tenant == "" {
+		return true, nil
+	}
+	m, err := metadata.Load(vmDir)
+	if err != nil {
+		return false, err
+	}
+	return m.Tenant == tenant, nil
+}
+
+// diskVMDir returns the VM directory holding the image at path, the first
+// directory below the base of its storage class, or "" when there is none.
+func diskVMDir(path string) string {
+	path = filepath.Clean(path)
+	for _, base := range filesystem.StorageClasses() {
+		rel, err := filepath.Rel(filepath.Clean(base), path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if vmID, rest, nested := strings.Cut(rel, "/"); nested && rest != "" {
+			return filepath.Join(base, vmID)
+		}
+	}
+	return ""
+}
+
+// ownsDisk reports whether the caller may use the image at path. The admin
+// token may use every image. A tenant may use the images charged to it and,
+// of the images charged to nobody, those in the directories of its domains.
+func ownsDisk(ctx context.Context, path string) (bool, error) {
+	tenant := quota.TenantFrom(ctx)
+	if tenant == "" {
+		return true, nil
+	}
+	if owner, _, ok := quota.Default.Lookup(quota.DiskKey(path)); ok {
+		return owner == tenant, nil
+	}
+	vmDir := diskVMDir(path)
+	if vmDir == "" || !filesystem.FileExists(vmDir) {
+		return false, nil
+	}
+	return ownsDomain(ctx, vmDir)
+}
+
+// checkDiskAccess writes the error to w and returns false unless the image at
+// path, taken from the request field named field, lies inside a storage
+// class, isn't one of the controller's own files and belongs to the caller.
+func checkDiskAccess(w http.ResponseWriter, ctx context.Context, field string, path string) bool {
+	if !inStorageClass(path) {
+		utils.JSONRequestErrorResponse(w, utils.FieldError(field, "must be inside DEFINITIONS_DIR or a storage class directory"))
+		return false
+	}
+	if controllerFile(filepath.Base(path)) {
+		utils.JSONRequestErrorResponse(w, utils.FieldError(field, "must not be a file of the controller"))
+		return false
+	}
+	return checkDiskOwner(w, ctx, path)
+}
+
+// checkDiskOwner writes the error to w and returns false unless the caller
+// owns the image at path, see ownsDisk.
+func checkDiskOwner(w http.ResponseWriter, ctx context.Context, path string) bool {
+	owned, err := ownsDisk(ctx, path)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to load metadata: %s", err), http.StatusInternalServerError)
+		return false
+	}
+	if !owned {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Disk image %s belongs to another tenant", path), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// reserveDomainQuota charges the vCPUs and memory of domain to the tenant of
+// vmID, replacing what the domain held before, like reserveQuota.
+func reserveDomainQuota(w http.ResponseWriter, ctx context.Context, vmID string, domain *domainxml.Domain) (undo func(), ok bool) {
+	tenant := domainTenant(ctx, vmID)
+	if tenant == "" {
+		return func() {}, true
+	}
+	alloc, err := quota.DomainAllocation(domain)
+	if err != nil {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("xml_config", "has an invalid memory size: %s", err))
+		return nil, false
+	}
+	return reserveQuota(w, tenant, quota.DomainKey(vmID), alloc)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"libvirt-controller/internal/reconcile"
+	"libvirt-controller/internal/server/utils"
+)
+
+// ReconcileHandler reports the libvirt domains and definition directories
+// that don't match, without changing anything
+func ReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := reconcile.Check(r.Context())
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to reconcile domains: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{
+		"clean":  report.Clean(),
+		"report": report,
+	}, http.StatusOK)
+}
+
+// RepairReconcileHandler redefines orphaned definitions and adopts untracked
+// domains, see reconcile.Repair
+func RepairReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := reconcile.Check(r.Context())
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to reconcile domains: %s", err), http.StatusInternalServerError)
+		return
+	}
+	reconcile.Repair(r.Context(), report)
+
+	utils.JSONResponse(w, map[string]interface{}{
+		"success": len(report.Errors) == 0,
+		"report":  report,
+	}, http.StatusOK)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/qemu"
+)
+
+const defaultRemoteStateTimeout = 10 * time.Second
+
+// Guest agent calls made for ?remoteState=true; swapped out in tests.
+var (
+	guestPing            = qemu.GuestPing
+	guestHostName        = qemu.GetHostName
+	guestOSInfo          = qemu.GetOSInfo
+	guestFileSystemUsage = qemu.GetFileSystemUsage
+	guestInterfaces      = qemu.GetNetworkInterfaces
+	guestTime            = qemu.GetGuestTime
+	guestUsers           = qemu.GetLoggedInUsers
+)
+
+// collectRemoteState queries the guest agent of vmID for everything in
+// QemuAgentStateInfo. The calls run concurrently and together are bounded by
+// REMOTE_STATE_TIMEOUT; a call that fails or runs out of time leaves its
+// field empty. It returns an error only when the agent doesn't answer a ping.
+func collectRemoteState(ctx context.Context, vmID string) (*QemuAgentStateInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.Seconds("REMOTE_STATE_TIMEOUT", defaultRemoteStateTimeout))
+	defer cancel()
+
+	if err := guestPing(ctx, vmID); err != nil {
+		return nil, err
+	}
+
+	// Every call writes only its own field
+	info := &QemuAgentStateInfo{}
+	calls := []func(){
+		func() {
+			if hostname, err := guestHostName(ctx, vmID); err == nil {
+				info.Hostname = hostname
+			}
+		},
+		func() {
+			if osInfo, err := guestOSInfo(ctx, vmID); err == nil {
+				info.OSInfo = osInfo
+			}
+		},
+		func() {
+			if fsInfo, err := guestFileSystemUsage(ctx, vmID); err == nil {
+				info.FSInfo = fsInfo
+			}
+		},
+		func() {
+			if interfaces, err := guestInterfaces(ctx, vmID); err == nil {
+				info.Interfaces = interfaces
+			}
+		},
+		func() {
+			if t, err := guestTime(ctx, vmID); err == nil {
+				info.Time = t
+			}
+		},
+		func() {
+			if users, err := guestUsers(ctx, vmID); err == nil {
+				info.Users = users
+			}
+		},
+	}
+
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		wg.Add(1)
+		go func(call func()) {
+			defer wg.Done()
+			call()
+		}(call)
+	}
+	wg.Wait()
+	return info, nil
+}
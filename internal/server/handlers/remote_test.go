@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/qemu"
+)
+
+// mockGuestAgent replaces the remote state calls; every call takes delay and
+// the users call fails.
+func mockGuestAgent(t *testing.T, pingErr error, delay time.Duration) {
+	t.Helper()
+	ping, hostName, osInfo, fsUsage := guestPing, guestHostName, guestOSInfo, guestFileSystemUsage
+	interfaces, gTime, users := guestInterfaces, guestTime, guestUsers
+	t.Cleanup(func() {
+		guestPing, guestHostName, guestOSInfo, guestFileSystemUsage = ping, hostName, osInfo, fsUsage
+		guestInterfaces, guestTime, guestUsers = interfaces, gTime, users
+	})
+
+	wait := func(ctx context.Context) error {
+		select {
+		case <-time.After(delay):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	guestPing = func(ctx context.Context, vm string) error { return pingErr }
+	guestHostName = func(ctx context.Context, vm string) (string, error) {
+		return "web-1", wait(ctx)
+	}
+	guestOSInfo = func(ctx context.Context, vm string) (*qemu.OSInfo, error) {
+		return &qemu.OSInfo{ID: "debian"}, wait(ctx)
+	}
+	guestFileSystemUsage = func(ctx context.Context, vm string) ([]qemu.FileSystemInfo, error) {
+		return []qemu.FileSystemInfo{{}}, wait(ctx)
+	}
+	guestInterfaces = func(ctx context.Context, vm string) ([]qemu.NetworkInterface, error) {
+		return []qemu.NetworkInterface{{}}, wait(ctx)
+	}
+	guestTime = func(ctx context.Context, vm string) (*qemu.GuestTime, error) {
+		return &qemu.GuestTime{}, wait(ctx)
+	}
+	guestUsers = func(ctx context.Context, vm string) ([]qemu.GuestUser, error) {
+		wait(ctx)
+		return nil, errors.New("error: Guest agent is not responding")
+	}
+}
+
+func TestCollectRemoteState(t *testing.T) {
+	mockGuestAgent(t, nil, 100*time.Millisecond)
+
+	start := time.Now()
+	info, err := collectRemoteState(context.Background(), "vm-1")
+	if err != nil {
+		t.Fatalf("collectRemoteState() error = %v", err)
+	}
+	// Six sequential calls would take 600ms
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("calls did not run concurrently, took %s", elapsed)
+	}
+
+	if info.Hostname != "web-1" || info.OSInfo == nil || info.FSInfo == nil || info.Interfaces == nil || info.Time == nil {
+		t.Errorf("missing fields in %+v", info)
+	}
+	if info.Users != nil {
+		t.Errorf("failed call should leave Users nil, got %+v", info.Users)
+	}
+}
+
+func TestCollectRemoteStateTimeout(t *testing.T) {
+	t.Setenv("REMOTE_STATE_TIMEOUT", "1")
+	mockGuestAgent(t, nil, time.Minute)
+
+	start := time.Now()
+	info, err := collectRemoteState(context.Background(), "vm-1")
+	if err != nil {
+		t.Fatalf("collectRemoteState() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("collection was not bounded by the timeout, took %s", elapsed)
+	}
+	if info.Hostname != "" || info.OSInfo != nil || info.Time != nil {
+		t.Errorf("timed out calls should leave their fields empty, got %+v", info)
+	}
+}
+
+func TestCollectRemoteStateAgentUnavailable(t *testing.T) {
+	mockGuestAgent(t, errors.New("error: Guest agent is not responding"), 0)
+
+	if info, err := collectRemoteState(context.Background(), "vm-1"); err == nil {
+		t.Errorf("expected an error, got %+v", info)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/quota"
+	"libvirt-controller/internal/server/utils"
+)
+
+// getDomainResources reads the current allocation and power state of a domain.
+func getDomainResources(ctx context.Context, vmID string) (*helpers.DomainResources, string, error) {
+	domInfo, err := libvirt.GetDomainInfo(ctx, vmID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get domain info: %w", err)
+	}
+	resources, err := helpers.ParseDomainResources(domInfo)
+	if err != nil {
+		return nil, "", err
+	}
+	status, err := helpers.ParseDomainStatus(domInfo)
+	if err != nil {
+		return nil, "", err
+	}
+	return resources, status, nil
+}
+
+// GetResourcesHandler returns the vCPU and memory allocation of a domain
+func GetResourcesHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	resources, status, err := getDomainResources(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), libvirtErrorStatus(err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":            vmID,
+		"status":        status,
+		"vcpus":         resources.VCPUs,
+		"memory_mb":     resources.MemoryKiB / 1024,
+		"max_memory_mb": resources.MaxMemoryKiB / 1024,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+type SetMemoryRequest struct {
+	MemoryMB uint64 `json:"memory_mb"`
+	RaiseMax bool   `json:"raise_max"` // Raise max memory if needed, applied on next boot
+}
+
+func (req *SetMemoryRequest) Validate() error {
+	if req.MemoryMB == 0 {
+		return utils.FieldError("memory_mb", "must be > 0")
+	}
+	return nil
+}
+
+var errMemoryAboveMax = errors.New("requested memory exceeds the domain's max memory")
+
+// validateMemoryChange checks a memory request against the domain's maximum.
+func validateMemoryChange(requestedKiB uint64, maxKiB uint64, raiseMax bool) error {
+	if requestedKiB == 0 {
+		return errors.New("'memory_mb' must be > 0")
+	}
+	if requestedKiB > maxKiB && !raiseMax {
+		return fmt.Errorf("%w (%d MB > %d MB), set 'raise_max' to raise it", errMemoryAboveMax, requestedKiB/1024, maxKiB/1024)
+	}
+	return nil
+}
+
+// SetMemoryHandler changes the memory assigned to a domain
+func SetMemoryHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req SetMemoryRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	resources, status, err := getDomainResources(r.Context(), vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), libvirtErrorStatus(err))
+		return
+	}
+
+	requestedKiB := req.MemoryMB * 1024
+	if err := validateMemoryChange(requestedKiB, resources.MaxMemoryKiB, req.RaiseMax); err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The tenant owning the domain is charged for its max memory
+	undoQuota := func() {}
+	if owner, alloc, ok := quota.Default.Lookup(quota.DomainKey(vmID)); ok {
+		alloc.MemoryMB = int64(max(resources.MaxMemoryKiB, requestedKiB) / 1024)
+		if undoQuota, ok = reserveQuota(w, owner, quota.DomainKey(vmID), alloc); !ok {
+			return
+		}
+	}
+
+	running := status == "running" || status == "paused"
+	restartRequired := false
+
+	if requestedKiB > resources.MaxMemoryKiB {
+		// A running guest can't grow beyond its boot time maximum, so both
+		// values only go into the persistent definition
+		if _, err := libvirt.SetMaxMemory(r.Context(), vmID, requestedKiB); err != nil {
+			undoQuota()
+			libvirtErrorResponse(w, "Failed to raise max memory", err)
+			return
+		}
+		if _, err := libvirt.SetMemory(r.Context(), vmID, requestedKiB, false, true); err != nil {
+			undoQuota()
+			libvirtErrorResponse(w, "Failed to set memory", err)
+			return
+		}
+		restartRequired = running
+	} else if _, err := libvirt.SetMemory(r.Context(), vmID, requestedKiB, running, true); err != nil {
+		undoQuota()
+		libvirtErrorResponse(w, "Failed to set memory", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":          true,
+		"memory_mb":        req.MemoryMB,
+		"max_memory_mb":    max(resources.MaxMemoryKiB, requestedKiB) / 1024,
+		"restart_required": restartRequired,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
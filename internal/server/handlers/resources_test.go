@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateMemoryChange(t *testing.T) {
+	const maxKiB = 2048 * 1024
+
+	cases := []struct {
+		name      string
+		requested uint64
+		raiseMax  bool
+		wantErr   bool
+		aboveMax  bool
+	}{
+		{"zero", 0, false, true, false},
+		{"below max", maxKiB - 1024, false, false, false},
+		{"equal to max", maxKiB, false, false, false},
+		{"one MB above max", maxKiB + 1024, false, true, true},
+		{"above max with raise", maxKiB + 1024, true, false, false},
+		{"zero with raise", 0, true, true, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateMemoryChange(tc.requested, maxKiB, tc.raiseMax)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("validateMemoryChange(%d, %d, %t) error = %v, wantErr %t", tc.requested, maxKiB, tc.raiseMax, err, tc.wantErr)
+			}
+			if errors.Is(err, errMemoryAboveMax) != tc.aboveMax {
+				t.Errorf("expected errMemoryAboveMax = %t, got %v", tc.aboveMax, err)
+			}
+		})
+	}
+}
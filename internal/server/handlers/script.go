@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+const (
+	defaultScriptMaxBytes = 64 * 1024
+	defaultScriptTimeout  = 5 * time.Minute
+)
+
+// runGuestScript runs scripts through the guest agent; swapped out in tests.
+var runGuestScript = qemu.RunGuestScript
+
+type RunScriptRequest struct {
+	Interpreter string `json:"interpreter"`               // bash, sh or powershell
+	Script      string `json:"script"`                    // At most SCRIPT_MAX_BYTES
+	Timeout     int    `json:"timeout_seconds,omitempty"` // At most SCRIPT_TIMEOUT, which is also the default
+}
+
+func (req *RunScriptRequest) Validate() error {
+	if !slices.Contains(qemu.ScriptInterpreters(), req.Interpreter) {
+		return utils.FieldError("interpreter", "must be one of %s", strings.Join(qemu.ScriptInterpreters(), ", "))
+	}
+	if req.Script == "" {
+		return utils.FieldError("script", "is required")
+	}
+	if maxBytes := config.Int("SCRIPT_MAX_BYTES", defaultScriptMaxBytes); len(req.Script) > maxBytes {
+		return utils.FieldError("script", "must be at most %d bytes", maxBytes)
+	}
+	if req.Timeout < 0 {
+		return utils.FieldError("timeout_seconds", "must be >= 0")
+	}
+	if maxTimeout := config.Seconds("SCRIPT_TIMEOUT", defaultScriptTimeout); time.Duration(req.Timeout)*time.Second > maxTimeout {
+		return utils.FieldError("timeout_seconds", "must be at most %d", int(maxTimeout/time.Second))
+	}
+	return nil
+}
+
+// RunScriptHandler uploads a script into the guest, runs it through the guest
+// agent and returns its output
+func RunScriptHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req RunScriptRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if !requireAgent(w, r, vmID) {
+		return
+	}
+
+	timeout := config.Seconds("SCRIPT_TIMEOUT", defaultScriptTimeout)
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+
+	result, err := runGuestScript(r.Context(), vmID, req.Interpreter, []byte(req.Script), timeout)
+	if errors.Is(err, qemu.ErrGuestCommandTimeout) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Script did not finish within %s, it keeps running in the guest", timeout), http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to run script: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   result.ExitCode == 0,
+		"exit_code": result.ExitCode,
+		"stdout":    result.Stdout,
+		"stderr":    result.Stderr,
+	}
+	status := http.StatusOK
+	if result.ExitCode != 0 {
+		response["error"] = "Script failed inside the guest"
+		status = http.StatusUnprocessableEntity
+	}
+	utils.JSONResponse(w, response, status)
+}
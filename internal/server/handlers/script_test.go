@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/qemu"
+)
+
+func TestRunScriptHandler(t *testing.T) {
+	original, originalRunning := runGuestScript, requireRunning
+	defer func() { runGuestScript, requireRunning = original, originalRunning }()
+	requireRunning = func(ctx context.Context, domain string) error { return nil }
+	t.Setenv("SCRIPT_MAX_BYTES", "32")
+	t.Setenv("SCRIPT_TIMEOUT", "60")
+
+	tests := []struct {
+		name        string
+		body        string
+		result      *qemu.GuestExecResult
+		err         error
+		wantStatus  int
+		wantTimeout time.Duration
+	}{
+		{"success", `{"interpreter": "bash", "script": "echo hi"}`, &qemu.GuestExecResult{Stdout: "hi\n"}, nil, http.StatusOK, time.Minute},
+		{"custom timeout", `{"interpreter": "powershell", "script": "Write-Host hi", "timeout_seconds": 5}`, &qemu.GuestExecResult{}, nil, http.StatusOK, 5 * time.Second},
+		{"script fails", `{"interpreter": "sh", "script": "exit 2"}`, &qemu.GuestExecResult{ExitCode: 2}, nil, http.StatusUnprocessableEntity, time.Minute},
+		{"timed out", `{"interpreter": "bash", "script": "sleep 600"}`, nil, fmt.Errorf("%w: /bin/bash", qemu.ErrGuestCommandTimeout), http.StatusGatewayTimeout, time.Minute},
+		{"no agent", `{"interpreter": "bash", "script": "true"}`, nil, fmt.Errorf("error: Guest agent is not responding"), http.StatusInternalServerError, time.Minute},
+		{"unknown interpreter", `{"interpreter": "perl", "script": "print 1"}`, nil, nil, http.StatusBadRequest, 0},
+		{"script too large", `{"interpreter": "bash", "script": "` + strings.Repeat("x", 33) + `"}`, nil, nil, http.StatusBadRequest, 0},
+		{"timeout too long", `{"interpreter": "bash", "script": "true", "timeout_seconds": 61}`, nil, nil, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotTimeout time.Duration
+			runGuestScript = func(ctx context.Context, vm string, interpreter string, script []byte, timeout time.Duration) (*qemu.GuestExecResult, error) {
+				gotTimeout = timeout
+				return tt.result, tt.err
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/script", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			RunScriptHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if gotTimeout != tt.wantTimeout {
+				t.Errorf("timeout = %s, want %s", gotTimeout, tt.wantTimeout)
+			}
+		})
+	}
+}
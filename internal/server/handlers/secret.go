@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"unicode"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// libvirt and qemu-img calls made for secrets and encrypted disks; swapped
+// out in tests.
+var (
+	defineSecret   = libvirt.DefineSecret
+	setSecretValue = libvirt.SetSecretValue
+	listSecrets    = libvirt.ListSecrets
+	undefineSecret = libvirt.UndefineSecret
+	secretValue    = libvirt.SecretValue
+	encryptDisk    = qemu.EncryptDisk
+)
+
+// Passphrases need minPassphraseLength characters from at least three of
+// lower case, upper case, digits and others, or longPassphraseLength of any.
+const (
+	minPassphraseLength  = 12
+	longPassphraseLength = 20
+)
+
+// CreateSecretRequest holds either a disk passphrase or an arbitrary value.
+// Neither is ever returned or logged.
+type CreateSecretRequest struct {
+	Passphrase  string `json:"passphrase,omitempty"`  // Unlocks the disks encrypted with the secret
+	Value       string `json:"value,omitempty"`       // Base64 encoded value, e.g. a ceph key
+	Description string `json:"description,omitempty"` // Shown by virsh secret-dumpxml
+	Private     bool   `json:"private,omitempty"`     // The value can't be read back through libvirt
+}
+
+func (req *CreateSecretRequest) Validate() error {
+	switch {
+	case req.Passphrase == "" && req.Value == "":
+		return utils.FieldError("passphrase", "or value is required")
+	case req.Passphrase != "" && req.Value != "":
+		return utils.FieldError("value", "can't be combined with passphrase")
+	case req.Value != "":
+		if _, err := base64.StdEncoding.DecodeString(req.Value); err != nil {
+			return utils.FieldError("value", "must be base64 encoded")
+		}
+		return nil
+	}
+	if req.Private {
+		return utils.FieldError("private", "can't be set for a passphrase, it's read back to encrypt disks")
+	}
+	return validatePassphrase(req.Passphrase)
+}
+
+// validatePassphrase rejects passphrases that are too weak to protect a disk.
+func validatePassphrase(passphrase string) error {
+	length := len([]rune(passphrase))
+	if length >= longPassphraseLength {
+		return nil
+	}
+	if length < minPassphraseLength {
+		return utils.FieldError("passphrase", "must be at least %d characters long", minPassphraseLength)
+	}
+
+	var lower, upper, digit, other bool
+	for _, r := range passphrase {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	if classes < 3 {
+		return utils.FieldError("passphrase", "must mix at least three of lower case, upper case, digits and symbols, or be at least %d characters long", longPassphraseLength)
+	}
+	return nil
+}
+
+// CreateSecretHandler defines a libvirt secret holding a disk passphrase or
+// another value. Its UUID is passed as secret when creating a disk and in the
+// disk spec, or to whatever else unlocks with it.
+func CreateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req CreateSecretRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+	value := []byte(req.Passphrase)
+	if req.Value != "" {
+		value, _ = base64.StdEncoding.DecodeString(req.Value)
+	}
+
+	uuid, err := defineSecret(r.Context(), req.Description, req.Private)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to define secret", err)
+		return
+	}
+	if err := setSecretValue(r.Context(), uuid, value); err != nil {
+		// A secret without a value can't unlock anything
+		undefineSecret(context.WithoutCancel(r.Context()), uuid)
+		libvirtErrorResponse(w, "Failed to set secret value", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"secret":  map[string]interface{}{"uuid": uuid},
+	}
+	utils.JSONResponse(w, response, http.StatusCreated)
+}
+
+// ListSecretsHandler lists the libvirt secrets, without their values
+func ListSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	secrets, err := listSecrets(r.Context())
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list secrets", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"secrets": secrets,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// DeleteSecretHandler undefines a libvirt secret. Disks encrypted with it
+// can't be unlocked anymore.
+func DeleteSecretHandler(w http.ResponseWriter, r *http.Request) {
+	uuid := chi.URLParam(r, "uuid")
+	if !domainxml.IsUUID(uuid) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("'%s' is not a secret UUID", uuid), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := undefineSecret(r.Context(), uuid); err != nil {
+		libvirtErrorResponse(w, "Failed to delete secret", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Secret deleted successfully",
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/libvirt"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const testSecretUUID = "6d5c1a3e-0f4b-4a8e-9c2d-1b7e3f5a9d20"
+
+func TestCreateSecretHandler(t *testing.T) {
+	originalDefine, originalSet, originalUndefine := defineSecret, setSecretValue, undefineSecret
+	defer func() { defineSecret, setSecretValue, undefineSecret = originalDefine, originalSet, originalUndefine }()
+
+	tests := []struct {
+		name        string
+		body        string
+		setErr      error
+		wantStatus  int
+		wantValue   string
+		wantPrivate bool
+	}{
+		{"mixed classes", `{"passphrase": "Correct-horse7", "description": "vm-1 disks"}`, nil, http.StatusCreated, "Correct-horse7", false},
+		{"long", `{"passphrase": "correct horse battery staple"}`, nil, http.StatusCreated, "correct horse battery staple", false},
+		{"base64 value", `{"value": "aG9yc2Uta2V5", "private": true}`, nil, http.StatusCreated, "horse-key", true},
+		{"set value fails", `{"value": "aG9yc2Uta2V5"}`, errors.New("error: internal error"), http.StatusInternalServerError, "horse-key", false},
+		{"missing", `{}`, nil, http.StatusBadRequest, "", false},
+		{"both", `{"passphrase": "correct horse battery staple", "value": "aG9yc2Uta2V5"}`, nil, http.StatusBadRequest, "", false},
+		{"invalid base64", `{"value": "horse!"}`, nil, http.StatusBadRequest, "", false},
+		{"private passphrase", `{"passphrase": "correct horse battery staple", "private": true}`, nil, http.StatusBadRequest, "", false},
+		{"too short", `{"passphrase": "Aa1!"}`, nil, http.StatusBadRequest, "", false},
+		{"too few classes", `{"passphrase": "correcthorse7"}`, nil, http.StatusBadRequest, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotValue string
+			var gotPrivate, undefined bool
+			defineSecret = func(ctx context.Context, description string, private bool) (string, error) {
+				gotPrivate = private
+				return testSecretUUID, nil
+			}
+			setSecretValue = func(ctx context.Context, uuid string, value []byte) error {
+				gotValue = string(value)
+				return tt.setErr
+			}
+			undefineSecret = func(ctx context.Context, uuid string) (string, error) {
+				undefined = true
+				return "", nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/secret", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			CreateSecretHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if gotValue != tt.wantValue || gotPrivate != tt.wantPrivate {
+				t.Errorf("value %q private %t, want %q %t", gotValue, gotPrivate, tt.wantValue, tt.wantPrivate)
+			}
+			if undefined != (tt.setErr != nil) {
+				t.Errorf("undefined = %t", undefined)
+			}
+			if strings.Contains(rec.Body.String(), "horse") {
+				t.Errorf("response contains the secret value: %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteSecretHandler(t *testing.T) {
+	original := undefineSecret
+	defer func() { undefineSecret = original }()
+
+	undefineSecret = func(ctx context.Context, uuid string) (string, error) {
+		if uuid != testSecretUUID {
+			return "", errors.Join(libvirt.ErrSecretNotFound, errors.New("error: failed to get secret"))
+		}
+		return "", nil
+	}
+
+	tests := []struct {
+		uuid       string
+		wantStatus int
+	}{
+		{testSecretUUID, http.StatusOK},
+		{"0b7f5e2a-9a3c-4d1e-8f6b-2c4d6e8f0a1b", http.StatusNotFound},
+		{"not-a-uuid", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uuid, func(t *testing.T) {
+			r := chi.NewRouter()
+			r.Delete("/v1/secret/{uuid}", DeleteSecretHandler)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/v1/secret/"+tt.uuid, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/server/utils"
+)
+
+// libvirt and host calls made by the shared dir handlers; swapped out in tests.
+var (
+	dumpXML          = libvirt.DumpXML
+	attachFilesystem = libvirt.AttachFilesystem
+	detachFilesystem = libvirt.DetachFilesystem
+	checkSharedDir   = domainxml.CheckSharedDir
+	checkVirtiofsd   = domainxml.CheckVirtiofsd
+)
+
+type AttachSharedDirRequest struct {
+	Source string `json:"source"`         // Host directory, must be under SHARED_DIR_BASES
+	Tag    string `json:"tag"`            // Mount tag seen by the guest
+	Live   bool   `json:"live,omitempty"` // Also hotplug into the running domain
+}
+
+func (req *AttachSharedDirRequest) Validate() error {
+	if req.Source == "" {
+		return utils.FieldError("source", "is required")
+	}
+	if !filepath.IsAbs(req.Source) {
+		return utils.FieldError("source", "must be an absolute path")
+	}
+	// The source is fine, anything left is about the tag
+	spec := domainxml.SharedDirSpec{Source: req.Source, Tag: req.Tag}
+	if err := spec.Validate(); err != nil {
+		return utils.FieldError("tag", "is invalid: %s", err)
+	}
+	return nil
+}
+
+type DetachSharedDirRequest struct {
+	Tag  string `json:"tag"`
+	Live bool   `json:"live,omitempty"` // Also unplug from the running domain
+}
+
+func (req *DetachSharedDirRequest) Validate() error {
+	if req.Tag == "" {
+		return utils.FieldError("tag", "is required")
+	}
+	return nil
+}
+
+// persistentDomain parses the definition the domain boots with next.
+func persistentDomain(w http.ResponseWriter, r *http.Request, vmID string) (*domainxml.Domain, bool) {
+	out, err := dumpXML(r.Context(), vmID, true)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to read domain XML", err)
+		return nil, false
+	}
+	domain, err := domainxml.Parse([]byte(out))
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to parse domain XML: %s", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	return domain, true
+}
+
+// AttachSharedDirHandler shares a host directory with the domain over virtio-fs
+func AttachSharedDirHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req AttachSharedDirRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if err := checkSharedDir(req.Source); err != nil {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("source", "%s", err))
+		return
+	}
+	if err := checkVirtiofsd(); err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	domain, ok := persistentDomain(w, r, vmID)
+	if !ok {
+		return
+	}
+	// qemu refuses to start a virtio-fs device on private guest memory
+	if !domain.HasSharedMemory() {
+		utils.JSONErrorResponse(w, "virtio-fs requires shared guest memory, redefine the domain with 'shared_memory' set", http.StatusConflict)
+		return
+	}
+	if _, exists := domain.FindFilesystem(req.Tag); exists {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Tag '%s' is already in use", req.Tag), http.StatusConflict)
+		return
+	}
+
+	spec := domainxml.SharedDirSpec{Source: req.Source, Tag: req.Tag}
+	if _, err := attachFilesystem(r.Context(), vmID, spec.Filesystem(), req.Live); err != nil {
+		libvirtErrorResponse(w, "Failed to attach shared directory", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"source":  req.Source,
+		"tag":     req.Tag,
+		"live":    req.Live,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// DetachSharedDirHandler removes a virtio-fs share from the domain
+func DetachSharedDirHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req DetachSharedDirRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	domain, ok := persistentDomain(w, r, vmID)
+	if !ok {
+		return
+	}
+	fs, exists := domain.FindFilesystem(req.Tag)
+	if !exists {
+		utils.JSONErrorResponse(w, fmt.Sprintf("No shared directory with tag '%s'", req.Tag), http.StatusNotFound)
+		return
+	}
+
+	if _, err := detachFilesystem(r.Context(), vmID, fs, req.Live); err != nil {
+		libvirtErrorResponse(w, "Failed to detach shared directory", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"source":  fs.Source.Dir,
+		"tag":     req.Tag,
+		"live":    req.Live,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/helpers"
+)
+
+func TestAttachSharedDir(t *testing.T) {
+	originalDump, originalAttach, originalCheck, originalVirtiofsd := dumpXML, attachFilesystem, checkSharedDir, checkVirtiofsd
+	defer func() {
+		dumpXML, attachFilesystem, checkSharedDir, checkVirtiofsd = originalDump, originalAttach, originalCheck, originalVirtiofsd
+	}()
+	checkSharedDir = func(source string) error { return nil }
+	checkVirtiofsd = func() error { return nil }
+
+	const (
+		private = `<domain type="kvm"><name>vm-1</name></domain>`
+		shared  = `<domain type="kvm"><name>vm-1</name><memoryBacking><source type="memfd"/><access mode="shared"/></memoryBacking>
+			<devices><filesystem type="mount" accessmode="passthrough"><driver type="virtiofs"/><source dir="/srv/a"/><target dir="a"/></filesystem></devices></domain>`
+	)
+	tests := []struct {
+		name       string
+		body       string
+		domainXML  string
+		wantStatus int
+		wantAttach bool
+	}{
+		{"attached", `{"source": "/srv/b", "tag": "b", "live": true}`, shared, http.StatusOK, true},
+		{"private memory", `{"source": "/srv/b", "tag": "b"}`, private, http.StatusConflict, false},
+		{"tag in use", `{"source": "/srv/b", "tag": "a"}`, shared, http.StatusConflict, false},
+		{"relative source", `{"source": "srv/b", "tag": "b"}`, shared, http.StatusBadRequest, false},
+		{"tag with spaces", `{"source": "/srv/b", "tag": "my share"}`, shared, http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumpXML = func(ctx context.Context, domain string, inactive bool) (string, error) {
+				return tt.domainXML, nil
+			}
+			var attached *domainxml.Filesystem
+			var live bool
+			attachFilesystem = func(ctx context.Context, domain string, fs domainxml.Filesystem, l bool) (string, error) {
+				attached, live = &fs, l
+				return "", nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/shared-dir/attach", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			AttachSharedDirHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if (attached != nil) != tt.wantAttach {
+				t.Fatalf("attached = %+v, want attach %t", attached, tt.wantAttach)
+			}
+			if attached != nil && (attached.Source.Dir != "/srv/b" || attached.Target.Dir != "b" || attached.Driver.Type != "virtiofs" || !live) {
+				t.Errorf("attached %+v live=%t", attached, live)
+			}
+		})
+	}
+}
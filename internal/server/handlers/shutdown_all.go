@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/events"
+	"libvirt-controller/internal/jobs"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/server/utils"
+)
+
+const (
+	defaultShutdownAllTimeout = 120 * time.Second
+	maxShutdownAllTimeout     = time.Hour
+)
+
+// Calls made by the shutdown of all domains; swapped out in tests.
+var (
+	listRunningDomains = libvirt.ListAllDomains
+	waitForState       = libvirt.WaitForState
+)
+
+type ShutdownAllRequest struct {
+	Confirm bool `json:"confirm"`                   // Required, this stops every running domain
+	Timeout int  `json:"timeout_seconds,omitempty"` // Grace period per domain before it's destroyed
+}
+
+func (req *ShutdownAllRequest) Validate() error {
+	if !req.Confirm {
+		return utils.FieldError("confirm", "must be true to shut down every running domain")
+	}
+	if req.Timeout < 0 || time.Duration(req.Timeout)*time.Second > maxShutdownAllTimeout {
+		return utils.FieldError("timeout_seconds", "must be between 0 and %d", int(maxShutdownAllTimeout/time.Second))
+	}
+	return nil
+}
+
+// Outcomes of shutting down a domain.
+const (
+	shutdownResultShutOff   = "shut_off"  // Shut down by the guest
+	shutdownResultDestroyed = "destroyed" // Destroyed after the grace period
+	shutdownResultFailed    = "failed"
+)
+
+// ShutdownAllResult is the outcome of shutting down one domain.
+type ShutdownAllResult struct {
+	ID     string `json:"id"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ShutdownAllHandler shuts down every running domain ahead of host
+// maintenance as a background job
+func ShutdownAllHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req ShutdownAllRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+	timeout := config.Seconds("SHUTDOWN_ALL_TIMEOUT", defaultShutdownAllTimeout)
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+
+	ids, err := listRunningDomains(r.Context(), false)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to list running domains", err)
+		return
+	}
+
+	job := jobs.Default.StartWithResult(r.Context(), "host.shutdown_all", "", func(ctx context.Context, report jobs.Reporter) (interface{}, error) {
+		return shutdownAll(ctx, ids, timeout, report)
+	})
+	acceptedJobResponse(w, job)
+}
+
+// shutdownAll shuts down the domains at once, so the host waits for the
+// slowest guest rather than for all of them in turn. Domains still running
+// after timeout are destroyed.
+func shutdownAll(ctx context.Context, ids []string, timeout time.Duration, report jobs.Reporter) ([]ShutdownAllResult, error) {
+	results := make([]ShutdownAllResult, len(ids))
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	report(0, fmt.Sprintf("Shutting down %d domains", len(ids)))
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = shutdownOne(ctx, id, timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			report(float64(done)/float64(len(ids))*100, fmt.Sprintf("%d of %d domains stopped", done, len(ids)))
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Result == shutdownResultFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d domains could not be stopped", failed, len(ids))
+	}
+	return results, nil
+}
+
+// shutdownOne asks the guest to shut down, through the agent or ACPI,
+// and destroys the domain if it's still running after timeout.
+func shutdownOne(ctx context.Context, vmID string, timeout time.Duration) ShutdownAllResult {
+	if _, err := shutdownDomain(ctx, vmID); err != nil && !errors.Is(err, libvirt.ErrAlreadyStopped) {
+		log.Printf("Failed to shut down VM %s, destroying it: %v", vmID, err)
+	} else {
+		events.Notify(vmID, "domain.shutdown", "Domain shutdown initiated for host maintenance", nil)
+		// Transient domains are gone once they shut down
+		_, err := waitForState(ctx, vmID, libvirt.StateShutOff, timeout)
+		if err == nil || errors.Is(err, libvirt.ErrDomainNotFound) {
+			return shutOff(vmID)
+		}
+		log.Printf("VM %s did not shut down, destroying it: %v", vmID, err)
+	}
+
+	_, err := destroyDomain(ctx, vmID)
+	switch {
+	case err == nil:
+		events.Notify(vmID, "domain.stopped", "Domain destroyed after the shutdown grace period", map[string]interface{}{"forced": true})
+		return ShutdownAllResult{ID: vmID, Result: shutdownResultDestroyed}
+	case errors.Is(err, libvirt.ErrAlreadyStopped), errors.Is(err, libvirt.ErrDomainNotFound):
+		// It made it after all
+		return shutOff(vmID)
+	default:
+		log.Printf("Failed to destroy VM %s: %v", vmID, err)
+		return ShutdownAllResult{ID: vmID, Result: shutdownResultFailed, Error: err.Error()}
+	}
+}
+
+func shutOff(vmID string) ShutdownAllResult {
+	events.Notify(vmID, "domain.stopped", "Domain shut down for host maintenance", map[string]interface{}{"forced": false})
+	return ShutdownAllResult{ID: vmID, Result: shutdownResultShutOff}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/libvirt"
+)
+
+func TestShutdownAll(t *testing.T) {
+	originalShutdown, originalDestroy, originalWait := shutdownDomain, destroyDomain, waitForState
+	defer func() { shutdownDomain, destroyDomain, waitForState = originalShutdown, originalDestroy, originalWait }()
+
+	shutdownDomain = func(ctx context.Context, domain string) (string, error) {
+		if domain == "no-acpi" {
+			return "", errors.New("error: Requested operation is not valid: ACPI shutdown failed")
+		}
+		return "", nil
+	}
+	waitForState = func(ctx context.Context, domain string, target libvirt.DomainState, timeout time.Duration) (libvirt.DomainState, error) {
+		switch domain {
+		case "graceful":
+			return libvirt.StateShutOff, nil
+		case "transient":
+			return "", fmt.Errorf("%w: failed to get domain", libvirt.ErrDomainNotFound)
+		}
+		return libvirt.StateRunning, fmt.Errorf("%w: %s is running", libvirt.ErrStateTimeout, domain)
+	}
+	var destroyed []string
+	destroyDomain = func(ctx context.Context, domain string) (string, error) {
+		destroyed = append(destroyed, domain)
+		if domain == "stuck" {
+			return "", errors.New("error: Failed to terminate process: Device or resource busy")
+		}
+		return "", nil
+	}
+
+	var progress []float64
+	report := func(p float64, message string) { progress = append(progress, p) }
+
+	// One domain at a time keeps destroyed and progress in order
+	want := []ShutdownAllResult{
+		{ID: "graceful", Result: "shut_off"},
+		{ID: "transient", Result: "shut_off"},
+		{ID: "hung", Result: "destroyed"},
+		{ID: "no-acpi", Result: "destroyed"},
+		{ID: "stuck", Result: "failed", Error: "error: Failed to terminate process: Device or resource busy"},
+	}
+	for _, w := range want {
+		results, err := shutdownAll(context.Background(), []string{w.ID}, time.Second, report)
+		if !reflect.DeepEqual(results, []ShutdownAllResult{w}) {
+			t.Errorf("results = %+v, want %+v", results, w)
+		}
+		if (err != nil) != (w.Result == "failed") {
+			t.Errorf("%s: error = %v", w.ID, err)
+		}
+	}
+
+	if wantDestroyed := []string{"hung", "no-acpi", "stuck"}; !reflect.DeepEqual(destroyed, wantDestroyed) {
+		t.Errorf("destroyed %v, want %v", destroyed, wantDestroyed)
+	}
+	if len(progress) != 2*len(want) || progress[len(progress)-1] != 100 {
+		t.Errorf("progress = %v", progress)
+	}
+}
+
+func TestShutdownAllRequestValidate(t *testing.T) {
+	tests := []struct {
+		req   ShutdownAllRequest
+		valid bool
+	}{
+		{ShutdownAllRequest{Confirm: true}, true},
+		{ShutdownAllRequest{Confirm: true, Timeout: 300}, true},
+		{ShutdownAllRequest{}, false},
+		{ShutdownAllRequest{Confirm: true, Timeout: 7200}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.req.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tt.req, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/server/utils"
+)
+
+// Free space required before an operation starts writing, unless the
+// matching *_MIN_FREE_MB variable is set.
+const (
+	defaultDefineMinFreeMB     = 64
+	defaultDiskCreateMinFreeMB = 1024
+)
+
+// checkStorage verifies that every dir is writable and has the free space
+// configured in envName. On failure the error is written to w and ok is false.
+func checkStorage(w http.ResponseWriter, envName string, defMB int, dirs ...string) (ok bool) {
+	required := uint64(max(config.Int(envName, defMB), 0)) << 20
+	for _, dir := range dirs {
+		err := filesystem.CheckStorage(dir, required)
+		if err == nil {
+			continue
+		}
+
+		var storageErr *filesystem.StorageError
+		if !errors.As(err, &storageErr) {
+			utils.JSONErrorResponse(w, "Failed to check storage: "+err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		body := map[string]interface{}{
+			"error":          "Insufficient storage: " + storageErr.Error(),
+			"path":           storageErr.Path,
+			"required_bytes": storageErr.RequiredBytes,
+		}
+		if storageErr.Err == nil {
+			body["available_bytes"] = storageErr.AvailableBytes
+		}
+		utils.JSONResponse(w, body, http.StatusInsufficientStorage)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/server/utils"
+	"libvirt-controller/internal/templates"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// templateErrorResponse reports a failed template store operation with the
+// status matching its cause.
+func templateErrorResponse(w http.ResponseWriter, message string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, templates.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, templates.ErrExists):
+		status = http.StatusConflict
+	}
+	utils.JSONErrorResponse(w, message+": "+err.Error(), status)
+}
+
+type CreateTemplateRequest struct {
+	Name    string `json:"name"`
+	Content string `json:"content"` // Domain XML with {{.VMID}} and other placeholders
+}
+
+func (req *CreateTemplateRequest) Validate() error {
+	if !templates.ValidName(req.Name) {
+		return utils.FieldError("name", "may only contain letters, digits, '_', '.' and '-'")
+	}
+	return validateTemplateContent(req.Content)
+}
+
+type UpdateTemplateRequest struct {
+	Content string `json:"content"`
+}
+
+func (req *UpdateTemplateRequest) Validate() error {
+	return validateTemplateContent(req.Content)
+}
+
+func validateTemplateContent(content string) error {
+	if content == "" {
+		return utils.FieldError("content", "is required")
+	}
+	if err := templates.Check(content); err != nil {
+		return utils.FieldError("content", "is not a valid template: %s", err)
+	}
+	return nil
+}
+
+// ListTemplatesHandler lists the stored domain templates, without their content
+func ListTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := templates.List()
+	if err != nil {
+		templateErrorResponse(w, "Failed to list templates", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success":   true,
+		"templates": list,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// GetTemplateHandler returns a stored domain template
+func GetTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !templates.ValidName(name) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("'%s' is not a template name", name), http.StatusBadRequest)
+		return
+	}
+
+	tmpl, err := templates.Get(name)
+	if err != nil {
+		templateErrorResponse(w, "Failed to read template", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success":  true,
+		"template": tmpl,
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// CreateTemplateHandler stores a new domain template
+func CreateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req CreateTemplateRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	if _, err := templates.Save(req.Name, req.Content, false); err != nil {
+		templateErrorResponse(w, "Failed to save template", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Template created",
+		"name":    req.Name,
+	}
+	utils.JSONResponse(w, response, http.StatusCreated)
+}
+
+// UpdateTemplateHandler replaces a domain template, or creates it. Domains
+// defined from it before keep their definition.
+func UpdateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !templates.ValidName(name) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("'%s' is not a template name", name), http.StatusBadRequest)
+		return
+	}
+
+	// Decode and validate the JSON request
+	var req UpdateTemplateRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	created, err := templates.Save(name, req.Content, true)
+	if err != nil {
+		templateErrorResponse(w, "Failed to save template", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Template updated",
+		"name":    name,
+	}
+	status := http.StatusOK
+	if created {
+		response["message"] = "Template created"
+		status = http.StatusCreated
+	}
+	utils.JSONResponse(w, response, status)
+}
+
+// DeleteTemplateHandler deletes a domain template. Domains defined from it
+// keep their definition.
+func DeleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !templates.ValidName(name) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("'%s' is not a template name", name), http.StatusBadRequest)
+		return
+	}
+
+	if err := templates.Delete(name); err != nil {
+		templateErrorResponse(w, "Failed to delete template", err)
+		return
+	}
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Template deleted successfully",
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// DefineFromTemplateRequest defines a domain from a stored template. The
+// other fields are those of DefineRequest.
+type DefineFromTemplateRequest struct {
+	ID           string                 `json:"id"`
+	Template     string                 `json:"template"`
+	Variables    map[string]interface{} `json:"variables,omitempty"` // Values of the placeholders besides VMID
+	TTL          int                    `json:"ttl_seconds,omitempty"`
+	Force        bool                   `json:"force,omitempty"`
+	RequireDisks bool                   `json:"require_disks,omitempty"`
+	StorageClass string                 `json:"storage_class,omitempty"`
+}
+
+func (req *DefineFromTemplateRequest) Validate() error {
+	if req.Template == "" {
+		return utils.FieldError("template", "is required")
+	}
+	if !templates.ValidName(req.Template) {
+		return utils.FieldError("template", "is not a template name")
+	}
+	if _, ok := req.Variables[templates.IDVariable]; ok {
+		return utils.FieldError("variables", "must not set %s, it is the id", templates.IDVariable)
+	}
+	// The XML is only rendered later, the other fields are checked as usual
+	define := req.defineRequest(" ")
+	return define.Validate()
+}
+
+// defineRequest returns the define of the rendered xmlConfig.
+func (req *DefineFromTemplateRequest) defineRequest(xmlConfig string) DefineRequest {
+	return DefineRequest{
+		ID:           req.ID,
+		XMLConfig:    xmlConfig,
+		TTL:          req.TTL,
+		Force:        req.Force,
+		RequireDisks: req.RequireDisks,
+		StorageClass: req.StorageClass,
+	}
+}
+
+// DefineFromTemplateHandler renders a stored template with the request's
+// variables and defines the domain like DefineDomainHandler
+func DefineFromTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode and validate the JSON request
+	var req DefineFromTemplateRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	tmpl, err := templates.Get(req.Template)
+	if err != nil {
+		templateErrorResponse(w, "Failed to read template", err)
+		return
+	}
+	xmlConfig, err := templates.Render(tmpl.Name, tmpl.Content, req.ID, req.Variables)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to render template: %s", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// A hard-coded name would define another domain than the id
+	domain, err := domainxml.Parse([]byte(xmlConfig))
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Rendered template is not valid domain XML: %s", err), http.StatusUnprocessableEntity)
+		return
+	}
+	if domain.Name != req.ID {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Rendered domain name '%s' does not match id '%s', name it {{.VMID}}", domain.Name, req.ID), http.StatusUnprocessableEntity)
+		return
+	}
+
+	define(w, r, req.defineRequest(xmlConfig))
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/templates"
+)
+
+func TestDefineFromTemplate(t *testing.T) {
+	originalList, originalDefine := listDomains, defineDomain
+	defer func() { listDomains, defineDomain = originalList, originalDefine }()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return nil, nil }
+	defineDomain = func(ctx context.Context, xmlPath string) (string, error) { return "", nil }
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	t.Setenv("TEMPLATES_DIR", t.TempDir())
+
+	if _, err := templates.Save("small", `<domain type='kvm'><name>{{.VMID}}</name><memory unit='MiB'>{{.MemoryMB}}</memory></domain>`, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := templates.Save("fixed", `<domain type='kvm'><name>web</name></domain>`, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"defined", `{"id": "vm-1", "template": "small", "variables": {"MemoryMB": 2048}}`, http.StatusCreated},
+		{"missing variable", `{"id": "vm-2", "template": "small"}`, http.StatusUnprocessableEntity},
+		{"name is not the id", `{"id": "vm-3", "template": "fixed"}`, http.StatusUnprocessableEntity},
+		{"unknown template", `{"id": "vm-4", "template": "large"}`, http.StatusNotFound},
+		{"id set as variable", `{"id": "vm-5", "template": "small", "variables": {"VMID": "vm-6"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/from-template", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			DefineFromTemplateHandler(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	saved, err := os.ReadFile(filepath.Join(dir, "vm-1", "server.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<domain type='kvm'><name>vm-1</name><memory unit='MiB'>2048</memory></domain>`; string(saved) != want {
+		t.Errorf("server.xml = %s, want %s", saved, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vm-3")); !os.IsNotExist(err) {
+		t.Error("nothing must be written for a template naming another domain")
+	}
+}
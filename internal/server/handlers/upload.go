@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/quota"
+	"libvirt-controller/internal/server/utils"
+)
+
+// maxUploadFieldBytes bounds the form fields sent along with an upload.
+const maxUploadFieldBytes = 4096
+
+// maxUploadBytes bounds uploaded images, by default to MAX_DISK_SIZE_GB.
+func maxUploadBytes() int64 {
+	defMB := config.Int("MAX_DISK_SIZE_GB", defaultMaxDiskSizeGB) * 1024
+	return int64(config.Int("DISK_UPLOAD_MAX_MB", defMB)) << 20
+}
+
+// UploadDiskRequest holds the form fields of a disk upload. They have to
+// come before the file part, which is streamed straight to the target.
+type UploadDiskRequest struct {
+	Name   string // File name of the disk in Path
+	Path   string // Absolute directory the disk is written to, inside a storage class
+	Size   int    // GB to resize the image to, left as uploaded when 0
+	SHA256 string // Expected hex checksum of the upload, optional
+}
+
+func (req *UploadDiskRequest) Validate() error {
+	if req.Name == "" {
+		return utils.FieldError("name", "is required")
+	}
+	if req.Name != filepath.Base(req.Name) || req.Name == "." || req.Name == ".." {
+		return utils.FieldError("name", "must be a file name without directories")
+	}
+	if controllerFile(req.Name) {
+		return utils.FieldError("name", "must not be a file of the controller")
+	}
+	if req.Path == "" {
+		return utils.FieldError("path", "is required")
+	}
+	if !filepath.IsAbs(req.Path) {
+		return utils.FieldError("path", "must be absolute")
+	}
+	if !inStorageClass(filepath.Join(req.Path, req.Name)) {
+		return utils.FieldError("path", "must be inside DEFINITIONS_DIR or a storage class directory")
+	}
+	if req.SHA256 != "" {
+		if sum, err := hex.DecodeString(req.SHA256); err != nil || len(sum) != sha256.Size {
+			return utils.FieldError("sha256", "must be a hex encoded SHA-256 checksum")
+		}
+	}
+	if req.Size != 0 {
+		return validateDiskSize(req.Size)
+	}
+	return nil
+}
+
+// setField stores a form field of the upload.
+func (req *UploadDiskRequest) setField(name string, value string) error {
+	switch name {
+	case "name":
+		req.Name = value
+	case "path":
+		req.Path = value
+	case "sha256":
+		req.SHA256 = strings.ToLower(value)
+	case "size":
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return utils.FieldError("size", "must be a number")
+		}
+		req.Size = size
+	}
+	return nil
+}
+
+// UploadDiskHandler creates a disk from an image uploaded as
+// multipart/form-data, for hosts that can't download images themselves. The
+// file is streamed to disk, never held in memory.
+func UploadDiskHandler(w http.ResponseWriter, r *http.Request) {
+	parts, err := r.MultipartReader()
+	if err != nil {
+		utils.JSONErrorResponse(w, "Request must be multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	var req UploadDiskRequest
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			utils.JSONRequestErrorResponse(w, utils.FieldError("file", "is required"))
+			return
+		}
+		if err != nil {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read upload: %v", err), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() == "file" {
+			uploadDisk(w, r, &req, part)
+			return
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldBytes))
+		if err != nil {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read upload: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := req.setField(part.FormName(), string(value)); err != nil {
+			utils.JSONRequestErrorResponse(w, err)
+			return
+		}
+	}
+}
+
+// uploadDisk writes the file part of an upload to the disk described by req.
+func uploadDisk(w http.ResponseWriter, r *http.Request, req *UploadDiskRequest, file io.Reader) {
+	if err := req.Validate(); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+	if !checkDiskOwner(w, r.Context(), filepath.Join(req.Path, req.Name)) {
+		return
+	}
+
+	if !checkStorage(w, "DISK_CREATE_MIN_FREE_MB", defaultDiskCreateMinFreeMB, req.Path) {
+		return
+	}
+	if err := filesystem.CreateDirectory(req.Path, 0755); err != nil {
+		log.Printf("Error creating directory %s: %v", req.Path, err)
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to create disk directory: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	imagePath := filepath.Join(req.Path, req.Name)
+	if _, err := os.Stat(imagePath); err == nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Disk %s already exists", imagePath), http.StatusConflict)
+		return
+	}
+
+	// Written next to the target and renamed once complete and verified
+	tmp, err := os.CreateTemp(req.Path, "."+req.Name+".upload-*")
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to create disk: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename succeeded
+
+	limit := maxUploadBytes()
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(file, limit+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > limit {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Upload exceeds %d MB", limit>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.JSONErrorResponse(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to write upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if req.SHA256 != "" && checksum != req.SHA256 {
+		response := map[string]interface{}{
+			"success":  false,
+			"error":    "Checksum of the upload does not match",
+			"expected": req.SHA256,
+			"actual":   checksum,
+		}
+		utils.JSONResponse(w, response, http.StatusUnprocessableEntity)
+		return
+	}
+
+	info, err := diskInfo(r.Context(), tmp.Name())
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Upload is not a disk image: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Charged for the size the disk can grow to, a larger image isn't shrunk
+	sizeGB := max(req.Size, bytesToGB(info.VirtualSize))
+	undoQuota, ok := reserveQuota(w, quota.TenantFrom(r.Context()), quota.DiskKey(imagePath), quota.Allocation{DiskGB: int64(sizeGB)})
+	if !ok {
+		return
+	}
+
+	if err := os.Chmod(tmp.Name(), 0660); err == nil {
+		err = os.Rename(tmp.Name(), imagePath)
+	}
+	if err != nil {
+		undoQuota()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to save disk at %s: %v", imagePath, err), http.StatusInternalServerError)
+		return
+	}
+
+	resized := false
+	if req.Size != 0 {
+		if _, resized, err = growDisk(r.Context(), imagePath, req.Size); err != nil {
+			undoQuota()
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to resize disk at %s: %v", imagePath, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Disk uploaded successfully",
+		"disk": map[string]interface{}{
+			"name":    req.Name,
+			"path":    imagePath,
+			"size":    sizeGB,
+			"format":  info.Format,
+			"bytes":   written,
+			"sha256":  checksum,
+			"resized": resized,
+		},
+	}
+	utils.JSONResponse(w, response, http.StatusCreated)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/quota"
+)
+
+// uploadRequest builds a multipart disk upload with fields before the file.
+func uploadRequest(t *testing.T, fields [][2]string, file []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, f := range fields {
+		form.WriteField(f[0], f[1])
+	}
+	if file != nil {
+		part, err := form.CreateFormFile("file", "image.qcow2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/disk/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestUploadDiskHandler(t *testing.T) {
+	original := diskInfo
+	defer func() { diskInfo = original }()
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		return &qemu.DiskInfo{Filename: path, Format: "qcow2", VirtualSize: 3 << 30}, nil
+	}
+	t.Setenv("DISK_CREATE_MIN_FREE_MB", "0")
+	t.Setenv("DISK_UPLOAD_MAX_MB", "1")
+
+	image := []byte("QFI\xfb image data")
+	sum := sha256.Sum256(image)
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name       string
+		fields     func(dir string) [][2]string
+		file       []byte
+		wantStatus int
+	}{
+		{"uploaded", func(dir string) [][2]string {
+			return [][2]string{{"path", dir}, {"name", "disk.qcow2"}, {"sha256", checksum}}
+		}, image, http.StatusCreated},
+		{"checksum mismatch", func(dir string) [][2]string {
+			return [][2]string{{"path", dir}, {"name", "disk.qcow2"}, {"sha256", hex.EncodeToString(make([]byte, 32))}}
+		}, image, http.StatusUnprocessableEntity},
+		{"too large", func(dir string) [][2]string { return [][2]string{{"path", dir}, {"name", "disk.qcow2"}} }, make([]byte, 1<<20+1), http.StatusRequestEntityTooLarge},
+		{"name with directories", func(dir string) [][2]string { return [][2]string{{"path", dir}, {"name", "../disk.qcow2"}} }, image, http.StatusBadRequest},
+		{"relative path", func(dir string) [][2]string { return [][2]string{{"path", "disks"}, {"name", "disk.qcow2"}} }, image, http.StatusBadRequest},
+		{"outside the storage classes", func(dir string) [][2]string { return [][2]string{{"path", "/etc"}, {"name", "disk.qcow2"}} }, image, http.StatusBadRequest},
+		{"file of the controller", func(dir string) [][2]string { return [][2]string{{"path", dir}, {"name", "server.xml"}} }, image, http.StatusBadRequest},
+		{"fields after the file", func(dir string) [][2]string { return nil }, image, http.StatusBadRequest},
+		{"no file", func(dir string) [][2]string { return [][2]string{{"path", dir}, {"name", "disk.qcow2"}} }, nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("DEFINITIONS_DIR", dir)
+			rec := httptest.NewRecorder()
+			UploadDiskHandler(rec, uploadRequest(t, tt.fields(dir), tt.file))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			entries, _ := os.ReadDir(dir)
+			if tt.wantStatus != http.StatusCreated {
+				if len(entries) != 0 {
+					t.Errorf("failed upload left %v behind", entries)
+				}
+				return
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "disk.qcow2"))
+			if err != nil || !bytes.Equal(data, image) {
+				t.Errorf("disk holds %q, %v", data, err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("upload left %v behind", entries)
+			}
+		})
+	}
+}
+
+func TestUploadDiskRefusesExistingDisk(t *testing.T) {
+	t.Setenv("DISK_CREATE_MIN_FREE_MB", "0")
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	if err := os.WriteFile(filepath.Join(dir, "disk.qcow2"), []byte("in use"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	UploadDiskHandler(rec, uploadRequest(t, [][2]string{{"path", dir}, {"name", "disk.qcow2"}}, []byte("new")))
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "disk.qcow2")); string(data) != "in use" {
+		t.Errorf("existing disk was overwritten with %q", data)
+	}
+}
+
+func TestUploadDiskChargesImageSize(t *testing.T) {
+	original := diskInfo
+	defer func() { diskInfo = original }()
+	diskInfo = func(ctx context.Context, path string) (*qemu.DiskInfo, error) {
+		return &qemu.DiskInfo{Filename: path, Format: "qcow2", VirtualSize: 3 << 30}, nil
+	}
+	t.Setenv("DISK_CREATE_MIN_FREE_MB", "0")
+	originalIndex := quota.Default
+	defer func() { quota.Default = originalIndex }()
+	quota.Default = quota.NewIndex()
+	quotaFile := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(quotaFile, []byte(`{"token": {"tenant": "acme", "disk_gb": 4}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Default.Configure(quotaFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// A 3 GB image asked for as 1 GB is charged and reported with 3 GB
+	classDir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", classDir)
+	dir := filepath.Join(classDir, "vm-1")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.Save(dir, &metadata.Metadata{Tenant: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	req := uploadRequest(t, [][2]string{{"path", dir}, {"name", "a.qcow2"}, {"size", "1"}}, []byte("image"))
+	req = req.WithContext(quota.WithTenant(req.Context(), "acme"))
+	rec := httptest.NewRecorder()
+	UploadDiskHandler(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var body struct {
+		Disk struct {
+			Size int `json:"size"`
+		} `json:"disk"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Disk.Size != 3 {
+		t.Errorf("size = %d, %v; want 3", body.Disk.Size, err)
+	}
+	if usage := quota.Default.Usage("acme"); usage.DiskGB != 3 {
+		t.Errorf("disk charged = %d GB, want 3", usage.DiskGB)
+	}
+
+	// Another one doesn't fit into the remaining 1 GB
+	req = uploadRequest(t, [][2]string{{"path", dir}, {"name", "b.qcow2"}, {"size", "1"}}, []byte("image"))
+	req = req.WithContext(quota.WithTenant(req.Context(), "acme"))
+	rec = httptest.NewRecorder()
+	UploadDiskHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.qcow2")); !os.IsNotExist(err) {
+		t.Error("a disk over quota must not be saved")
+	}
+}
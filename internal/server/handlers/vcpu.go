@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/server/utils"
+)
+
+// libvirt and agent calls made by vCPU hotplug, next to guestPing; swapped
+// out in tests.
+var (
+	setVCPUs    = libvirt.SetVCPUs
+	onlineVCPUs = qemu.OnlineVCPUs
+)
+
+// The guest only sees hotplugged CPUs once it has handled the ACPI event, so
+// onlining is retried a few times until all of them show up.
+var (
+	vcpuOnlineAttempts = 5
+	vcpuOnlineInterval = time.Second
+)
+
+type VCPUHotplugRequest struct {
+	VCPUs int `json:"vcpus"` // Active vCPUs, at most the domain's maximum
+}
+
+func (req *VCPUHotplugRequest) Validate() error {
+	if req.VCPUs <= 0 {
+		return utils.FieldError("vcpus", "must be > 0")
+	}
+	return nil
+}
+
+// VCPUHotplugHandler changes the active vCPUs of a running domain and onlines
+// the new ones inside the guest through the guest agent
+func VCPUHotplugHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	// Decode and validate the JSON request
+	var req VCPUHotplugRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	state, err := domainState(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get domain state", err)
+		return
+	}
+	if state != libvirt.StateRunning {
+		utils.JSONErrorResponse(w, "vCPU hotplug needs a running domain", http.StatusConflict)
+		return
+	}
+
+	// The quota is charged for the maximum, which hotplug can't exceed
+	if _, err := setVCPUs(r.Context(), vmID, req.VCPUs, true, true); err != nil {
+		libvirtErrorResponse(w, "Failed to set vCPUs", err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"vcpus":   req.VCPUs,
+	}
+
+	if err := guestPing(r.Context(), vmID); err != nil {
+		response["manual_online_required"] = true
+		response["message"] = "Guest agent unavailable, online the new CPUs inside the guest"
+		utils.JSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	online, err := onlineVCPUs(r.Context(), vmID)
+	for attempt := 1; err == nil && online < req.VCPUs && attempt < vcpuOnlineAttempts; attempt++ {
+		time.Sleep(vcpuOnlineInterval)
+		online, err = onlineVCPUs(r.Context(), vmID)
+	}
+	if err != nil {
+		response["manual_online_required"] = true
+		response["message"] = fmt.Sprintf("Failed to online CPUs through the guest agent, online them inside the guest: %s", err)
+		utils.JSONResponse(w, response, http.StatusOK)
+		return
+	}
+
+	response["online_vcpus"] = online
+	response["manual_online_required"] = online < req.VCPUs
+	utils.JSONResponse(w, response, http.StatusOK)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+)
+
+func TestVCPUHotplug(t *testing.T) {
+	originalState, originalSet, originalPing, originalOnline, originalInterval := domainState, setVCPUs, guestPing, onlineVCPUs, vcpuOnlineInterval
+	defer func() {
+		domainState, setVCPUs, guestPing, onlineVCPUs, vcpuOnlineInterval = originalState, originalSet, originalPing, originalOnline, originalInterval
+	}()
+	vcpuOnlineInterval = 0
+
+	tests := []struct {
+		name       string
+		state      libvirt.DomainState
+		pingErr    error
+		online     []int // Successive online counts reported by the guest
+		onlineErr  error
+		wantStatus int
+		wantSet    bool
+		wantOnline float64
+		wantManual bool
+	}{
+		{"onlined", libvirt.StateRunning, nil, []int{2, 4}, nil, http.StatusOK, true, 4, false},
+		{"not all online", libvirt.StateRunning, nil, []int{2, 2, 2, 2, 2}, nil, http.StatusOK, true, 2, true},
+		{"no agent", libvirt.StateRunning, errors.New("agent not connected"), nil, nil, http.StatusOK, true, 0, true},
+		{"guest-exec fails", libvirt.StateRunning, nil, nil, errors.New("guest-exec disabled"), http.StatusOK, true, 0, true},
+		{"shut off", libvirt.StateShutOff, nil, nil, nil, http.StatusConflict, false, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domainState = func(ctx context.Context, domain string) (libvirt.DomainState, error) { return tt.state, nil }
+			set := false
+			setVCPUs = func(ctx context.Context, domain string, count int, live bool, config bool) (string, error) {
+				set = count == 4 && live
+				return "", nil
+			}
+			guestPing = func(ctx context.Context, vm string) error { return tt.pingErr }
+			calls := 0
+			onlineVCPUs = func(ctx context.Context, vm string) (int, error) {
+				if tt.onlineErr != nil {
+					return 0, tt.onlineErr
+				}
+				calls++
+				return tt.online[min(calls, len(tt.online))-1], nil
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/vcpu-hotplug", strings.NewReader(`{"vcpus": 4}`))
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			VCPUHotplugHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if set != tt.wantSet {
+				t.Fatalf("setvcpus called = %t, want %t", set, tt.wantSet)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			online, _ := body["online_vcpus"].(float64)
+			if online != tt.wantOnline || body["manual_online_required"] != tt.wantManual {
+				t.Errorf("response = %v", body)
+			}
+		})
+	}
+}
@@ -3,17 +3,27 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/events"
 	"libvirt-controller/internal/filesystem"
 	"libvirt-controller/internal/helpers"
 	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/lifecycle"
+	"libvirt-controller/internal/metadata"
 	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/quota"
 	"libvirt-controller/internal/server/utils"
 
 	"github.com/go-chi/chi/v5"
@@ -21,48 +31,118 @@ import (
 
 // Request struct to handle expected JSON fields
 type DefineRequest struct {
-	ID        string `json:"id"`
-	XMLConfig string `json:"xml_config"`
+	ID        string                `json:"id"`
+	XMLConfig string                `json:"xml_config"`
+	Spec      *domainxml.DomainSpec `json:"spec,omitempty"`        // Generate the XML instead of passing it
+	TTL       int                   `json:"ttl_seconds,omitempty"` // Delete the domain after this many seconds
+	Force     bool                  `json:"force,omitempty"`       // Redefine even if another domain has the same name
+
+	// RequireDisks rejects a definition whose disk images don't exist yet,
+	// by default they are only reported. See DEFINE_REQUIRE_DISKS.
+	RequireDisks bool `json:"require_disks,omitempty"`
+
+	// StorageClass selects the base directory of the VM, see STORAGE_CLASSES
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+func (req *DefineRequest) Validate() error {
+	if req.ID == "" {
+		return utils.FieldError("id", "is required")
+	}
+	if !domainNamePattern.MatchString(req.ID) {
+		return utils.FieldError("id", "may only contain letters, digits, '_', '.', '+' and '-'")
+	}
+	if req.XMLConfig == "" && req.Spec == nil {
+		return utils.FieldError("xml_config", "is required unless 'spec' is set")
+	}
+	if req.XMLConfig != "" && req.Spec != nil {
+		return utils.FieldError("spec", "must not be set together with 'xml_config'")
+	}
+	if req.TTL < 0 {
+		return utils.FieldError("ttl_seconds", "must be >= 0")
+	}
+	if req.StorageClass != "" {
+		if _, err := filesystem.StorageClassDir(req.StorageClass); err != nil {
+			return utils.FieldError("storage_class", "must be one of the configured storage classes")
+		}
+	}
+	return nil
 }
 
 // DefineDomainHandler handles libvirt domain creation and updates
 func DefineDomainHandler(w http.ResponseWriter, r *http.Request) {
-	// Read raw request body
-	rawBody, err := io.ReadAll(r.Body)
-	if err != nil {
-		utils.JSONErrorResponse(w, "Failed to read request body", http.StatusInternalServerError)
+	// Decode and validate the JSON request
+	var req DefineRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
+	define(w, r, req)
+}
 
-	// Ensure body is not empty
-	if len(rawBody) == 0 {
-		utils.JSONErrorResponse(w, "Empty request body", http.StatusBadRequest)
-		return
+// define defines the domain of a validated request, see DefineDomainHandler.
+func define(w http.ResponseWriter, r *http.Request, req DefineRequest) {
+	vmID := req.ID
+	// A concurrent define of the same id would interleave the writes below
+	defer lifecycle.Lock(vmID)()
+
+	// Generate the XML from the spec before touching the filesystem
+	xmlConfig := req.XMLConfig
+	if req.Spec != nil {
+		if req.Spec.Name == "" {
+			req.Spec.Name = vmID
+		}
+		var err error
+		xmlConfig, err = domainxml.Build(*req.Spec)
+		if err != nil {
+			utils.JSONRequestErrorResponse(w, utils.FieldError("spec", "is invalid: %s", err))
+			return
+		}
+		if err := checkNetworks(r.Context(), req.Spec); errors.Is(err, errMissingNetwork) {
+			utils.JSONErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			libvirtErrorResponse(w, "Failed to list networks", err)
+			return
+		}
 	}
 
-	// Decode JSON request from rawBody
-	var req DefineRequest
-	if err := json.Unmarshal(rawBody, &req); err != nil {
-		utils.JSONErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
-		log.Println("JSON Unmarshal error:", err) // Print error for debugging
+	// Basic validation for DEFINITIONS_DIR
+	if len(filesystem.StorageClasses()) == 0 {
+		utils.JSONErrorResponse(w, "DEFINITIONS_DIR environment variable not set", http.StatusInternalServerError)
 		return
 	}
 
-	// Validate required fields
-	if req.ID == "" {
-		utils.JSONErrorResponse(w, "Missing 'id'", http.StatusBadRequest)
+	// Redefinitions stay in the storage class the VM already lives in
+	existingDir, existingClass, err := filesystem.FindVMDir(vmID)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to verify VM directory: %s", err), http.StatusInternalServerError)
 		return
 	}
-	if req.XMLConfig == "" {
-		utils.JSONErrorResponse(w, "Missing 'xmlConfig'", http.StatusBadRequest)
+	storageClass := req.StorageClass
+	if storageClass == "" {
+		storageClass = existingClass
+	}
+	if storageClass == "" {
+		storageClass = filesystem.DefaultStorageClass
+	}
+	// Tenants can't redefine the domains of others
+	if existingDir != "" {
+		if owned, err := ownsDomain(r.Context(), existingDir); err != nil {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to load metadata: %s", err), http.StatusInternalServerError)
+			return
+		} else if !owned {
+			utils.JSONErrorResponse(w, fmt.Sprintf("VM '%s' already exists", vmID), http.StatusConflict)
+			return
+		}
+	}
+	if existingDir != "" && storageClass != existingClass {
+		utils.JSONErrorResponse(w, fmt.Sprintf("VM '%s' already exists in storage class '%s'", vmID, existingClass), http.StatusConflict)
 		return
 	}
-
-	vmID := req.ID
-	definitionsDir := os.Getenv("DEFINITIONS_DIR")
-
-	// Basic validation for DEFINITIONS_DIR
-	if definitionsDir == "" {
+	definitionsDir, err := filesystem.StorageClassDir(storageClass)
+	if err != nil {
+		// Only the default class can be missing here, Validate checked the others
 		utils.JSONErrorResponse(w, "DEFINITIONS_DIR environment variable not set", http.StatusInternalServerError)
 		return
 	}
@@ -70,6 +150,61 @@ func DefineDomainHandler(w http.ResponseWriter, r *http.Request) {
 	// Create VM directory
 	vmDir := filepath.Join(definitionsDir, vmID)
 
+	// Refuse to silently rewrite a domain that belongs to another id
+	domain, err := domainxml.Parse([]byte(xmlConfig))
+	if err != nil {
+		utils.JSONRequestErrorResponse(w, utils.FieldError("xml_config", "is not valid domain XML: %s", err))
+		return
+	}
+	// A tenant could otherwise rewrite the domain of another id by its name
+	if quota.TenantFrom(r.Context()) != "" {
+		if req.Force {
+			utils.JSONErrorResponse(w, "'force' requires the admin token", http.StatusForbidden)
+			return
+		}
+		if domain.Name != vmID {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Domain name '%s' does not match id '%s'", domain.Name, vmID), http.StatusBadRequest)
+			return
+		}
+	}
+	if !req.Force {
+		if err := checkNameCollision(r.Context(), vmDir, domain.Name); err != nil {
+			if errors.Is(err, errNameCollision) {
+				utils.JSONErrorResponse(w, err.Error(), http.StatusConflict)
+				return
+			}
+			libvirtErrorResponse(w, "Failed to check for name collisions", err)
+			return
+		}
+	}
+
+	// Disks are usually created after the define, so missing ones only fail
+	// it when asked to
+	missing := missingDisks(domain)
+	if len(missing) > 0 && (req.RequireDisks || config.Bool("DEFINE_REQUIRE_DISKS", false)) {
+		missingDisksResponse(w, "Domain references disks that don't exist", missing)
+		return
+	}
+
+	// Fail upfront rather than halfway through writing the definition
+	if !checkStorage(w, "DEFINE_MIN_FREE_MB", defaultDefineMinFreeMB, vmDir) {
+		return
+	}
+
+	// Charge the tenant before anything is written. A redefinition stays with
+	// the tenant that owns the domain and replaces what it held before.
+	tenant := domainTenant(r.Context(), vmID)
+	undoQuota, ok := reserveDomainQuota(w, r.Context(), vmID, domain)
+	if !ok {
+		return
+	}
+	defined := false
+	defer func() {
+		if !defined {
+			undoQuota()
+		}
+	}()
+
 	// filesystem.CreateDirectory will create the directory if it doesn't exist,
 	// and do nothing if it already exists.
 	if err := filesystem.CreateDirectory(vmDir, 0755); err != nil {
@@ -78,8 +213,6 @@ func DefineDomainHandler(w http.ResponseWriter, r *http.Request) {
 		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to create VM directory: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
-	// Define the domain (VM) using the saved XML configuration
-	xmlConfig := req.XMLConfig
 
 	// filesystem.SaveFile will overwrite "server.xml" if it exists,
 	// and create it if it doesn't.
@@ -90,26 +223,156 @@ func DefineDomainHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Record the storage class, and the expiry for the reaper, before the
+	// domain exists in libvirt
+	m, err := metadata.Load(vmDir)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to load metadata: %s", err), http.StatusInternalServerError)
+		return
+	}
+	m.StorageClass = storageClass
+	if tenant != "" {
+		m.Tenant = tenant
+	}
+	if req.TTL > 0 {
+		expiry := time.Now().UTC().Add(time.Duration(req.TTL) * time.Second)
+		m.ExpiresAt = &expiry
+	}
+	if err := metadata.Save(vmDir, m); err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to save metadata: %s", err), http.StatusInternalServerError)
+		return
+	}
+
 	// Define the domain in libvirt
 	// Ensure your libvirt.DefineDomain can handle an existing domain definition
 	// (e.g., if you're redefining, it should update or detach/attach)
-	if _, err := libvirt.DefineDomain(filepath.Join(vmDir, "server.xml")); err != nil {
+	if _, err := defineDomain(r.Context(), filepath.Join(vmDir, "server.xml")); err != nil {
 		// Log the error for debugging
 		log.Printf("Error defining domain with libvirt from %s/server.xml: %v", vmDir, err)
 		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to define domain: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
+	defined = true
 
 	// Domain defined
 	response := map[string]interface{}{
-		"success": true,
-		"message": "Domain defined",
-		"id":      vmID,
-		"path":    vmDir,
+		"success":       true,
+		"message":       "Domain defined",
+		"id":            vmID,
+		"path":          vmDir,
+		"storage_class": storageClass,
+	}
+	if req.TTL > 0 {
+		response["expires_at"] = m.ExpiresAt
+	}
+	if len(missing) > 0 {
+		response["missing_disks"] = missing
+		response["warning"] = "Some disks don't exist yet, create them before starting the domain"
 	}
 	utils.JSONResponse(w, response, http.StatusCreated)
 }
 
+// missingDisks returns the file backed disks of domain whose image doesn't
+// exist on the host.
+func missingDisks(domain *domainxml.Domain) []domainxml.DiskFile {
+	var missing []domainxml.DiskFile
+	for _, disk := range domain.DiskFiles() {
+		if !filesystem.FileExists(disk.Source) {
+			missing = append(missing, disk)
+		}
+	}
+	return missing
+}
+
+// definedMissingDisks returns the missing disks of the inactive definition
+// of vmID, the one libvirt starts. Domains libvirt doesn't know report none.
+func definedMissingDisks(ctx context.Context, vmID string) []domainxml.DiskFile {
+	data, err := dumpXML(ctx, vmID, true)
+	if err != nil {
+		return nil
+	}
+	domain, err := domainxml.Parse([]byte(data))
+	if err != nil {
+		return nil
+	}
+	return missingDisks(domain)
+}
+
+func missingDisksResponse(w http.ResponseWriter, message string, missing []domainxml.DiskFile) {
+	response := map[string]interface{}{
+		"success":       false,
+		"error":         message,
+		"missing_disks": missing,
+	}
+	utils.JSONResponse(w, response, http.StatusUnprocessableEntity)
+}
+
+// Libvirt calls of defines; swapped out in tests.
+var (
+	listDomains  = libvirt.ListAllDomains
+	defineDomain = libvirt.DefineDomain
+	listNetworks = libvirt.ListNetworks
+	checkBridge  = domainxml.CheckBridge
+)
+
+var errNameCollision = errors.New("domain name is already in use")
+
+// checkNameCollision fails when name belongs to a libvirt domain that wasn't
+// defined from vmDir. virsh define updates the domain with the same name, so
+// defining it would rewrite another id's domain and leave two directories
+// pointing at a single domain.
+func checkNameCollision(ctx context.Context, vmDir string, name string) error {
+	domains, err := listDomains(ctx, true)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(domains, name) {
+		return nil
+	}
+
+	// Redefining the domain this directory already owns is an update
+	if data, err := os.ReadFile(filepath.Join(vmDir, "server.xml")); err == nil {
+		if previous, err := domainxml.Parse(data); err == nil && previous.Name == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q belongs to another domain, set 'force' to redefine it anyway", errNameCollision, name)
+}
+
+var errMissingNetwork = errors.New("network is not available")
+
+// missingNetworkHint ends the errors of networks and bridges that don't exist.
+const missingNetworkHint = "pick another network or bridge for the interface or set DEFAULT_NETWORK or DEFAULT_BRIDGE"
+
+// checkNetworks fails when one of the libvirt networks or host bridges a
+// generated domain joins doesn't exist or one of the networks isn't active;
+// the domain would be defined but fail to start.
+func checkNetworks(ctx context.Context, spec *domainxml.DomainSpec) error {
+	networks, bridges := spec.Networks()
+	for _, name := range bridges {
+		if err := checkBridge(name); err != nil {
+			return fmt.Errorf("%w: %w, %s", errMissingNetwork, err, missingNetworkHint)
+		}
+	}
+	if len(networks) == 0 {
+		return nil
+	}
+	existing, err := listNetworks(ctx)
+	if err != nil {
+		return err
+	}
+	for _, name := range networks {
+		i := slices.IndexFunc(existing, func(n libvirt.Network) bool { return n.Name == name })
+		switch {
+		case i < 0:
+			return fmt.Errorf("%w: network %q doesn't exist on this host, %s", errMissingNetwork, name, missingNetworkHint)
+		case !existing[i].Active:
+			return fmt.Errorf("%w: network %q isn't active, start it with virsh net-start", errMissingNetwork, name)
+		}
+	}
+	return nil
+}
+
 // DomainMiddleware ensures that a valid domain exists
 func DomainMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -120,33 +383,38 @@ func DomainMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		definitionsDir := os.Getenv("DEFINITIONS_DIR")
-		if definitionsDir == "" {
+		if len(filesystem.StorageClasses()) == 0 {
 			utils.JSONErrorResponse(w, "DEFINITIONS_DIR environment variable not set", http.StatusInternalServerError)
 			return
 		}
 
-		// 2. Construct VM directory path
-		vmDir := filepath.Join(definitionsDir, vmID)
-
-		// 3. Use the helper function to check if the VM directory exists
-		exists, err := filesystem.CheckDirectoryExists(vmDir)
+		// 2. Look for the VM directory in every storage class
+		vmDir, _, err := filesystem.FindVMDir(vmID)
 		if err != nil {
 			// This catches cases where path exists but isn't a directory, or other os.Stat errors
-			fmt.Printf("Error during VM directory check %s: %v\n", vmDir, err) // Log for debugging
-			if err.Error() == fmt.Sprintf("path '%s' exists but is not a directory", vmDir) {
-				utils.JSONErrorResponse(w, fmt.Sprintf("Path '%s' exists but is not a directory for VM ID '%s'.", vmDir, vmID), http.StatusConflict)
+			fmt.Printf("Error during VM directory check for %s: %v\n", vmID, err) // Log for debugging
+			if strings.HasSuffix(err.Error(), "exists but is not a directory") {
+				utils.JSONErrorResponse(w, fmt.Sprintf("%s for VM ID '%s'.", err.Error(), vmID), http.StatusConflict)
 			} else {
 				utils.JSONErrorResponse(w, fmt.Sprintf("Failed to verify VM directory: %s", err.Error()), http.StatusInternalServerError)
 			}
 			return
 		}
-		if !exists {
+		if vmDir == "" {
 			// Directory does not exist
 			utils.JSONErrorResponse(w, fmt.Sprintf("VM directory for ID '%s' not found.", vmID), http.StatusNotFound)
 			return
 		}
 
+		// 3. Tenants only see their own domains, others look like missing ones
+		if owned, err := ownsDomain(r.Context(), vmDir); err != nil {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to load metadata: %s", err), http.StatusInternalServerError)
+			return
+		} else if !owned {
+			utils.JSONErrorResponse(w, fmt.Sprintf("VM directory for ID '%s' not found.", vmID), http.StatusNotFound)
+			return
+		}
+
 		// 4. Add vmID and vmDir to the request context
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, helpers.VMIDKey, vmID)
@@ -163,66 +431,139 @@ type CloudInitRequest struct {
 	VendorData    string `json:"vendorData,omitempty"`
 	UserData      string `json:"userData,omitempty"`
 	NetworkConfig string `json:"networkConfig,omitempty"`
+	Datasource    string `json:"datasource,omitempty"` // nocloud (default) or configdrive
+
+	// ValidateNetworkConfig checks a nocloud networkConfig against the
+	// cloud-init network config format before the ISO is built, unless false
+	ValidateNetworkConfig *bool `json:"validateNetworkConfig,omitempty"`
 }
 
-// CloudInitHandler handles cloud init image generation
-func CloudInitHandler(w http.ResponseWriter, r *http.Request) {
-	vmID := helpers.MustGetVMID(r.Context())
-	vmDir := helpers.MustGetVMDir(r.Context())
+func (req *CloudInitRequest) Validate() error {
+	if req.Datasource == "" {
+		req.Datasource = helpers.DatasourceNoCloud
+	}
+	if !helpers.IsCloudInitDatasource(req.Datasource) {
+		return utils.FieldError("datasource", "must be %s or %s", helpers.DatasourceNoCloud, helpers.DatasourceConfigDrive)
+	}
 
-	// Read raw request body
-	rawBody, err := io.ReadAll(r.Body)
-	if err != nil {
-		utils.JSONErrorResponse(w, "Failed to read request body", http.StatusInternalServerError)
-		return
+	// ConfigDrive reads everything but user_data as JSON
+	if req.Datasource == helpers.DatasourceConfigDrive {
+		jsonFields := []struct{ name, value string }{
+			{"metaData", req.MetaData},
+			{"vendorData", req.VendorData},
+			{"networkConfig", req.NetworkConfig},
+		}
+		for _, f := range jsonFields {
+			if f.value != "" && !json.Valid([]byte(f.value)) {
+				return utils.FieldError(f.name, "must be JSON for the %s datasource", helpers.DatasourceConfigDrive)
+			}
+		}
 	}
 
-	// Ensure body is not empty
-	if len(rawBody) == 0 {
-		utils.JSONErrorResponse(w, "Empty request body", http.StatusBadRequest)
-		return
+	// A broken network-config boots the VM without network, so it is caught
+	// here. ConfigDrive's network_data.json has a format of its own.
+	validate := req.ValidateNetworkConfig == nil || *req.ValidateNetworkConfig
+	if validate && req.Datasource == helpers.DatasourceNoCloud && req.NetworkConfig != "" {
+		if err := helpers.ValidateNetworkConfig(req.NetworkConfig); err != nil {
+			return utils.FieldError("networkConfig", "is invalid: %s", err)
+		}
 	}
+	return nil
+}
 
-	// Decode JSON request from rawBody
+// CloudInitHandler handles cloud init image generation
+func CloudInitHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	defer lifecycle.Lock(vmID)()
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	// Decode and validate the JSON request
 	var req CloudInitRequest
-	if err := json.Unmarshal(rawBody, &req); err != nil {
-		utils.JSONErrorResponse(w, "Invalid JSON", http.StatusBadRequest)
-		log.Println("JSON Unmarshal error:", err) // Print error for debugging
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
 
-	// Save CloudInit files
-	cloudInitFiles := map[string]string{
-		"meta-data":      req.MetaData,
-		"vendor-data":    req.VendorData,
-		"user-data":      req.UserData,
-		"network-config": req.NetworkConfig,
+	// Save CloudInit files, remembering what they replaced
+	cloudInitFiles := []struct{ name, content string }{
+		{"meta-data", req.MetaData},
+		{"vendor-data", req.VendorData},
+		{"user-data", req.UserData},
+		{"network-config", req.NetworkConfig},
 	}
 
-	for fileName, content := range cloudInitFiles {
-		if content != "" {
-			if err := filesystem.SaveFile(vmDir, fileName, []byte(content)); err != nil {
-				utils.JSONErrorResponse(w, fmt.Sprintf("Failed to save '%s' file", fileName), http.StatusInternalServerError)
-				return
-			}
+	var written []cloudInitBackup
+	for _, f := range cloudInitFiles {
+		if f.content == "" {
+			continue
+		}
+		backup, err := backupCloudInitFile(vmDir, f.name)
+		if err == nil {
+			err = filesystem.SaveFileAtomic(vmDir, f.name, []byte(f.content))
 		}
+		if err != nil {
+			restoreCloudInitFiles(vmDir, written)
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to save '%s' file, no changes were applied", f.name), http.StatusInternalServerError)
+			return
+		}
+		written = append(written, backup)
 	}
 
-	// Generate cloud-init ISO
-	if err := helpers.GenerateCloudInitISO(vmDir); err != nil {
-		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to create cloud-init ISO: %s", err.Error()), http.StatusInternalServerError)
+	// Generate cloud-init ISO, the previous one stays in place on failure
+	if err := generateCloudInitISO(r.Context(), vmDir, req.Datasource); err != nil {
+		restoreCloudInitFiles(vmDir, written)
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to create cloud-init ISO, no changes were applied: %s", err.Error()), http.StatusInternalServerError)
 		return
 	}
 
 	// Respond
 	response := map[string]interface{}{
-		"message": "cloud-init drive generated",
-		"id":      vmID,
-		"path":    vmDir,
+		"message":    "cloud-init drive generated",
+		"id":         vmID,
+		"path":       vmDir,
+		"datasource": req.Datasource,
 	}
 	utils.JSONResponse(w, response, http.StatusCreated)
 }
 
+// generateCloudInitISO builds the cloud-init ISO; swapped out in tests.
+var generateCloudInitISO = helpers.GenerateCloudInitISO
+
+// cloudInitBackup is the content a cloud-init file had before a request
+// overwrote it. Existed is false for files the request created.
+type cloudInitBackup struct {
+	name    string
+	data    []byte
+	existed bool
+}
+
+func backupCloudInitFile(vmDir string, name string) (cloudInitBackup, error) {
+	data, err := os.ReadFile(filepath.Join(vmDir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return cloudInitBackup{name: name}, nil
+	}
+	if err != nil {
+		return cloudInitBackup{}, err
+	}
+	return cloudInitBackup{name: name, data: data, existed: true}, nil
+}
+
+// restoreCloudInitFiles rolls the files back to their backups, so the files
+// keep matching the ISO that is still attached to the domain.
+func restoreCloudInitFiles(vmDir string, backups []cloudInitBackup) {
+	for _, b := range backups {
+		var err error
+		if b.existed {
+			err = filesystem.SaveFileAtomic(vmDir, b.name, b.data)
+		} else {
+			err = os.Remove(filepath.Join(vmDir, b.name))
+		}
+		if err != nil {
+			log.Printf("Failed to restore cloud-init file %s/%s: %v", vmDir, b.name, err)
+		}
+	}
+}
+
 type QemuAgentStateInfo struct {
 	Hostname   string                  `json:"hostname"`
 	OSInfo     *qemu.OSInfo            `json:"osInfo"`
@@ -244,10 +585,9 @@ func RetrieveDomainHandler(w http.ResponseWriter, r *http.Request) {
 	includeRemote := r.URL.Query().Get("remoteState") == "true"
 
 	// Get domain info using the libvirt package
-	domInfo, err := libvirt.GetDomainInfo(vmID)
+	domInfo, err := libvirt.GetDomainInfo(r.Context(), vmID)
 	if err != nil {
-		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to get domain info: %s", err),
-			http.StatusInternalServerError)
+		libvirtErrorResponse(w, "Failed to get domain info", err)
 		return
 	}
 
@@ -266,26 +606,12 @@ func RetrieveDomainHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if includeRemote {
-		if err := qemu.GuestPing(vmID); err == nil {
-			hostname, _ := qemu.GetHostName(vmID)
-			osInfo, _ := qemu.GetOSInfo(vmID)
-			fsInfo, _ := qemu.GetFileSystemInfo(vmID)
-			interfaces, _ := qemu.GetNetworkInterfaces(vmID)
-			guestTime, _ := qemu.GetGuestTime(vmID)
-			users, _ := qemu.GetLoggedInUsers(vmID)
-
-			response.RemoteInfo = &QemuAgentStateInfo{
-				Hostname:   hostname,
-				OSInfo:     osInfo,
-				FSInfo:     fsInfo,
-				Interfaces: interfaces,
-				Time:       guestTime,
-				Users:      users,
-			}
-		} else {
+		remoteInfo, err := collectRemoteState(r.Context(), vmID)
+		if err != nil {
 			// Optionally log the issue
 			log.Printf("Guest agent not available for VM %s: %v", vmID, err)
 		}
+		response.RemoteInfo = remoteInfo
 	}
 
 	// Marshal the response to JSON
@@ -298,20 +624,9 @@ func DeleteDomainHandler(w http.ResponseWriter, r *http.Request) {
 	vmID := helpers.MustGetVMID(r.Context())
 	vmDir := helpers.MustGetVMDir(r.Context())
 
-	// Attempt to destroy the VM. Log the error if it fails.
-	if _, err := libvirt.DestroyDomain(vmID); err != nil {
-		log.Printf("Warning: Failed to destroy VM, it might be already off: %v", err)
-	}
-
-	// Undefine the VM.
-	if _, err := libvirt.UndefineDomain(vmID); err != nil {
-		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to undefine VM: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	// Delete the VM directory.
-	if err := filesystem.DeleteDirectory(vmDir); err != nil {
-		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to delete VM directory: %v", err), http.StatusInternalServerError)
+	// Destroy and undefine the VM, then delete its directory.
+	if err := lifecycle.Delete(r.Context(), vmID, vmDir); err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to delete VM: %v", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -323,59 +638,235 @@ func DeleteDomainHandler(w http.ResponseWriter, r *http.Request) {
 	utils.JSONResponse(w, response, http.StatusOK)
 }
 
+// defaultWaitTimeout bounds ?wait=true lifecycle calls without ?timeout.
+const defaultWaitTimeout = 30 * time.Second
+
+// parseWait reads the ?wait and ?timeout query parameters of lifecycle calls.
+// It returns 0 when the caller doesn't want to wait for the transition.
+func parseWait(r *http.Request) (time.Duration, error) {
+	if r.URL.Query().Get("wait") != "true" {
+		return 0, nil
+	}
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		return defaultWaitTimeout, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0, errors.New("'timeout' must be a positive number of seconds")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// waitForTransition blocks until the domain reaches target and responds with
+// the reached state, or with 504 when it doesn't get there in time.
+func waitForTransition(w http.ResponseWriter, r *http.Request, vmID string, target libvirt.DomainState, timeout time.Duration) {
+	state, err := libvirt.WaitForState(r.Context(), vmID, target, timeout)
+	if err != nil {
+		if errors.Is(err, libvirt.ErrStateTimeout) {
+			utils.JSONErrorResponse(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
+		libvirtErrorResponse(w, "Failed to get domain state", err)
+		return
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{"status": "success", "state": state}, http.StatusOK)
+}
+
+// Lifecycle operations; swapped out in tests.
+var (
+	startDomain       = libvirt.StartDomain
+	startDomainPaused = libvirt.StartDomainPaused
+	resumeDomain      = libvirt.ResumeDomain
+	rebootDomain      = libvirt.RebootDomain
+	resetDomain       = libvirt.ResetDomain
+	shutdownDomain    = libvirt.ShutdownDomain
+	destroyDomain     = libvirt.DestroyDomain
+)
+
+// lifecycleEvents are the webhooks of lifecycle actions that changed the
+// power state of a domain.
+var lifecycleEvents = map[string]struct {
+	eventType, message string
+	data               map[string]interface{}
+}{
+	"start":        {"domain.started", "Domain was started", nil},
+	"start paused": {"domain.paused", "Domain was started paused", nil},
+	"resume":       {"domain.resumed", "Domain was resumed", nil},
+	"reboot":       {"domain.rebooted", "Domain was rebooted", nil},
+	"reset":        {"domain.rebooted", "Domain was reset", map[string]interface{}{"forced": true}},
+	"shut down":    {"domain.shutdown", "Domain shutdown was initiated", nil},
+	"power off":    {"domain.stopped", "Domain was powered off", map[string]interface{}{"forced": true}},
+}
+
+// runLifecycle runs a lifecycle operation and reports whether the domain
+// changed state. A domain already in the requested state (benign) is not an
+// error, so retried calls are idempotent. Any other failure is written to w
+// and ok is false.
+func runLifecycle(w http.ResponseWriter, r *http.Request, action string, op func(context.Context, string) (string, error), benign error) (changed bool, ok bool) {
+	vmID := helpers.MustGetVMID(r.Context())
+
+	_, err := op(r.Context(), vmID)
+	switch {
+	case err == nil:
+		if event, ok := lifecycleEvents[action]; ok {
+			events.Notify(vmID, event.eventType, event.message, event.data)
+		}
+		return true, true
+	case benign != nil && errors.Is(err, benign):
+		return false, true
+	default:
+		log.Printf("Failed to %s VM %s: %v", action, vmID, err)
+		libvirtErrorResponse(w, fmt.Sprintf("Failed to %s domain", action), err)
+		return false, false
+	}
+}
+
 func StartDomainHandler(w http.ResponseWriter, r *http.Request) {
 	vmID := helpers.MustGetVMID(r.Context())
 
-	// Attempt to start the VM. Log a message if it fails but respond as success.
-	if _, err := libvirt.StartDomain(vmID); err != nil {
-		log.Printf("Warning: Failed to start VM, it might be already running: %v", err)
+	wait, err := parseWait(r)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// qemu only names the first image it fails to open, list them all instead
+	if missing := definedMissingDisks(r.Context(), vmID); len(missing) > 0 {
+		missingDisksResponse(w, "Domain can't start, disks don't exist", missing)
+		return
+	}
+
+	// ?paused=true starts the vCPUs paused until the domain is resumed
+	if r.URL.Query().Get("paused") == "true" {
+		startPausedDomain(w, r, vmID, wait)
+		return
 	}
 
-	utils.JSONResponse(w, map[string]interface{}{"status": "success"}, http.StatusOK)
+	changed, ok := runLifecycle(w, r, "start", startDomain, libvirt.ErrAlreadyRunning)
+	if !ok {
+		return
+	}
+
+	if wait > 0 {
+		waitForTransition(w, r, vmID, libvirt.StateRunning, wait)
+		return
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{"status": "success", "changed": changed}, http.StatusOK)
 }
 
-func RebootDomainHandler(w http.ResponseWriter, r *http.Request) {
-	vmID := helpers.MustGetVMID(r.Context())
+// startPausedDomain starts a domain paused and reports the state it is in,
+// which is running rather than paused when it was already started.
+func startPausedDomain(w http.ResponseWriter, r *http.Request, vmID string, wait time.Duration) {
+	changed, ok := runLifecycle(w, r, "start paused", startDomainPaused, libvirt.ErrAlreadyRunning)
+	if !ok {
+		return
+	}
 
-	// Attempt to reboot the VM. Log a message if it fails but respond as success.
-	if _, err := libvirt.RebootDomain(vmID); err != nil {
-		log.Printf("Warning: Failed to reboot VM, it might be already running: %v", err)
+	if wait > 0 {
+		waitForTransition(w, r, vmID, libvirt.StatePaused, wait)
+		return
 	}
 
-	utils.JSONResponse(w, map[string]interface{}{"status": "success"}, http.StatusOK)
+	state, err := domainState(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get domain state", err)
+		return
+	}
+	response := map[string]interface{}{"status": "success", "changed": changed, "state": state}
+	if state == libvirt.StatePaused {
+		response["message"] = "Domain is paused, resume it with POST /v1/domain/" + vmID + "/resume"
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
 }
 
-func ResetDomainHandler(w http.ResponseWriter, r *http.Request) {
+// ResumeDomainHandler lets a paused domain run, e.g. one started paused.
+// Resuming a running domain changes nothing.
+func ResumeDomainHandler(w http.ResponseWriter, r *http.Request) {
 	vmID := helpers.MustGetVMID(r.Context())
 
-	// Attempt to reset the VM. Log a message if it fails but respond as success.
-	if _, err := libvirt.ResetDomain(vmID); err != nil {
-		log.Printf("Warning: Failed to reset VM, it might be already running: %v", err)
+	// virsh resume succeeds on a running domain as well, only a paused one
+	// is resumed and reported as such
+	state, err := domainState(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get domain state", err)
+		return
+	}
+	changed := false
+	if state == libvirt.StatePaused {
+		var ok bool
+		if changed, ok = runLifecycle(w, r, "resume", resumeDomain, nil); !ok {
+			return
+		}
+		if state, err = domainState(r.Context(), vmID); err != nil {
+			libvirtErrorResponse(w, "Failed to get domain state", err)
+			return
+		}
+	}
+	utils.JSONResponse(w, map[string]interface{}{"status": "success", "changed": changed, "state": state}, http.StatusOK)
+}
+
+func RebootDomainHandler(w http.ResponseWriter, r *http.Request) {
+	// Rebooting a stopped domain is a conflict, not a no-op
+	if _, ok := runLifecycle(w, r, "reboot", rebootDomain, nil); !ok {
+		return
+	}
+
+	utils.JSONResponse(w, map[string]interface{}{"status": "success", "changed": true}, http.StatusOK)
+}
+
+func ResetDomainHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := runLifecycle(w, r, "reset", resetDomain, nil); !ok {
+		return
 	}
 
-	utils.JSONResponse(w, map[string]interface{}{"status": "success"}, http.StatusOK)
+	utils.JSONResponse(w, map[string]interface{}{"status": "success", "changed": true}, http.StatusOK)
 }
 
 func ShutdownDomainHandler(w http.ResponseWriter, r *http.Request) {
 	vmID := helpers.MustGetVMID(r.Context())
 
-	// Attempt to shut down the VM. Log a message if it fails but respond as success.
-	if _, err := libvirt.ShutdownDomain(vmID); err != nil {
-		log.Printf("Warning: Failed to shut down VM, it might be already off: %v", err)
+	wait, err := parseWait(r)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changed, ok := runLifecycle(w, r, "shut down", shutdownDomain, libvirt.ErrAlreadyStopped)
+	if !ok {
+		return
+	}
+
+	if wait > 0 {
+		waitForTransition(w, r, vmID, libvirt.StateShutOff, wait)
+		return
 	}
 
-	utils.JSONResponse(w, map[string]interface{}{"status": "success"}, http.StatusOK)
+	utils.JSONResponse(w, map[string]interface{}{"status": "success", "changed": changed}, http.StatusOK)
 }
 
 func StopDomainHandler(w http.ResponseWriter, r *http.Request) {
 	vmID := helpers.MustGetVMID(r.Context())
 
-	// Attempt to destroy the VM. Log a message if it fails but respond as success.
-	if _, err := libvirt.DestroyDomain(vmID); err != nil {
-		log.Printf("Warning: Failed to power off VM, it might be already off: %v", err)
+	wait, err := parseWait(r)
+	if err != nil {
+		utils.JSONErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	changed, ok := runLifecycle(w, r, "power off", destroyDomain, libvirt.ErrAlreadyStopped)
+	if !ok {
+		return
+	}
+
+	if wait > 0 {
+		waitForTransition(w, r, vmID, libvirt.StateShutOff, wait)
+		return
 	}
 
-	utils.JSONResponse(w, map[string]interface{}{"status": "success"}, http.StatusOK)
+	utils.JSONResponse(w, map[string]interface{}{"status": "success", "changed": changed}, http.StatusOK)
 }
 
 func ElevateVMHandler(w http.ResponseWriter, r *http.Request) {
@@ -398,45 +889,68 @@ type ResetPasswordRequest struct {
 	Password string `json:"password"`
 }
 
+func (req *ResetPasswordRequest) Validate() error {
+	if req.Username == "" {
+		return utils.FieldError("user", "is required")
+	}
+	if strings.ContainsAny(req.Username, ":\n") {
+		return utils.FieldError("user", "must not contain ':' or newlines")
+	}
+	if req.Password == "" {
+		return utils.FieldError("password", "is required")
+	}
+	if strings.Contains(req.Password, "\n") {
+		return utils.FieldError("password", "must not contain newlines")
+	}
+	return nil
+}
+
+// runGuestCommand runs commands through the guest agent; swapped out in tests.
+var runGuestCommand = qemu.RunGuestCommand
+
 func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	vmID := chi.URLParam(r, "id")
+	vmID := helpers.MustGetVMID(r.Context())
 
+	// Decode and validate the JSON request
 	var request ResetPasswordRequest
-	err := json.NewDecoder(r.Body).Decode(&request)
-	if err != nil {
-		utils.JSONErrorResponse(w, fmt.Sprintf("Invalid request body: %s", err),
-			http.StatusBadRequest)
+	if err := utils.DecodeJSON(r, &request); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
 		return
 	}
 
-	if request.Username == "" || request.Password == "" {
-		utils.JSONErrorResponse(w, "Username and password are required",
-			http.StatusBadRequest)
+	if !requireAgent(w, r, vmID) {
 		return
 	}
 
-	// Construct the command to reset the password.  This is just an example,
-	// and the exact command will depend on the guest OS.  Also, BE VERY
-	// CAREFUL when constructing commands from user input to avoid command
-	// injection vulnerabilities.  Sanitize the username and password!
-	command := "chpasswd" // Example command, might be different for your OS
-	args := []string{
-		fmt.Sprintf("%s:%s", request.Username, request.Password),
-	}
+	// chpasswd reads "user:password" lines from stdin, which keeps the
+	// credentials out of the guest's process list.
+	input := []byte(fmt.Sprintf("%s:%s\n", request.Username, request.Password))
 
-	// Execute the command using the qemu guest agent
-	output, err := libvirt.QemuAgentExec(vmID, command, args, true)
+	// Run the command through the guest agent and wait for its exit status
+	result, err := runGuestCommand(r.Context(), vmID, "chpasswd", nil, input, 30*time.Second)
 	if err != nil {
-		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to execute command: %s, Output: %s",
-			err, output), http.StatusInternalServerError)
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to execute command: %s", err),
+			http.StatusInternalServerError)
+		return
+	}
+
+	// The agent call succeeding says nothing about chpasswd itself
+	if result.ExitCode != 0 {
+		response := map[string]interface{}{
+			"success":   false,
+			"error":     "Password reset failed inside the guest",
+			"exit_code": result.ExitCode,
+			"stderr":    result.Stderr,
+		}
+		utils.JSONResponse(w, response, http.StatusUnprocessableEntity)
 		return
 	}
 
 	// Return a success response
 	response := map[string]interface{}{
-		"success": true,
-		"message": "Password reset successfully",
-		"output":  output,
+		"success":   true,
+		"message":   "Password reset successfully",
+		"exit_code": result.ExitCode,
 	}
 	utils.JSONResponse(w, response, http.StatusOK)
 }
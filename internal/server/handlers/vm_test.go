@@ -0,0 +1,678 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/qemu"
+	"libvirt-controller/internal/quota"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// virshError mimics a classified virsh failure.
+func virshError(kind error, stderr string) error {
+	return fmt.Errorf("%w: command execution failed: %s, exit status 1", kind, stderr)
+}
+
+func TestLifecycleHandlers(t *testing.T) {
+	originals := []func(context.Context, string) (string, error){startDomain, shutdownDomain, destroyDomain, rebootDomain}
+	defer func() {
+		startDomain, shutdownDomain, destroyDomain, rebootDomain = originals[0], originals[1], originals[2], originals[3]
+	}()
+
+	failure := errors.New("command execution failed: error: Cannot access storage file '/data/vm-1/disk.img', exit status 1")
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		op          *func(context.Context, string) (string, error)
+		err         error
+		wantStatus  int
+		wantChanged bool
+	}{
+		{"start", StartDomainHandler, &startDomain, nil, http.StatusOK, true},
+		{"start already running", StartDomainHandler, &startDomain, virshError(libvirt.ErrAlreadyRunning, "error: Domain is already active"), http.StatusOK, false},
+		{"start failure", StartDomainHandler, &startDomain, failure, http.StatusInternalServerError, false},
+		{"start unknown domain", StartDomainHandler, &startDomain, virshError(libvirt.ErrDomainNotFound, "error: failed to get domain 'vm-1'"), http.StatusNotFound, false},
+		{"shutdown already stopped", ShutdownDomainHandler, &shutdownDomain, virshError(libvirt.ErrAlreadyStopped, "error: Requested operation is not valid: domain is not running"), http.StatusOK, false},
+		{"shutdown failure", ShutdownDomainHandler, &shutdownDomain, failure, http.StatusInternalServerError, false},
+		{"stop already stopped", StopDomainHandler, &destroyDomain, virshError(libvirt.ErrAlreadyStopped, "error: Requested operation is not valid: domain is not running"), http.StatusOK, false},
+		{"stop failure", StopDomainHandler, &destroyDomain, failure, http.StatusInternalServerError, false},
+		{"reboot stopped domain", RebootDomainHandler, &rebootDomain, virshError(libvirt.ErrAlreadyStopped, "error: Requested operation is not valid: domain is not running"), http.StatusConflict, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*tt.op = func(ctx context.Context, domain string) (string, error) {
+				if domain != "vm-1" {
+					t.Errorf("operation called for %q", domain)
+				}
+				return "", tt.err
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/start", nil)
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Status  string `json:"status"`
+				Changed bool   `json:"changed"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid response body %q: %v", rec.Body, err)
+			}
+			if body.Status != "success" || body.Changed != tt.wantChanged {
+				t.Errorf("got %+v, want success with changed=%t", body, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestStartDomainPaused(t *testing.T) {
+	originalStart, originalPaused, originalState := startDomain, startDomainPaused, domainState
+	defer func() { startDomain, startDomainPaused, domainState = originalStart, originalPaused, originalState }()
+	startDomain = func(ctx context.Context, domain string) (string, error) {
+		t.Error("started without --paused")
+		return "", nil
+	}
+	t.Setenv("DEFINITIONS_DIR", t.TempDir())
+
+	tests := []struct {
+		name        string
+		err         error
+		state       libvirt.DomainState
+		wantChanged bool
+		wantMessage bool
+	}{
+		{"started paused", nil, libvirt.StatePaused, true, true},
+		{"already running", virshError(libvirt.ErrAlreadyRunning, "error: Domain is already active"), libvirt.StateRunning, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startDomainPaused = func(ctx context.Context, domain string) (string, error) { return "", tt.err }
+			domainState = func(ctx context.Context, domain string) (libvirt.DomainState, error) { return tt.state, nil }
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/start?paused=true", nil)
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			StartDomainHandler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				Changed bool                `json:"changed"`
+				State   libvirt.DomainState `json:"state"`
+				Message string              `json:"message"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid response body %q: %v", rec.Body, err)
+			}
+			if body.Changed != tt.wantChanged || body.State != tt.state || (body.Message != "") != tt.wantMessage {
+				t.Errorf("got %+v", body)
+			}
+		})
+	}
+}
+
+func TestResumeDomain(t *testing.T) {
+	originalResume, originalState := resumeDomain, domainState
+	defer func() { resumeDomain, domainState = originalResume, originalState }()
+
+	tests := []struct {
+		name        string
+		state       libvirt.DomainState
+		wantChanged bool
+	}{
+		{"paused", libvirt.StatePaused, true},
+		{"running", libvirt.StateRunning, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := tt.state
+			resumed := false
+			resumeDomain = func(ctx context.Context, domain string) (string, error) {
+				resumed = true
+				state = libvirt.StateRunning
+				return "", nil
+			}
+			domainState = func(ctx context.Context, domain string) (libvirt.DomainState, error) { return state, nil }
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/resume", nil)
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			ResumeDomainHandler(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				Changed bool                `json:"changed"`
+				State   libvirt.DomainState `json:"state"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid response body %q: %v", rec.Body, err)
+			}
+			if resumed != tt.wantChanged || body.Changed != tt.wantChanged || body.State != libvirt.StateRunning {
+				t.Errorf("resumed = %t, got %+v", resumed, body)
+			}
+		})
+	}
+}
+
+func TestCheckNameCollision(t *testing.T) {
+	original := listDomains
+	defer func() { listDomains = original }()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		if !includeInactive {
+			t.Error("inactive domains must be included")
+		}
+		return []string{"vm-1", "web"}, nil
+	}
+
+	dir := t.TempDir()
+	ownDir := filepath.Join(dir, "vm-1")
+	if err := os.MkdirAll(ownDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ownDir, "server.xml"), []byte("<domain><name>vm-1</name></domain>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		vmDir   string
+		domain  string
+		wantErr bool
+	}{
+		{"unused name", filepath.Join(dir, "vm-2"), "vm-2", false},
+		{"redefine own domain", ownDir, "vm-1", false},
+		{"name of another id", filepath.Join(dir, "vm-2"), "vm-1", true},
+		{"name of a domain defined elsewhere", ownDir, "web", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNameCollision(context.Background(), tt.vmDir, tt.domain)
+			if tt.wantErr != errors.Is(err, errNameCollision) {
+				t.Errorf("checkNameCollision() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefineDomainRejectsCollidingName(t *testing.T) {
+	original := listDomains
+	defer func() { listDomains = original }()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		return []string{"vm-1"}, nil
+	}
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+
+	body := `{"id": "vm-2", "xml_config": "<domain type='kvm'><name>vm-1</name></domain>"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/domain/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	DefineDomainHandler(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vm-2")); !os.IsNotExist(err) {
+		t.Error("nothing must be written for a colliding definition")
+	}
+}
+
+func TestConcurrentDefinesOfOneID(t *testing.T) {
+	originalList, originalDefine := listDomains, defineDomain
+	defer func() { listDomains, defineDomain = originalList, originalDefine }()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return nil, nil }
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+
+	var (
+		mu       sync.Mutex
+		inFlight int
+		overlaps int
+		defined  string // Definition libvirt got last
+	)
+	defineDomain = func(ctx context.Context, xmlPath string) (string, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > 1 {
+			overlaps++
+		}
+		mu.Unlock()
+
+		data, err := os.ReadFile(xmlPath)
+		time.Sleep(5 * time.Millisecond) // Leave room for another define to interleave
+
+		mu.Lock()
+		defer mu.Unlock()
+		inFlight--
+		defined = string(data)
+		return "", err
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"id": "vm-1", "xml_config": "<domain type='kvm'><name>vm-1</name><memory unit='MiB'>%d</memory></domain>"}`, i*512)
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			DefineDomainHandler(rec, req)
+			if rec.Code != http.StatusCreated {
+				t.Errorf("status = %d: %s", rec.Code, rec.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if overlaps > 0 {
+		t.Errorf("%d defines of vm-1 overlapped", overlaps)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, "vm-1", "server.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved) != defined {
+		t.Errorf("server.xml = %s, but libvirt was last given %s", saved, defined)
+	}
+}
+
+func TestLifecycleHandlersSendWebhooks(t *testing.T) {
+	originals := []func(context.Context, string) (string, error){startDomain, rebootDomain, destroyDomain}
+	defer func() { startDomain, rebootDomain, destroyDomain = originals[0], originals[1], originals[2] }()
+	startDomain = func(ctx context.Context, domain string) (string, error) { return "", nil }
+	rebootDomain = func(ctx context.Context, domain string) (string, error) { return "", nil }
+	destroyDomain = func(ctx context.Context, domain string) (string, error) {
+		return "", virshError(libvirt.ErrAlreadyStopped, "error: Requested operation is not valid: domain is not running")
+	}
+
+	received := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received <- payload.ID + " " + payload.Type
+	}))
+	defer receiver.Close()
+	t.Setenv("WEBHOOK_URL", receiver.URL)
+	t.Setenv("NODE_ID", "node-1")
+	t.Setenv("WEBHOOK_DEBOUNCE_MS", "0")
+
+	for _, handler := range []http.HandlerFunc{StartDomainHandler, RebootDomainHandler, StopDomainHandler} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/start", nil)
+		req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+		handler(httptest.NewRecorder(), req)
+	}
+
+	// Stopping a stopped domain changed nothing and sends nothing
+	for _, want := range []string{"vm-1 domain.started", "vm-1 domain.rebooted"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("webhook %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %q webhook", want)
+		}
+	}
+	select {
+	case got := <-received:
+		t.Errorf("unexpected webhook %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDefineDomainEnforcesQuota(t *testing.T) {
+	original := listDomains
+	defer func() { listDomains = original }()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		return nil, nil
+	}
+	originalIndex := quota.Default
+	defer func() { quota.Default = originalIndex }()
+	quota.Default = quota.NewIndex()
+	quotaFile := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(quotaFile, []byte(`{"token": {"tenant": "acme", "memory_mb": 4096}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Default.Configure(quotaFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := quota.Default.Reserve("acme", quota.DomainKey("vm-1"), quota.Allocation{VCPUs: 2, MemoryMB: 3072}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+
+	body := `{"id": "vm-2", "xml_config": "<domain type='kvm'><name>vm-2</name><memory unit='GiB'>2</memory><vcpu>1</vcpu></domain>"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/domain/", strings.NewReader(body))
+	req = req.WithContext(quota.WithTenant(req.Context(), "acme"))
+	rec := httptest.NewRecorder()
+	DefineDomainHandler(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vm-2")); !os.IsNotExist(err) {
+		t.Error("nothing must be written for a definition over quota")
+	}
+	if usage := quota.Default.Usage("acme"); usage.MemoryMB != 3072 {
+		t.Errorf("usage = %+v, the rejected domain must not be charged", usage)
+	}
+}
+
+func TestDefineRestrictsTenants(t *testing.T) {
+	original := listDomains
+	defer func() { listDomains = original }()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		t.Error("a rejected define must not look for name collisions")
+		return nil, nil
+	}
+	withQuota(t)
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"force", `{"id": "vm-1", "force": true, "xml_config": "<domain type='kvm'><name>vm-1</name></domain>"}`, http.StatusForbidden},
+		{"name of another domain", `{"id": "vm-1", "xml_config": "<domain type='kvm'><name>vm-other</name></domain>"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/", strings.NewReader(tt.body))
+			req = req.WithContext(quota.WithTenant(req.Context(), "acme"))
+			rec := httptest.NewRecorder()
+			DefineDomainHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "vm-1")); !os.IsNotExist(err) {
+				t.Error("nothing must be written for a rejected definition")
+			}
+		})
+	}
+}
+
+func TestDomainMiddlewareTenantOwnership(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	for vmID, tenant := range map[string]string{"vm-acme": "acme", "vm-other": "other", "vm-admin": ""} {
+		vmDir := filepath.Join(dir, vmID)
+		if err := os.Mkdir(vmDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := metadata.Save(vmDir, &metadata.Metadata{Tenant: tenant}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := chi.NewRouter()
+	router.With(DomainMiddleware).Get("/v1/domain/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		vmID       string
+		tenant     string
+		wantStatus int
+	}{
+		{"vm-acme", "acme", http.StatusOK},
+		{"vm-other", "acme", http.StatusNotFound},
+		{"vm-admin", "acme", http.StatusNotFound},
+		{"vm-other", "", http.StatusOK},
+		{"vm-admin", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/v1/domain/"+tt.vmID, nil)
+		req = req.WithContext(quota.WithTenant(req.Context(), tt.tenant))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s as %q: status = %d, want %d: %s", tt.vmID, tt.tenant, rec.Code, tt.wantStatus, rec.Body)
+		}
+	}
+}
+
+func TestMissingDisks(t *testing.T) {
+	originalList, originalStart := listDomains, startDomain
+	defer func() { listDomains, startDomain = originalList, originalStart }()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) {
+		return nil, nil
+	}
+	started := false
+	startDomain = func(ctx context.Context, domain string) (string, error) {
+		started = true
+		return "", nil
+	}
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	existing := filepath.Join(dir, "root.qcow2")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "data.qcow2")
+	xmlConfig := fmt.Sprintf(`<domain type='kvm'><name>vm-1</name><devices>
+		<disk type='file' device='disk'><source file='%s'/><target dev='vda'/></disk>
+		<disk type='file' device='disk'><source file='%s'/><target dev='vdb'/></disk>
+		<disk type='file' device='cdrom'><target dev='sda'/></disk></devices></domain>`, existing, missing)
+	define := func(requireDisks bool) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"id": "vm-1", "xml_config": xmlConfig, "require_disks": requireDisks})
+		rec := httptest.NewRecorder()
+		DefineDomainHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/domain/", bytes.NewReader(body)))
+		return rec
+	}
+	wantMissing := `"missing_disks":[{"target":"vdb","source":"` + missing + `"}]`
+
+	rec := define(true)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), wantMissing) {
+		t.Fatalf("strict define: status = %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "vm-1")); !os.IsNotExist(err) {
+		t.Error("nothing must be written for a definition with missing disks")
+	}
+
+	// Start checks the definition libvirt has, not the one saved on disk
+	originalDump := dumpXML
+	defer func() { dumpXML = originalDump }()
+	dumpXML = func(ctx context.Context, domain string, inactive bool) (string, error) {
+		if domain != "vm-1" || !inactive {
+			return "", fmt.Errorf("unexpected dump of %s, inactive %v", domain, inactive)
+		}
+		return xmlConfig, nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/start", nil)
+	req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+	rec = httptest.NewRecorder()
+	StartDomainHandler(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), wantMissing) {
+		t.Fatalf("start: status = %d: %s", rec.Code, rec.Body)
+	}
+	if started {
+		t.Error("a domain with missing disks must not be started")
+	}
+}
+
+func TestDefineDomainChecksNetworks(t *testing.T) {
+	originalList, originalDefine, originalNetworks, originalBridge := listDomains, defineDomain, listNetworks, checkBridge
+	defer func() {
+		listDomains, defineDomain, listNetworks, checkBridge = originalList, originalDefine, originalNetworks, originalBridge
+	}()
+	listDomains = func(ctx context.Context, includeInactive bool) ([]string, error) { return nil, nil }
+	defineDomain = func(ctx context.Context, xmlPath string) (string, error) { return "", nil }
+	listNetworks = func(ctx context.Context) ([]libvirt.Network, error) {
+		return []libvirt.Network{{Name: "default", Active: true}, {Name: "isolated"}}, nil
+	}
+	checkBridge = func(name string) error {
+		if name != "br0" {
+			return fmt.Errorf("bridge %q doesn't exist on this host", name)
+		}
+		return nil
+	}
+	t.Setenv("DEFINITIONS_DIR", t.TempDir())
+
+	tests := []struct {
+		name           string
+		defaultNetwork string
+		defaultBridge  string
+		interfaces     string
+		want           int
+		wantError      string
+	}{
+		{"default network", "", "", `[{}]`, http.StatusCreated, ""},
+		{"missing default network", "lan", "", `[{}]`, http.StatusUnprocessableEntity, `network \"lan\" doesn't exist on this host, pick another`},
+		{"inactive network", "", "", `[{"network": "isolated"}]`, http.StatusUnprocessableEntity, `network \"isolated\" isn't active`},
+		{"no interfaces", "lan", "", `[]`, http.StatusCreated, ""},
+		{"bridge", "", "", `[{"bridge": "br0"}]`, http.StatusCreated, ""},
+		{"missing bridge", "", "", `[{"bridge": "br9"}]`, http.StatusUnprocessableEntity, `bridge \"br9\" doesn't exist on this host, pick another`},
+		{"missing default bridge", "", "br9", `[{}]`, http.StatusUnprocessableEntity, `bridge \"br9\" doesn't exist on this host, pick another`},
+		{"invalid default bridge", "", "br0'/>", `[{}]`, http.StatusBadRequest, "DEFAULT_BRIDGE"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_NETWORK", tt.defaultNetwork)
+			t.Setenv("DEFAULT_BRIDGE", tt.defaultBridge)
+			body := fmt.Sprintf(`{"id": "vm-%d", "spec": {"memory_mb": 512, "vcpus": 1, "disks": [], "interfaces": %s}}`, i, tt.interfaces)
+			rec := httptest.NewRecorder()
+			DefineDomainHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/domain/", strings.NewReader(body)))
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantError) {
+				t.Errorf("body = %s, want %s", rec.Body, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestCloudInitValidatesNetworkConfig(t *testing.T) {
+	off := false
+	invalid := "version: 2\nethernets:\n  eth0:\n    dhcp: true\n"
+	tests := []struct {
+		name    string
+		req     CloudInitRequest
+		wantErr bool
+	}{
+		{"invalid", CloudInitRequest{NetworkConfig: invalid}, true},
+		{"valid", CloudInitRequest{NetworkConfig: "version: 2\nethernets:\n  eth0:\n    dhcp4: true\n"}, false},
+		{"validation off", CloudInitRequest{NetworkConfig: invalid, ValidateNetworkConfig: &off}, false},
+		{"configdrive", CloudInitRequest{NetworkConfig: `{"links": []}`, Datasource: helpers.DatasourceConfigDrive}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloudInitRollsBackOnISOFailure(t *testing.T) {
+	original := generateCloudInitISO
+	defer func() { generateCloudInitISO = original }()
+	generateCloudInitISO = func(ctx context.Context, dir string, datasource string) error {
+		return errors.New("failed to create cloud-init ISO: command execution failed: genisoimage: No space left on device, exit status 1")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "user-data"), []byte("#cloud-config\nhostname: old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"userData": "#cloud-config\nhostname: new\n", "metaData": "instance-id: vm-1\n"}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/cloud-init", strings.NewReader(body))
+	ctx := context.WithValue(req.Context(), helpers.VMIDKey, "vm-1")
+	req = req.WithContext(context.WithValue(ctx, helpers.VMDirKey, dir))
+	rec := httptest.NewRecorder()
+	CloudInitHandler(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusInternalServerError, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "no changes were applied") {
+		t.Errorf("error does not say nothing was applied: %s", rec.Body)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "user-data"))
+	if err != nil || string(data) != "#cloud-config\nhostname: old\n" {
+		t.Errorf("user-data = %q, %v; want the previous content", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "meta-data")); !os.IsNotExist(err) {
+		t.Errorf("meta-data created by the failed request was not removed: %v", err)
+	}
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	original, originalRunning := runGuestCommand, requireRunning
+	defer func() { runGuestCommand, requireRunning = original, originalRunning }()
+	requireRunning = func(ctx context.Context, domain string) error { return nil }
+
+	tests := []struct {
+		name       string
+		body       string
+		result     *qemu.GuestExecResult
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{"success", `{"user": "root", "password": "s3cret"}`, &qemu.GuestExecResult{}, nil, http.StatusOK, `"exit_code":0`},
+		{"chpasswd fails", `{"user": "nobody2", "password": "s3cret"}`, &qemu.GuestExecResult{ExitCode: 1, Stderr: "chpasswd: user 'nobody2' does not exist\n"}, nil,
+			http.StatusUnprocessableEntity, `"exit_code":1,"stderr":"chpasswd: user 'nobody2' does not exist\n"`},
+		{"agent fails", `{"user": "root", "password": "s3cret"}`, nil, errors.New("error: Guest agent is not responding"), http.StatusInternalServerError, "Guest agent is not responding"},
+		{"newline in password", `{"user": "root", "password": "a\nroot:b"}`, nil, nil, http.StatusBadRequest, "password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input string
+			runGuestCommand = func(ctx context.Context, vm string, path string, args []string, stdin []byte, timeout time.Duration) (*qemu.GuestExecResult, error) {
+				if path != "chpasswd" {
+					t.Errorf("ran %s, want chpasswd", path)
+				}
+				input = string(stdin)
+				return tt.result, tt.err
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/reset-password", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), helpers.VMIDKey, "vm-1"))
+			rec := httptest.NewRecorder()
+			ResetPasswordHandler(rec, req)
+
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("status = %d, want %d with %s: %s", rec.Code, tt.wantStatus, tt.wantBody, rec.Body)
+			}
+			if tt.name == "success" && input != "root:s3cret\n" {
+				t.Errorf("chpasswd got %q on stdin", input)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/domainxml"
+	"libvirt-controller/internal/filesystem"
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/lifecycle"
+	"libvirt-controller/internal/server/utils"
+)
+
+const (
+	definitionFile = "server.xml"
+	backupFile     = "server.xml.bak"
+)
+
+// backupGenerations is how many previous definitions are kept. The newest
+// is server.xml.bak, older ones are server.xml.bak.1, server.xml.bak.2, ...
+func backupGenerations() int {
+	return max(config.Int("XML_BACKUP_GENERATIONS", 3), 1)
+}
+
+// backupName returns the file name of the given backup generation (0 = newest).
+func backupName(generation int) string {
+	if generation == 0 {
+		return backupFile
+	}
+	return fmt.Sprintf("%s.%d", backupFile, generation)
+}
+
+// pushBackup stores data as the newest backup, shifting older generations
+// down and dropping the oldest one beyond the configured limit.
+func pushBackup(vmDir string, data []byte) error {
+	generations := backupGenerations()
+	for g := generations - 1; g > 0; g-- {
+		src := filepath.Join(vmDir, backupName(g-1))
+		if err := os.Rename(src, filepath.Join(vmDir, backupName(g))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return filesystem.SaveFileAtomic(vmDir, backupName(0), data)
+}
+
+// popBackup removes the newest backup and returns its contents, moving the
+// older generations up by one.
+func popBackup(vmDir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(vmDir, backupName(0)))
+	if err != nil {
+		return nil, err
+	}
+	for g := 1; ; g++ {
+		src := filepath.Join(vmDir, backupName(g))
+		if err := os.Rename(src, filepath.Join(vmDir, backupName(g-1))); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Nothing older left, drop the generation we just consumed
+				if g == 1 {
+					os.Remove(filepath.Join(vmDir, backupName(0)))
+				}
+				return data, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// saveDefinition keeps the current server.xml as a backup generation and
+// then atomically replaces it. It returns the previous contents (nil if none).
+func saveDefinition(vmDir string, xmlConfig []byte) ([]byte, error) {
+	previous, err := os.ReadFile(filepath.Join(vmDir, definitionFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read current definition: %w", err)
+	}
+
+	if previous != nil {
+		if err := pushBackup(vmDir, previous); err != nil {
+			return nil, fmt.Errorf("failed to back up current definition: %w", err)
+		}
+	}
+
+	if err := filesystem.SaveFileAtomic(vmDir, definitionFile, xmlConfig); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// GetDomainXMLHandler returns the stored domain definition
+func GetDomainXMLHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	data, err := os.ReadFile(filepath.Join(vmDir, definitionFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			utils.JSONErrorResponse(w, "Domain definition not found", http.StatusNotFound)
+			return
+		}
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read domain definition: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":         vmID,
+		"xml_config": string(data),
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+type UpdateXMLRequest struct {
+	XMLConfig string `json:"xml_config"`
+}
+
+func (req *UpdateXMLRequest) Validate() error {
+	if req.XMLConfig == "" {
+		return utils.FieldError("xml_config", "is required")
+	}
+	return nil
+}
+
+// UpdateDomainXMLHandler replaces the domain definition with a validated XML
+func UpdateDomainXMLHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	defer lifecycle.Lock(vmID)()
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	// Decode and validate the JSON request
+	var req UpdateXMLRequest
+	if err := utils.DecodeJSON(r, &req); err != nil {
+		utils.JSONRequestErrorResponse(w, err)
+		return
+	}
+
+	// Validate the document before it replaces a working definition
+	domain, err := domainxml.Parse([]byte(req.XMLConfig))
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Invalid domain XML: %s", err), http.StatusBadRequest)
+		return
+	}
+	if domain.Name != vmID {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Domain name '%s' does not match id '%s'", domain.Name, vmID), http.StatusBadRequest)
+		return
+	}
+	// The guest would read and write any host file it names as a disk
+	for _, disk := range domain.DiskFiles() {
+		if !inStorageClass(disk.Source) {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Disk source '%s' of %s must be inside DEFINITIONS_DIR or a storage class directory", disk.Source, disk.Target), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Charge the new allocation in place of the old one before anything changes
+	undoQuota, ok := reserveDomainQuota(w, r.Context(), vmID, domain)
+	if !ok {
+		return
+	}
+
+	previous, err := os.ReadFile(filepath.Join(vmDir, definitionFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		undoQuota()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read current definition: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if err := filesystem.SaveFileAtomic(vmDir, definitionFile, []byte(req.XMLConfig)); err != nil {
+		undoQuota()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to save XML config: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := defineDomain(r.Context(), filepath.Join(vmDir, definitionFile)); err != nil {
+		// libvirt kept the old definition, so put the old file back as well
+		if previous != nil {
+			if restoreErr := filesystem.SaveFileAtomic(vmDir, definitionFile, previous); restoreErr != nil {
+				log.Printf("Error restoring %s/%s after failed define: %v", vmDir, definitionFile, restoreErr)
+			}
+		}
+		undoQuota()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to define domain: %s", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// The replaced definition only becomes a backup now, a failed update
+	// leaves the backup generations alone
+	if previous != nil {
+		if err := pushBackup(vmDir, previous); err != nil {
+			log.Printf("Error backing up the previous definition in %s: %v", vmDir, err)
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Domain definition updated",
+		"id":      vmID,
+		"diff":    helpers.DiffLines(string(previous), req.XMLConfig),
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// RollbackDomainXMLHandler restores the newest backup of the domain
+// definition and defines the domain from it. The current definition is
+// discarded, so repeated rollbacks walk further back through the backups.
+func RollbackDomainXMLHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	defer lifecycle.Lock(vmID)()
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	if !filesystem.FileExists(filepath.Join(vmDir, backupName(0))) {
+		utils.JSONErrorResponse(w, "No previous definition to roll back to", http.StatusNotFound)
+		return
+	}
+
+	// Redefining underneath a migration or block job can corrupt the domain
+	info, err := getJobInfo(r.Context(), vmID)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to get job info", err)
+		return
+	}
+	if info.Active() {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Domain has an active %s job", info.Operation), http.StatusConflict)
+		return
+	}
+
+	current, err := os.ReadFile(filepath.Join(vmDir, definitionFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read current definition: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	restored, err := popBackup(vmDir)
+	if err != nil {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read backup: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The restored definition may allocate more than the current one
+	undoQuota := func() {}
+	if domain, err := domainxml.Parse(restored); err == nil {
+		var ok bool
+		if undoQuota, ok = reserveDomainQuota(w, r.Context(), vmID, domain); !ok {
+			if err := pushBackup(vmDir, restored); err != nil {
+				log.Printf("Error restoring backup in %s: %v", vmDir, err)
+			}
+			return
+		}
+	}
+
+	// undo puts the consumed backup, the current definition and the quota
+	// reservation back
+	undo := func() {
+		undoQuota()
+		if err := pushBackup(vmDir, restored); err != nil {
+			log.Printf("Error restoring backup in %s: %v", vmDir, err)
+		}
+		if current != nil {
+			if err := filesystem.SaveFileAtomic(vmDir, definitionFile, current); err != nil {
+				log.Printf("Error restoring %s/%s: %v", vmDir, definitionFile, err)
+			}
+		}
+	}
+
+	if err := filesystem.SaveFileAtomic(vmDir, definitionFile, restored); err != nil {
+		undo()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to restore definition: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := defineDomain(r.Context(), filepath.Join(vmDir, definitionFile)); err != nil {
+		undo()
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to define domain: %s", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":    true,
+		"message":    "Domain definition rolled back",
+		"id":         vmID,
+		"xml_config": string(restored),
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
+
+// SyncDomainXMLHandler overwrites the stored definition with the persistent
+// definition registered in libvirt, picking up out-of-band edits such as
+// `virsh edit`. The replaced file is kept as a backup generation.
+func SyncDomainXMLHandler(w http.ResponseWriter, r *http.Request) {
+	vmID := helpers.MustGetVMID(r.Context())
+	defer lifecycle.Lock(vmID)()
+	vmDir := helpers.MustGetVMDir(r.Context())
+
+	live, err := libvirt.DumpXML(r.Context(), vmID, true)
+	if err != nil {
+		libvirtErrorResponse(w, "Failed to dump domain XML", err)
+		return
+	}
+
+	current, err := os.ReadFile(filepath.Join(vmDir, definitionFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		utils.JSONErrorResponse(w, fmt.Sprintf("Failed to read current definition: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	changed := string(current) != live
+	if changed {
+		if _, err := saveDefinition(vmDir, []byte(live)); err != nil {
+			utils.JSONErrorResponse(w, fmt.Sprintf("Failed to save XML config: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"id":      vmID,
+		"changed": changed,
+		"diff":    helpers.DiffLines(string(current), live),
+	}
+	utils.JSONResponse(w, response, http.StatusOK)
+}
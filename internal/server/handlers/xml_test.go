@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/helpers"
+	"libvirt-controller/internal/libvirt"
+	"libvirt-controller/internal/quota"
+)
+
+// withQuota swaps in a quota index where acme may use 4 GiB of memory.
+func withQuota(t *testing.T) {
+	t.Helper()
+	original := quota.Default
+	t.Cleanup(func() { quota.Default = original })
+	quota.Default = quota.NewIndex()
+	path := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(path, []byte(`{"token": {"tenant": "acme", "memory_mb": 4096}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := quota.Default.Configure(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateDomainXMLEnforcesQuota(t *testing.T) {
+	original := defineDomain
+	defer func() { defineDomain = original }()
+	withQuota(t)
+	if _, err := quota.Default.Reserve("acme", quota.DomainKey("vm-1"), quota.Allocation{VCPUs: 1, MemoryMB: 2048}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		memory     string
+		defineErr  error
+		wantStatus int
+		wantMemory int64
+	}{
+		{"within quota", "3", nil, http.StatusOK, 3072},
+		{"over quota", "8", nil, http.StatusForbidden, 2048},
+		{"define fails", "3", errors.New("command execution failed: error: unsupported configuration"), http.StatusUnprocessableEntity, 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := quota.Default.Reserve("acme", quota.DomainKey("vm-1"), quota.Allocation{VCPUs: 1, MemoryMB: 2048}); err != nil {
+				t.Fatal(err)
+			}
+			defined := false
+			defineDomain = func(ctx context.Context, xmlPath string) (string, error) {
+				defined = true
+				return "", tt.defineErr
+			}
+			dir := t.TempDir()
+
+			body := `{"xml_config": "<domain type='kvm'><name>vm-1</name><memory unit='GiB'>` + tt.memory + `</memory><vcpu>1</vcpu></domain>"}`
+			req := httptest.NewRequest(http.MethodPatch, "/v1/domain/vm-1/xml", strings.NewReader(body))
+			ctx := quota.WithTenant(req.Context(), "acme")
+			ctx = context.WithValue(ctx, helpers.VMIDKey, "vm-1")
+			req = req.WithContext(context.WithValue(ctx, helpers.VMDirKey, dir))
+			rec := httptest.NewRecorder()
+			UpdateDomainXMLHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusForbidden && defined {
+				t.Error("a definition over quota must not be defined")
+			}
+			if usage := quota.Default.Usage("acme"); usage.MemoryMB != tt.wantMemory {
+				t.Errorf("memory charged = %d MiB, want %d", usage.MemoryMB, tt.wantMemory)
+			}
+		})
+	}
+}
+
+func TestUpdateDomainXMLChecksDiskSources(t *testing.T) {
+	original := defineDomain
+	defer func() { defineDomain = original }()
+	defineDomain = func(ctx context.Context, xmlPath string) (string, error) { return "", nil }
+	classDir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", classDir)
+	t.Setenv("STORAGE_CLASSES", "")
+	vmDir := filepath.Join(classDir, "vm-1")
+	if err := os.Mkdir(vmDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		source     string
+		wantStatus int
+	}{
+		{"inside a storage class", filepath.Join(vmDir, "disk.qcow2"), http.StatusOK},
+		{"host file", "/etc/shadow", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xmlConfig := fmt.Sprintf(`<domain type='kvm'><name>vm-1</name><devices><disk type='file' device='disk'><source file='%s'/><target dev='vda'/></disk></devices></domain>`, tt.source)
+			body, _ := json.Marshal(UpdateXMLRequest{XMLConfig: xmlConfig})
+			req := httptest.NewRequest(http.MethodPatch, "/v1/domain/vm-1/xml", strings.NewReader(string(body)))
+			ctx := context.WithValue(req.Context(), helpers.VMIDKey, "vm-1")
+			req = req.WithContext(context.WithValue(ctx, helpers.VMDirKey, vmDir))
+			rec := httptest.NewRecorder()
+			UpdateDomainXMLHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateDomainXMLRotatesBackupsAfterDefine(t *testing.T) {
+	original := defineDomain
+	defer func() { defineDomain = original }()
+
+	const (
+		v1 = "<domain type='kvm'><name>vm-1</name><memory unit='MiB'>512</memory></domain>"
+		v2 = "<domain type='kvm'><name>vm-1</name><memory unit='MiB'>1024</memory></domain>"
+		v3 = "<domain type='kvm'><name>vm-1</name><memory unit='MiB'>2048</memory></domain>"
+	)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, backupName(0)), []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, definitionFile), []byte(v2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	update := func(xmlConfig string, defineErr error) int {
+		defineDomain = func(ctx context.Context, xmlPath string) (string, error) { return "", defineErr }
+		body, _ := json.Marshal(UpdateXMLRequest{XMLConfig: xmlConfig})
+		req := httptest.NewRequest(http.MethodPatch, "/v1/domain/vm-1/xml", strings.NewReader(string(body)))
+		ctx := context.WithValue(req.Context(), helpers.VMIDKey, "vm-1")
+		req = req.WithContext(context.WithValue(ctx, helpers.VMDirKey, dir))
+		rec := httptest.NewRecorder()
+		UpdateDomainXMLHandler(rec, req)
+		return rec.Code
+	}
+	files := func() (definition, newest, older string) {
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(dir, name))
+			return string(data)
+		}
+		return read(definitionFile), read(backupName(0)), read(backupName(1))
+	}
+
+	if status := update(v3, errors.New("command execution failed: error: unsupported configuration")); status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d for a failed define", status)
+	}
+	if definition, newest, older := files(); definition != v2 || newest != v1 || older != "" {
+		t.Errorf("after a failed define: server.xml = %q, backups %q and %q; want them unchanged", definition, newest, older)
+	}
+
+	if status := update(v3, nil); status != http.StatusOK {
+		t.Fatalf("status = %d for an update", status)
+	}
+	if definition, newest, older := files(); definition != v3 || newest != v2 || older != v1 {
+		t.Errorf("after an update: server.xml = %q, backups %q and %q", definition, newest, older)
+	}
+}
+
+func TestRollbackDomainXML(t *testing.T) {
+	originalDefine, originalJob := defineDomain, getJobInfo
+	defer func() { defineDomain, getJobInfo = originalDefine, originalJob }()
+	withQuota(t)
+
+	const (
+		v1  = "<domain type='kvm'><name>vm-1</name><memory unit='MiB'>512</memory><vcpu>1</vcpu></domain>"
+		v2  = "<domain type='kvm'><name>vm-1</name><memory unit='MiB'>1024</memory><vcpu>1</vcpu></domain>"
+		v3  = "<domain type='kvm'><name>vm-1</name><memory unit='MiB'>2048</memory><vcpu>1</vcpu></domain>"
+		big = "<domain type='kvm'><name>vm-1</name><memory unit='GiB'>8</memory><vcpu>1</vcpu></domain>"
+	)
+	tests := []struct {
+		name       string
+		backups    []string // Newest first
+		tenant     string
+		job        string
+		defineErr  error
+		wantStatus int
+		// The files afterwards: server.xml and the backups newest first
+		wantFiles []string
+	}{
+		{"rolls back", []string{v2, v1}, "", "None", nil, http.StatusOK, []string{v2, v1, ""}},
+		{"no backup", nil, "", "None", nil, http.StatusNotFound, []string{v3, ""}},
+		{"active job", []string{v2}, "", "Unbounded", nil, http.StatusConflict, []string{v3, v2}},
+		{"define fails", []string{v2, v1}, "", "None", errors.New("command execution failed: error: unsupported configuration"),
+			http.StatusUnprocessableEntity, []string{v3, v2, v1}},
+		{"over quota", []string{big, v1}, "acme", "None", nil, http.StatusForbidden, []string{v3, big, v1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getJobInfo = func(ctx context.Context, domain string) (*libvirt.DomainJobInfo, error) {
+				return &libvirt.DomainJobInfo{Type: tt.job, Operation: "Outgoing migration"}, nil
+			}
+			defined := ""
+			defineDomain = func(ctx context.Context, xmlPath string) (string, error) {
+				data, _ := os.ReadFile(xmlPath)
+				defined = string(data)
+				return "", tt.defineErr
+			}
+			dir := t.TempDir()
+			files := append([]string{definitionFile}, backupName(0), backupName(1))
+			for i, data := range append([]string{v3}, tt.backups...) {
+				if err := os.WriteFile(filepath.Join(dir, files[i]), []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/domain/vm-1/xml/rollback", nil)
+			ctx := quota.WithTenant(req.Context(), tt.tenant)
+			ctx = context.WithValue(ctx, helpers.VMIDKey, "vm-1")
+			req = req.WithContext(context.WithValue(ctx, helpers.VMDirKey, dir))
+			rec := httptest.NewRecorder()
+			RollbackDomainXMLHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			for i, want := range tt.wantFiles {
+				data, _ := os.ReadFile(filepath.Join(dir, files[i]))
+				if string(data) != want {
+					t.Errorf("%s = %q, want %q", files[i], data, want)
+				}
+			}
+			if tt.wantStatus == http.StatusOK {
+				var response struct {
+					XMLConfig string `json:"xml_config"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.XMLConfig != v2 || defined != v2 {
+					t.Errorf("restored %q and defined %q, want the newest backup", response.XMLConfig, defined)
+				}
+			} else if tt.defineErr == nil && defined != "" {
+				t.Errorf("defined %q although the rollback was refused", defined)
+			}
+		})
+	}
+}
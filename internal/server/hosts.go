@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"libvirt-controller/internal/cmdutil"
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/server/utils"
+)
+
+// libvirtHosts reads the hosts requests may target with ?host= from
+// LIBVIRT_HOSTS, a comma separated list of name=uri pairs such as
+// "node2=qemu+ssh://root@node2/system". Malformed entries are skipped.
+func libvirtHosts() map[string]string {
+	hosts := make(map[string]string)
+	for _, item := range config.List("LIBVIRT_HOSTS") {
+		name, uri, ok := strings.Cut(item, "=")
+		name, uri = strings.TrimSpace(name), strings.TrimSpace(uri)
+		if !ok || name == "" || uri == "" {
+			log.Printf("invalid entry %q in LIBVIRT_HOSTS, expected name=uri", item)
+			continue
+		}
+		hosts[name] = uri
+	}
+	return hosts
+}
+
+// LibvirtHost routes the virsh calls of a request to the host named by the
+// ?host= query parameter. Only hosts in the allowlist are accepted, without
+// the parameter the local libvirt daemon is used.
+func LibvirtHost(hosts map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.URL.Query().Get("host")
+			if host == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			uri, ok := hosts[host]
+			if !ok {
+				utils.JSONErrorResponse(w, fmt.Sprintf("Unknown host %q", host), http.StatusBadRequest)
+				return
+			}
+			ctx := cmdutil.WithConnectURI(r.Context(), uri)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"libvirt-controller/internal/cmdutil"
+)
+
+func TestLibvirtHosts(t *testing.T) {
+	t.Setenv("LIBVIRT_HOSTS", "node2=qemu+ssh://root@node2/system, broken, =qemu:///system, node3 = qemu+tcp://node3/system")
+
+	want := map[string]string{
+		"node2": "qemu+ssh://root@node2/system",
+		"node3": "qemu+tcp://node3/system",
+	}
+	if got := libvirtHosts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("libvirtHosts() = %v, want %v", got, want)
+	}
+}
+
+func TestLibvirtHost(t *testing.T) {
+	hosts := map[string]string{"node2": "qemu+ssh://root@node2/system"}
+	tests := []struct {
+		name     string
+		target   string
+		want     int
+		wantArgs []string
+	}{
+		{"local by default", "/", http.StatusOK, []string{"list"}},
+		{"allowed host", "/?host=node2", http.StatusOK, []string{"-c", "qemu+ssh://root@node2/system", "list"}},
+		{"unknown host", "/?host=node9", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotArgs []string
+			h := LibvirtHost(hosts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotArgs = cmdutil.VirshArgs(r.Context(), "list")
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d; got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("virsh args = %q, want %q", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/quota"
+	"libvirt-controller/internal/server/utils"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+
+	defaultIdempotencyWindow = 24 * time.Hour
+	maxIdempotencyKeyLength  = 255
+)
+
+// idempotencyWindow is how long a key and its response are remembered.
+func idempotencyWindow() time.Duration {
+	return config.Seconds("IDEMPOTENCY_WINDOW", defaultIdempotencyWindow)
+}
+
+// idempotencyEntry is a request seen with a key. response is nil while the
+// first request is still being handled.
+type idempotencyEntry struct {
+	bodyHash  [sha256.Size]byte
+	response  *recordedResponse
+	expiresAt time.Time
+}
+
+type recordedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyStore keeps keys in memory. The controller is a single process
+// per node, so there is nothing to share them with.
+type idempotencyStore struct {
+	mu      sync.Mutex
+	window  time.Duration
+	now     func() time.Time
+	entries map[string]*idempotencyEntry
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		window:  window,
+		now:     time.Now,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// begin claims key for a new request. When the key is already known the
+// existing entry is returned instead and the caller must not execute.
+func (s *idempotencyStore) begin(key string, bodyHash [sha256.Size]byte) (existing *idempotencyEntry, claimed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for k, e := range s.entries {
+		if e.response != nil && now.After(e.expiresAt) {
+			delete(s.entries, k)
+		}
+	}
+
+	if e, ok := s.entries[key]; ok {
+		copied := *e
+		return &copied, false
+	}
+	s.entries[key] = &idempotencyEntry{bodyHash: bodyHash}
+	return nil, true
+}
+
+// finish stores the response of a claimed key. Server errors are forgotten
+// so that the retry the client is about to send gets another chance.
+func (s *idempotencyStore) finish(key string, res *recordedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if res == nil || res.status >= http.StatusInternalServerError {
+		delete(s.entries, key)
+		return
+	}
+	if e, ok := s.entries[key]; ok {
+		e.response = res
+		e.expiresAt = s.now().Add(s.window)
+	}
+}
+
+// Idempotency replays the stored response when a request repeats the
+// Idempotency-Key of an earlier one, instead of executing it again. Keys are
+// scoped to the method, path and tenant, and reusing one with a different
+// body is rejected. Requests without the header are not affected.
+func Idempotency(store *idempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				utils.JSONErrorResponse(w, "Idempotency-Key must not be longer than 255 characters", http.StatusBadRequest)
+				return
+			}
+
+			// The body is bounded by MaxBodySize, reading it up front is cheap
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				// Nothing is executed for an unreadable body, let the handler report it as usual
+				r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errorReader{err}))
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// Callers never see the responses of other tenants
+			scoped := fmt.Sprintf("%s %s %q %s", r.Method, r.URL.Path, quota.TenantFrom(r.Context()), key)
+			existing, claimed := store.begin(scoped, sha256.Sum256(body))
+			if !claimed {
+				switch {
+				case existing.bodyHash != sha256.Sum256(body):
+					utils.JSONErrorResponse(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+				case existing.response == nil:
+					utils.JSONErrorResponse(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+				default:
+					replay(w, existing.response)
+				}
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				// A panicking handler leaves no response worth replaying
+				if !rec.completed {
+					store.finish(scoped, nil)
+					return
+				}
+				store.finish(scoped, &recordedResponse{status: rec.status, header: w.Header().Clone(), body: rec.body.Bytes()})
+			}()
+			next.ServeHTTP(rec, r)
+			rec.completed = true
+		})
+	}
+}
+
+// errorReader returns err once the preceding readers are drained.
+type errorReader struct{ err error }
+
+func (e errorReader) Read([]byte) (int, error) { return 0, e.err }
+
+func replay(w http.ResponseWriter, res *recordedResponse) {
+	for k, v := range res.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(res.status)
+	w.Write(res.body)
+}
+
+// responseRecorder passes the response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	completed   bool
+	body        bytes.Buffer
+}
+
+func (rw *responseRecorder) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	rw.status = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
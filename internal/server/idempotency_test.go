@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/quota"
+)
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	calls := 0
+	h := Idempotency(newIdempotencyStore(time.Hour))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"success":true}`))
+	}))
+
+	send := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(idempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send("/v1/disk/", "abc", `{"name":"disk"}`)
+	replayed := send("/v1/disk/", "abc", `{"name":"disk"}`)
+	if calls != 1 {
+		t.Fatalf("expected the handler to run once, ran %d times", calls)
+	}
+	if replayed.Code != first.Code || replayed.Body.String() != first.Body.String() {
+		t.Errorf("replay %d %q differs from original %d %q", replayed.Code, replayed.Body, first.Code, first.Body)
+	}
+	if replayed.Header().Get(idempotencyReplayedHeader) != "true" || replayed.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected replay headers: %v", replayed.Header())
+	}
+
+	if rec := send("/v1/disk/", "abc", `{"name":"other"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a reused key with another body, got %d", rec.Code)
+	}
+
+	// Keys are scoped per endpoint, and requests without a key always run
+	send("/v1/domain/", "abc", `{"name":"disk"}`)
+	send("/v1/disk/", "", `{"name":"disk"}`)
+	if calls != 3 {
+		t.Errorf("expected 3 handler runs, got %d", calls)
+	}
+}
+
+func TestIdempotencyKeysAreScopedToTenant(t *testing.T) {
+	calls := 0
+	h := Idempotency(newIdempotencyStore(time.Hour))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(quota.TenantFrom(r.Context())))
+	}))
+
+	// The admin token and two tenant tokens reuse the same key and body
+	for _, tenant := range []string{"", "acme", "globex", "acme"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/domain/", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, "abc")
+		req = req.WithContext(quota.WithTenant(req.Context(), tenant))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Body.String() != tenant {
+			t.Errorf("tenant %q got the response of %q", tenant, rec.Body)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 handler runs, got %d", calls)
+	}
+}
+
+func TestIdempotencyForgetsServerErrors(t *testing.T) {
+	calls := 0
+	h := Idempotency(newIdempotencyStore(time.Hour))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/domain/", strings.NewReader(`{}`))
+		req.Header.Set(idempotencyKeyHeader, "abc")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls != 2 {
+		t.Errorf("expected a failed request to run again on retry, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyStoreExpiry(t *testing.T) {
+	store := newIdempotencyStore(time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	var hash [32]byte
+	if _, claimed := store.begin("key", hash); !claimed {
+		t.Fatal("expected a new key to be claimed")
+	}
+	store.finish("key", &recordedResponse{status: http.StatusOK})
+	if _, claimed := store.begin("key", hash); claimed {
+		t.Fatal("expected a known key not to be claimed")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, claimed := store.begin("key", hash); !claimed {
+		t.Error("expected an expired key to be claimed again")
+	}
+}
@@ -1,27 +1,31 @@
 package server
 
 import (
+	"mime"
 	"net/http"
 	"os"
 	"strings"
 
+	"libvirt-controller/internal/quota"
 	"libvirt-controller/internal/server/utils"
 )
 
-// AuthMiddleware checks for a valid Bearer token in the Authorization header
+// AuthMiddleware checks for a valid Bearer token in the Authorization header.
+// Besides AUTH_TOKEN the tokens of the tenants in QUOTA_FILE are accepted,
+// their requests are charged to the tenant.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		expectedToken := os.Getenv("AUTH_TOKEN")
 
-		// If AUTH_TOKEN is not configured, proceed with the request unconditionally
-		if expectedToken == "" {
+		// If no token is configured, proceed with the request unconditionally
+		if expectedToken == "" && !quota.Default.Enabled() {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		authHeader := r.Header.Get("Authorization")
 
-		// If AUTH_TOKEN is set, check for the Authorization header
+		// If a token is set, check for the Authorization header
 		if authHeader == "" {
 			utils.JSONErrorResponse(w, "Missing Authorization header", http.StatusUnauthorized)
 			return
@@ -29,12 +33,62 @@ func AuthMiddleware(next http.Handler) http.Handler {
 
 		// Check for Bearer prefix and extract the token
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" || parts[1] != expectedToken {
+		if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
 			utils.JSONErrorResponse(w, "Invalid or missing token", http.StatusUnauthorized)
 			return
 		}
+		ctx := withIdentity(r.Context(), tokenIdentity(parts[1]))
+		if parts[1] != expectedToken {
+			tenant, ok := quota.Default.Tenant(parts[1])
+			if !ok {
+				utils.JSONErrorResponse(w, "Invalid or missing token", http.StatusUnauthorized)
+				return
+			}
+			ctx = quota.WithTenant(ctx, tenant)
+		}
 
 		// Token is valid, proceed with the request
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAdmin limits a route to the AUTH_TOKEN caller, for operations
+// spanning every tenant such as host maintenance and libvirt secrets.
+// Tenant tokens get 403 Forbidden.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if quota.TenantFrom(r.Context()) != "" {
+			utils.JSONErrorResponse(w, "This operation requires the admin token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireJSON rejects mutating requests whose body is not declared as JSON
+// with 415 Unsupported Media Type. Requests without a body, such as most
+// lifecycle calls, don't need a Content-Type.
+func RequireJSON(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// ContentLength is -1 for chunked bodies of unknown size
+		if r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			utils.JSONErrorResponse(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"libvirt-controller/internal/quota"
+)
+
+func TestRequireJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		contentType string
+		want        int
+	}{
+		{"json", http.MethodPost, `{}`, "application/json", http.StatusOK},
+		{"json with charset", http.MethodPost, `{}`, "application/json; charset=utf-8", http.StatusOK},
+		{"form", http.MethodPost, "a=b", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"text", http.MethodPatch, `{}`, "text/plain", http.StatusUnsupportedMediaType},
+		{"missing", http.MethodPut, `{}`, "", http.StatusUnsupportedMediaType},
+		{"no body", http.MethodPost, "", "", http.StatusOK},
+		{"get", http.MethodGet, "", "text/plain", http.StatusOK},
+	}
+
+	h := RequireJSON(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/domain/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d; got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareTenantTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(path, []byte(`{"tenant-token": {"tenant": "acme", "vcpus": 4}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	original := quota.Default
+	t.Cleanup(func() { quota.Default = original })
+	quota.Default = quota.NewIndex()
+	if err := quota.Default.Configure(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AUTH_TOKEN", "admin-token")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantTenant string
+	}{
+		{"admin", "admin-token", http.StatusOK, ""},
+		{"tenant", "tenant-token", http.StatusOK, "acme"},
+		{"unknown", "other", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tenant string
+			h := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tenant = quota.TenantFrom(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/v1/inventory", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || tenant != tt.wantTenant {
+				t.Errorf("got status %d, tenant %q; want %d, %q", rec.Code, tenant, tt.wantStatus, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	h := RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for tenant, want := range map[string]int{"": http.StatusOK, "acme": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/host/shutdown-all", nil)
+		req = req.WithContext(quota.WithTenant(req.Context(), tenant))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("tenant %q: status = %d, want %d", tenant, rec.Code, want)
+		}
+	}
+}
@@ -7,20 +7,14 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 )
 
 func (s *Server) RegisterRoutes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	}))
+	r.Use(CORS(corsOrigins()))
 
 	r.Use(AuthMiddleware) // Apply authentication
 
@@ -35,23 +29,56 @@ func (s *Server) RegisterRoutes() http.Handler {
 		w.Write([]byte("ok"))
 	})
 
+	// Create operations remember their Idempotency-Key so retries don't repeat them
+	idempotent := Idempotency(newIdempotencyStore(idempotencyWindow()))
+
+	// Domain operations may target another libvirt host with ?host=
+	libvirtHost := LibvirtHost(libvirtHosts())
+
+	// Disk image uploads are multipart and outgrow the body limit and
+	// timeouts of the JSON API, so they are routed ahead of it
+	r.With(Timeout(longRequestTimeout()), RouteReadTimeout(longRequestTimeout())).Post("/v1/disk/upload", handlers.UploadDiskHandler)
+
 	r.Route("/v1", func(r chi.Router) {
+		r.Use(Timeout(requestTimeout()))
+		r.Use(RequireJSON)
+		r.Use(MaxBodySize(maxBodyBytes()))
+
 		// Host-related routes
+		// Host-wide operations are limited to the admin token
 		r.Route("/host", func(r chi.Router) {
+			r.Use(RequireAdmin)
 			r.Post("/statistics", handlers.SystemStatsHandler)
 			r.Post("/hash", handlers.HashPasswordHandler)
+			r.Get("/agents", handlers.AgentsHealthHandler)
+			r.Get("/reconcile", handlers.ReconcileHandler)                // Differences between libvirt and DEFINITIONS_DIR
+			r.Post("/reconcile", handlers.RepairReconcileHandler)         // Repair them without deleting anything
+			r.Post("/shutdown-all", handlers.ShutdownAllHandler)          // Stop every running domain for maintenance, async
+			r.Get("/devices", handlers.ListHostDevicesHandler)            // PCI devices and their IOMMU groups, for passthrough
+			r.Get("/domcapabilities", handlers.DomainCapabilitiesHandler) // Firmware, devices and features for ?arch= and ?machine=
 			// Add more host-related routes here if needed
 		})
 
 		// Domain-related routes
 		r.Route("/domain", func(r chi.Router) {
-			r.Post("/", handlers.DefineDomainHandler) // Create a VM.
+			r.With(RouteTimeout(longRequestTimeout()), RouteMaxBodySize(maxLargeBodyBytes()), idempotent).Post("/", handlers.DefineDomainHandler) // Create a VM.
+
+			r.With(RouteTimeout(longRequestTimeout()), idempotent).Post("/from-template", handlers.DefineFromTemplateHandler) // Create a VM from a stored template
+
+			// Apply a lifecycle action to all VMs matching ?label=
+			r.With(RequireAdmin).Post("/batch", handlers.BatchDomainHandler)
+
 			r.Route("/{id}", func(r chi.Router) {
 				r.Use(handlers.DomainMiddleware)
+				r.Use(libvirtHost)
+				r.Use(Audit)
 				r.Get("/", handlers.RetrieveDomainHandler)          // Get information about VM.
+				r.Get("/describe", handlers.DescribeDomainHandler)  // Get everything about the VM at once
+				r.Get("/disk-mapping", handlers.DiskMappingHandler) // Host disk files mapped to guest devices and mountpoints
 				r.Delete("/", handlers.DeleteDomainHandler)         // Delete a VM.
-				r.Post("/cloud-init", handlers.CloudInitHandler)    // Create/Update Cloud Init image
-				r.Post("/start", handlers.StartDomainHandler)       // Turn on the VM
+				r.Get("/screenshot", handlers.ScreenshotHandler)    // Capture the VM console as PNG
+				r.Post("/start", handlers.StartDomainHandler)       // Turn on the VM, paused with ?paused=true
+				r.Post("/resume", handlers.ResumeDomainHandler)     // Resume a paused VM
 				r.Post("/reboot", handlers.RebootDomainHandler)     // Reboot the VM
 				r.Post("/reset", handlers.RebootDomainHandler)      // Reboot the VM
 				r.Post("/shutdowm", handlers.ShutdownDomainHandler) // Shutdown the VM
@@ -59,20 +86,113 @@ func (s *Server) RegisterRoutes() http.Handler {
 				r.Post("/elevate", handlers.ElevateVMHandler)       // Snapshot the VM
 				r.Post("/commit", handlers.CommitVMHandler)         // Commit snapshot changes the VM
 				r.Post("/revert", handlers.RevertVMHandler)         // Revert snapshot changes the VM
+				r.Post("/backup", handlers.BackupDomainHandler)     // Push a consistent disk backup, async
+
+				// Routes with their own timeout or body size limit
+				r.With(RouteMaxBodySize(maxLargeBodyBytes())).Post("/cloud-init", handlers.CloudInitHandler)        // Create/Update Cloud Init image
+				r.With(RouteTimeout(longRequestTimeout())).Post("/migrate", handlers.MigrateDomainHandler)          // Live migrate the VM to another host
+				r.With(RouteTimeout(0)).Get("/logs", handlers.DomainLogsHandler)                                    // Tail the VM's qemu log, streams with ?follow=true
+				r.With(RouteTimeout(0)).Post("/guest/update", handlers.GuestUpdateHandler)                          // Upgrade the guest packages, bounded by GUEST_UPDATE_TIMEOUT
+				r.With(RouteTimeout(0)).Post("/script", handlers.RunScriptHandler)                                  // Run a script in the guest, bounded by SCRIPT_TIMEOUT
+				r.With(RouteTimeout(longRequestTimeout())).Post("/grow-filesystem", handlers.GrowFilesystemHandler) // Grow a guest partition and filesystem after a disk resize
+
+				// Cloud-init drive of the running VM
+				r.Post("/cloud-init/eject", handlers.EjectCloudInitHandler)   // Eject the cloud-init ISO
+				r.Post("/cloud-init/insert", handlers.InsertCloudInitHandler) // Insert the current cloud-init ISO
+
+				// Installation media
+				r.Post("/cdrom/attach", handlers.AttachISOHandler) // Load an ISO into a CD-ROM drive
+				r.Post("/cdrom/detach", handlers.DetachISOHandler) // Eject it again
+
+				// Mutating operations recorded by Audit
+				r.Get("/audit", handlers.GetAuditLogHandler)
+
+				// Controller-side metadata (labels, TTL, snapshot schedule)
+				r.Get("/metadata", handlers.GetMetadataHandler)
+				r.Put("/metadata", handlers.UpdateMetadataHandler)
+
+				// Resource allocation
+				r.Get("/resources", handlers.GetResourcesHandler)
+				r.Post("/memory", handlers.SetMemoryHandler)
+				r.Post("/vcpu-hotplug", handlers.VCPUHotplugHandler)
+
+				// PCI passthrough
+				r.With(RequireAdmin).Post("/hostdev/attach", handlers.AttachHostDeviceHandler) // Admin token only, like /host/devices
+				r.Post("/hostdev/detach", handlers.DetachHostDeviceHandler)
+
+				// virtio-fs host directory shares
+				r.Post("/shared-dir/attach", handlers.AttachSharedDirHandler)
+				r.Post("/shared-dir/detach", handlers.DetachSharedDirHandler)
+
+				// Domain definition
+				r.Get("/xml", handlers.GetDomainXMLHandler)
+				r.With(RouteMaxBodySize(maxLargeBodyBytes())).Patch("/xml", handlers.UpdateDomainXMLHandler)
+				r.Post("/xml/rollback", handlers.RollbackDomainXMLHandler)
+				r.Post("/sync", handlers.SyncDomainXMLHandler)
+
+				// Libvirt job monitoring
+				r.Get("/job", handlers.GetDomainJobHandler)
+				r.Post("/job/abort", handlers.AbortDomainJobHandler)
+
+				// Guest agent operations
+				r.Post("/reset-password", handlers.ResetPasswordHandler) // Reset a guest user's password
+				r.Post("/fstrim", handlers.FstrimHandler)                // Discard unused guest blocks
+				r.Post("/fsfreeze", handlers.FsfreezeHandler)            // Freeze guest filesystems for a backup
+				r.Post("/fsthaw", handlers.FsthawHandler)                // Thaw guest filesystems
+				r.Get("/fsfreeze", handlers.FreezeStatusHandler)         // Check whether the guest is frozen
+				r.Get("/agent/info", handlers.GetAgentInfoHandler)       // Agent version and supported commands
+
+				// Guest processes, through ps/kill or tasklist/taskkill
+				r.Get("/processes", handlers.ListGuestProcessesHandler)
+				r.With(RequireAdmin).Post("/processes/kill", handlers.KillGuestProcessHandler) // Admin token only
+
+				// Serial console output kept by the console capture
+				r.Get("/console/log", handlers.ConsoleLogHandler)
 			})
 		})
 
+		// Host-wide domain inventory
+		r.With(RequireAdmin).Get("/inventory", handlers.InventoryHandler)
+
+		// Background job routes
+		r.Route("/jobs", func(r chi.Router) {
+			r.Get("/", handlers.ListJobsHandler)
+			r.Get("/{jobID}", handlers.GetJobHandler)
+		})
+
 		// Disk-related routes
 		r.Route("/disk", func(r chi.Router) {
-			r.Post("/", handlers.CreateDiskHandler)
+			r.With(RouteTimeout(longRequestTimeout()), idempotent).Post("/", handlers.CreateDiskHandler) // Downloads the image
+			r.With(RouteTimeout(longRequestTimeout())).Post("/check", handlers.CheckDiskHandler)         // Check and optionally repair an image
+			r.With(RouteTimeout(longRequestTimeout())).Post("/sparsify", handlers.SparsifyDiskHandler)   // Release unused space of an offline image
+			r.Get("/references", handlers.DiskReferencesHandler)                                         // Domains using an image directly or as a backing file
+			r.Post("/batch-delete", handlers.BatchDeleteDiskHandler)                                     // Delete several unused images at once
+			r.Post("/iso", handlers.CreateISOHandler)                                                    // Build an ISO, e.g. a driver disk
 			r.Route("/{id}", func(r chi.Router) {
-				r.Post("/resize", handlers.ResizeDiskHandler)
+				r.With(RouteTimeout(longRequestTimeout())).Post("/resize", handlers.ResizeDiskHandler)
 				r.Delete("/", handlers.DeleteDiskHandler)
 				//r.Post("/migrate", handlers.MigrateDiskHandler)    // Migrate Disk to new hypervisor
 			})
 			// Add more host-related routes here if needed
 		})
 
+		// Domain definition templates, see TEMPLATES_DIR
+		r.Route("/template", func(r chi.Router) {
+			r.Get("/", handlers.ListTemplatesHandler)
+			r.With(RequireAdmin, RouteMaxBodySize(maxLargeBodyBytes())).Post("/", handlers.CreateTemplateHandler) // Admin token only
+			r.Get("/{name}", handlers.GetTemplateHandler)
+			r.With(RequireAdmin, RouteMaxBodySize(maxLargeBodyBytes())).Put("/{name}", handlers.UpdateTemplateHandler) // Admin token only
+			r.With(RequireAdmin).Delete("/{name}", handlers.DeleteTemplateHandler)                                     // Admin token only
+		})
+
+		// libvirt secrets unlocking encrypted disks and network storage
+		r.Route("/secret", func(r chi.Router) {
+			r.Use(RequireAdmin)
+			r.Get("/", handlers.ListSecretsHandler)
+			r.Post("/", handlers.CreateSecretHandler) // Values are never returned
+			r.Delete("/{uuid}", handlers.DeleteSecretHandler)
+		})
+
 	})
 
 	return r
@@ -4,14 +4,20 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"libvirt-controller/internal/metadata"
+	"libvirt-controller/internal/quota"
 )
 
 func TestHandler(t *testing.T) {
 	s := &Server{}
-	server := httptest.NewServer(http.HandlerFunc(s.HelloWorldHandler))
+	server := httptest.NewServer(s.RegisterRoutes())
 	defer server.Close()
-	resp, err := http.Get(server.URL)
+	resp, err := http.Get(server.URL + "/healthz")
 	if err != nil {
 		t.Fatalf("error making request to server. Err: %v", err)
 	}
@@ -20,7 +26,7 @@ func TestHandler(t *testing.T) {
 	if resp.StatusCode != http.StatusOK {
 		t.Errorf("expected status OK; got %v", resp.Status)
 	}
-	expected := "{\"message\":\"Hello World\"}"
+	expected := "ok"
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		t.Fatalf("error reading response body. Err: %v", err)
@@ -29,3 +35,83 @@ func TestHandler(t *testing.T) {
 		t.Errorf("expected response body to be %v; got %v", expected, string(body))
 	}
 }
+
+func TestDiskUploadBypassesRequireJSON(t *testing.T) {
+	s := &Server{}
+	server := httptest.NewServer(s.RegisterRoutes())
+	defer server.Close()
+
+	tests := []struct {
+		path        string
+		contentType string
+		want        int
+	}{
+		// Reaches the handler, which misses the file part
+		{"/v1/disk/upload", "multipart/form-data; boundary=x", http.StatusBadRequest},
+		// The rest of /v1/disk still only takes JSON
+		{"/v1/disk/", "multipart/form-data; boundary=x", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(server.URL+tt.path, tt.contentType, strings.NewReader("--x--\r\n"))
+		if err != nil {
+			t.Fatalf("POST %s: %v", tt.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("POST %s: status = %d, want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestAdminOnlyRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	if err := os.WriteFile(path, []byte(`{"tenant-token": {"tenant": "acme"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	original := quota.Default
+	t.Cleanup(func() { quota.Default = original })
+	quota.Default = quota.NewIndex()
+	if err := quota.Default.Configure(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AUTH_TOKEN", "admin-token")
+	dir := t.TempDir()
+	t.Setenv("DEFINITIONS_DIR", dir)
+	if err := os.Mkdir(filepath.Join(dir, "vm-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.Save(filepath.Join(dir, "vm-1"), &metadata.Metadata{Tenant: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{}
+	server := httptest.NewServer(s.RegisterRoutes())
+	defer server.Close()
+
+	routes := []struct {
+		method string
+		route  string
+	}{
+		{http.MethodPost, "/v1/host/shutdown-all"},
+		{http.MethodPost, "/v1/secret/"},
+		{http.MethodPost, "/v1/domain/batch?label=a"},
+		{http.MethodPost, "/v1/domain/vm-1/processes/kill"},
+		{http.MethodPost, "/v1/domain/vm-1/hostdev/attach"},
+		{http.MethodPost, "/v1/template/"},
+		{http.MethodPut, "/v1/template/web"},
+		{http.MethodDelete, "/v1/template/web"},
+	}
+	for _, tt := range routes {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.route, strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer tenant-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.route, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s with a tenant token: status = %d, want %d", tt.method, tt.route, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+}
@@ -7,9 +7,19 @@ import (
 	"strconv"
 	"time"
 
+	"libvirt-controller/internal/config"
+
 	_ "github.com/joho/godotenv/autoload"
 )
 
+// Connection timeouts of the HTTP servers, see ConfigureTimeouts.
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 10 * time.Second
+	defaultWriteTimeout      = 30 * time.Second
+	defaultIdleTimeout       = time.Minute
+)
+
 type Server struct {
 	port int
 }
@@ -22,12 +32,23 @@ func NewServer() *http.Server {
 
 	// Declare Server config
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", NewServer.port),
-		Handler:      NewServer.RegisterRoutes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		Addr:    fmt.Sprintf(":%d", NewServer.port),
+		Handler: NewServer.RegisterRoutes(),
 	}
+	ConfigureTimeouts(server)
 
 	return server
 }
+
+// ConfigureTimeouts bounds how long srv waits for slow clients, from the
+// HTTP_*_TIMEOUT settings; 0 disables a timeout. The API routes move the
+// write deadline of their own request with Timeout and RouteTimeout, and
+// only the disk upload moves its read deadline with RouteReadTimeout, so
+// ReadTimeout bounds reading the request of every other route.
+func ConfigureTimeouts(srv *http.Server) {
+	srv.ReadHeaderTimeout = config.Seconds("HTTP_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout)
+	srv.ReadTimeout = config.Seconds("HTTP_READ_TIMEOUT", defaultReadTimeout)
+	srv.WriteTimeout = config.Seconds("HTTP_WRITE_TIMEOUT", defaultWriteTimeout)
+	srv.IdleTimeout = config.Seconds("HTTP_IDLE_TIMEOUT", defaultIdleTimeout)
+	srv.SetKeepAlivesEnabled(config.Bool("HTTP_KEEP_ALIVE", true))
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConfigureTimeouts(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want [4]time.Duration // Read header, read, write and idle timeouts
+	}{
+		{
+			name: "defaults",
+			want: [4]time.Duration{5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute},
+		},
+		{
+			name: "configured",
+			env:  map[string]string{"HTTP_READ_HEADER_TIMEOUT": "2", "HTTP_READ_TIMEOUT": "20", "HTTP_WRITE_TIMEOUT": "0", "HTTP_IDLE_TIMEOUT": "300"},
+			want: [4]time.Duration{2 * time.Second, 20 * time.Second, 0, 5 * time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			srv := &http.Server{}
+			ConfigureTimeouts(srv)
+
+			got := [4]time.Duration{srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout}
+			if got != tt.want {
+				t.Errorf("timeouts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewServerUsesTimeouts(t *testing.T) {
+	t.Setenv("HTTP_READ_TIMEOUT", "42")
+	if srv := NewServer(); srv.ReadTimeout != 42*time.Second {
+		t.Errorf("ReadTimeout = %s, want 42s", srv.ReadTimeout)
+	}
+}
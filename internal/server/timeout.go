@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"libvirt-controller/internal/config"
+	"libvirt-controller/internal/server/utils"
+)
+
+const (
+	defaultRequestTimeout = time.Minute
+	defaultLongTimeout    = 30 * time.Minute
+
+	// writeGrace leaves time to send the 504 after the deadline
+	writeGrace = 5 * time.Second
+)
+
+// requestTimeout is the deadline of ordinary API calls.
+func requestTimeout() time.Duration {
+	return config.Seconds("REQUEST_TIMEOUT", defaultRequestTimeout)
+}
+
+// longRequestTimeout is the deadline of calls that download images or move
+// whole domains around.
+func longRequestTimeout() time.Duration {
+	return config.Seconds("LONG_REQUEST_TIMEOUT", defaultLongTimeout)
+}
+
+type timeoutKey struct{}
+
+// requestTimer cancels a request context once its timeout elapses. Routes
+// move the deadline with RouteTimeout before their handler runs.
+type requestTimer struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	cancel  context.CancelCauseFunc
+	expired bool
+}
+
+func (t *requestTimer) expire() {
+	t.mu.Lock()
+	t.expired = true
+	t.mu.Unlock()
+	t.cancel(context.DeadlineExceeded)
+}
+
+func (t *requestTimer) hasExpired() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.expired
+}
+
+// reset restarts the timer with d, or stops it for good when d is 0.
+func (t *requestTimer) reset(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expired {
+		return
+	}
+	t.timer.Stop()
+	if d > 0 {
+		t.timer.Reset(d)
+	}
+}
+
+// Timeout cancels the request context after d. Commands started with the
+// request context are killed, and a handler failing because of it answers
+// 504 Gateway Timeout instead of its own error.
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithCancelCause(r.Context())
+			defer cancel(nil)
+
+			t := &requestTimer{cancel: cancel}
+			t.timer = time.AfterFunc(d, t.expire)
+			defer t.timer.Stop()
+			setWriteDeadline(w, d)
+
+			tw := &timeoutWriter{ResponseWriter: w, timer: t}
+			next.ServeHTTP(tw, r.WithContext(context.WithValue(ctx, timeoutKey{}, t)))
+
+			if !tw.wroteHeader && t.hasExpired() {
+				utils.JSONErrorResponse(w, "Request timed out", http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
+
+// RouteTimeout replaces the deadline set by Timeout for a single route.
+// A zero duration removes it, which streaming endpoints rely on.
+func RouteTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := r.Context().Value(timeoutKey{}).(*requestTimer); ok {
+				t.reset(d)
+				setWriteDeadline(w, d)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RouteReadTimeout replaces the server wide ReadTimeout for a single route,
+// so uploads larger than it allows can still be read. A zero duration
+// removes it.
+func RouteReadTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline := time.Time{}
+			if d > 0 {
+				deadline = time.Now().Add(d)
+			}
+			// Not every writer supports deadlines, the server timeout applies then
+			_ = http.NewResponseController(w).SetReadDeadline(deadline)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setWriteDeadline keeps the server wide WriteTimeout from cutting off
+// responses that are allowed to take longer.
+func setWriteDeadline(w http.ResponseWriter, d time.Duration) {
+	deadline := time.Time{}
+	if d > 0 {
+		deadline = time.Now().Add(d + writeGrace)
+	}
+	// Not every writer supports deadlines, the server timeout applies then
+	_ = http.NewResponseController(w).SetWriteDeadline(deadline)
+}
+
+// timeoutWriter swaps server errors caused by the expired deadline for a 504.
+type timeoutWriter struct {
+	http.ResponseWriter
+	timer       *requestTimer
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	if code >= http.StatusInternalServerError && tw.timer.hasExpired() {
+		tw.timedOut = true
+		utils.JSONErrorResponse(tw.ResponseWriter, "Request timed out", http.StatusGatewayTimeout)
+		return
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.timedOut {
+		// Drop the handler's own error body
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	_ = http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"libvirt-controller/internal/server/utils"
+)
+
+// slowHandler fails the way a handler does when its command is killed.
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+		utils.JSONErrorResponse(w, "command aborted", http.StatusInternalServerError)
+	case <-time.After(200 * time.Millisecond):
+		w.Write([]byte("done"))
+	}
+}
+
+func TestTimeoutReturnsGatewayTimeout(t *testing.T) {
+	h := Timeout(20 * time.Millisecond)(http.HandlerFunc(slowHandler))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status 504; got %d", rec.Code)
+	}
+}
+
+func TestTimeoutCancelsWithDeadlineExceeded(t *testing.T) {
+	var cause error
+	h := Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		cause = context.Cause(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !errors.Is(cause, context.DeadlineExceeded) {
+		t.Errorf("expected cause %v; got %v", context.DeadlineExceeded, cause)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504; got %d", rec.Code)
+	}
+}
+
+func TestRouteTimeoutOverrides(t *testing.T) {
+	tests := []struct {
+		name  string
+		route time.Duration
+	}{
+		{"longer", time.Second},
+		{"exempt", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Timeout(20 * time.Millisecond)(RouteTimeout(tt.route)(http.HandlerFunc(slowHandler)))
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+				t.Errorf("expected the handler to finish; got %d %q", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Validator is implemented by request types that check their own fields.
+type Validator interface {
+	Validate() error
+}
+
+// RequestError is a client error found while decoding or validating a
+// request body. Field is set when the error concerns a single field.
+type RequestError struct {
+	Status  int
+	Field   string
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// FieldError reports an invalid value of a single request field.
+func FieldError(field string, format string, args ...interface{}) error {
+	return &RequestError{
+		Status:  http.StatusBadRequest,
+		Field:   field,
+		Message: fmt.Sprintf("field '%s' ", field) + fmt.Sprintf(format, args...),
+	}
+}
+
+// DecodeJSON decodes the request body into v, rejecting empty bodies,
+// unknown fields and trailing data, then runs v.Validate when available.
+func DecodeJSON(r *http.Request, v interface{}) error {
+	rawBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return &RequestError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit)}
+		}
+		return &RequestError{Status: http.StatusBadRequest, Message: "Failed to read request body"}
+	}
+	if len(bytes.TrimSpace(rawBody)) == 0 {
+		return &RequestError{Status: http.StatusBadRequest, Message: "Empty request body"}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(rawBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if decoder.More() {
+		return &RequestError{Status: http.StatusBadRequest, Message: "Request body must contain a single JSON object"}
+	}
+
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// decodeError turns encoding/json errors into messages naming the problem.
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &RequestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid JSON at offset %d", syntaxErr.Offset)}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			return &RequestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Request body must be a JSON %s", typeErr.Type)}
+		}
+		return FieldError(field, "must be of type %s", typeErr.Type)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &RequestError{Status: http.StatusBadRequest, Message: "Invalid JSON: unexpected end of body"}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &RequestError{Status: http.StatusBadRequest, Field: field, Message: fmt.Sprintf("unknown field '%s'", field)}
+	default:
+		return &RequestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid JSON: %s", err)}
+	}
+}
+
+// JSONRequestErrorResponse writes the error returned by DecodeJSON.
+func JSONRequestErrorResponse(w http.ResponseWriter, err error) {
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		// Plain errors come from Validate methods
+		JSONErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := map[string]string{"error": reqErr.Message}
+	if reqErr.Field != "" {
+		body["field"] = reqErr.Field
+	}
+	JSONResponse(w, body, reqErr.Status)
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type sizeRequest struct {
+	Size int `json:"size"`
+}
+
+func (req *sizeRequest) Validate() error {
+	if req.Size <= 0 {
+		return FieldError("size", "must be > 0")
+	}
+	return nil
+}
+
+func TestDecodeJSON(t *testing.T) {
+	cases := []struct {
+		body      string
+		wantField string
+		wantMsg   string
+	}{
+		{`{"size": 10}`, "", ""},
+		{``, "", "Empty request body"},
+		{`{"size": 0}`, "size", "field 'size' must be > 0"},
+		{`{"size": "big"}`, "size", "field 'size' must be of type int"},
+		{`{"size": 10, "colour": "red"}`, "colour", "unknown field 'colour'"},
+		{`{"size": 10`, "", "Invalid JSON: unexpected end of body"},
+		{`{"size": 10} {}`, "", "Request body must contain a single JSON object"},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+		var req sizeRequest
+		err := DecodeJSON(r, &req)
+		if tc.wantMsg == "" {
+			if err != nil {
+				t.Errorf("DecodeJSON(%q) unexpected error: %v", tc.body, err)
+			}
+			continue
+		}
+
+		var reqErr *RequestError
+		if !errors.As(err, &reqErr) {
+			t.Errorf("DecodeJSON(%q) = %v, want a RequestError", tc.body, err)
+			continue
+		}
+		if reqErr.Message != tc.wantMsg || reqErr.Field != tc.wantField {
+			t.Errorf("DecodeJSON(%q) = %q (field %q), want %q (field %q)", tc.body, reqErr.Message, reqErr.Field, tc.wantMsg, tc.wantField)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package templates stores domain definition templates, domain XML with
+// text/template placeholders such as {{.VMID}} or {{.MemoryMB}}, as files in
+// TEMPLATES_DIR.
+package templates
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"libvirt-controller/internal/filesystem"
+)
+
+// extension is the file extension of stored templates.
+const extension = ".xml.tmpl"
+
+// IDVariable is the variable holding the id of the domain being defined.
+const IDVariable = "VMID"
+
+var (
+	ErrNotFound      = errors.New("template not found")
+	ErrExists        = errors.New("template already exists")
+	ErrNotConfigured = errors.New("TEMPLATES_DIR environment variable not set")
+)
+
+// namePattern matches template names that are safe to use as a file name.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Template is a stored template.
+type Template struct {
+	Name      string    `json:"name"`
+	Content   string    `json:"content,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ValidName reports whether name can be used for a template.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Dir returns TEMPLATES_DIR.
+func Dir() (string, error) {
+	dir := os.Getenv("TEMPLATES_DIR")
+	if dir == "" {
+		return "", ErrNotConfigured
+	}
+	return dir, nil
+}
+
+func path(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+extension), nil
+}
+
+// List returns the stored templates sorted by name, without their content.
+func List() ([]Template, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Template{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Template{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), extension)
+		if !ok || !entry.Type().IsRegular() || !ValidName(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Deleted while listing
+			continue
+		}
+		list = append(list, Template{Name: name, UpdatedAt: info.ModTime().UTC()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// Get returns the template called name.
+func Get(name string) (*Template, error) {
+	p, err := path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{Name: name, Content: string(data), UpdatedAt: info.ModTime().UTC()}, nil
+}
+
+// Save stores content as the template called name, see Check for its
+// syntax. An existing template is only replaced when replace is set.
+func Save(name, content string, replace bool) (created bool, err error) {
+	p, err := path(name)
+	if err != nil {
+		return false, err
+	}
+	exists := filesystem.FileExists(p)
+	if exists && !replace {
+		return false, ErrExists
+	}
+	if err := filesystem.CreateDirectory(filepath.Dir(p), 0755); err != nil {
+		return false, err
+	}
+	if err := filesystem.SaveFileAtomic(filepath.Dir(p), filepath.Base(p), []byte(content)); err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// Delete removes the template called name.
+func Delete(name string) error {
+	p, err := path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Check returns the syntax errors of the template content.
+func Check(content string) error {
+	_, err := parse("template", content)
+	return err
+}
+
+// parse parses content, referencing a variable that isn't passed fails the
+// rendering rather than printing "<no value>".
+func parse(name, content string) (*template.Template, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, fmt.Errorf("template is empty")
+	}
+	return template.New(name).Option("missingkey=error").Parse(content)
+}
+
+// Render executes the template content with variables and the domain id as
+// {{.VMID}}. Strings are XML escaped, also inside objects and lists, so they
+// can't change the structure of the document.
+func Render(name, content, vmID string, variables map[string]interface{}) (string, error) {
+	tmpl, err := parse(name, content)
+	if err != nil {
+		return "", err
+	}
+
+	data := make(map[string]interface{}, len(variables)+1)
+	for key, value := range variables {
+		data[key] = escapeValue(value)
+	}
+	data[IDVariable] = vmID
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// escapeValue escapes the strings of a JSON value. Object keys are escaped
+// as well, a range over the object prints them.
+func escapeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return escape(v)
+	case map[string]interface{}:
+		escaped := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			escaped[escape(key)] = escapeValue(value)
+		}
+		return escaped
+	case []interface{}:
+		escaped := make([]interface{}, len(v))
+		for i, value := range v {
+			escaped[i] = escapeValue(value)
+		}
+		return escaped
+	}
+	return value
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const small = `<domain type='kvm'><name>{{.VMID}}</name><memory unit='MiB'>{{.MemoryMB}}</memory><description>{{.Owner}}</description></domain>`
+
+func TestRender(t *testing.T) {
+	// Variables arrive decoded from JSON, numbers as float64
+	var variables map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"MemoryMB": 2048, "Owner": "ops & <dev>"}`), &variables); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Render("small", small, "vm-1", variables)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	want := `<domain type='kvm'><name>vm-1</name><memory unit='MiB'>2048</memory><description>ops &amp; &lt;dev&gt;</description></domain>`
+	if got != want {
+		t.Errorf("Render() = %s, want %s", got, want)
+	}
+}
+
+func TestRenderEscapesNestedValues(t *testing.T) {
+	var variables map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"Disk": {"Source": "/data/a'/><disk>"}, "Tags": ["a&b", {"c": "<d>"}]}`), &variables); err != nil {
+		t.Fatal(err)
+	}
+
+	content := `<source file='{{.Disk.Source}}'/>{{range .Tags}}<tag>{{.}}</tag>{{end}}{{range $k, $v := index .Tags 1}}<{{$k}}>{{$v}}</{{$k}}>{{end}}`
+	got, err := Render("nested", content, "vm-1", variables)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	want := `<source file='/data/a&#39;/&gt;&lt;disk&gt;'/><tag>a&amp;b</tag><tag>map[c:&lt;d&gt;]</tag><c>&lt;d&gt;</c>`
+	if got != want {
+		t.Errorf("Render() = %s, want %s", got, want)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		variables map[string]interface{}
+	}{
+		{"missing variable", small, map[string]interface{}{"MemoryMB": 1024}},
+		{"syntax", `<name>{{.VMID</name>`, nil},
+		{"empty", " \n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Render(tt.name, tt.content, "vm-1", tt.variables); err == nil {
+				t.Errorf("Render() = %s, want an error", got)
+			}
+		})
+	}
+}
+
+func TestRenderKeepsID(t *testing.T) {
+	got, err := Render("id", `{{.VMID}}`, "vm-1", map[string]interface{}{"VMID": "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "vm-1" {
+		t.Errorf("Render() = %s, want vm-1", got)
+	}
+}
+
+func TestStore(t *testing.T) {
+	t.Setenv("TEMPLATES_DIR", t.TempDir()+"/templates")
+
+	if list, err := List(); err != nil || len(list) != 0 {
+		t.Fatalf("List() = %v, %v; want no templates", list, err)
+	}
+	if created, err := Save("small", small, false); err != nil || !created {
+		t.Fatalf("Save() = %v, %v; want created", created, err)
+	}
+	if _, err := Save("small", small, false); !errors.Is(err, ErrExists) {
+		t.Errorf("Save() of an existing template error = %v, want ErrExists", err)
+	}
+	replaced := strings.Replace(small, "kvm", "qemu", 1)
+	if created, err := Save("small", replaced, true); err != nil || created {
+		t.Errorf("Save() with replace = %v, %v; want replaced", created, err)
+	}
+
+	tmpl, err := Get("small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.Content != replaced {
+		t.Errorf("Get() content = %s, want %s", tmpl.Content, replaced)
+	}
+	list, err := List()
+	if err != nil || len(list) != 1 || list[0].Name != "small" || list[0].Content != "" {
+		t.Errorf("List() = %+v, %v; want only small without content", list, err)
+	}
+
+	if err := Delete("small"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get("small"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a deleted template error = %v, want ErrNotFound", err)
+	}
+	if err := Delete("small"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a deleted template error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestStoreNotConfigured(t *testing.T) {
+	t.Setenv("TEMPLATES_DIR", "")
+	if _, err := List(); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("List() error = %v, want ErrNotConfigured", err)
+	}
+}
@@ -0,0 +1,6 @@
+// Package version holds the version of the controller build.
+package version
+
+// Version is set at build time with
+// -ldflags "-X libvirt-controller/internal/version.Version=v1.2.3".
+var Version = "dev"